@@ -0,0 +1,223 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+// Package bootstrap provides profiles that prepare a provisioned VM for a
+// specific workload, either through user data at boot or over SSH.
+package bootstrap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+)
+
+var (
+	// ErrGPUNotReady is returned when nvidia-smi does not report a working GPU
+	// on the VM.
+	ErrGPUNotReady = errors.New("GPU driver is not ready: nvidia-smi failed")
+	// ErrGPUTimeout is returned when the GPU driver is not ready in time.
+	ErrGPUTimeout = errors.New("Timed out waiting for the GPU driver")
+)
+
+const (
+	// DriverLatest selects the latest driver branch. It supports all GPUs from
+	// the Maxwell generation on.
+	DriverLatest = "535"
+	// DriverLegacyKepler selects the last driver branch supporting Kepler GPUs,
+	// such as the K80.
+	DriverLegacyKepler = "470"
+
+	// gpuScriptPath is the path the install script is uploaded to over SSH.
+	gpuScriptPath = "/tmp/libretto-gpu-bootstrap.sh"
+)
+
+// GPUProfile describes the GPU driver and CUDA stack to install on a VM.
+type GPUProfile struct {
+	// DriverVersion is the NVIDIA driver branch to install, such as "535".
+	DriverVersion string
+	// CUDAVersion [optional] is the CUDA toolkit version to install, such as
+	// "12-2". The toolkit is not installed if it is empty.
+	CUDAVersion string
+}
+
+// gpuInstanceTypes maps instance type prefixes of the supported providers to
+// the driver branch required by their GPUs. Prefixes are matched in order, so
+// more specific prefixes must come first.
+var gpuInstanceTypes = []struct {
+	prefix string
+	driver string
+}{
+	// AWS
+	{"p2.", DriverLegacyKepler},
+	{"g2.", DriverLegacyKepler},
+	{"p3.", DriverLatest},
+	{"p3dn.", DriverLatest},
+	{"p4d.", DriverLatest},
+	{"p5.", DriverLatest},
+	{"g3.", DriverLatest},
+	{"g3s.", DriverLatest},
+	{"g4dn.", DriverLatest},
+	{"g5.", DriverLatest},
+	{"g6.", DriverLatest},
+	// GCP
+	{"a2-", DriverLatest},
+	{"a3-", DriverLatest},
+	{"g2-", DriverLatest},
+}
+
+// azureGPUSeries maps the series of Azure VM sizes with NVIDIA GPUs to the
+// driver branch required by their GPUs. The series is the size without its
+// "Standard_" prefix and vCPU count, such as "NCas_T4_v3" for
+// "Standard_NC4as_T4_v3". Series are matched exactly, as sizes of the same
+// family may have AMD GPUs, such as NVv4 and NDv5 MI300X.
+var azureGPUSeries = map[string]string{
+	"NC":             DriverLegacyKepler,
+	"NCr":            DriverLegacyKepler,
+	"NCs_v2":         DriverLatest,
+	"NCrs_v2":        DriverLatest,
+	"NCs_v3":         DriverLatest,
+	"NCrs_v3":        DriverLatest,
+	"NCas_T4_v3":     DriverLatest,
+	"NCads_A100_v4":  DriverLatest,
+	"NCads_H100_v5":  DriverLatest,
+	"NDs":            DriverLatest,
+	"NDrs_v2":        DriverLatest,
+	"NDasr_v4":       DriverLatest,
+	"NDamsr_A100_v4": DriverLatest,
+	"NDisr_H100_v5":  DriverLatest,
+	"NV":             DriverLatest,
+	"NVs_v3":         DriverLatest,
+	"NVads_A10_v5":   DriverLatest,
+}
+
+// azureSizeRegexp matches Azure VM sizes, capturing their family and the
+// rest of the size after the vCPU count.
+var azureSizeRegexp = regexp.MustCompile(`^Standard_([A-Z]+)\d+(.*)$`)
+
+// GPUProfileForInstanceType returns the GPU profile for the given instance
+// type, machine type or VM size. The second return value is false if the
+// instance type is not known to have an NVIDIA GPU.
+func GPUProfileForInstanceType(instanceType string) (*GPUProfile, bool) {
+	if m := azureSizeRegexp.FindStringSubmatch(instanceType); m != nil {
+		driver, ok := azureGPUSeries[m[1]+m[2]]
+		if !ok {
+			return nil, false
+		}
+		return &GPUProfile{DriverVersion: driver}, true
+	}
+	for _, t := range gpuInstanceTypes {
+		if strings.HasPrefix(instanceType, t.prefix) {
+			return &GPUProfile{DriverVersion: t.driver}, true
+		}
+	}
+	return nil, false
+}
+
+// Script returns a shell script which installs the GPU driver and, if
+// requested, the CUDA toolkit on Debian, Ubuntu and RHEL based guests. The
+// script does nothing if nvidia-smi already works.
+func (p *GPUProfile) Script() string {
+	driver := p.DriverVersion
+	if driver == "" {
+		driver = DriverLatest
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`#!/bin/sh
+set -e
+if command -v nvidia-smi >/dev/null 2>&1 && nvidia-smi >/dev/null 2>&1; then
+  exit 0
+fi
+. /etc/os-release
+if command -v apt-get >/dev/null 2>&1; then
+  export DEBIAN_FRONTEND=noninteractive
+  apt-get update
+  apt-get install -y linux-headers-$(uname -r) wget
+`)
+	fmt.Fprintf(&b, "  apt-get install -y nvidia-driver-%s-server || apt-get install -y nvidia-driver-%s\n", driver, driver)
+	if p.CUDAVersion != "" {
+		fmt.Fprintf(&b, `  repo="$ID$(echo $VERSION_ID | tr -d .)"
+  wget -q -O /tmp/cuda-keyring.deb "https://developer.download.nvidia.com/compute/cuda/repos/$repo/x86_64/cuda-keyring_1.1-1_all.deb"
+  dpkg -i /tmp/cuda-keyring.deb
+  apt-get update
+  apt-get install -y cuda-toolkit-%s
+`, p.CUDAVersion)
+	}
+	b.WriteString(`elif command -v dnf >/dev/null 2>&1; then
+  dnf install -y kernel-devel-$(uname -r) kernel-headers
+  repo="rhel$(echo $VERSION_ID | cut -d. -f1)"
+  dnf config-manager --add-repo "https://developer.download.nvidia.com/compute/cuda/repos/$repo/x86_64/cuda-$repo.repo"
+`)
+	fmt.Fprintf(&b, "  dnf module install -y nvidia-driver:%s-dkms\n", driver)
+	if p.CUDAVersion != "" {
+		fmt.Fprintf(&b, "  dnf install -y cuda-toolkit-%s\n", p.CUDAVersion)
+	}
+	b.WriteString(`else
+  echo "unsupported distribution: $ID" >&2
+  exit 1
+fi
+modprobe nvidia || true
+nvidia-smi
+`)
+	return b.String()
+}
+
+// UserData returns the install script as user data, to be passed to the
+// provider at provision time. Use WaitForGPU to wait until it is done.
+func (p *GPUProfile) UserData() []byte {
+	return []byte(p.Script())
+}
+
+// Install installs the GPU driver over SSH and verifies it with nvidia-smi.
+// The client must be connected, as root or as a user with passwordless sudo.
+func (p *GPUProfile) Install(client ssh.Client) error {
+	script := p.Script()
+	if err := client.Upload(strings.NewReader(script), gpuScriptPath, len(script), 0755); err != nil {
+		return fmt.Errorf("failed to upload the GPU bootstrap script: %s", err)
+	}
+
+	var stderr bytes.Buffer
+	if err := client.Run("sudo sh "+gpuScriptPath, &bytes.Buffer{}, &stderr); err != nil {
+		return fmt.Errorf("failed to install the GPU driver: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return VerifyGPU(client)
+}
+
+// VerifyGPU runs nvidia-smi on the VM using the connected client and returns
+// ErrGPUNotReady if it does not report a working GPU.
+func VerifyGPU(client ssh.Client) error {
+	var stdout bytes.Buffer
+	if err := client.Run("nvidia-smi -L", &stdout, &bytes.Buffer{}); err != nil {
+		return ErrGPUNotReady
+	}
+	if !strings.Contains(stdout.String(), "GPU") {
+		return ErrGPUNotReady
+	}
+	return nil
+}
+
+// WaitForGPU waits until nvidia-smi reports a working GPU on the VM, for
+// example while the user data installs the driver. It checks every 10 seconds
+// and returns ErrGPUTimeout if the GPU is not ready within maxWait.
+func WaitForGPU(client ssh.Client, maxWait time.Duration) error {
+	start := time.Now()
+
+	for {
+		if err := VerifyGPU(client); err == nil {
+			return nil
+		}
+
+		if time.Since(start) >= maxWait {
+			break
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+
+	return ErrGPUTimeout
+}
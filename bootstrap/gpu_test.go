@@ -0,0 +1,83 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package bootstrap
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/apcera/libretto/ssh"
+)
+
+// TestGPUProfileForInstanceType tests that GPU instance types get the driver
+// branch supporting their GPU and other instance types get no profile.
+func TestGPUProfileForInstanceType(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		driver       string
+	}{
+		{"p2.xlarge", DriverLegacyKepler},
+		{"g4dn.xlarge", DriverLatest},
+		{"a2-highgpu-1g", DriverLatest},
+		{"Standard_NC6", DriverLegacyKepler},
+		{"Standard_NC6s_v3", DriverLatest},
+		{"Standard_NC24rs_v3", DriverLatest},
+		{"Standard_NC4as_T4_v3", DriverLatest},
+		{"Standard_NC24ads_A100_v4", DriverLatest},
+		{"Standard_ND96asr_v4", DriverLatest},
+		{"Standard_NV12s_v3", DriverLatest},
+		{"Standard_NV4as_v4", ""},
+		{"Standard_ND96isr_MI300X_v5", ""},
+		{"Standard_NCv2", ""},
+		{"Standard_D2s_v3", ""},
+		{"m4.large", ""},
+	}
+
+	for _, test := range tests {
+		p, ok := GPUProfileForInstanceType(test.instanceType)
+		if test.driver == "" {
+			if ok {
+				t.Fatalf("Expected no GPU profile for %s, got %+v", test.instanceType, p)
+			}
+			continue
+		}
+		if !ok || p.DriverVersion != test.driver {
+			t.Fatalf("Expected driver %s for %s, got %+v", test.driver, test.instanceType, p)
+		}
+	}
+}
+
+// TestScriptCUDA tests that the CUDA toolkit is only installed when requested.
+func TestScriptCUDA(t *testing.T) {
+	p := GPUProfile{DriverVersion: DriverLatest}
+	if s := p.Script(); strings.Contains(s, "cuda-toolkit") || !strings.Contains(s, "nvidia-driver-535") {
+		t.Fatalf("Unexpected script without CUDA:\n%s", s)
+	}
+
+	p.CUDAVersion = "12-2"
+	if s := p.Script(); !strings.Contains(s, "cuda-toolkit-12-2") {
+		t.Fatalf("Unexpected script with CUDA:\n%s", s)
+	}
+}
+
+// TestVerifyGPU tests that VerifyGPU reports whether nvidia-smi lists a GPU.
+func TestVerifyGPU(t *testing.T) {
+	client := &ssh.MockSSHClient{
+		MockRun: func(command string, stdout io.Writer, stderr io.Writer) error {
+			io.WriteString(stdout, "GPU 0: Tesla T4 (UUID: GPU-1234)\n")
+			return nil
+		},
+	}
+	if err := VerifyGPU(client); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	client.MockRun = func(command string, stdout io.Writer, stderr io.Writer) error {
+		return errors.New("nvidia-smi: command not found")
+	}
+	if err := VerifyGPU(client); err != ErrGPUNotReady {
+		t.Fatalf("Expected %s, got %v", ErrGPUNotReady, err)
+	}
+}
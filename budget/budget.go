@@ -0,0 +1,349 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+// Package budget provides guardrails which refuse to provision VMs beyond the
+// instance count, instance-hours or estimated spend configured for a project.
+package budget
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+	"github.com/apcera/util/uuid"
+)
+
+const (
+	// LimitInstances is the limit on the number of concurrent instances.
+	LimitInstances = "instances"
+	// LimitInstanceHours is the limit on the accumulated instance-hours.
+	LimitInstanceHours = "instance-hours"
+	// LimitSpend is the limit on the estimated spend.
+	LimitSpend = "spend"
+)
+
+// ErrBudgetExceeded is returned when provisioning a VM would exceed a limit of
+// its project.
+type ErrBudgetExceeded struct {
+	Project string
+	Limit   string
+	Current float64
+	Max     float64
+}
+
+func (e ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("budget exceeded for project %q: %s is %g, limit is %g", e.Project, e.Limit, e.Current, e.Max)
+}
+
+// Limits configures the guardrails of a project. A zero value disables the
+// respective limit.
+type Limits struct {
+	// MaxInstances is the maximum number of concurrent instances.
+	MaxInstances int
+	// MaxInstanceHours is the maximum number of accumulated instance-hours,
+	// including the hours of destroyed instances.
+	MaxInstanceHours float64
+	// MaxSpend is the maximum estimated spend, including the spend of destroyed
+	// instances. Running instances count for at least one hour of their cost,
+	// so new instances must leave room for that.
+	MaxSpend float64
+}
+
+// Instance is an instance recorded in the inventory.
+type Instance struct {
+	// ID uniquely identifies the instance in its project. It is the
+	// reservation ID returned by Guard.Reserve, or the ID the reservation was
+	// rekeyed to, such as the ID of the instance at the provider. Names are
+	// not unique, as several VMs may have the same name.
+	ID           string
+	Name         string
+	InstanceType string
+	Started      time.Time
+	// Stopped is the time the instance was destroyed. It is zero while the
+	// instance exists.
+	Stopped time.Time
+}
+
+// hours returns the number of hours the instance has existed at the given time.
+func (i Instance) hours(now time.Time) float64 {
+	end := i.Stopped
+	if end.IsZero() {
+		end = now
+	}
+	return end.Sub(i.Started).Hours()
+}
+
+// Inventory keeps track of the instances provisioned for each project.
+type Inventory interface {
+	// List returns all the instances recorded for the project.
+	List(project string) ([]Instance, error)
+	// Put records the instance for the project, replacing any instance with
+	// the same ID.
+	Put(project string, instance Instance) error
+	// Delete removes the instance with the given ID from the project.
+	Delete(project string, id string) error
+}
+
+// MemoryInventory is an Inventory kept in memory. It is safe for concurrent use.
+type MemoryInventory struct {
+	mu        sync.Mutex
+	instances map[string][]Instance
+}
+
+// List returns all the instances recorded for the project.
+func (m *MemoryInventory) List(project string) ([]Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Instance(nil), m.instances[project]...), nil
+}
+
+// Put records the instance for the project, replacing any instance with the
+// same ID.
+func (m *MemoryInventory) Put(project string, instance Instance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.instances == nil {
+		m.instances = make(map[string][]Instance)
+	}
+	for i, inst := range m.instances[project] {
+		if inst.ID == instance.ID {
+			m.instances[project][i] = instance
+			return nil
+		}
+	}
+	m.instances[project] = append(m.instances[project], instance)
+	return nil
+}
+
+// Delete removes the instance with the given ID from the project.
+func (m *MemoryInventory) Delete(project string, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, inst := range m.instances[project] {
+		if inst.ID == id {
+			m.instances[project] = append(m.instances[project][:i], m.instances[project][i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// CostEstimator estimates the cost of running instances.
+type CostEstimator interface {
+	// HourlyCost returns the estimated cost of running an instance of the
+	// given type for one hour.
+	HourlyCost(instanceType string) (float64, error)
+}
+
+// PriceTable is a CostEstimator backed by a static map of instance types to
+// hourly prices.
+type PriceTable map[string]float64
+
+// HourlyCost returns the hourly price of the instance type. An error is
+// returned if the instance type is not in the table.
+func (p PriceTable) HourlyCost(instanceType string) (float64, error) {
+	price, ok := p[instanceType]
+	if !ok {
+		return 0, fmt.Errorf("no price known for instance type %q", instanceType)
+	}
+	return price, nil
+}
+
+// Guard checks provisioning requests against the limits of their project. It
+// is safe for concurrent use.
+type Guard struct {
+	// Inventory records the instances of each project. Required.
+	Inventory Inventory
+	// Estimator estimates the spend of instances. It is required only if a
+	// project has a MaxSpend limit.
+	Estimator CostEstimator
+	// Limits maps project names to their limits. Projects without limits are
+	// not restricted.
+	Limits map[string]Limits
+
+	mu sync.Mutex
+}
+
+// Reserve records a new instance for the project if it fits the limits of the
+// project, and returns its unique reservation ID. It returns ErrBudgetExceeded
+// if it does not fit.
+func (g *Guard) Reserve(project string, name string, instanceType string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	instances, err := g.Inventory.List(project)
+	if err != nil {
+		return "", fmt.Errorf("failed to list the inventory of project %q: %s", project, err)
+	}
+
+	now := time.Now()
+	if limits, ok := g.Limits[project]; ok {
+		if err := g.check(project, limits, instances, instanceType, now); err != nil {
+			return "", err
+		}
+	}
+
+	id := uuid.Variant4().String()
+	if err := g.Inventory.Put(project, Instance{ID: id, Name: name, InstanceType: instanceType, Started: now}); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Rekey changes the ID of the instance of the project, typically from its
+// reservation ID to the ID of the instance at the provider once it is
+// launched, so that it can be released by that ID.
+func (g *Guard) Rekey(project string, id string, newID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	instances, err := g.Inventory.List(project)
+	if err != nil {
+		return fmt.Errorf("failed to list the inventory of project %q: %s", project, err)
+	}
+
+	for _, inst := range instances {
+		if inst.ID == newID {
+			return fmt.Errorf("instance %q already recorded for project %q", newID, project)
+		}
+	}
+	for _, inst := range instances {
+		if inst.ID == id {
+			inst.ID = newID
+			if err := g.Inventory.Put(project, inst); err != nil {
+				return err
+			}
+			return g.Inventory.Delete(project, id)
+		}
+	}
+	return fmt.Errorf("instance %q not recorded for project %q", id, project)
+}
+
+// Release marks the instance of the project with the given ID as destroyed.
+// Its instance-hours and spend keep counting against the limits of the
+// project.
+func (g *Guard) Release(project string, id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	instances, err := g.Inventory.List(project)
+	if err != nil {
+		return fmt.Errorf("failed to list the inventory of project %q: %s", project, err)
+	}
+
+	for _, inst := range instances {
+		if inst.ID == id && inst.Stopped.IsZero() {
+			inst.Stopped = time.Now()
+			return g.Inventory.Put(project, inst)
+		}
+	}
+	return nil
+}
+
+// check returns ErrBudgetExceeded if a new instance of the given type does not
+// fit the limits.
+func (g *Guard) check(project string, limits Limits, instances []Instance, instanceType string, now time.Time) error {
+	var running int
+	var hours, spend float64
+	for _, inst := range instances {
+		if inst.Stopped.IsZero() {
+			running++
+		}
+		h := inst.hours(now)
+		hours += h
+
+		if limits.MaxSpend > 0 {
+			cost, err := g.hourlyCost(inst.InstanceType)
+			if err != nil {
+				return err
+			}
+			// Running instances are committed to at least one hour.
+			if inst.Stopped.IsZero() && h < 1 {
+				h = 1
+			}
+			spend += h * cost
+		}
+	}
+
+	if limits.MaxInstances > 0 && running+1 > limits.MaxInstances {
+		return ErrBudgetExceeded{Project: project, Limit: LimitInstances, Current: float64(running), Max: float64(limits.MaxInstances)}
+	}
+
+	if limits.MaxInstanceHours > 0 && hours >= limits.MaxInstanceHours {
+		return ErrBudgetExceeded{Project: project, Limit: LimitInstanceHours, Current: hours, Max: limits.MaxInstanceHours}
+	}
+
+	if limits.MaxSpend > 0 {
+		cost, err := g.hourlyCost(instanceType)
+		if err != nil {
+			return err
+		}
+		if spend+cost > limits.MaxSpend {
+			return ErrBudgetExceeded{Project: project, Limit: LimitSpend, Current: spend, Max: limits.MaxSpend}
+		}
+	}
+
+	return nil
+}
+
+func (g *Guard) hourlyCost(instanceType string) (float64, error) {
+	if g.Estimator == nil {
+		return 0, fmt.Errorf("a cost estimator is required to enforce a spend limit")
+	}
+	return g.Estimator.HourlyCost(instanceType)
+}
+
+// VM wraps a VirtualMachine so that Provision consults the Guard first and
+// returns ErrBudgetExceeded instead of provisioning beyond the limits of the
+// project. Destroy releases the instance in the Guard.
+type VM struct {
+	lvm.VirtualMachine
+
+	Guard        *Guard
+	Project      string
+	InstanceType string
+	// InstanceID [optional] returns the ID of the instance at the provider
+	// once it is provisioned, such as the instance ID of an AWS VM. The
+	// reservation is rekeyed to it, so that it can be released by another
+	// process knowing only the instance.
+	InstanceID func() string
+
+	// ReservationID is the ID of the instance in the Guard, set by
+	// Provision.
+	ReservationID string
+}
+
+// Provision reserves the VM in the Guard and provisions it. The reservation is
+// released if provisioning fails.
+func (vm *VM) Provision() error {
+	id, err := vm.Guard.Reserve(vm.Project, vm.GetName(), vm.InstanceType)
+	if err != nil {
+		return err
+	}
+	vm.ReservationID = id
+
+	if err := vm.VirtualMachine.Provision(); err != nil {
+		if errRelease := vm.Guard.Release(vm.Project, vm.ReservationID); errRelease != nil {
+			return lvm.WrapErrors(err, errRelease)
+		}
+		return err
+	}
+
+	if vm.InstanceID != nil {
+		if instanceID := vm.InstanceID(); instanceID != "" {
+			if err := vm.Guard.Rekey(vm.Project, vm.ReservationID, instanceID); err != nil {
+				return err
+			}
+			vm.ReservationID = instanceID
+		}
+	}
+	return nil
+}
+
+// Destroy destroys the VM and releases it in the Guard.
+func (vm *VM) Destroy() error {
+	if err := vm.VirtualMachine.Destroy(); err != nil {
+		return err
+	}
+	return vm.Guard.Release(vm.Project, vm.ReservationID)
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package budget
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apcera/libretto/virtualmachine/mockprovider"
+)
+
+func newMockVM(g *Guard, name string) *VM {
+	return &VM{
+		VirtualMachine: &mockprovider.VM{
+			MockGetName:   func() string { return name },
+			MockProvision: func() error { return nil },
+			MockDestroy:   func() error { return nil },
+		},
+		Guard:        g,
+		Project:      "test",
+		InstanceType: "m4.large",
+	}
+}
+
+// TestMaxInstances tests that Provision is refused once the maximum number of
+// concurrent instances is reached, and allowed again after a Destroy.
+func TestMaxInstances(t *testing.T) {
+	g := &Guard{
+		Inventory: &MemoryInventory{},
+		Limits:    map[string]Limits{"test": {MaxInstances: 2}},
+	}
+
+	var vms []*VM
+	for i := 0; i < 2; i++ {
+		vm := newMockVM(g, fmt.Sprintf("vm-%d", i))
+		if err := vm.Provision(); err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+		vms = append(vms, vm)
+	}
+
+	err := newMockVM(g, "vm-2").Provision()
+	if e, ok := err.(ErrBudgetExceeded); !ok || e.Limit != LimitInstances {
+		t.Fatalf("Expected an instances ErrBudgetExceeded, got %v", err)
+	}
+
+	if err := vms[0].Destroy(); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if err := newMockVM(g, "vm-2").Provision(); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+}
+
+// TestMaxSpend tests that the spend of existing and destroyed instances counts
+// against the spend limit.
+func TestMaxSpend(t *testing.T) {
+	inv := &MemoryInventory{}
+	inv.Put("test", Instance{
+		ID:           "old",
+		Name:         "old",
+		InstanceType: "m4.large",
+		Started:      time.Now().Add(-10 * time.Hour),
+		Stopped:      time.Now().Add(-90 * time.Minute),
+	})
+	g := &Guard{
+		Inventory: inv,
+		Estimator: PriceTable{"m4.large": 1},
+		Limits:    map[string]Limits{"test": {MaxSpend: 10}},
+	}
+
+	if err := newMockVM(g, "vm-0").Provision(); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+
+	err := newMockVM(g, "vm-1").Provision()
+	if e, ok := err.(ErrBudgetExceeded); !ok || e.Limit != LimitSpend {
+		t.Fatalf("Expected a spend ErrBudgetExceeded, got %v", err)
+	}
+
+	g.Estimator = PriceTable{}
+	if err := newMockVM(g, "vm-1").Provision(); err == nil {
+		t.Fatalf("Expected an error for an unknown instance type")
+	}
+}
+
+// TestSameName tests that VMs with the same name are reserved and released
+// independently, by their reservation or instance ID.
+func TestSameName(t *testing.T) {
+	g := &Guard{
+		Inventory: &MemoryInventory{},
+		Limits:    map[string]Limits{"test": {MaxInstances: 2}},
+	}
+
+	first, second := newMockVM(g, "vm"), newMockVM(g, "vm")
+	second.InstanceID = func() string { return "i-123" }
+	for _, vm := range []*VM{first, second} {
+		if err := vm.Provision(); err != nil {
+			t.Fatalf("Expected nil error, got %s", err)
+		}
+	}
+	if first.ReservationID == "" || second.ReservationID != "i-123" {
+		t.Fatalf("Expected distinct reservation IDs, got %q and %q", first.ReservationID, second.ReservationID)
+	}
+
+	if err := second.Destroy(); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	instances, _ := g.Inventory.List("test")
+	for _, inst := range instances {
+		if stopped := !inst.Stopped.IsZero(); stopped != (inst.ID == "i-123") {
+			t.Fatalf("Expected only i-123 to be released, got %+v", instances)
+		}
+	}
+}
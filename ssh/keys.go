@@ -8,16 +8,23 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	gossh "golang.org/x/crypto/ssh"
 )
 
+// trustedUserCAKeysPath is the path on the guest the certificate authority
+// public key is written to by TrustedUserCAUserData.
+const trustedUserCAKeysPath = "/etc/ssh/trusted_user_ca_keys.pub"
+
 // NewKeyPair generates a new SSH keypair. This will return a private & public key encoded as DER.
 func NewKeyPair() (keyPair *KeyPair, err error) {
 	priv, err := rsa.GenerateKey(rand.Reader, 2048)
@@ -42,6 +49,87 @@ func NewKeyPair() (keyPair *KeyPair, err error) {
 	}, nil
 }
 
+// SignCertificate signs the public key, in authorized_keys format, with the
+// PEM encoded private key of a certificate authority. It returns a user
+// certificate in authorized_keys format, valid for the given principals from
+// now until validity elapses. At least one principal is required, as sshd
+// rejects user certificates without principals.
+func SignCertificate(caPrivateKey []byte, publicKey []byte, principals []string, validity time.Duration) ([]byte, error) {
+	if len(principals) == 0 {
+		return nil, ErrNoPrincipals
+	}
+
+	ca, err := gossh.ParsePrivateKey(caPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(publicKey)
+	if err != nil {
+		return nil, ErrPublicKey
+	}
+
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cert := &gossh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        gossh.UserCert,
+		KeyId:           strings.Join(principals, ","),
+		ValidPrincipals: principals,
+		// Allow for some clock skew between the signer and the guest.
+		ValidAfter:  uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore: uint64(now.Add(validity).Unix()),
+		Permissions: gossh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":              "",
+				"permit-port-forwarding":  "",
+				"permit-agent-forwarding": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+
+	return gossh.MarshalAuthorizedKey(cert), nil
+}
+
+// ParseCertificate parses an SSH certificate in authorized_keys format.
+func ParseCertificate(certificate []byte) (*gossh.Certificate, error) {
+	pub, _, _, _, err := gossh.ParseAuthorizedKey(certificate)
+	if err != nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	cert, ok := pub.(*gossh.Certificate)
+	if !ok {
+		return nil, ErrInvalidCertificate
+	}
+	return cert, nil
+}
+
+// TrustedUserCAUserData returns cloud-init user data that configures sshd on
+// the guest to trust user certificates signed by the certificate authority
+// with the given public key, in authorized_keys format.
+func TrustedUserCAUserData(caPublicKey []byte) []byte {
+	key := strings.TrimSpace(string(caPublicKey))
+	return []byte(fmt.Sprintf(`#cloud-config
+write_files:
+  - path: %[1]s
+    permissions: '0644'
+    content: |
+      %[2]s
+runcmd:
+  - grep -q '^TrustedUserCAKeys' /etc/ssh/sshd_config || echo 'TrustedUserCAKeys %[1]s' >> /etc/ssh/sshd_config
+  - systemctl restart sshd || systemctl restart ssh || service ssh restart
+`, trustedUserCAKeysPath, key))
+}
+
 // KeyPair represents a Public and Private keypair.
 type KeyPair struct {
 	PrivateKey []byte
@@ -37,6 +37,14 @@ var (
 	ErrUnableToWriteFile = errors.New("Unable to write file")
 	// ErrNotImplemented is returned when a function is not implemented (typically by the Mock implementation).
 	ErrNotImplemented = errors.New("Operation not implemented")
+	// ErrInvalidCertificate is returned when the SSH certificate cannot be parsed or does not match the private key.
+	ErrInvalidCertificate = errors.New("Invalid SSH certificate")
+	// ErrCertificateExpired is returned when the SSH certificate is not valid at the current time.
+	ErrCertificateExpired = errors.New("SSH certificate is expired or not yet valid")
+	// ErrCertificatePrincipal is returned when the SSH user is not a valid principal of the SSH certificate.
+	ErrCertificatePrincipal = errors.New("SSH user is not a principal of the SSH certificate")
+	// ErrNoPrincipals is returned when an SSH certificate is signed without principals, which sshd rejects.
+	ErrNoPrincipals = errors.New("SSH certificate must have at least one principal")
 )
 
 const (
@@ -68,12 +76,15 @@ type Client interface {
 	GetSSHPassword() string
 }
 
-// Credentials supplies SSH credentials.
+// Credentials supplies SSH credentials. SSHCertificate is optional and, when
+// set, must be a certificate for SSHPrivateKey signed by a certificate
+// authority trusted by the guest, in authorized_keys format.
 type Credentials struct {
-	mu            sync.Mutex
-	SSHUser       string
-	SSHPassword   string
-	SSHPrivateKey string
+	mu             sync.Mutex
+	SSHUser        string
+	SSHPassword    string
+	SSHPrivateKey  string
+	SSHCertificate string
 }
 
 // Options provides SSH options like KeepAlive.
@@ -435,6 +446,49 @@ var readPrivateKey = func(key string) (cssh.AuthMethod, error) {
 	return cssh.PublicKeys(signer), nil
 }
 
+var readCertificate = func(key string, certificate string, principal string) (cssh.AuthMethod, error) {
+	signer, err := cssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ParseCertificate([]byte(certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCertificate(cert, principal, time.Now()); err != nil {
+		return nil, err
+	}
+
+	certSigner, err := cssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	return cssh.PublicKeys(certSigner), nil
+}
+
+// checkCertificate verifies that the certificate is valid at the given time
+// for the given principal.
+func checkCertificate(cert *cssh.Certificate, principal string, now time.Time) error {
+	unixNow := now.Unix()
+	if unixNow < int64(cert.ValidAfter) {
+		return ErrCertificateExpired
+	}
+	if cert.ValidBefore != cssh.CertTimeInfinity && unixNow >= int64(cert.ValidBefore) {
+		return ErrCertificateExpired
+	}
+
+	// A certificate without principals matches no user, as sshd rejects it.
+	for _, p := range cert.ValidPrincipals {
+		if p == principal {
+			return nil
+		}
+	}
+	return ErrCertificatePrincipal
+}
+
 var getAuth = func(c *Credentials, authType string) (cssh.AuthMethod, error) {
 	var (
 		auth cssh.AuthMethod
@@ -445,6 +499,9 @@ var getAuth = func(c *Credentials, authType string) (cssh.AuthMethod, error) {
 	case PasswordAuth:
 		return cssh.Password(c.SSHPassword), nil
 	case KeyAuth:
+		if c.SSHCertificate != "" {
+			return readCertificate(c.SSHPrivateKey, c.SSHCertificate, c.SSHUser)
+		}
 		return readPrivateKey(c.SSHPrivateKey)
 	}
 	return auth, err
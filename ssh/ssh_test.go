@@ -4,6 +4,7 @@ package ssh
 
 import (
 	"testing"
+	"time"
 
 	cssh "golang.org/x/crypto/ssh"
 )
@@ -66,3 +67,50 @@ func TestConnectAuthPrecedence(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// TestSignCertificate tests that a signed certificate is accepted for its
+// principals and rejected for other users or once it expired.
+func TestSignCertificate(t *testing.T) {
+	ca, err := NewKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate CA key pair: %s", err)
+	}
+	user, err := NewKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate user key pair: %s", err)
+	}
+
+	b, err := SignCertificate(ca.PrivateKey, user.PublicKey, []string{"ubuntu"}, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to sign certificate: %s", err)
+	}
+	cert, err := ParseCertificate(b)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+
+	if err := checkCertificate(cert, "ubuntu", time.Now()); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if err := checkCertificate(cert, "root", time.Now()); err != ErrCertificatePrincipal {
+		t.Fatalf("Expected %s, got %v", ErrCertificatePrincipal, err)
+	}
+	if err := checkCertificate(cert, "ubuntu", time.Now().Add(2*time.Hour)); err != ErrCertificateExpired {
+		t.Fatalf("Expected %s, got %v", ErrCertificateExpired, err)
+	}
+
+	if _, err := readCertificate(string(user.PrivateKey), string(b), "ubuntu"); err != nil {
+		t.Fatalf("Expected nil error, got %s", err)
+	}
+	if _, err := ParseCertificate(user.PublicKey); err != ErrInvalidCertificate {
+		t.Fatalf("Expected %s, got %v", ErrInvalidCertificate, err)
+	}
+
+	if _, err := SignCertificate(ca.PrivateKey, user.PublicKey, nil, time.Hour); err != ErrNoPrincipals {
+		t.Fatalf("Expected %s, got %v", ErrNoPrincipals, err)
+	}
+	cert.ValidPrincipals = nil
+	if err := checkCertificate(cert, "ubuntu", time.Now()); err != ErrCertificatePrincipal {
+		t.Fatalf("Expected %s, got %v", ErrCertificatePrincipal, err)
+	}
+}
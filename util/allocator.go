@@ -0,0 +1,144 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var (
+	// ErrNoFreePort is returned when all the ports of an Allocator are in use.
+	ErrNoFreePort = errors.New("no free port left to allocate")
+	// ErrNoFreeSubnet is returned when all the subnets of an Allocator are in
+	// use.
+	ErrNoFreeSubnet = errors.New("no free subnet left to allocate")
+)
+
+// Allocator allocates ports and private /24 subnets of the host to local VMs,
+// such as for SSH port forwarding and host-only networks, so that VMs
+// provisioned concurrently do not conflict. Ports which are in use on the host,
+// and subnets which overlap with the networks of the host, are skipped, so
+// that other processes are not conflicted with either once they use them.
+// Allocations only last as long as the process, so providers pass the ports
+// and subnets their existing VMs use to the Except variants.
+type Allocator struct {
+	// PortMin and PortMax are the range of the ports.
+	PortMin int
+	PortMax int
+	// SubnetMin and SubnetMax are the range of the third octet of the
+	// 192.168.x.0/24 subnets.
+	SubnetMin int
+	SubnetMax int
+
+	mu      sync.Mutex
+	ports   map[int]bool
+	subnets map[int]bool
+}
+
+// DefaultAllocator is the allocator of the local providers. It allocates ports
+// 2200 to 2999, and subnets 192.168.56.0/24 to 192.168.254.0/24.
+var DefaultAllocator = &Allocator{PortMin: 2200, PortMax: 2999, SubnetMin: 56, SubnetMax: 254}
+
+// AllocatePort returns a free TCP port of the host, which is not allocated
+// until it is released.
+func (a *Allocator) AllocatePort() (int, error) {
+	return a.AllocatePortExcept(nil)
+}
+
+// AllocatePortExcept returns a free TCP port of the host like AllocatePort,
+// skipping the used ports as well, such as the forwarded ports of halted VMs
+// which nothing listens on.
+func (a *Allocator) AllocatePortExcept(used map[int]bool) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ports == nil {
+		a.ports = map[int]bool{}
+	}
+	for port := a.PortMin; port <= a.PortMax; port++ {
+		if a.ports[port] || used[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		a.ports[port] = true
+		return port, nil
+	}
+	return 0, ErrNoFreePort
+}
+
+// ReleasePort releases the allocated port.
+func (a *Allocator) ReleasePort(port int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.ports, port)
+}
+
+// AllocateSubnet returns a private /24 subnet which does not overlap with the
+// networks of the host, and which is not allocated until it is released.
+func (a *Allocator) AllocateSubnet() (*net.IPNet, error) {
+	return a.AllocateSubnetExcept(nil)
+}
+
+// AllocateSubnetExcept returns a private /24 subnet like AllocateSubnet, which
+// does not overlap with the used networks either, such as the networks of host
+// interfaces which are down.
+func (a *Allocator) AllocateSubnetExcept(used []*net.IPNet) (*net.IPNet, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range used {
+		addrs = append(addrs, n)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.subnets == nil {
+		a.subnets = map[int]bool{}
+	}
+	for i := a.SubnetMin; i <= a.SubnetMax; i++ {
+		if a.subnets[i] {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(fmt.Sprintf("192.168.%d.0/24", i))
+		if err != nil {
+			return nil, err
+		}
+		if overlaps(subnet, addrs) {
+			continue
+		}
+		a.subnets[i] = true
+		return subnet, nil
+	}
+	return nil, ErrNoFreeSubnet
+}
+
+// ReleaseSubnet releases the allocated subnet.
+func (a *Allocator) ReleaseSubnet(subnet *net.IPNet) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ip := subnet.IP.To4(); ip != nil {
+		delete(a.subnets, int(ip[2]))
+	}
+}
+
+// overlaps returns whether the subnet overlaps with any of the networks.
+func overlaps(subnet *net.IPNet, addrs []net.Addr) bool {
+	for _, addr := range addrs {
+		n, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if n.Contains(subnet.IP) || subnet.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package util
+
+import (
+	"net"
+	"testing"
+)
+
+// TestAllocatePort makes sure ports in use or allocated are skipped.
+func TestAllocatePort(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	used := l.Addr().(*net.TCPAddr).Port
+
+	if used+2 > 65535 {
+		t.Skip("port range out of bounds")
+	}
+	a := &Allocator{PortMin: used, PortMax: used + 2}
+	seen := map[int]bool{}
+	for {
+		port, err := a.AllocatePort()
+		if err == ErrNoFreePort {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Expected to get no errors, got: %s", err)
+		}
+		if port == used || seen[port] {
+			t.Fatalf("Port %d allocated twice or in use", port)
+		}
+		seen[port] = true
+	}
+
+	for port := range seen {
+		a.ReleasePort(port)
+		if p, err := a.AllocatePort(); err != nil || p != port {
+			t.Fatalf("Expected released port %d to be allocated again, got: %d, %v", port, p, err)
+		}
+		break
+	}
+}
+
+// TestAllocateSubnet makes sure allocated subnets are skipped.
+func TestAllocateSubnet(t *testing.T) {
+	a := &Allocator{SubnetMin: 200, SubnetMax: 254}
+	first, err := a.AllocateSubnet()
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	second, err := a.AllocateSubnet()
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if first.String() == second.String() {
+		t.Fatalf("Subnet %s allocated twice", first)
+	}
+	if ones, _ := first.Mask.Size(); ones != 24 {
+		t.Fatalf("Expected a /24 subnet, got: %s", first)
+	}
+
+	a.ReleaseSubnet(first)
+	if again, err := a.AllocateSubnet(); err != nil || again.String() != first.String() {
+		t.Fatalf("Expected released subnet %s to be allocated again, got: %v, %v", first, again, err)
+	}
+}
+
+// TestAllocateExcept makes sure used ports and subnets are skipped.
+func TestAllocateExcept(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	a := &Allocator{PortMin: free, PortMax: free, SubnetMin: 250, SubnetMax: 251}
+	if _, err := a.AllocatePortExcept(map[int]bool{free: true}); err != ErrNoFreePort {
+		t.Fatalf("Expected the used port %d to be skipped, got: %v", free, err)
+	}
+
+	_, used, _ := net.ParseCIDR("192.168.250.1/24")
+	subnet, err := a.AllocateSubnetExcept([]*net.IPNet{used})
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if subnet.String() != "192.168.251.0/24" {
+		t.Fatalf("Expected the used subnet %s to be skipped, got: %s", used, subnet)
+	}
+}
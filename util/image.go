@@ -0,0 +1,122 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IsURL returns whether the image source is an HTTP(S) URL rather than a local
+// path.
+func IsURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// FetchImage returns the absolute local path of the VM image at src, which is
+// a local path or an HTTP(S) URL. URLs are downloaded once into cacheDir, or
+// into a libretto directory of the temporary directory if it is empty. The
+// SHA-256 checksum of the image is verified against checksum, a hex string,
+// if it is not empty.
+func FetchImage(src string, checksum string, cacheDir string) (string, error) {
+	if !IsURL(src) {
+		p, err := filepath.Abs(src)
+		if err != nil {
+			return "", err
+		}
+		if checksum != "" {
+			if err := verifyChecksum(p, checksum); err != nil {
+				return "", err
+			}
+		}
+		return p, nil
+	}
+
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "libretto")
+	}
+	// Keep the images of different URLs with the same file name apart.
+	sum := sha256.Sum256([]byte(src))
+	dir, err := filepath.Abs(filepath.Join(cacheDir, hex.EncodeToString(sum[:8])))
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, path.Base(u.Path))
+
+	if _, err := os.Stat(p); err == nil {
+		if checksum == "" || verifyChecksum(p, checksum) == nil {
+			return p, nil
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := download(src, p, checksum); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// download downloads the URL to the file at p, verifying its checksum if it is
+// not empty. The file is only created once the download completes.
+func download(src string, p string, checksum string) error {
+	resp, err := http.Get(src)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading %s: %s", src, resp.Status)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), ".download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %s", src, err)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); checksum != "" && !strings.EqualFold(actual, checksum) {
+		return fmt.Errorf("sha256 checksum %s of %s does not match %s", actual, src, checksum)
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// verifyChecksum checks that the SHA-256 checksum of the file at p matches the
+// given one.
+func verifyChecksum(p string, checksum string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, checksum) {
+		return fmt.Errorf("sha256 checksum %s of %s does not match %s", actual, p, checksum)
+	}
+	return nil
+}
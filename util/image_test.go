@@ -0,0 +1,51 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestFetchImage makes sure images are downloaded once and verified.
+func TestFetchImage(t *testing.T) {
+	content := []byte("ova")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "libretto-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	for i := 0; i < 2; i++ {
+		p, err := FetchImage(server.URL+"/images/vm.ova", checksum, cacheDir)
+		if err != nil {
+			t.Fatalf("Expected to get no errors, got: %s", err)
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil || string(b) != string(content) {
+			t.Fatalf("Expected the content of the image at %s, got: %q, %v", p, b, err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("Expected the image to be downloaded once, got %d downloads", requests)
+	}
+
+	if _, err := FetchImage(server.URL+"/images/other.ova", "00", cacheDir); err == nil {
+		t.Fatal("Expected an error for a checksum mismatch")
+	}
+}
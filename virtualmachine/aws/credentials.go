@@ -0,0 +1,347 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/go-ini/ini"
+)
+
+const (
+	// webIdentityTokenFileEnv is the env var for the path of the web identity
+	// token file, as set for IAM roles for service accounts.
+	webIdentityTokenFileEnv = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	// roleARNEnv is the env var for the role to assume with the web identity
+	// token.
+	roleARNEnv = "AWS_ROLE_ARN"
+	// roleSessionNameEnv is the env var for the session name of the role
+	// assumed with the web identity token.
+	roleSessionNameEnv = "AWS_ROLE_SESSION_NAME"
+
+	// credentialsExpiryWindow is how long before their expiration temporary
+	// credentials are refreshed.
+	credentialsExpiryWindow = 5 * time.Minute
+)
+
+var (
+	// ErrSSOTokenExpired is returned when the cached SSO access token of the
+	// profile is missing or expired. It is renewed by "aws sso login".
+	ErrSSOTokenExpired = errors.New("Missing or expired AWS SSO token, run aws sso login")
+
+	// sessions caches the sessions by region and credentials, so that
+	// temporary credentials are shared and refreshed across VMs.
+	sessions   = make(map[string]*session.Session)
+	sessionsMu sync.Mutex
+)
+
+// Credentials configures how the AWS provider authenticates. The zero value
+// uses the default credential chain: the environment, a web identity token,
+// the shared config and credentials files, and the instance role.
+type Credentials struct {
+	// Profile [optional] is the named profile of the shared config and
+	// credentials files. It may be an SSO profile or a profile assuming a role.
+	// It takes precedence over a web identity token of the environment.
+	Profile string
+
+	// RoleARN [optional] is a role to assume with the credentials of the chain.
+	// The temporary credentials are refreshed automatically.
+	RoleARN string
+	// ExternalID [optional] is the external ID required by the role.
+	ExternalID string
+	// RoleSessionName [optional] is the session name of the assumed role.
+	RoleSessionName string
+	// Duration [optional] is the duration of the role session. It defaults
+	// to 15 minutes.
+	Duration time.Duration
+}
+
+// getService returns the EC2 client of the region of the VM, authenticated
+// with the credentials of the VM.
+func (vm *VM) getService() (*ec2.EC2, error) {
+	return newService(vm.Region, vm.Credentials, vm.Endpoints)
+}
+
+// newService returns an EC2 client of the region, credentials and endpoints.
+func newService(region string, c Credentials, e Endpoints) (*ec2.EC2, error) {
+	s, err := getSession(region, c, e)
+	if err != nil {
+		return nil, err
+	}
+	return ec2.New(s), nil
+}
+
+// getSession returns the cached session of the region, credentials and
+// endpoints, creating it if needed.
+func getSession(region string, c Credentials, e Endpoints) (*session.Session, error) {
+	region = getRegion(region)
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", region, c.Profile, c.RoleARN, c.ExternalID, c.RoleSessionName, c.Duration, e.key())
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	if s, ok := sessions[key]; ok {
+		return s, nil
+	}
+
+	s, err := newSession(region, c, e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+
+	sessions[key] = s
+	return s, nil
+}
+
+// newSession creates a session with the credentials of the chain, assuming
+// the role of the credentials if set, and the given endpoints.
+func newSession(region string, c Credentials, e Endpoints) (*session.Session, error) {
+	s, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region:                        aws.String(region),
+			CredentialsChainVerboseErrors: aws.Bool(true),
+			HTTPClient:                    &http.Client{Timeout: 30 * time.Second},
+			EndpointResolver:              e.resolver(),
+		},
+		Profile:           c.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The vendored SDK knows neither web identity nor SSO credentials. An
+	// explicit profile takes precedence over the web identity of the
+	// environment.
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		if p := newWebIdentityProvider(s); p != nil && c.Profile == "" {
+			s.Config.Credentials = credentials.NewCredentials(p)
+		} else if p, err := newSSOProvider(c.Profile); err != nil {
+			return nil, err
+		} else if p != nil {
+			s.Config.Credentials = credentials.NewCredentials(p)
+		}
+	}
+
+	if c.RoleARN != "" {
+		s.Config.Credentials = stscreds.NewCredentials(s, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			if c.ExternalID != "" {
+				p.ExternalID = aws.String(c.ExternalID)
+			}
+			if c.RoleSessionName != "" {
+				p.RoleSessionName = c.RoleSessionName
+			}
+			if c.Duration > 0 {
+				p.Duration = c.Duration
+			}
+			p.ExpiryWindow = credentialsExpiryWindow
+		})
+	}
+
+	return s, nil
+}
+
+// webIdentityProvider retrieves temporary credentials by assuming a role with
+// a web identity token, as set up by IAM roles for service accounts.
+type webIdentityProvider struct {
+	credentials.Expiry
+
+	svc             *sts.STS
+	tokenFile       string
+	roleARN         string
+	roleSessionName string
+}
+
+// newWebIdentityProvider returns a web identity provider if the web identity
+// env vars are set and nil otherwise.
+func newWebIdentityProvider(s *session.Session) *webIdentityProvider {
+	tokenFile := os.Getenv(webIdentityTokenFileEnv)
+	roleARN := os.Getenv(roleARNEnv)
+	if tokenFile == "" || roleARN == "" {
+		return nil
+	}
+
+	name := os.Getenv(roleSessionNameEnv)
+	if name == "" {
+		name = fmt.Sprintf("libretto-%d", time.Now().UnixNano())
+	}
+
+	// AssumeRoleWithWebIdentity requests are not signed.
+	return &webIdentityProvider{
+		svc:             sts.New(s, &aws.Config{Credentials: credentials.AnonymousCredentials}),
+		tokenFile:       tokenFile,
+		roleARN:         roleARN,
+		roleSessionName: name,
+	}
+}
+
+// Retrieve assumes the role with the current token of the token file.
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Failed to read web identity token: %v", err)
+	}
+
+	resp, err := p.svc.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Failed to assume role with web identity: %v", err)
+	}
+
+	p.SetExpiration(*resp.Credentials.Expiration, credentialsExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
+
+// ssoProvider retrieves the role credentials of an SSO profile with the
+// access token cached by "aws sso login".
+type ssoProvider struct {
+	credentials.Expiry
+
+	startURL  string
+	region    string
+	accountID string
+	roleName  string
+}
+
+// newSSOProvider returns an SSO provider if the profile of the shared config
+// file is an SSO profile and nil otherwise.
+func newSSOProvider(profile string) (*ssoProvider, error) {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	section := "default"
+	if profile != "" && profile != "default" {
+		section = "profile " + profile
+	}
+
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		path = filepath.Join(homeDir(), ".aws", "config")
+	}
+	f, err := ini.Load(path)
+	if err != nil {
+		// A missing config file is not an SSO profile.
+		return nil, nil
+	}
+	s, err := f.GetSection(section)
+	if err != nil || s.Key("sso_start_url").String() == "" {
+		return nil, nil
+	}
+
+	p := &ssoProvider{
+		startURL:  s.Key("sso_start_url").String(),
+		region:    s.Key("sso_region").String(),
+		accountID: s.Key("sso_account_id").String(),
+		roleName:  s.Key("sso_role_name").String(),
+	}
+	if p.region == "" || p.accountID == "" || p.roleName == "" {
+		return nil, fmt.Errorf("Incomplete SSO configuration of AWS profile %q", profile)
+	}
+	return p, nil
+}
+
+// Retrieve returns the role credentials of the SSO profile.
+func (p *ssoProvider) Retrieve() (credentials.Value, error) {
+	token, err := p.accessToken()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	query := url.Values{}
+	query.Set("account_id", p.accountID)
+	query.Set("role_name", p.roleName)
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://portal.sso.%s.amazonaws.com/federation/credentials?%s", p.region, query.Encode()), nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("Failed to get SSO role credentials: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return credentials.Value{}, fmt.Errorf("Failed to get SSO role credentials: %s", resp.Status)
+	}
+
+	var result struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			Expiration      int64  `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return credentials.Value{}, fmt.Errorf("Failed to decode SSO role credentials: %v", err)
+	}
+
+	creds := result.RoleCredentials
+	p.SetExpiration(time.Unix(0, creds.Expiration*int64(time.Millisecond)), credentialsExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		ProviderName:    "SSOProvider",
+	}, nil
+}
+
+// accessToken returns the access token cached for the start URL by
+// "aws sso login". ErrSSOTokenExpired is returned if it is missing or
+// expired.
+func (p *ssoProvider) accessToken() (string, error) {
+	hash := sha1.Sum([]byte(p.startURL))
+	path := filepath.Join(homeDir(), ".aws", "sso", "cache", hex.EncodeToString(hash[:])+".json")
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", ErrSSOTokenExpired
+	}
+
+	var cache struct {
+		AccessToken string    `json:"accessToken"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}
+	if err := json.Unmarshal(b, &cache); err != nil {
+		return "", fmt.Errorf("Failed to decode SSO token cache: %v", err)
+	}
+	if cache.AccessToken == "" || time.Now().After(cache.ExpiresAt) {
+		return "", ErrSSOTokenExpired
+	}
+	return cache.AccessToken, nil
+}
+
+// homeDir returns the home directory of the current user.
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	return os.Getenv("USERPROFILE") // windows
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// allocateElasticIP allocates an Elastic IP in the VPC scope and associates it
+// with the instance of the VM. The address is released if the association
+// fails.
+func allocateElasticIP(svc *ec2.EC2, vm *VM) error {
+	addr, err := svc.AllocateAddress(&ec2.AllocateAddressInput{
+		Domain: aws.String(ec2.DomainTypeVpc),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to allocate Elastic IP: %v", err)
+	}
+
+	assoc, err := svc.AssociateAddress(&ec2.AssociateAddressInput{
+		AllocationId: addr.AllocationId,
+		InstanceId:   aws.String(vm.InstanceID),
+	})
+	if err != nil {
+		if errRelease := releaseElasticIP(svc, *addr.AllocationId, ""); errRelease != nil {
+			return fmt.Errorf("Failed to associate Elastic IP: %v, and to release it: %v", err, errRelease)
+		}
+		return fmt.Errorf("Failed to associate Elastic IP: %v", err)
+	}
+
+	vm.ElasticIP = aws.StringValue(addr.PublicIp)
+	vm.ElasticIPAllocationID = aws.StringValue(addr.AllocationId)
+	vm.ElasticIPAssociationID = aws.StringValue(assoc.AssociationId)
+	return nil
+}
+
+// releaseElasticIP disassociates the Elastic IP if the association ID is set
+// and releases it.
+func releaseElasticIP(svc *ec2.EC2, allocationID string, associationID string) error {
+	if associationID != "" {
+		_, err := svc.DisassociateAddress(&ec2.DisassociateAddressInput{
+			AssociationId: aws.String(associationID),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to disassociate Elastic IP: %v", err)
+		}
+	}
+
+	_, err := svc.ReleaseAddress(&ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocationID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to release Elastic IP: %v", err)
+	}
+
+	return nil
+}
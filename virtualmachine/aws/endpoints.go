@@ -0,0 +1,106 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+const (
+	// PartitionAWS is the standard commercial partition.
+	PartitionAWS = "aws"
+	// PartitionChina is the partition of the China regions.
+	PartitionChina = "aws-cn"
+	// PartitionGovCloud is the partition of the AWS GovCloud (US) regions.
+	PartitionGovCloud = "aws-us-gov"
+)
+
+// Endpoints configures the endpoints of the AWS services. The zero value uses
+// the standard endpoints of the region.
+type Endpoints struct {
+	// Partition [optional] is PartitionAWS, PartitionChina or
+	// PartitionGovCloud. It defaults to the partition of the region, which
+	// must be set for regions the SDK does not know yet.
+	Partition string
+	// URL [optional] is the endpoint of all the services, such as the URL of
+	// a local test environment like localstack.
+	URL string
+	// EC2, SSM and STS [optional] are the endpoints of the respective
+	// service, such as VPC interface endpoints. They take precedence over URL.
+	EC2 string
+	SSM string
+	STS string
+}
+
+// key returns a string identifying the endpoints, to cache sessions.
+func (e Endpoints) key() string {
+	return strings.Join([]string{e.Partition, e.URL, e.EC2, e.SSM, e.STS}, "|")
+}
+
+// resolver returns an endpoint resolver which resolves the configured
+// endpoints and falls back to the default resolver.
+func (e Endpoints) resolver() endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		url := e.URL
+		switch service {
+		case endpoints.Ec2ServiceID:
+			if e.EC2 != "" {
+				url = e.EC2
+			}
+		case endpoints.SsmServiceID:
+			if e.SSM != "" {
+				url = e.SSM
+			}
+		case endpoints.StsServiceID:
+			if e.STS != "" {
+				url = e.STS
+			}
+		}
+		if url != "" {
+			return endpoints.ResolvedEndpoint{URL: url, SigningRegion: region}, nil
+		}
+
+		if e.Partition != "" && e.Partition != partitionOf(region) {
+			// The region is unknown to the SDK, which would resolve it in the
+			// standard partition.
+			return endpoints.ResolvedEndpoint{
+				URL:           fmt.Sprintf("https://%s.%s.%s", service, region, dnsSuffix(e.Partition)),
+				SigningRegion: region,
+			}, nil
+		}
+
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
+
+// partitionOf returns the partition of the region, PartitionAWS if the SDK
+// does not know the region.
+func partitionOf(region string) string {
+	p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if !ok {
+		return PartitionAWS
+	}
+	return p.ID()
+}
+
+// dnsSuffix returns the DNS suffix of the endpoints of the partition.
+func dnsSuffix(partition string) string {
+	if partition == PartitionChina {
+		return "amazonaws.com.cn"
+	}
+	return "amazonaws.com"
+}
+
+// consoleURL returns the URL of the AWS console of the partition.
+func consoleURL(partition string, region string) string {
+	switch partition {
+	case PartitionChina:
+		return "https://console.amazonaws.cn"
+	case PartitionGovCloud:
+		return "https://console.amazonaws-us-gov.com"
+	}
+	return fmt.Sprintf("https://%s.console.aws.amazon.com", region)
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/apcera/libretto/ssh"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var (
+	// ErrFleetCount is returned when fewer than one instance is requested.
+	ErrFleetCount = errors.New("Instance count must be at least 1")
+	// ErrFleetSpot is returned when several spot instances are requested.
+	ErrFleetSpot = errors.New("Spot instances cannot be provisioned with ProvisionN")
+	// ErrFleetPrivateIP is returned when several instances are requested with
+	// a specific private IP address or specific IPv6 addresses.
+	ErrFleetPrivateIP = errors.New("Several instances cannot have the same private IP or IPv6 addresses")
+	// ErrFleetCapacity is the error of the instances EC2 did not launch
+	// because of insufficient capacity.
+	ErrFleetCapacity = errors.New("Instance not launched because of insufficient capacity")
+)
+
+// ProvisionResult is the result of the provision of one instance by
+// ProvisionN.
+type ProvisionResult struct {
+	// VM is the provisioned VM. It is nil if the instance was not launched.
+	VM *VM
+	// Err is the error provisioning the instance, if any.
+	Err error
+}
+
+// ProvisionN launches count instances identical to the VM in a single
+// RunInstances call, which is much faster than calling Provision count times.
+// The VMs are named after the VM with a numeric suffix. EC2 may launch fewer
+// instances than requested, in which case the results of the missing
+// instances have the error ErrFleetCapacity. Instances which fail to become
+// ready are terminated, with the error in their result. An error is returned
+// if no instance could be launched. The VMs share the managed security group
+// of the VM, which is deleted by DeleteManagedSecurityGroup rather than by
+// their Destroy.
+func (vm *VM) ProvisionN(count int) ([]ProvisionResult, error) {
+	if count < 1 {
+		return nil, ErrFleetCount
+	}
+	if vm.Spot != nil {
+		return nil, ErrFleetSpot
+	}
+	if count > 1 && (vm.PrivateIPAddress != "" || len(vm.IPv6Addresses) > 0) {
+		return nil, ErrFleetPrivateIP
+	}
+
+	wait() // Avoid the AWS rate limit.
+
+	svc, err := vm.getService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.Hibernation {
+		if err := validateHibernation(vm); err != nil {
+			return nil, err
+		}
+	}
+
+	if vm.RootVolume != nil && vm.RootVolume.DeviceName == "" {
+		vm.RootVolume.DeviceName, err = getRootDeviceName(svc, vm.AMI)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	userData, err := encodeUserData(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if vm.ManagedSecurityGroup != nil && vm.ManagedSecurityGroup.ID == "" {
+		if err := createSecurityGroup(svc, vm); err != nil {
+			return nil, err
+		}
+	}
+
+	in := instanceInfo(vm)
+	in.MinCount = aws.Int64(1)
+	in.MaxCount = aws.Int64(int64(count))
+	in.UserData = userData
+	// The VMs are named after their instance is launched.
+	in.TagSpecifications = tagSpecifications(vm.Tags)
+	params := launchParams(vm)
+
+	req, resp := svc.RunInstancesRequest(in)
+	req.Handlers.Build.PushBack(addQueryParams(params))
+	if err := req.Send(); err != nil {
+		err = fmt.Errorf("Failed to create instances: %v", err)
+		if errDelete := vm.DeleteManagedSecurityGroup(); errDelete != nil {
+			return nil, fmt.Errorf("%v, and %v", err, errDelete)
+		}
+		return nil, err
+	}
+
+	results := make([]ProvisionResult, count)
+	var wg sync.WaitGroup
+	for i := range results {
+		if i >= len(resp.Instances) {
+			results[i].Err = ErrFleetCapacity
+			continue
+		}
+		if !hasInstanceID(resp.Instances[i]) {
+			results[i].Err = ErrNoInstanceID
+			continue
+		}
+
+		member := vm.fleetMember(fmt.Sprintf("%s-%d", vm.Name, i), *resp.Instances[i].InstanceId)
+		results[i].VM = member
+
+		wg.Add(1)
+		go func(result *ProvisionResult) {
+			defer wg.Done()
+			if err := result.VM.finishProvision(svc); err != nil {
+				result.Err = result.VM.abortLaunch(svc, err)
+				return
+			}
+			if err := result.VM.SetTag("Name", result.VM.GetName()); err != nil {
+				result.Err = result.VM.abortLaunch(svc, err)
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// fleetMember returns a VM with the configuration of the VM for the launched
+// instance with the given name and ID.
+func (vm *VM) fleetMember(name string, instanceID string) *VM {
+	return &VM{
+		Name:                          name,
+		Region:                        vm.Region,
+		AMI:                           vm.AMI,
+		InstanceType:                  vm.InstanceType,
+		InstanceID:                    instanceID,
+		KeyPair:                       vm.KeyPair,
+		IamInstanceProfileName:        vm.IamInstanceProfileName,
+		RootVolume:                    vm.RootVolume,
+		Volumes:                       vm.Volumes,
+		KeepRootVolumeOnDestroy:       vm.KeepRootVolumeOnDestroy,
+		DeleteNonRootVolumeOnDestroy:  vm.DeleteNonRootVolumeOnDestroy,
+		VPC:                           vm.VPC,
+		SubnetID:                      vm.SubnetID,
+		SecurityGroupIDs:              vm.SecurityGroupIDs,
+		AssociatePublicIP:             vm.AssociatePublicIP,
+		PlacementGroup:                vm.PlacementGroup,
+		Tenancy:                       vm.Tenancy,
+		HostID:                        vm.HostID,
+		CapacityReservationID:         vm.CapacityReservationID,
+		CapacityReservationPreference: vm.CapacityReservationPreference,
+		IPv6AddressCount:              vm.IPv6AddressCount,
+		AllocateElasticIP:             vm.AllocateElasticIP,
+		Subnet:                        vm.Subnet,
+		SecurityGroups:                vm.SecurityGroups,
+		Credentials:                   vm.Credentials,
+		Endpoints:                     vm.Endpoints,
+		Hibernation:                   vm.Hibernation,
+		Tags:                          vm.Tags,
+		TerminationProtection:         vm.TerminationProtection,
+		StopProtection:                vm.StopProtection,
+		DisableProtectionOnDestroy:    vm.DisableProtectionOnDestroy,
+		UserData:                      vm.UserData,
+		GzipUserData:                  vm.GzipUserData,
+		UseSSM:                        vm.UseSSM,
+		SSMPortForwarding:             vm.SSMPortForwarding,
+		SSHCreds: ssh.Credentials{
+			SSHUser:        vm.SSHCreds.SSHUser,
+			SSHPassword:    vm.SSHCreds.SSHPassword,
+			SSHPrivateKey:  vm.SSHCreds.SSHPrivateKey,
+			SSHCertificate: vm.SSHCreds.SSHCertificate,
+		},
+		DeleteKeysOnDestroy: vm.DeleteKeysOnDestroy,
+		CheckHealthOnSSH:    vm.CheckHealthOnSSH,
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var (
+	// ErrHibernationInstanceType is returned when hibernation is requested
+	// for an instance type which does not support it.
+	ErrHibernationInstanceType = errors.New("Instance type does not support hibernation")
+	// ErrHibernationSpot is returned when hibernation is requested for a spot
+	// instance, which hibernates through its interruption behavior instead.
+	ErrHibernationSpot = errors.New("Spot instances hibernate through InterruptionHibernate")
+)
+
+// hibernationFamilies are the instance families which support hibernation.
+var hibernationFamilies = []string{
+	"c3", "c4", "c5", "c5d", "c6g", "c6i", "c7g", "c7i", "i3", "m3", "m4", "m5",
+	"m5a", "m5ad", "m5d", "m6a", "m6g", "m6i", "m7g", "m7i", "r3", "r4", "r5",
+	"r5a", "r5ad", "r5d", "r6a", "r6g", "r6i", "r7g", "r7i", "t2", "t3", "t3a",
+	"t4g",
+}
+
+// validateHibernation returns an error if the VM cannot be launched with
+// hibernation enabled. The support of the AMI is checked by EC2.
+func validateHibernation(vm *VM) error {
+	if vm.Spot != nil {
+		return ErrHibernationSpot
+	}
+
+	instanceType := vm.InstanceType
+	if instanceType == "" {
+		instanceType = defaultInstanceType
+	}
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	supported := false
+	for _, f := range hibernationFamilies {
+		if family == f {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return ErrHibernationInstanceType
+	}
+
+	// The encryption of the root volume is checked by EC2, as it may come
+	// from the AMI or from the EBS encryption by default of the account.
+	return nil
+}
+
+// Suspend hibernates the VM, saving its memory to its root volume. It
+// requires the VM to be provisioned with Hibernation, otherwise
+// ErrNoSupportSuspend is returned.
+func (vm *VM) Suspend() error {
+	if !vm.Hibernation {
+		return ErrNoSupportSuspend
+	}
+
+	svc, err := vm.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return ErrNoInstanceID
+	}
+
+	// The vendored SDK does not know Hibernate yet.
+	req, _ := svc.StopInstancesRequest(&ec2.StopInstancesInput{
+		InstanceIds: []*string{
+			aws.String(vm.InstanceID),
+		},
+	})
+	req.Handlers.Build.PushBack(addQueryParams(map[string]string{
+		"Hibernate": "true",
+	}))
+	if err := req.Send(); err != nil {
+		return fmt.Errorf("Failed to hibernate instance: %v", err)
+	}
+
+	return nil
+}
+
+// Resume starts a hibernated VM, restoring its memory, and waits until it is
+// running. It requires the VM to be provisioned with Hibernation, otherwise
+// ErrNoSupportResume is returned.
+func (vm *VM) Resume() error {
+	if !vm.Hibernation {
+		return ErrNoSupportResume
+	}
+
+	if err := vm.Start(); err != nil {
+		return err
+	}
+
+	svc, err := vm.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS service: %v", err)
+	}
+	return waitUntilReady(svc, vm.InstanceID)
+}
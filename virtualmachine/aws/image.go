@@ -0,0 +1,88 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// imageWaitAttempts is the number of times the image is checked, every 15
+// seconds, before giving up waiting for it to become available.
+const imageWaitAttempts = 120
+
+// ErrNoImageName is returned when an image is created without a name.
+var ErrNoImageName = errors.New("Missing image name")
+
+// CreateImage creates an AMI from the instance of the VM, including the block
+// device mappings of its volumes, waits for it to become available and tags it
+// with its name. The instance is rebooted for a consistent file system unless
+// noReboot is true. The ID of the AMI is returned, to be used as the AMI of
+// subsequent provisions.
+func (vm *VM) CreateImage(name, description string, noReboot bool) (string, error) {
+	if name == "" {
+		return "", ErrNoImageName
+	}
+
+	svc, err := vm.getService()
+	if err != nil {
+		return "", fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	in := &ec2.CreateImageInput{
+		InstanceId:          aws.String(vm.InstanceID),
+		Name:                aws.String(name),
+		NoReboot:            aws.Bool(noReboot),
+		BlockDeviceMappings: imageBlockDeviceMappings(vm),
+	}
+	if description != "" {
+		in.Description = aws.String(description)
+	}
+
+	resp, err := svc.CreateImage(in)
+	if err != nil {
+		return "", fmt.Errorf("Failed to create image: %v", err)
+	}
+	imageID := aws.StringValue(resp.ImageId)
+
+	err = svc.WaitUntilImageAvailableWithContext(aws.BackgroundContext(), &ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageID)},
+	}, request.WithWaiterMaxAttempts(imageWaitAttempts))
+	if err != nil {
+		return imageID, fmt.Errorf("Failed waiting for image %s: %v", imageID, err)
+	}
+
+	_, err = svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(imageID)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("Name"),
+				Value: aws.String(name)},
+		},
+	})
+	if err != nil {
+		return imageID, fmt.Errorf("Failed to create tag on image: %v", err)
+	}
+
+	return imageID, nil
+}
+
+// imageBlockDeviceMappings returns the block device mappings of the volumes of
+// the VM for a new image. The snapshots are taken from the volumes of the
+// instance, so the snapshot IDs and the encryption are left to EC2.
+func imageBlockDeviceMappings(vm *VM) []*ec2.BlockDeviceMapping {
+	devices := blockDeviceMappings(vm)
+	for _, device := range devices {
+		device.Ebs.SnapshotId = nil
+		device.Ebs.Encrypted = nil
+	}
+	return devices
+}
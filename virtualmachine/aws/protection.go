@@ -0,0 +1,85 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// EnableTerminationProtection prevents the instance of the VM from being
+// terminated through the API until the protection is disabled.
+func (vm *VM) EnableTerminationProtection() error {
+	return vm.setTerminationProtection(true)
+}
+
+// DisableTerminationProtection allows the instance of the VM to be terminated
+// again.
+func (vm *VM) DisableTerminationProtection() error {
+	return vm.setTerminationProtection(false)
+}
+
+// EnableStopProtection prevents the instance of the VM from being stopped
+// through the API until the protection is disabled.
+func (vm *VM) EnableStopProtection() error {
+	return vm.setStopProtection(true)
+}
+
+// DisableStopProtection allows the instance of the VM to be stopped again.
+func (vm *VM) DisableStopProtection() error {
+	return vm.setStopProtection(false)
+}
+
+func (vm *VM) setTerminationProtection(enabled bool) error {
+	svc, err := vm.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return ErrNoInstanceID
+	}
+
+	_, err = svc.ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(vm.InstanceID),
+		DisableApiTermination: &ec2.AttributeBooleanValue{
+			Value: aws.Bool(enabled),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to set termination protection: %v", err)
+	}
+
+	vm.TerminationProtection = enabled
+	return nil
+}
+
+func (vm *VM) setStopProtection(enabled bool) error {
+	svc, err := vm.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return ErrNoInstanceID
+	}
+
+	// The vendored SDK does not know DisableApiStop yet.
+	req, _ := svc.ModifyInstanceAttributeRequest(&ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(vm.InstanceID),
+	})
+	req.Handlers.Build.PushBack(addQueryParams(map[string]string{
+		"DisableApiStop.Value": strconv.FormatBool(enabled),
+	}))
+	if err := req.Send(); err != nil {
+		return fmt.Errorf("Failed to set stop protection: %v", err)
+	}
+
+	vm.StopProtection = enabled
+	return nil
+}
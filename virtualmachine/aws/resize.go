@@ -0,0 +1,118 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/apcera/libretto/ssh"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var (
+	// ErrResizeInstanceStore is returned when resizing an instance with an
+	// instance store root volume, which cannot be stopped.
+	ErrResizeInstanceStore = errors.New("Instances with an instance store root volume cannot be resized")
+	// ErrResizeENA is returned when resizing an instance without ENA support to
+	// an instance type which requires it.
+	ErrResizeENA = errors.New("Instance type requires ENA support, which the instance does not have")
+)
+
+// enaFamilies are the prefixes of the instance families which require ENA
+// support, such as the families of the Nitro system.
+var enaFamilies = []string{
+	"a1", "c5", "c6", "c7", "d3", "g4", "g5", "g6", "hpc", "i3en", "i4", "im4",
+	"inf", "is4", "m5", "m6", "m7", "mac", "p3dn", "p4", "p5", "r5", "r6", "r7",
+	"t3", "t4", "trn", "u-", "x2", "z1d",
+}
+
+// requiresENA returns true if the instance type requires ENA support.
+func requiresENA(instanceType string) bool {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	for _, prefix := range enaFamilies {
+		if strings.HasPrefix(family, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resize changes the instance type of the VM. The instance is stopped, its
+// type is modified and it is started again, after which Resize waits until SSH
+// is available. An error is returned if the instance has an instance store
+// root volume or if the instance type requires ENA support which the instance
+// lacks. A running instance is started again if its type cannot be modified.
+func (vm *VM) Resize(instanceType string) error {
+	svc, err := vm.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return ErrNoInstanceID
+	}
+
+	resp, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(vm.InstanceID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to describe instance: %s", err)
+	}
+	if len(resp.Reservations) < 1 || len(resp.Reservations[0].Instances) < 1 {
+		return ErrNoInstance
+	}
+	inst := resp.Reservations[0].Instances[0]
+
+	if aws.StringValue(inst.RootDeviceType) != ec2.DeviceTypeEbs {
+		return ErrResizeInstanceStore
+	}
+	if requiresENA(instanceType) && !aws.BoolValue(inst.EnaSupport) {
+		return ErrResizeENA
+	}
+
+	ids := []*string{aws.String(vm.InstanceID)}
+	if _, err := svc.StopInstances(&ec2.StopInstancesInput{InstanceIds: ids}); err != nil {
+		return fmt.Errorf("Failed to stop instance: %v", err)
+	}
+	if err := svc.WaitUntilInstanceStopped(&ec2.DescribeInstancesInput{InstanceIds: ids}); err != nil {
+		return fmt.Errorf("Failed waiting for instance to stop: %v", err)
+	}
+
+	_, err = svc.ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(vm.InstanceID),
+		InstanceType: &ec2.AttributeValue{
+			Value: aws.String(instanceType),
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("Failed to modify instance type: %v", err)
+		// Restart the instance with its previous type if it was running.
+		if inst.State == nil || aws.StringValue(inst.State.Name) != ec2.InstanceStateNameRunning {
+			return err
+		}
+		if _, errStart := svc.StartInstances(&ec2.StartInstancesInput{InstanceIds: ids}); errStart != nil {
+			return fmt.Errorf("%v, and failed to restart instance: %v", err, errStart)
+		}
+		if errStart := waitUntilReady(svc, vm.InstanceID); errStart != nil {
+			return fmt.Errorf("%v, and %v", err, errStart)
+		}
+		return err
+	}
+	vm.InstanceType = instanceType
+
+	if _, err := svc.StartInstances(&ec2.StartInstancesInput{InstanceIds: ids}); err != nil {
+		return fmt.Errorf("Failed to start instance: %v", err)
+	}
+	if err := waitUntilReady(svc, vm.InstanceID); err != nil {
+		return err
+	}
+
+	// The public IP changes unless it is an Elastic IP, so it is looked up
+	// again.
+	_, err = vm.GetSSH(ssh.Options{})
+	return err
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apcera/util/uuid"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// managedTag is the tag of the resources created and deleted by libretto.
+const managedTag = "libretto:managed"
+
+// ManagedSecurityGroup represents a security group created for the VM by
+// Provision and deleted by Destroy.
+type ManagedSecurityGroup struct {
+	// IngressRules are the rules of the inbound traffic allowed to the VM.
+	// All outbound traffic is allowed.
+	IngressRules []IngressRule
+	// ID is the ID of the security group. It is set by Provision.
+	ID string
+}
+
+// IngressRule represents a rule of the inbound traffic allowed by a security
+// group.
+type IngressRule struct {
+	// Protocol is "tcp", "udp", "icmp" or "-1" for all protocols.
+	Protocol string
+	// FromPort and ToPort are the port range of TCP and UDP, or the ICMP type
+	// and code.
+	FromPort int
+	ToPort   int
+	// CIDR is the IPv4 or IPv6 source range, such as "0.0.0.0/0".
+	CIDR string
+}
+
+// createSecurityGroup creates the managed security group of the VM in the VPC
+// of the VM, or of its subnet, and authorizes its ingress rules. The group is
+// deleted if the rules cannot be authorized.
+func createSecurityGroup(svc *ec2.EC2, vm *VM) error {
+	group := vm.ManagedSecurityGroup
+
+	vpcID, err := getVPCID(svc, vm)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("libretto-%s", uuid.Variant4())
+	in := &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(name),
+		Description: aws.String("Managed by libretto"),
+	}
+	if vpcID != "" {
+		in.VpcId = aws.String(vpcID)
+	}
+	resp, err := svc.CreateSecurityGroup(in)
+	if err != nil {
+		return fmt.Errorf("Failed to create security group: %v", err)
+	}
+	id := aws.StringValue(resp.GroupId)
+
+	if err := authorizeIngress(svc, id, group.IngressRules); err != nil {
+		if errDelete := deleteSecurityGroup(svc, id); errDelete != nil {
+			return fmt.Errorf("%v, and failed to delete the security group: %v", err, errDelete)
+		}
+		return err
+	}
+
+	_, err = svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{aws.String(id)},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("Name"),
+				Value: aws.String(name)},
+			{Key: aws.String(managedTag),
+				Value: aws.String("true")},
+		},
+	})
+	if err != nil {
+		if errDelete := deleteSecurityGroup(svc, id); errDelete != nil {
+			return fmt.Errorf("Failed to create tag on security group: %v, and to delete it: %v", err, errDelete)
+		}
+		return fmt.Errorf("Failed to create tag on security group: %v", err)
+	}
+
+	group.ID = id
+	return nil
+}
+
+// DeleteManagedSecurityGroup deletes the managed security group of the VM, such
+// as the group shared by the VMs provisioned by ProvisionN once they are
+// destroyed. Destroy deletes the group of a VM provisioned by Provision.
+func (vm *VM) DeleteManagedSecurityGroup() error {
+	if vm.ManagedSecurityGroup == nil || vm.ManagedSecurityGroup.ID == "" {
+		return nil
+	}
+
+	svc, err := vm.getService()
+	if err != nil {
+		return fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if err := deleteSecurityGroup(svc, vm.ManagedSecurityGroup.ID); err != nil {
+		return err
+	}
+	vm.ManagedSecurityGroup.ID = ""
+	return nil
+}
+
+// authorizeIngress authorizes the ingress rules in the security group.
+func authorizeIngress(svc *ec2.EC2, groupID string, rules []IngressRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	perms := make([]*ec2.IpPermission, 0, len(rules))
+	for _, rule := range rules {
+		perm := &ec2.IpPermission{
+			IpProtocol: aws.String(rule.Protocol),
+			FromPort:   aws.Int64(int64(rule.FromPort)),
+			ToPort:     aws.Int64(int64(rule.ToPort)),
+		}
+		if strings.Contains(rule.CIDR, ":") {
+			perm.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String(rule.CIDR)}}
+		} else {
+			perm.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(rule.CIDR)}}
+		}
+		perms = append(perms, perm)
+	}
+
+	_, err := svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(groupID),
+		IpPermissions: perms,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to authorize security group ingress: %v", err)
+	}
+	return nil
+}
+
+// deleteSecurityGroup deletes the security group. It fails while instances
+// use it.
+func deleteSecurityGroup(svc *ec2.EC2, groupID string) error {
+	_, err := svc.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+		GroupId: aws.String(groupID),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete security group: %v", err)
+	}
+	return nil
+}
+
+// getVPCID returns the VPC of the VM, or the VPC of its subnet. It returns an
+// empty string for the default VPC.
+func getVPCID(svc *ec2.EC2, vm *VM) (string, error) {
+	if vm.VPC != "" {
+		return vm.VPC, nil
+	}
+
+	subnetID := vm.SubnetID
+	if subnetID == "" {
+		subnetID = vm.Subnet
+	}
+	if subnetID == "" {
+		return "", nil
+	}
+
+	resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: []*string{aws.String(subnetID)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to describe subnet: %v", err)
+	}
+	if len(resp.Subnets) < 1 {
+		return "", fmt.Errorf("Missing subnet %s", subnetID)
+	}
+	return aws.StringValue(resp.Subnets[0].VpcId), nil
+}
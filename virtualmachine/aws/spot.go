@@ -0,0 +1,205 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// InterruptionTerminate terminates a spot instance when it is interrupted.
+	InterruptionTerminate = "terminate"
+	// InterruptionStop stops a spot instance when it is interrupted. It
+	// requires a persistent request.
+	InterruptionStop = "stop"
+	// InterruptionHibernate hibernates a spot instance when it is interrupted.
+	// It requires a persistent request.
+	InterruptionHibernate = "hibernate"
+)
+
+var (
+	// ErrNoSpotPrice is returned when a spot instance is requested without a
+	// maximum price.
+	ErrNoSpotPrice = errors.New("Missing spot instance maximum price")
+	// ErrSpotInterruption is returned when an interruption behavior other than
+	// terminate is requested for a one-time spot instance request.
+	ErrSpotInterruption = errors.New("Spot instances can only be stopped or hibernated with a persistent request")
+	// ErrSpotCapacityReservation is returned when a spot instance is requested
+	// into a capacity reservation.
+	ErrSpotCapacityReservation = errors.New("Spot instances cannot be launched into a capacity reservation")
+)
+
+// SpotOptions represents the options to provision a spot instance instead of
+// an on-demand instance.
+type SpotOptions struct {
+	// MaxPrice is the maximum hourly price to pay for the instance, such as
+	// "0.05". Required.
+	MaxPrice string
+	// Persistent makes the request persistent, so that an interrupted instance
+	// is relaunched until the request is canceled on Destroy. Otherwise the
+	// request is one-time.
+	Persistent bool
+	// InterruptionBehavior is what happens to an interrupted instance:
+	// InterruptionTerminate (the default), InterruptionStop or
+	// InterruptionHibernate.
+	InterruptionBehavior string
+}
+
+// requestSpotInstance requests a spot instance with the launch specification
+// of the VM, waits until the request is fulfilled and returns the ID of the
+// request and of the instance. The request is canceled if it is not fulfilled.
+// The given query parameters of the RunInstances input are added to the launch
+// specification.
+func requestSpotInstance(svc *ec2.EC2, vm *VM, in *ec2.RunInstancesInput, params map[string]string) (string, string, error) {
+	spot := vm.Spot
+	if spot.MaxPrice == "" {
+		return "", "", ErrNoSpotPrice
+	}
+
+	requestType := ec2.SpotInstanceTypeOneTime
+	if spot.Persistent {
+		requestType = ec2.SpotInstanceTypePersistent
+	}
+	if spot.InterruptionBehavior != "" && spot.InterruptionBehavior != InterruptionTerminate && !spot.Persistent {
+		return "", "", ErrSpotInterruption
+	}
+	if vm.CapacityReservationID != "" {
+		return "", "", ErrSpotCapacityReservation
+	}
+
+	req, resp := svc.RequestSpotInstancesRequest(&ec2.RequestSpotInstancesInput{
+		InstanceCount:       aws.Int64(instanceCount),
+		SpotPrice:           aws.String(spot.MaxPrice),
+		Type:                aws.String(requestType),
+		LaunchSpecification: spotLaunchSpecification(in),
+	})
+	spotParams := make(map[string]string)
+	for k, v := range params {
+		// Only the block device mappings are part of a launch specification.
+		if strings.HasPrefix(k, "BlockDeviceMapping.") {
+			spotParams["LaunchSpecification."+k] = v
+		}
+	}
+	if spot.InterruptionBehavior != "" {
+		spotParams["InstanceInterruptionBehavior"] = spot.InterruptionBehavior
+	}
+	req.Handlers.Build.PushBack(addQueryParams(spotParams))
+	if err := req.Send(); err != nil {
+		return "", "", fmt.Errorf("Failed to request spot instance: %v", err)
+	}
+
+	if len(resp.SpotInstanceRequests) < 1 || resp.SpotInstanceRequests[0].SpotInstanceRequestId == nil {
+		return "", "", errors.New("Missing spot instance request ID")
+	}
+	requestID := *resp.SpotInstanceRequests[0].SpotInstanceRequestId
+
+	describe := &ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{aws.String(requestID)},
+	}
+	err := svc.WaitUntilSpotInstanceRequestFulfilled(describe)
+	if err == nil {
+		var out *ec2.DescribeSpotInstanceRequestsOutput
+		out, err = svc.DescribeSpotInstanceRequests(describe)
+		if err == nil {
+			if len(out.SpotInstanceRequests) > 0 && out.SpotInstanceRequests[0].InstanceId != nil {
+				return requestID, *out.SpotInstanceRequests[0].InstanceId, nil
+			}
+			err = ErrNoInstanceID
+		}
+	}
+
+	if errCancel := cancelSpotInstanceRequest(svc, requestID); errCancel != nil {
+		return "", "", fmt.Errorf("Failed waiting for spot instance request %s: %v: %v", requestID, err, errCancel)
+	}
+	return "", "", fmt.Errorf("Failed waiting for spot instance request %s: %v", requestID, err)
+}
+
+// cancelSpotInstanceRequest cancels the spot instance request with the given
+// ID. It does not terminate the instance launched by the request.
+func cancelSpotInstanceRequest(svc *ec2.EC2, requestID string) error {
+	_, err := svc.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{aws.String(requestID)},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to cancel spot instance request: %v", err)
+	}
+	return nil
+}
+
+// spotLaunchSpecification returns the spot launch specification equivalent to
+// the given RunInstances input.
+func spotLaunchSpecification(in *ec2.RunInstancesInput) *ec2.RequestSpotLaunchSpecification {
+	spec := &ec2.RequestSpotLaunchSpecification{
+		BlockDeviceMappings: in.BlockDeviceMappings,
+		EbsOptimized:        in.EbsOptimized,
+		IamInstanceProfile:  in.IamInstanceProfile,
+		ImageId:             in.ImageId,
+		InstanceType:        in.InstanceType,
+		KeyName:             in.KeyName,
+		Monitoring:          in.Monitoring,
+		NetworkInterfaces:   in.NetworkInterfaces,
+		SecurityGroupIds:    in.SecurityGroupIds,
+		SubnetId:            in.SubnetId,
+		UserData:            in.UserData,
+	}
+	if in.Placement != nil {
+		spec.Placement = &ec2.SpotPlacement{
+			AvailabilityZone: in.Placement.AvailabilityZone,
+			GroupName:        in.Placement.GroupName,
+			Tenancy:          in.Placement.Tenancy,
+		}
+	}
+
+	// A private IP address and IPv6 addresses can only be requested through
+	// a network interface
+	hasIPv6 := in.Ipv6AddressCount != nil || len(in.Ipv6Addresses) > 0
+	if (in.PrivateIpAddress != nil || hasIPv6) && len(spec.NetworkInterfaces) == 0 {
+		spec.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex:      aws.Int64(0),
+			SubnetId:         in.SubnetId,
+			Groups:           in.SecurityGroupIds,
+			PrivateIpAddress: in.PrivateIpAddress,
+			Ipv6AddressCount: in.Ipv6AddressCount,
+			Ipv6Addresses:    in.Ipv6Addresses,
+		}}
+		spec.SubnetId = nil
+		spec.SecurityGroupIds = nil
+	}
+
+	return spec
+}
+
+// addQueryParams returns a build handler which adds the given parameters to
+// the query encoded in the body of an EC2 request. It is used for parameters
+// the vendored SDK does not know yet.
+func addQueryParams(params map[string]string) func(*request.Request) {
+	return func(r *request.Request) {
+		if r.Error != nil || r.Body == nil || len(params) == 0 {
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+
+		query, err := url.ParseQuery(string(body))
+		if err != nil {
+			r.Error = err
+			return
+		}
+		for name, value := range params {
+			query.Set(name, value)
+		}
+		r.SetBufferBody([]byte(query.Encode()))
+	}
+}
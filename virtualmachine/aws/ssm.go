@@ -0,0 +1,398 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// ssmDocument is the document which runs shell commands.
+	ssmDocument = "AWS-RunShellScript"
+	// ssmPortForwardingDocument is the document of Session Manager port
+	// forwarding sessions.
+	ssmPortForwardingDocument = "AWS-StartPortForwardingSession"
+
+	// ssmUploadChunk is the number of base64 characters uploaded per command,
+	// to stay below the size limit of the command parameters.
+	ssmUploadChunk = 32 * 1024
+	// ssmDownloadChunk is the number of bytes downloaded per command, so that
+	// their base64 encoding fits the 24000 characters of output SSM returns.
+	ssmDownloadChunk = 16 * 1024
+)
+
+var (
+	// SSMTimeout is the maximum time to wait for a command run through SSM.
+	// This is not thread-safe.
+	SSMTimeout = 10 * time.Minute
+
+	// ErrSSMNotManaged is returned when the instance is not online in SSM,
+	// such as when the SSM agent is not running or the instance profile lacks
+	// the permissions of SSM.
+	ErrSSMNotManaged = errors.New("Instance is not managed by SSM")
+	// ErrSSMTimeout is returned when a command run through SSM does not
+	// complete in time.
+	ErrSSMTimeout = errors.New("Timed out waiting for SSM command")
+
+	// This ensures that ssmClient implements the ssh.Client interface at
+	// compile time.
+	_ ssh.Client = (*ssmClient)(nil)
+)
+
+// ssmClient is an ssh.Client which runs commands on an instance through SSM
+// Run Command, without network access to the instance. Files are transferred
+// base64 encoded through commands, which suits small files only.
+type ssmClient struct {
+	svc        *ssm.SSM
+	instanceID string
+
+	privateKey string
+	password   string
+}
+
+// getSSMClient returns an SSM client for the instance of the VM.
+func (vm *VM) getSSMClient() (*ssmClient, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	s, err := getSession(vm.Region, vm.Credentials, vm.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssmClient{svc: ssm.New(s), instanceID: vm.InstanceID}, nil
+}
+
+// Connect checks that the instance is online in SSM.
+func (c *ssmClient) Connect() error {
+	resp, err := c.svc.DescribeInstanceInformation(&ssm.DescribeInstanceInformationInput{
+		Filters: []*ssm.InstanceInformationStringFilter{{
+			Key:    aws.String("InstanceIds"),
+			Values: []*string{aws.String(c.instanceID)},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to describe SSM instance: %v", err)
+	}
+
+	for _, info := range resp.InstanceInformationList {
+		if aws.StringValue(info.PingStatus) == ssm.PingStatusOnline {
+			return nil
+		}
+	}
+	return ErrSSMNotManaged
+}
+
+// Disconnect does nothing, as SSM commands are not connection based.
+func (c *ssmClient) Disconnect() {}
+
+// Run runs the command on the instance and writes its output to stdout and
+// stderr once it completes. SSM returns at most 24000 characters of each.
+func (c *ssmClient) Run(command string, stdout io.Writer, stderr io.Writer) error {
+	resp, err := c.svc.SendCommand(&ssm.SendCommandInput{
+		DocumentName: aws.String(ssmDocument),
+		InstanceIds:  []*string{aws.String(c.instanceID)},
+		Parameters: map[string][]*string{
+			"commands": {aws.String(command)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to send SSM command: %v", err)
+	}
+
+	in := &ssm.GetCommandInvocationInput{
+		CommandId:  resp.Command.CommandId,
+		InstanceId: aws.String(c.instanceID),
+	}
+	start := time.Now()
+	for {
+		time.Sleep(2 * time.Second)
+
+		inv, err := c.svc.GetCommandInvocation(in)
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == ssm.ErrCodeInvocationDoesNotExist {
+			// The invocation is not visible right after the command is sent.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to get SSM command invocation: %v", err)
+		}
+
+		switch aws.StringValue(inv.Status) {
+		case ssm.CommandInvocationStatusPending, ssm.CommandInvocationStatusInProgress,
+			ssm.CommandInvocationStatusDelayed, ssm.CommandInvocationStatusCancelling:
+			if time.Since(start) >= SSMTimeout {
+				return ErrSSMTimeout
+			}
+			continue
+		}
+
+		io.WriteString(stdout, aws.StringValue(inv.StandardOutputContent))
+		io.WriteString(stderr, aws.StringValue(inv.StandardErrorContent))
+		if aws.StringValue(inv.Status) != ssm.CommandInvocationStatusSuccess {
+			return fmt.Errorf("SSM command %s: %s (exit code %d)", aws.StringValue(inv.Status), command, aws.Int64Value(inv.ResponseCode))
+		}
+		return nil
+	}
+}
+
+// Upload writes the content of src to the file dst on the instance with the
+// given mode.
+func (c *ssmClient) Upload(src io.Reader, dst string, size int, mode uint32) error {
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(b)
+
+	tmp := dst + ".b64"
+	redirect := ">"
+	for i := 0; i == 0 || i < len(encoded); i += ssmUploadChunk {
+		end := i + ssmUploadChunk
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		cmd := fmt.Sprintf("printf '%%s' '%s' %s %s", encoded[i:end], redirect, shellQuote(tmp))
+		if err := c.Run(cmd, ioutil.Discard, ioutil.Discard); err != nil {
+			return err
+		}
+		redirect = ">>"
+	}
+
+	cmd := fmt.Sprintf("base64 -d %s > %s && rm -f %s && chmod %o %s", shellQuote(tmp), shellQuote(dst), shellQuote(tmp), mode, shellQuote(dst))
+	return c.Run(cmd, ioutil.Discard, ioutil.Discard)
+}
+
+// Download writes the content of the file src on the instance to dst.
+func (c *ssmClient) Download(dst io.WriteCloser, src string) error {
+	defer dst.Close()
+
+	for i := 0; ; i++ {
+		var stdout bytes.Buffer
+		cmd := fmt.Sprintf("dd if=%s bs=%d skip=%d count=1 2>/dev/null | base64 | tr -d '\\n'", shellQuote(src), ssmDownloadChunk, i)
+		if err := c.Run(cmd, &stdout, ioutil.Discard); err != nil {
+			return err
+		}
+
+		chunk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+		if err != nil {
+			return fmt.Errorf("Failed to decode downloaded file: %v", err)
+		}
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		if len(chunk) < ssmDownloadChunk {
+			return nil
+		}
+	}
+}
+
+// Validate checks that the client has an instance.
+func (c *ssmClient) Validate() error {
+	if c.instanceID == "" {
+		return ErrNoInstanceID
+	}
+	return nil
+}
+
+// WaitForSSH waits until the instance is online in SSM.
+func (c *ssmClient) WaitForSSH(maxWait time.Duration) error {
+	start := time.Now()
+
+	for {
+		if err := c.Connect(); err == nil {
+			return nil
+		}
+
+		if time.Since(start) >= maxWait {
+			break
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+
+	return ssh.ErrTimeout
+}
+
+// SetSSHPrivateKey stores the private key, which SSM does not use.
+func (c *ssmClient) SetSSHPrivateKey(s string) {
+	c.privateKey = s
+}
+
+// GetSSHPrivateKey returns the stored private key.
+func (c *ssmClient) GetSSHPrivateKey() string {
+	return c.privateKey
+}
+
+// SetSSHPassword stores the password, which SSM does not use.
+func (c *ssmClient) SetSSHPassword(s string) {
+	c.password = s
+}
+
+// GetSSHPassword returns the stored password.
+func (c *ssmClient) GetSSHPassword() string {
+	return c.password
+}
+
+// ssmTunnelClient is an SSH client connected through a Session Manager port
+// forwarding session, which is terminated on Disconnect.
+type ssmTunnelClient struct {
+	*ssh.SSHClient
+	session *exec.Cmd
+}
+
+// Disconnect closes the SSH connection and terminates the port forwarding
+// session.
+func (c *ssmTunnelClient) Disconnect() {
+	c.SSHClient.Disconnect()
+	if c.session.Process != nil {
+		c.session.Process.Kill()
+		c.session.Wait()
+	}
+}
+
+// getSSMTunnelClient starts a Session Manager session forwarding a local port,
+// chosen by the session-manager-plugin, to port 22 of the instance and returns
+// an SSH client connected through it. It requires the aws CLI and its
+// session-manager-plugin. The CLI is passed the credentials of the VM, such as
+// those of its assumed role, through the environment.
+func (vm *VM) getSSMTunnelClient(options ssh.Options) (ssh.Client, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	s, err := getSession(vm.Region, vm.Credentials, vm.Endpoints)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s.Config.Credentials.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get AWS credentials: %v", err)
+	}
+
+	args := []string{
+		"ssm", "start-session",
+		"--target", vm.InstanceID,
+		"--document-name", ssmPortForwardingDocument,
+		"--parameters", "portNumber=22",
+	}
+	if region := getRegion(vm.Region); region != "" {
+		args = append(args, "--region", region)
+	}
+	if url := vm.Endpoints.SSM; url != "" {
+		args = append(args, "--endpoint-url", url)
+	} else if vm.Endpoints.URL != "" {
+		args = append(args, "--endpoint-url", vm.Endpoints.URL)
+	}
+	session := exec.Command("aws", args...)
+	session.Env = credentialsEnv(os.Environ(), creds)
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start SSM port forwarding session: %v", err)
+	}
+
+	port, err := readForwardedPort(stdout, SSHTimeout)
+	if err != nil {
+		session.Process.Kill()
+		session.Wait()
+		return nil, err
+	}
+
+	client := &ssmTunnelClient{
+		SSHClient: &ssh.SSHClient{
+			Creds:   &vm.SSHCreds,
+			IP:      net.IPv4(127, 0, 0, 1),
+			Options: options,
+			Port:    port,
+		},
+		session: session,
+	}
+	if err := client.WaitForSSH(SSHTimeout); err != nil {
+		client.Disconnect()
+		return nil, err
+	}
+	return client, nil
+}
+
+// credentialsEnv returns the environment with the given credentials instead of
+// the credentials and profile it may have.
+func credentialsEnv(env []string, creds credentials.Value) []string {
+	var out []string
+	for _, v := range env {
+		switch strings.SplitN(v, "=", 2)[0] {
+		case "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_SECURITY_TOKEN", "AWS_PROFILE", "AWS_DEFAULT_PROFILE":
+			continue
+		}
+		out = append(out, v)
+	}
+	out = append(out,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+	)
+	if creds.SessionToken != "" {
+		out = append(out, "AWS_SESSION_TOKEN="+creds.SessionToken)
+	}
+	return out
+}
+
+// forwardedPortRegexp matches the line of the session-manager-plugin with the
+// local port it listens on.
+var forwardedPortRegexp = regexp.MustCompile(`Port (\d+) opened for sessionId`)
+
+// readForwardedPort reads the output of a port forwarding session until the
+// local port it listens on is printed, and returns it. The rest of the output
+// is discarded.
+func readForwardedPort(r io.Reader, timeout time.Duration) (int, error) {
+	ports := make(chan int, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if m := forwardedPortRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+				port, _ := strconv.Atoi(m[1])
+				ports <- port
+				break
+			}
+		}
+		close(ports)
+		io.Copy(ioutil.Discard, r)
+	}()
+
+	select {
+	case port, ok := <-ports:
+		if !ok {
+			return 0, errors.New("SSM port forwarding session ended before opening a local port")
+		}
+		return port, nil
+	case <-time.After(timeout):
+		return 0, errors.New("Timed out waiting for the SSM port forwarding session to open a local port")
+	}
+}
+
+// shellQuote quotes the string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
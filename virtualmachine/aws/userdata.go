@@ -0,0 +1,75 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// maxUserDataSize is the maximum size of the user data, before base64
+// encoding.
+const maxUserDataSize = 16 * 1024
+
+// ErrUserDataSources is returned when more than one of UserData, UserDataFile
+// and UserDataReader is set.
+var ErrUserDataSources = errors.New("Only one of UserData, UserDataFile and UserDataReader can be set")
+
+// encodeUserData returns the user data of the VM, gzipped if requested and
+// base64 encoded as RunInstances expects it. It returns nil if the VM has no
+// user data and an error if the user data exceeds the 16 KB limit.
+func encodeUserData(vm *VM) (*string, error) {
+	var sources int
+	for _, set := range []bool{vm.UserData != nil, vm.UserDataFile != "", vm.UserDataReader != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, ErrUserDataSources
+	}
+
+	data := vm.UserData
+	var err error
+	switch {
+	case vm.UserDataFile != "":
+		data, err = ioutil.ReadFile(vm.UserDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read user data: %v", err)
+		}
+	case vm.UserDataReader != nil:
+		data, err = ioutil.ReadAll(vm.UserDataReader)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read user data: %v", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if vm.GzipUserData {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("Failed to gzip user data: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("Failed to gzip user data: %v", err)
+		}
+		data = b.Bytes()
+	}
+
+	if len(data) > maxUserDataSize {
+		if vm.GzipUserData {
+			return nil, fmt.Errorf("User data is %d bytes gzipped, over the limit of %d bytes", len(data), maxUserDataSize)
+		}
+		return nil, fmt.Errorf("User data is %d bytes, over the limit of %d bytes, set GzipUserData to compress it", len(data), maxUserDataSize)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return &encoded, nil
+}
@@ -5,15 +5,13 @@ package aws
 import (
 	"errors"
 	"fmt"
-	"net/http"
 	"os"
-	"time"
+	"sort"
+	"strconv"
 
 	"github.com/apcera/util/uuid"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -135,32 +133,22 @@ func setNonRootDeleteOnDestroy(svc *ec2.EC2, instID string, delOnTerm bool) erro
 	return nil
 }
 
+// getService returns the EC2 client of the region, authenticated with the
+// default credential chain.
 func getService(region string) (*ec2.EC2, error) {
-	creds := credentials.NewChainCredentials(
-		[]credentials.Provider{
-			&credentials.EnvProvider{},               // check environment
-			&credentials.SharedCredentialsProvider{}, // check home dir
-		},
-	)
+	return newService(region, Credentials{}, Endpoints{})
+}
 
+// getRegion returns the given region, falling back to the region set in the
+// environment if it is empty.
+func getRegion(region string) string {
 	if region == "" { // user didn't set region
 		region = os.Getenv("AWS_DEFAULT_REGION") // aws cli checks this
 		if region == "" {
 			region = os.Getenv("AWS_REGION") // aws sdk checks this
 		}
 	}
-
-	s, err := session.NewSession(&aws.Config{
-		Credentials: creds,
-		Region:      &region,
-		CredentialsChainVerboseErrors: aws.Bool(true),
-		HTTPClient:                    &http.Client{Timeout: 30 * time.Second},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %v", err)
-	}
-
-	return ec2.New(s), nil
+	return region
 }
 
 func instanceInfo(vm *VM) *ec2.RunInstancesInput {
@@ -182,48 +170,32 @@ func instanceInfo(vm *VM) *ec2.RunInstancesInput {
 	}
 
 	var sid *string
-	if vm.Subnet != "" {
+	if vm.SubnetID != "" {
+		sid = aws.String(vm.SubnetID)
+	} else if vm.Subnet != "" {
 		sid = aws.String(vm.Subnet)
 	}
 
 	var sgid []*string
-	if len(vm.SecurityGroups) > 0 {
-		sgid = make([]*string, 0)
-		for _, sg := range vm.SecurityGroups {
-			sgid = append(sgid, aws.String(sg))
-		}
+	for _, sg := range append(append([]string(nil), vm.SecurityGroupIDs...), vm.SecurityGroups...) {
+		sgid = append(sgid, aws.String(sg))
 	}
-
-	devices := make([]*ec2.BlockDeviceMapping, len(vm.Volumes))
-	for _, volume := range vm.Volumes {
-		if volume.VolumeSize == 0 {
-			volume.VolumeSize = defaultVolumeSize
-		}
-		if volume.VolumeType == "" {
-			volume.VolumeType = defaultVolumeType
-		}
-
-		devices = append(devices, &ec2.BlockDeviceMapping{
-			DeviceName: aws.String(volume.DeviceName),
-			Ebs: &ec2.EbsBlockDevice{
-				VolumeSize:          aws.Int64(int64(volume.VolumeSize)),
-				VolumeType:          aws.String(volume.VolumeType),
-				DeleteOnTermination: aws.Bool(!vm.KeepRootVolumeOnDestroy),
-			},
-		})
+	if vm.ManagedSecurityGroup != nil && vm.ManagedSecurityGroup.ID != "" {
+		sgid = append(sgid, aws.String(vm.ManagedSecurityGroup.ID))
 	}
+
 	var privateIPAddress *string
 	if vm.PrivateIPAddress != "" {
 		privateIPAddress = aws.String(vm.PrivateIPAddress)
 	}
 
-	return &ec2.RunInstancesInput{
+	in := &ec2.RunInstancesInput{
 		ImageId:             aws.String(vm.AMI),
 		InstanceType:        aws.String(vm.InstanceType),
 		KeyName:             aws.String(vm.KeyPair),
 		MaxCount:            aws.Int64(instanceCount),
 		MinCount:            aws.Int64(instanceCount),
-		BlockDeviceMappings: devices,
+		BlockDeviceMappings: blockDeviceMappings(vm),
 		Monitoring: &ec2.RunInstancesMonitoringEnabled{
 			Enabled: aws.Bool(true),
 		},
@@ -231,7 +203,193 @@ func instanceInfo(vm *VM) *ec2.RunInstancesInput {
 		SecurityGroupIds:   sgid,
 		IamInstanceProfile: iamInstance,
 		PrivateIpAddress:   privateIPAddress,
+		TagSpecifications:  tagSpecifications(launchTags(vm)),
+	}
+
+	if vm.TerminationProtection {
+		in.DisableApiTermination = aws.Bool(true)
+	}
+
+	if vm.IPv6AddressCount > 0 {
+		in.Ipv6AddressCount = aws.Int64(int64(vm.IPv6AddressCount))
+	}
+	for _, addr := range vm.IPv6Addresses {
+		in.Ipv6Addresses = append(in.Ipv6Addresses, &ec2.InstanceIpv6Address{Ipv6Address: aws.String(addr)})
+	}
+
+	if vm.PlacementGroup != "" || vm.Tenancy != "" || vm.HostID != "" {
+		in.Placement = &ec2.Placement{}
+		if vm.PlacementGroup != "" {
+			in.Placement.GroupName = aws.String(vm.PlacementGroup)
+		}
+		if vm.Tenancy != "" {
+			in.Placement.Tenancy = aws.String(vm.Tenancy)
+		}
+		if vm.HostID != "" {
+			in.Placement.HostId = aws.String(vm.HostID)
+		}
+	}
+
+	// The public IP can only be controlled through a network interface.
+	if vm.AssociatePublicIP != nil {
+		in.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex:              aws.Int64(0),
+			AssociatePublicIpAddress: vm.AssociatePublicIP,
+			DeleteOnTermination:      aws.Bool(true),
+			SubnetId:                 sid,
+			Groups:                   sgid,
+			PrivateIpAddress:         privateIPAddress,
+			Ipv6AddressCount:         in.Ipv6AddressCount,
+			Ipv6Addresses:            in.Ipv6Addresses,
+		}}
+		in.SubnetId = nil
+		in.SecurityGroupIds = nil
+		in.PrivateIpAddress = nil
+		in.Ipv6AddressCount = nil
+		in.Ipv6Addresses = nil
+	}
+
+	return in
+}
+
+// launchTags returns the tags of the VM along with its Name tag.
+func launchTags(vm *VM) map[string]string {
+	tags := make(map[string]string, len(vm.Tags)+1)
+	for k, v := range vm.Tags {
+		tags[k] = v
+	}
+	if vm.Name != "" {
+		tags["Name"] = vm.Name
+	}
+	return tags
+}
+
+// tagSpecifications returns the specifications applying the tags to the instance, its volumes
+// and its network interfaces at launch.
+func tagSpecifications(tags map[string]string) []*ec2.TagSpecification {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ec2Tags := make([]*ec2.Tag, 0, len(keys))
+	for _, k := range keys {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+
+	resourceTypes := []string{
+		ec2.ResourceTypeInstance,
+		ec2.ResourceTypeVolume,
+		ec2.ResourceTypeNetworkInterface,
+	}
+	specs := make([]*ec2.TagSpecification, 0, len(resourceTypes))
+	for _, t := range resourceTypes {
+		specs = append(specs, &ec2.TagSpecification{
+			ResourceType: aws.String(t),
+			Tags:         ec2Tags,
+		})
+	}
+	return specs
+}
+
+// blockDeviceMappings returns the block device mappings of the volumes of the VM.
+func blockDeviceMappings(vm *VM) []*ec2.BlockDeviceMapping {
+	var devices []*ec2.BlockDeviceMapping
+	for _, volume := range blockDeviceVolumes(vm) {
+		if volume.VolumeSize == 0 && volume.SnapshotID == "" {
+			volume.VolumeSize = defaultVolumeSize
+		}
+		if volume.VolumeType == "" {
+			volume.VolumeType = defaultVolumeType
+		}
+
+		ebs := &ec2.EbsBlockDevice{
+			VolumeType:          aws.String(volume.VolumeType),
+			DeleteOnTermination: aws.Bool(!vm.KeepRootVolumeOnDestroy && !volume.KeepOnDestroy),
+		}
+		if volume.VolumeSize > 0 {
+			ebs.VolumeSize = aws.Int64(int64(volume.VolumeSize))
+		}
+		if volume.IOPS > 0 {
+			ebs.Iops = aws.Int64(int64(volume.IOPS))
+		}
+		if volume.Encrypted || volume.KMSKeyID != "" {
+			ebs.Encrypted = aws.Bool(true)
+		}
+		if volume.SnapshotID != "" {
+			ebs.SnapshotId = aws.String(volume.SnapshotID)
+		}
+
+		devices = append(devices, &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(volume.DeviceName),
+			Ebs:        ebs,
+		})
+	}
+	return devices
+}
+
+// blockDeviceVolumes returns the volumes of the block device mappings of the VM, the root
+// volume first.
+func blockDeviceVolumes(vm *VM) []EBSVolume {
+	var volumes []EBSVolume
+	if vm.RootVolume != nil {
+		volumes = append(volumes, *vm.RootVolume)
+	}
+	return append(volumes, vm.Volumes...)
+}
+
+// launchParams returns the query parameters of the RunInstances input of the VM which the
+// vendored SDK does not support yet, such as the KMS key and the throughput of the volumes,
+// the stop protection, the hibernation and the capacity reservation.
+func launchParams(vm *VM) map[string]string {
+	params := make(map[string]string)
+	if vm.StopProtection {
+		params["DisableApiStop"] = "true"
+	}
+	if vm.Hibernation {
+		params["HibernationOptions.Configured"] = "true"
+	}
+	if vm.CapacityReservationID != "" {
+		params["CapacityReservationSpecification.CapacityReservationTarget.CapacityReservationId"] = vm.CapacityReservationID
+	} else if vm.CapacityReservationPreference != "" {
+		params["CapacityReservationSpecification.CapacityReservationPreference"] = vm.CapacityReservationPreference
+	}
+
+	for i, volume := range blockDeviceVolumes(vm) {
+		prefix := fmt.Sprintf("BlockDeviceMapping.%d.Ebs.", i+1)
+		if volume.KMSKeyID != "" {
+			params[prefix+"KmsKeyId"] = volume.KMSKeyID
+		}
+		if volume.Throughput > 0 {
+			params[prefix+"Throughput"] = strconv.Itoa(volume.Throughput)
+		}
+	}
+	return params
+}
+
+// getRootDeviceName returns the root device name of the given AMI, or of the default AMI if
+// it is empty.
+func getRootDeviceName(svc *ec2.EC2, ami string) (string, error) {
+	if ami == "" {
+		ami = defaultAMI
 	}
+
+	resp, err := svc.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(ami)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to describe image: %v", err)
+	}
+	if len(resp.Images) < 1 || resp.Images[0].RootDeviceName == nil {
+		return "", fmt.Errorf("Missing root device name of image %s", ami)
+	}
+
+	return *resp.Images[0].RootDeviceName, nil
 }
 
 func hasInstanceID(instance *ec2.Instance) bool {
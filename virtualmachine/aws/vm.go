@@ -6,6 +6,7 @@ package aws
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -31,6 +32,34 @@ const (
 	StateDestroyed = "terminated"
 	// StatePending is the state AWS reports when the VM is pending.
 	StatePending = "pending"
+
+	// TenancyDefault runs the instance on shared hardware.
+	TenancyDefault = "default"
+	// TenancyDedicated runs the instance on single-tenant hardware.
+	TenancyDedicated = "dedicated"
+	// TenancyHost runs the instance on a Dedicated Host.
+	TenancyHost = "host"
+
+	// CapacityReservationOpen launches the instance into any open capacity
+	// reservation with matching attributes.
+	CapacityReservationOpen = "open"
+	// CapacityReservationNone launches the instance without using a capacity
+	// reservation.
+	CapacityReservationNone = "none"
+
+	// StatusOK is the status check result when the check passed.
+	StatusOK = "ok"
+	// StatusImpaired is the status check result when the check failed.
+	StatusImpaired = "impaired"
+	// StatusInitializing is the status check result while the instance is
+	// starting.
+	StatusInitializing = "initializing"
+	// StatusInsufficientData is the status check result when EC2 has not
+	// enough data to run the check.
+	StatusInsufficientData = "insufficient-data"
+	// StatusNotApplicable is the status check result when the instance is not
+	// running.
+	StatusNotApplicable = "not-applicable"
 )
 
 var (
@@ -42,6 +71,14 @@ var (
 	// interface at compile time.
 	_ virtualmachine.VirtualMachine = (*VM)(nil)
 
+	// This ensures that aws.VM implements the virtualmachine.DashboardLinker
+	// interface at compile time.
+	_ virtualmachine.DashboardLinker = (*VM)(nil)
+
+	// This ensures that aws.VM implements the virtualmachine.Addresser
+	// interface at compile time.
+	_ virtualmachine.Addresser = (*VM)(nil)
+
 	// nextProvision is the wall time when the next call to Provision will be
 	// allowed to proceed. This is part of the rate limiting system.
 	nextProvision time.Time
@@ -64,10 +101,15 @@ var (
 	ErrProvisionTimeout = errors.New("AWS provision timeout")
 	// ErrNoIPs is returned when no IP addresses are found for an instance.
 	ErrNoIPs = errors.New("Missing IPs for instance")
-	// ErrNoSupportSuspend is returned when vm.Suspend() is called.
-	ErrNoSupportSuspend = errors.New("Suspend action not supported by AWS")
-	// ErrNoSupportResume is returned when vm.Resume() is called.
-	ErrNoSupportResume = errors.New("Resume action not supported by AWS")
+	// ErrNoSupportSuspend is returned when vm.Suspend() is called on a VM
+	// without hibernation.
+	ErrNoSupportSuspend = errors.New("Suspend action not supported by AWS without hibernation")
+	// ErrNoSupportResume is returned when vm.Resume() is called on a VM
+	// without hibernation.
+	ErrNoSupportResume = errors.New("Resume action not supported by AWS without hibernation")
+	// ErrInstanceImpaired is returned by GetSSH, with CheckHealthOnSSH, when a
+	// status check of the instance failed.
+	ErrInstanceImpaired = errors.New("Instance status check impaired")
 )
 
 // VM represents an AWS EC2 virtual machine.
@@ -81,16 +123,120 @@ type VM struct {
 	IamInstanceProfileName string
 	PrivateIPAddress       string
 
+	// RootVolume [optional] customizes the root volume instead of taking the
+	// AMI defaults. Its DeviceName defaults to the root device of the AMI.
+	RootVolume                   *EBSVolume
 	Volumes                      []EBSVolume
 	KeepRootVolumeOnDestroy      bool
 	DeleteNonRootVolumeOnDestroy bool
 
-	VPC            string
-	Subnet         string
+	VPC string
+	// SubnetID [optional] is the ID of the subnet to launch the instance in.
+	SubnetID string
+	// SecurityGroupIDs [optional] are the IDs of the security groups of the
+	// instance.
+	SecurityGroupIDs []string
+	// ManagedSecurityGroup [optional] creates a security group with the given
+	// ingress rules for the VM on Provision and deletes it on Destroy.
+	ManagedSecurityGroup *ManagedSecurityGroup
+	// AssociatePublicIP [optional] overrides whether the subnet assigns a
+	// public IP to the instance. Without a public IP, such as in a private
+	// subnet behind NAT, GetSSH connects to the private IP.
+	AssociatePublicIP *bool
+
+	// PlacementGroup [optional] is the name of the placement group to launch
+	// the instance in.
+	PlacementGroup string
+	// Tenancy [optional] is TenancyDefault, TenancyDedicated or TenancyHost.
+	Tenancy string
+	// HostID [optional] is the ID of the Dedicated Host to launch the instance
+	// on with TenancyHost.
+	HostID string
+	// CapacityReservationID [optional] is the ID of the capacity reservation
+	// to launch the instance into.
+	CapacityReservationID string
+	// CapacityReservationPreference [optional] is CapacityReservationOpen or
+	// CapacityReservationNone. It is ignored if CapacityReservationID is set.
+	CapacityReservationPreference string
+
+	// IPv6AddressCount [optional] is the number of IPv6 addresses to assign
+	// from the subnet, which must have an IPv6 CIDR block. IPv6Addresses
+	// assigns specific addresses instead. GetAddresses returns them.
+	IPv6AddressCount int
+	IPv6Addresses    []string
+
+	// AllocateElasticIP allocates an Elastic IP on Provision, associates it
+	// with the instance and releases it on Destroy.
+	AllocateElasticIP bool
+	// ElasticIP is the address of the Elastic IP allocated by Provision.
+	ElasticIP string
+	// ElasticIPAllocationID is the allocation ID of the Elastic IP allocated
+	// by Provision.
+	ElasticIPAllocationID string
+	// ElasticIPAssociationID is the ID of the association of the Elastic IP
+	// with the instance.
+	ElasticIPAssociationID string
+
+	// Deprecated: Subnet is an alias for SubnetID.
+	Subnet string
+	// Deprecated: SecurityGroups are appended to SecurityGroupIDs.
 	SecurityGroups []string
 
+	// Credentials [optional] configures the AWS credentials, such as a named
+	// profile or a role to assume.
+	Credentials Credentials
+	// Endpoints [optional] configures the partition and custom endpoints, such
+	// as for GovCloud, China or a local test environment.
+	Endpoints Endpoints
+
+	// Hibernation enables hibernation, so that Suspend saves the memory of
+	// the instance to its root volume and Resume restores it. It requires a
+	// supported instance type and AMI and an encrypted root volume large
+	// enough for the memory of the instance, encrypted by RootVolume, the AMI
+	// or the EBS encryption by default of the account.
+	Hibernation bool
+
+	// TerminationProtection prevents the instance from being terminated and
+	// StopProtection from being stopped through the API. Destroy fails for a
+	// protected instance unless DisableProtectionOnDestroy is set.
+	TerminationProtection      bool
+	StopProtection             bool
+	DisableProtectionOnDestroy bool
+
+	// UserData [optional] is passed to the instance at boot, such as a
+	// cloud-init config. It can also be read from UserDataFile or
+	// UserDataReader. It is base64 encoded and, with GzipUserData, gzipped
+	// to fit the 16 KB limit, which cloud-init decompresses.
+	UserData       []byte
+	UserDataFile   string
+	UserDataReader io.Reader
+	GzipUserData   bool
+
+	// Tags [optional] are applied at launch to the instance, its volumes and
+	// its network interfaces, along with a Name tag with the name of the VM.
+	Tags map[string]string
+
+	// UseSSM makes GetSSH return a client which runs commands through SSM Run
+	// Command instead of SSH, for instances without a reachable IP. The
+	// instance needs the SSM agent and an instance profile allowing SSM.
+	UseSSM bool
+	// SSMPortForwarding makes GetSSH connect over SSH through a Session
+	// Manager session forwarding a local port to port 22 of the instance. It
+	// requires the aws CLI and its session-manager-plugin.
+	SSMPortForwarding bool
+
 	SSHCreds            ssh.Credentials // required
 	DeleteKeysOnDestroy bool
+	// CheckHealthOnSSH makes GetSSH fail with ErrInstanceImpaired if a status
+	// check of the instance is impaired, instead of trying to connect anyway.
+	CheckHealthOnSSH bool
+
+	// Spot [optional] provisions a spot instance instead of an on-demand
+	// instance.
+	Spot *SpotOptions
+	// SpotRequestID is the ID of the spot instance request. It is set by
+	// Provision and the request is canceled on Destroy.
+	SpotRequestID string
 }
 
 // EBSVolume represents an EBS Volume
@@ -98,6 +244,22 @@ type EBSVolume struct {
 	DeviceName string
 	VolumeSize int
 	VolumeType string
+
+	// IOPS [optional] is the number of provisioned IOPS of io1 and gp3 volumes.
+	IOPS int
+	// Throughput [optional] is the throughput of gp3 volumes in MiB/s.
+	Throughput int
+	// Encrypted encrypts the volume, with the default EBS key unless KMSKeyID
+	// is set.
+	Encrypted bool
+	// KMSKeyID [optional] is the ID or ARN of the KMS key to encrypt the
+	// volume with. It implies Encrypted.
+	KMSKeyID string
+	// SnapshotID [optional] is the ID of the snapshot to create the volume
+	// from.
+	SnapshotID string
+	// KeepOnDestroy keeps the volume when the instance is terminated.
+	KeepOnDestroy bool
 }
 
 // GetName returns the name of the virtual machine
@@ -107,7 +269,7 @@ func (vm *VM) GetName() string {
 
 // SetTag adds a tag to the VM and its attached volumes.
 func (vm *VM) SetTag(key, value string) error {
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return fmt.Errorf("failed to get AWS service: %v", err)
 	}
@@ -158,32 +320,106 @@ func (vm *VM) SetTags(tags map[string]string) error {
 func (vm *VM) Provision() error {
 	wait() // Avoid the AWS rate limit.
 
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return fmt.Errorf("failed to get AWS service: %v", err)
 	}
 
-	resp, err := svc.RunInstances(instanceInfo(vm))
-	if err != nil {
-		return fmt.Errorf("Failed to create instance: %v", err)
+	if vm.Hibernation {
+		if err := validateHibernation(vm); err != nil {
+			return err
+		}
 	}
 
-	if hasInstanceID(resp.Instances[0]) {
-		vm.InstanceID = *resp.Instances[0].InstanceId
+	if vm.RootVolume != nil && vm.RootVolume.DeviceName == "" {
+		vm.RootVolume.DeviceName, err = getRootDeviceName(svc, vm.AMI)
+		if err != nil {
+			return err
+		}
+	}
+
+	if vm.ManagedSecurityGroup != nil && vm.ManagedSecurityGroup.ID == "" {
+		if err := createSecurityGroup(svc, vm); err != nil {
+			return err
+		}
+	}
+
+	// Delete the managed security group if the instance is not created
+	var cleanup = func(err error) error {
+		if vm.ManagedSecurityGroup != nil && vm.ManagedSecurityGroup.ID != "" {
+			if errDelete := deleteSecurityGroup(svc, vm.ManagedSecurityGroup.ID); errDelete != nil {
+				return fmt.Errorf("%v, and %v", err, errDelete)
+			}
+			vm.ManagedSecurityGroup.ID = ""
+		}
+		return err
+	}
+
+	if err := vm.launch(svc); err != nil {
+		return cleanup(err)
+	}
+
+	if err := vm.finishProvision(svc); err != nil {
+		return cleanup(vm.abortLaunch(svc, err))
+	}
+	return nil
+}
+
+// launch launches the instance of the VM, as a spot instance if requested.
+func (vm *VM) launch(svc *ec2.EC2) error {
+	// Parameters the vendored SDK does not know yet are added to the query.
+	in := instanceInfo(vm)
+	params := launchParams(vm)
+
+	var err error
+	if in.UserData, err = encodeUserData(vm); err != nil {
+		return err
+	}
+
+	if vm.Spot != nil {
+		requestID, instanceID, err := requestSpotInstance(svc, vm, in, params)
+		if err != nil {
+			return err
+		}
+		vm.SpotRequestID = requestID
+		vm.InstanceID = instanceID
+
+		// Spot launch specifications cannot be tagged.
+		if err := vm.SetTags(launchTags(vm)); err != nil {
+			return vm.abortLaunch(svc, err)
+		}
 	} else {
-		return ErrNoInstanceID
+		req, resp := svc.RunInstancesRequest(in)
+		req.Handlers.Build.PushBack(addQueryParams(params))
+		if err := req.Send(); err != nil {
+			return fmt.Errorf("Failed to create instance: %v", err)
+		}
+
+		if hasInstanceID(resp.Instances[0]) {
+			vm.InstanceID = *resp.Instances[0].InstanceId
+		} else {
+			return ErrNoInstanceID
+		}
 	}
 
+	return nil
+}
+
+// finishProvision waits until the launched instance of the VM is ready and
+// sets it up.
+func (vm *VM) finishProvision(svc *ec2.EC2) error {
 	if err := waitUntilReady(svc, vm.InstanceID); err != nil {
 		return err
 	}
 
-	if vm.DeleteNonRootVolumeOnDestroy {
-		return setNonRootDeleteOnDestroy(svc, vm.InstanceID, true)
+	if vm.AllocateElasticIP {
+		if err := allocateElasticIP(svc, vm); err != nil {
+			return err
+		}
 	}
 
-	if vm.Name != "" {
-		if err := vm.SetTag("Name", vm.GetName()); err != nil {
+	if vm.DeleteNonRootVolumeOnDestroy {
+		if err := setNonRootDeleteOnDestroy(svc, vm.InstanceID, true); err != nil {
 			return err
 		}
 	}
@@ -191,6 +427,56 @@ func (vm *VM) Provision() error {
 	return nil
 }
 
+// abortLaunch cleans up after the error err once the instance of the VM is
+// launched, so that nothing leaks: it cancels the spot request, releases the
+// Elastic IP and terminates the instance. It waits until the instance is
+// terminated if the VM has a managed security group, so that the group can be
+// deleted. It returns err along with the errors of the cleanup.
+func (vm *VM) abortLaunch(svc *ec2.EC2, err error) error {
+	if vm.SpotRequestID != "" {
+		if errCancel := cancelSpotInstanceRequest(svc, vm.SpotRequestID); errCancel != nil {
+			err = fmt.Errorf("%v, and %v", err, errCancel)
+		} else {
+			vm.SpotRequestID = ""
+		}
+	}
+
+	if vm.ElasticIPAllocationID != "" {
+		if errRelease := releaseElasticIP(svc, vm.ElasticIPAllocationID, vm.ElasticIPAssociationID); errRelease != nil {
+			err = fmt.Errorf("%v, and %v", err, errRelease)
+		} else {
+			vm.ElasticIP = ""
+			vm.ElasticIPAllocationID = ""
+			vm.ElasticIPAssociationID = ""
+		}
+	}
+
+	if vm.InstanceID == "" {
+		return err
+	}
+	if vm.TerminationProtection {
+		if errProtection := vm.DisableTerminationProtection(); errProtection != nil {
+			return fmt.Errorf("%v, and %v", err, errProtection)
+		}
+	}
+	_, errTerminate := svc.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String(vm.InstanceID)},
+	})
+	if errTerminate != nil {
+		return fmt.Errorf("%v, and failed to terminate instance %s: %v", err, vm.InstanceID, errTerminate)
+	}
+	if vm.ManagedSecurityGroup != nil && vm.ManagedSecurityGroup.ID != "" {
+		errTerminate = svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{aws.String(vm.InstanceID)},
+		})
+		if errTerminate != nil {
+			return fmt.Errorf("%v, and failed waiting for instance %s to terminate: %v", err, vm.InstanceID, errTerminate)
+		}
+	}
+	vm.InstanceID = ""
+	return err
+}
+
 // wait implements a rate limiter that prevents more than one call every
 // 0.5s. The maximum time that the caller can be delayed is 1m.
 func wait() {
@@ -239,7 +525,7 @@ func getWaitTime(now time.Time, maxWait time.Duration) time.Duration {
 // PrivateIP consts can be used to retrieve respective IP address type. It
 // returns nil if there was an error obtaining the IPs.
 func (vm *VM) GetIPs() ([]net.IP, error) {
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get AWS service: %v", err)
 	}
@@ -276,10 +562,56 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
+// GetAddresses returns all the IP addresses of the network interfaces of the
+// VM: the private IPv4 addresses, their public IPv4 addresses and the IPv6
+// addresses, which are public.
+func (vm *VM) GetAddresses() ([]virtualmachine.Address, error) {
+	svc, err := vm.getService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	inst, err := svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{
+			aws.String(vm.InstanceID),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to describe instance: %s", err)
+	}
+	if len(inst.Reservations) < 1 || len(inst.Reservations[0].Instances) < 1 {
+		return nil, ErrNoInstance
+	}
+
+	var addrs []virtualmachine.Address
+	for _, nic := range inst.Reservations[0].Instances[0].NetworkInterfaces {
+		for _, private := range nic.PrivateIpAddresses {
+			if private.PrivateIpAddress != nil {
+				addrs = append(addrs, virtualmachine.Address{IP: net.ParseIP(*private.PrivateIpAddress)})
+			}
+			if private.Association != nil && private.Association.PublicIp != nil {
+				addrs = append(addrs, virtualmachine.Address{IP: net.ParseIP(*private.Association.PublicIp), Public: true})
+			}
+		}
+		for _, ipv6 := range nic.Ipv6Addresses {
+			if ipv6.Ipv6Address != nil {
+				addrs = append(addrs, virtualmachine.Address{IP: net.ParseIP(*ipv6.Ipv6Address), Public: true})
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
 // Destroy terminates the VM on AWS. It returns an error if AWS credentials are
 // missing or if there is no instance ID.
 func (vm *VM) Destroy() error {
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return fmt.Errorf("failed to get AWS service: %v", err)
 	}
@@ -288,6 +620,33 @@ func (vm *VM) Destroy() error {
 		// Probably need to call Provision first.
 		return ErrNoInstanceID
 	}
+
+	// Disable the termination protection first, so that the spot request is
+	// not canceled if the instance cannot be terminated.
+	if vm.DisableProtectionOnDestroy && vm.TerminationProtection {
+		if err := vm.DisableTerminationProtection(); err != nil {
+			return err
+		}
+	}
+
+	// Cancel the spot request before terminating the instance, so that a
+	// persistent request does not relaunch it.
+	if vm.SpotRequestID != "" {
+		if err := cancelSpotInstanceRequest(svc, vm.SpotRequestID); err != nil {
+			return err
+		}
+		vm.SpotRequestID = ""
+	}
+
+	if vm.ElasticIPAllocationID != "" {
+		if err := releaseElasticIP(svc, vm.ElasticIPAllocationID, vm.ElasticIPAssociationID); err != nil {
+			return err
+		}
+		vm.ElasticIP = ""
+		vm.ElasticIPAllocationID = ""
+		vm.ElasticIPAssociationID = ""
+	}
+
 	_, err = svc.TerminateInstances(&ec2.TerminateInstancesInput{
 		InstanceIds: []*string{
 			aws.String(vm.InstanceID),
@@ -297,6 +656,20 @@ func (vm *VM) Destroy() error {
 		return err
 	}
 
+	// The security group can only be deleted once the instance is gone.
+	if vm.ManagedSecurityGroup != nil && vm.ManagedSecurityGroup.ID != "" {
+		err = svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{
+			InstanceIds: []*string{aws.String(vm.InstanceID)},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed waiting for instance to terminate: %v", err)
+		}
+		if err := deleteSecurityGroup(svc, vm.ManagedSecurityGroup.ID); err != nil {
+			return err
+		}
+		vm.ManagedSecurityGroup.ID = ""
+	}
+
 	if !vm.DeleteKeysOnDestroy {
 		return nil
 	}
@@ -305,17 +678,89 @@ func (vm *VM) Destroy() error {
 	return nil
 }
 
-// GetSSH returns an SSH client that can be used to connect to a VM. An error
-// is returned if the VM has no IPs.
+// GetDashboardURL returns the URL of the instance details page in the AWS
+// console. An error is returned if the instance ID or the region is missing.
+func (vm *VM) GetDashboardURL() (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	region := getRegion(vm.Region)
+	if region == "" {
+		return "", ErrNoRegion
+	}
+
+	partition := vm.Endpoints.Partition
+	if partition == "" {
+		partition = partitionOf(region)
+	}
+
+	return fmt.Sprintf(
+		"%s/ec2/v2/home?region=%s#InstanceDetails:instanceId=%s",
+		consoleURL(partition, region), region, vm.InstanceID,
+	), nil
+}
+
+// GetSSH returns an SSH client that can be used to connect to a VM. It
+// connects to the private IP if the VM has no public IP. An error is returned
+// if the VM has no IPs, and, with CheckHealthOnSSH, ErrInstanceImpaired if EC2
+// reports the instance as impaired. With UseSSM or SSMPortForwarding, the
+// client goes through SSM instead of the IPs of the VM.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	if vm.CheckHealthOnSSH && vm.InstanceID != "" {
+		health, err := vm.GetHealth()
+		if err != nil {
+			return nil, err
+		}
+		if health.Impaired() {
+			return nil, ErrInstanceImpaired
+		}
+	}
+
+	if vm.UseSSM {
+		client, err := vm.getSSMClient()
+		if err != nil {
+			return nil, err
+		}
+		if err := client.WaitForSSH(SSHTimeout); err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	if vm.SSMPortForwarding {
+		return vm.getSSMTunnelClient(options)
+	}
+
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
 		return nil, err
 	}
 
+	ip := ips[PublicIP]
+	if ip == nil && len(ips) > PrivateIP {
+		ip = ips[PrivateIP]
+	}
+	if ip == nil && vm.InstanceID != "" {
+		// IPv6-only instances are reached over their IPv6 address.
+		addrs, err := vm.GetAddresses()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if addr.IsIPv6() {
+				ip = addr.IP
+				break
+			}
+		}
+	}
+	if ip == nil {
+		return nil, ErrNoIPs
+	}
+
 	client := &ssh.SSHClient{
 		Creds:   &vm.SSHCreds,
-		IP:      ips[PublicIP],
+		IP:      ip,
 		Options: options,
 		Port:    22,
 	}
@@ -329,7 +774,7 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 // returned if the instance ID is missing, if there was a problem querying AWS,
 // or if there are no instances.
 func (vm *VM) GetState() (string, error) {
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return "", fmt.Errorf("failed to get AWS service: %v", err)
 	}
@@ -358,9 +803,77 @@ func (vm *VM) GetState() (string, error) {
 	return *stat.Reservations[0].Instances[0].State.Name, nil
 }
 
+// Health represents the state of an instance along with the results of the
+// EC2 status checks.
+type Health struct {
+	// State is the state of the instance, such as "running".
+	State string
+	// SystemStatus is the result of the system status check, which detects
+	// problems of the AWS infrastructure running the instance.
+	SystemStatus string
+	// InstanceStatus is the result of the instance status check, which
+	// detects problems of the instance itself.
+	InstanceStatus string
+}
+
+// Impaired returns true if one of the status checks failed.
+func (h Health) Impaired() bool {
+	return h.SystemStatus == StatusImpaired || h.InstanceStatus == StatusImpaired
+}
+
+// Ready returns true if the instance is running and both status checks
+// passed.
+func (h Health) Ready() bool {
+	return h.State == StateStarted && h.SystemStatus == StatusOK && h.InstanceStatus == StatusOK
+}
+
+// GetHealth returns the state of the VM and the results of its status checks.
+// The status checks are StatusNotApplicable unless the VM is running. An error
+// is returned if the instance ID is missing or if there was a problem querying
+// AWS.
+func (vm *VM) GetHealth() (Health, error) {
+	svc, err := vm.getService()
+	if err != nil {
+		return Health{}, fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return Health{}, ErrNoInstanceID
+	}
+
+	resp, err := svc.DescribeInstanceStatus(&ec2.DescribeInstanceStatusInput{
+		InstanceIds:         []*string{aws.String(vm.InstanceID)},
+		IncludeAllInstances: aws.Bool(true),
+	})
+	if err != nil {
+		return Health{}, fmt.Errorf("Failed to describe instance status: %s", err)
+	}
+	if len(resp.InstanceStatuses) < 1 {
+		return Health{}, ErrNoInstance
+	}
+
+	status := resp.InstanceStatuses[0]
+	health := Health{
+		SystemStatus:   StatusNotApplicable,
+		InstanceStatus: StatusNotApplicable,
+	}
+	if status.InstanceState != nil {
+		health.State = aws.StringValue(status.InstanceState.Name)
+	}
+	if status.SystemStatus != nil && status.SystemStatus.Status != nil {
+		health.SystemStatus = *status.SystemStatus.Status
+	}
+	if status.InstanceStatus != nil && status.InstanceStatus.Status != nil {
+		health.InstanceStatus = *status.InstanceStatus.Status
+	}
+
+	return health, nil
+}
+
 // Halt shuts down the VM on AWS.
 func (vm *VM) Halt() error {
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return fmt.Errorf("failed to get AWS service: %v", err)
 	}
@@ -386,7 +899,7 @@ func (vm *VM) Halt() error {
 
 // Start boots a stopped VM.
 func (vm *VM) Start() error {
-	svc, err := getService(vm.Region)
+	svc, err := vm.getService()
 	if err != nil {
 		return fmt.Errorf("failed to get AWS service: %v", err)
 	}
@@ -409,16 +922,6 @@ func (vm *VM) Start() error {
 	return nil
 }
 
-// Suspend always returns an error because this isn't supported by AWS.
-func (vm *VM) Suspend() error {
-	return ErrNoSupportSuspend
-}
-
-// Resume always returns an error because this isn't supported by AWS.
-func (vm *VM) Resume() error {
-	return ErrNoSupportResume
-}
-
 // SetKeyPair sets the given private key and AWS key name for this vm
 func (vm *VM) SetKeyPair(privateKey string, name string) {
 	vm.SSHCreds.SSHPrivateKey = privateKey
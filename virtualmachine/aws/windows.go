@@ -0,0 +1,89 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package aws
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrInvalidPrivateKey is returned when the private key of the key pair is
+// not a PEM encoded RSA key.
+var ErrInvalidPrivateKey = errors.New("Invalid private key, expected a PEM encoded RSA key")
+
+// GetWindowsPassword waits until the Administrator password of a Windows
+// instance is available and returns it, decrypted with the private key of the
+// key pair the instance was launched with. EC2 generates the password during
+// the first boot, which can take several minutes.
+func (vm *VM) GetWindowsPassword(privateKey []byte) (string, error) {
+	key, err := parseRSAPrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	svc, err := vm.getService()
+	if err != nil {
+		return "", fmt.Errorf("failed to get AWS service: %v", err)
+	}
+
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	in := &ec2.GetPasswordDataInput{
+		InstanceId: aws.String(vm.InstanceID),
+	}
+	if err := svc.WaitUntilPasswordDataAvailable(in); err != nil {
+		return "", fmt.Errorf("Failed waiting for password data: %v", err)
+	}
+
+	resp, err := svc.GetPasswordData(in)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get password data: %v", err)
+	}
+
+	encrypted, err := base64.StdEncoding.DecodeString(strings.TrimSpace(aws.StringValue(resp.PasswordData)))
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode password data: %v", err)
+	}
+
+	password, err := rsa.DecryptPKCS1v15(rand.Reader, key, encrypted)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt password data: %v", err)
+	}
+
+	return string(password), nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded RSA private key in the PKCS #1 or
+// PKCS #8 format.
+func parseRSAPrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrInvalidPrivateKey
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,402 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	// AuthClientSecret authenticates as a service principal with a client
+	// secret.
+	AuthClientSecret = "client_secret"
+
+	// AuthClientCertificate authenticates as a service principal with a
+	// client certificate.
+	AuthClientCertificate = "client_certificate"
+
+	// AuthManagedIdentity authenticates with the system-assigned managed
+	// identity of the Azure resource libretto runs on, or with one of its
+	// user-assigned identities if a client id is set.
+	AuthManagedIdentity = "managed_identity"
+
+	// AuthCLI authenticates with the account logged in to the Azure CLI.
+	AuthCLI = "cli"
+
+	// imdsTokenEndpoint is the token endpoint of the Azure instance metadata
+	// service.
+	imdsTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+	// identityEndpointEnv and identityHeaderEnv are the env vars of the token
+	// endpoint of managed identities on App Service and Functions.
+	identityEndpointEnv = "IDENTITY_ENDPOINT"
+	identityHeaderEnv   = "IDENTITY_HEADER"
+
+	// tokenRefreshWindow is how long before its expiration a token is
+	// refreshed.
+	tokenRefreshWindow = 5 * time.Minute
+)
+
+var (
+	// tokens caches the managed identity and CLI tokens by credentials and
+	// resource, as retrieving them is comparatively slow.
+	tokens   = make(map[tokenKey]adal.OAuthTokenProvider)
+	tokensMu sync.Mutex
+)
+
+type tokenKey struct {
+	creds    OAuthCredentials
+	resource string
+}
+
+// authMethod returns the auth method of the given credentials.
+func authMethod(creds *OAuthCredentials) string {
+	switch {
+	case creds.AuthMethod != "":
+		return creds.AuthMethod
+	case creds.CertificatePath != "":
+		return AuthClientCertificate
+	default:
+		return AuthClientSecret
+	}
+}
+
+// validateCreds validates the credentials for their auth method.
+func validateCreds(creds *OAuthCredentials) error {
+	switch authMethod(creds) {
+	case AuthClientSecret:
+		if creds.ClientID == "" {
+			return fmt.Errorf("a client id must be specified")
+		}
+		if creds.ClientSecret == "" {
+			return fmt.Errorf("a client secret must be specified")
+		}
+		if creds.TenantID == "" {
+			return fmt.Errorf("a tenant id must be specified")
+		}
+	case AuthClientCertificate:
+		if creds.ClientID == "" {
+			return fmt.Errorf("a client id must be specified")
+		}
+		if creds.CertificatePath == "" {
+			return fmt.Errorf("a certificate path must be specified")
+		}
+		if creds.TenantID == "" {
+			return fmt.Errorf("a tenant id must be specified")
+		}
+	case AuthManagedIdentity, AuthCLI:
+	default:
+		return fmt.Errorf("unknown auth method %q", creds.AuthMethod)
+	}
+
+	if creds.SubscriptionID == "" {
+		return fmt.Errorf("a subscription id must be specified")
+	}
+	return nil
+}
+
+// getAuthorizer returns an authorizer of the Azure Resource Manager requests
+// of the VM.
+func (vm *VM) getAuthorizer() (autorest.Authorizer, error) {
+	tok, err := getToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(tok), nil
+}
+
+// getToken returns a token provider of the given resource using the auth
+// method of the credentials.
+func getToken(creds *OAuthCredentials, resource string) (adal.OAuthTokenProvider, error) {
+	switch authMethod(creds) {
+	case AuthClientSecret:
+		return getServicePrincipalToken(creds, resource)
+	case AuthClientCertificate:
+		return getCertificateToken(creds, resource)
+	}
+
+	key := tokenKey{creds: *creds, resource: resource}
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	if tok, ok := tokens[key]; ok {
+		return tok, nil
+	}
+
+	var tok adal.OAuthTokenProvider
+	switch authMethod(creds) {
+	case AuthManagedIdentity:
+		tok = &managedIdentityToken{resource: resource, clientID: creds.ClientID}
+	case AuthCLI:
+		tok = &cliToken{resource: resource, tenantID: creds.TenantID}
+	default:
+		return nil, fmt.Errorf("unknown auth method %q", creds.AuthMethod)
+	}
+
+	tokens[key] = tok
+	return tok, nil
+}
+
+// getServicePrincipalToken retrieves a new ServicePrincipalToken using values of the
+// passed credentials map.
+func getServicePrincipalToken(creds *OAuthCredentials, scope string) (*adal.ServicePrincipalToken, error) {
+	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, creds.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return adal.NewServicePrincipalToken(*oauthConfig, creds.ClientID, creds.ClientSecret, scope)
+}
+
+// getCertificateToken retrieves a new ServicePrincipalToken using the client
+// certificate of the passed credentials.
+func getCertificateToken(creds *OAuthCredentials, scope string) (*adal.ServicePrincipalToken, error) {
+	cert, key, err := loadCertificate(creds.CertificatePath, creds.CertificatePassword)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, creds.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, creds.ClientID, cert, key, scope)
+}
+
+// loadCertificate reads the certificate and RSA private key of a PKCS#12 or
+// PEM file.
+func loadCertificate(path string, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		key, cert, err := pkcs12.Decode(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode PKCS#12 certificate: %v", err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, fmt.Errorf("certificate private key is not an RSA key")
+		}
+		return cert, rsaKey, nil
+	}
+
+	var cert *x509.Certificate
+	var key *rsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert == nil {
+				cert, err = x509.ParseCertificate(block.Bytes)
+			}
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			var k interface{}
+			k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			if rsaKey, ok := k.(*rsa.PrivateKey); ok {
+				key = rsaKey
+			} else if err == nil {
+				err = fmt.Errorf("certificate private key is not an RSA key")
+			}
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode PEM certificate: %v", err)
+		}
+	}
+
+	if cert == nil || key == nil {
+		return nil, nil, fmt.Errorf("certificate file must contain a certificate and an RSA private key")
+	}
+	return cert, key, nil
+}
+
+// managedIdentityToken retrieves tokens of a managed identity from the
+// instance metadata service, or from the identity endpoint of App Service.
+type managedIdentityToken struct {
+	mu    sync.Mutex
+	token adal.Token
+
+	resource string
+	clientID string
+}
+
+// OAuthToken returns the current access token.
+func (t *managedIdentityToken) OAuthToken() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token.AccessToken
+}
+
+// EnsureFresh refreshes the token if it is about to expire.
+func (t *managedIdentityToken) EnsureFresh() error {
+	t.mu.Lock()
+	fresh := !t.token.WillExpireIn(tokenRefreshWindow)
+	t.mu.Unlock()
+
+	if fresh {
+		return nil
+	}
+	return t.Refresh()
+}
+
+// Refresh retrieves a new token.
+func (t *managedIdentityToken) Refresh() error {
+	return t.RefreshExchange(t.resource)
+}
+
+// RefreshExchange retrieves a new token of the given resource.
+func (t *managedIdentityToken) RefreshExchange(resource string) error {
+	query := url.Values{}
+	query.Set("resource", resource)
+	if t.clientID != "" {
+		query.Set("client_id", t.clientID)
+	}
+
+	endpoint := os.Getenv(identityEndpointEnv)
+	header := os.Getenv(identityHeaderEnv)
+	if endpoint != "" && header != "" {
+		query.Set("api-version", "2019-08-01")
+	} else {
+		endpoint = imdsTokenEndpoint
+		query.Set("api-version", "2018-02-01")
+	}
+
+	req, err := http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if header != "" {
+		req.Header.Set("X-IDENTITY-HEADER", header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get managed identity token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get managed identity token: %s", resp.Status)
+	}
+
+	var token adal.Token
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode managed identity token: %v", err)
+	}
+
+	t.mu.Lock()
+	t.token = token
+	t.mu.Unlock()
+	return nil
+}
+
+// cliToken retrieves tokens of the account logged in to the Azure CLI by
+// running "az account get-access-token".
+type cliToken struct {
+	mu    sync.Mutex
+	token adal.Token
+
+	resource string
+	tenantID string
+}
+
+// OAuthToken returns the current access token.
+func (t *cliToken) OAuthToken() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token.AccessToken
+}
+
+// EnsureFresh refreshes the token if it is about to expire.
+func (t *cliToken) EnsureFresh() error {
+	t.mu.Lock()
+	fresh := !t.token.WillExpireIn(tokenRefreshWindow)
+	t.mu.Unlock()
+
+	if fresh {
+		return nil
+	}
+	return t.Refresh()
+}
+
+// Refresh retrieves a new token.
+func (t *cliToken) Refresh() error {
+	return t.RefreshExchange(t.resource)
+}
+
+// RefreshExchange retrieves a new token of the given resource.
+func (t *cliToken) RefreshExchange(resource string) error {
+	args := []string{"account", "get-access-token", "--resource", resource, "--output", "json"}
+	if t.tenantID != "" {
+		args = append(args, "--tenant", t.tenantID)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("az", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to get Azure CLI token: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		AccessToken string `json:"accessToken"`
+		TokenType   string `json:"tokenType"`
+		// ExpiresOn is in local time, ExpiresOnUnix is only set by recent
+		// versions of the CLI.
+		ExpiresOn     string `json:"expiresOn"`
+		ExpiresOnUnix int64  `json:"expires_on"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("failed to decode Azure CLI token: %v", err)
+	}
+
+	expires := result.ExpiresOnUnix
+	if expires == 0 {
+		expiresOn, err := time.ParseInLocation("2006-01-02 15:04:05.999999", result.ExpiresOn, time.Local)
+		if err != nil {
+			return fmt.Errorf("failed to decode Azure CLI token expiration: %v", err)
+		}
+		expires = expiresOn.Unix()
+	}
+
+	t.mu.Lock()
+	t.token = adal.Token{
+		AccessToken: result.AccessToken,
+		ExpiresOn:   strconv.FormatInt(expires, 10),
+		Resource:    resource,
+		Type:        result.TokenType,
+	}
+	t.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNoBootDiagnostics is returned when the boot diagnostics of the VM are
+// not available, such as when they are not enabled.
+var ErrNoBootDiagnostics = errors.New("Boot diagnostics are not available for the VM")
+
+// bootDiagnostics holds the SAS URIs of the boot diagnostics of a VM.
+type bootDiagnostics struct {
+	ConsoleScreenshotBlobURI string `json:"consoleScreenshotBlobUri"`
+	SerialConsoleLogBlobURI  string `json:"serialConsoleLogBlobUri"`
+}
+
+// getBootDiagnostics returns the SAS URIs of the boot diagnostics of the VM.
+func (vm *VM) getBootDiagnostics() (*bootDiagnostics, error) {
+	path := vm.resourceID("Microsoft.Compute/virtualMachines", vm.Name) + "/retrieveBootDiagnosticsData"
+
+	var result bootDiagnostics
+	if _, err := vm.sendRequest("POST", path, computeAPIVersion, nil, &result); err != nil {
+		return nil, fmt.Errorf("failed to retrieve boot diagnostics: %v", err)
+	}
+	return &result, nil
+}
+
+// GetConsoleOutput returns the last lines of the serial console log of the
+// VM, or the whole log if lines is not positive. Boot diagnostics must be
+// enabled.
+func (vm *VM) GetConsoleOutput(lines int) (string, error) {
+	diag, err := vm.getBootDiagnostics()
+	if err != nil {
+		return "", err
+	}
+	if diag.SerialConsoleLogBlobURI == "" {
+		return "", ErrNoBootDiagnostics
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(diag.SerialConsoleLogBlobURI)
+	if err != nil {
+		return "", fmt.Errorf("failed to download the serial console log: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download the serial console log: %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to download the serial console log: %v", err)
+	}
+
+	output := string(b)
+	if lines <= 0 {
+		return output, nil
+	}
+	all := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return strings.Join(all, "\n"), nil
+}
+
+// GetScreenshotURL returns a URL of the console screenshot of the VM, which
+// is valid for two hours. Boot diagnostics must be enabled.
+func (vm *VM) GetScreenshotURL() (string, error) {
+	diag, err := vm.getBootDiagnostics()
+	if err != nil {
+		return "", err
+	}
+	if diag.ConsoleScreenshotBlobURI == "" {
+		return "", ErrNoBootDiagnostics
+	}
+	return diag.ConsoleScreenshotBlobURI, nil
+}
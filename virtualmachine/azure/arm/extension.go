@@ -0,0 +1,115 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// maxCustomDataSize is the maximum size of the custom data of a VM.
+const maxCustomDataSize = 65535
+
+// Extension is a VM extension which configures the VM after it boots, such as
+// running a script or enabling Azure AD login. The extensions of a VM are
+// installed one after the other, and Provision fails if one of them fails.
+type Extension struct {
+	// Name is the name of the extension resource.
+	Name string
+
+	// Publisher, Type and TypeHandlerVersion identify the extension, such
+	// as "Microsoft.Azure.Extensions", "CustomScript" and "2.1".
+	Publisher          string
+	Type               string
+	TypeHandlerVersion string
+
+	// AutoUpgradeMinorVersion lets Azure pick the latest minor version of
+	// the extension.
+	AutoUpgradeMinorVersion bool
+
+	// Settings [optional] is the public configuration of the extension.
+	Settings map[string]interface{}
+
+	// ProtectedSettings [optional] is the configuration of the extension
+	// which is encrypted and never returned by the API, such as secrets.
+	ProtectedSettings map[string]interface{}
+}
+
+// CustomScriptExtension returns an extension which downloads the files and
+// runs the command on a Linux VM. The command is a protected setting, so it
+// may contain secrets.
+func CustomScriptExtension(command string, fileURIs ...string) Extension {
+	ext := Extension{
+		Name:                    "CustomScript",
+		Publisher:               "Microsoft.Azure.Extensions",
+		Type:                    "CustomScript",
+		TypeHandlerVersion:      "2.1",
+		AutoUpgradeMinorVersion: true,
+		ProtectedSettings:       map[string]interface{}{"commandToExecute": command},
+	}
+	if len(fileURIs) > 0 {
+		ext.Settings = map[string]interface{}{"fileUris": fileURIs}
+	}
+	return ext
+}
+
+// AADLoginExtension returns an extension which enables SSH login to a Linux VM
+// with Azure AD accounts. The VM must have a system-assigned identity.
+func AADLoginExtension() Extension {
+	return Extension{
+		Name:                    "AADSSHLoginForLinux",
+		Publisher:               "Microsoft.Azure.ActiveDirectory",
+		Type:                    "AADSSHLoginForLinux",
+		TypeHandlerVersion:      "1.0",
+		AutoUpgradeMinorVersion: true,
+	}
+}
+
+// armExtensions returns the extensions parameter of the arm template. It is
+// an object, as arrays cannot be secure parameters.
+func (vm *VM) armExtensions() map[string]interface{} {
+	list := make([]interface{}, 0, len(vm.Extensions))
+	for _, ext := range vm.Extensions {
+		properties := map[string]interface{}{
+			"publisher":               ext.Publisher,
+			"type":                    ext.Type,
+			"typeHandlerVersion":      ext.TypeHandlerVersion,
+			"autoUpgradeMinorVersion": ext.AutoUpgradeMinorVersion,
+		}
+		if ext.Settings != nil {
+			properties["settings"] = ext.Settings
+		}
+		if ext.ProtectedSettings != nil {
+			properties["protectedSettings"] = ext.ProtectedSettings
+		}
+		list = append(list, map[string]interface{}{
+			"name":       ext.Name,
+			"properties": properties,
+		})
+	}
+	return map[string]interface{}{"list": list}
+}
+
+// customData returns the base64 encoded custom data of the VM.
+func (vm *VM) customData() string {
+	return base64.StdEncoding.EncodeToString(vm.CustomData)
+}
+
+// validateExtensions validates the custom data and extensions of the given VM.
+func validateExtensions(vm *VM) error {
+	if len(vm.CustomData) > maxCustomDataSize {
+		return fmt.Errorf("custom data must not exceed %d bytes", maxCustomDataSize)
+	}
+
+	names := make(map[string]bool)
+	for _, ext := range vm.Extensions {
+		if ext.Name == "" || ext.Publisher == "" || ext.Type == "" || ext.TypeHandlerVersion == "" {
+			return fmt.Errorf("an extension must have a name, publisher, type and type handler version")
+		}
+		if names[ext.Name] {
+			return fmt.Errorf("duplicate extension name %q", ext.Name)
+		}
+		names[ext.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+)
+
+// marketplaceOrderingAPIVersion is the API version of the marketplace terms
+// agreements.
+const marketplaceOrderingAPIVersion = "2021-01-01"
+
+// CaptureTimeout is the maximum time to wait for an image to be captured,
+// including the replication of gallery image versions. This is not
+// thread-safe.
+var CaptureTimeout = 60 * time.Minute
+
+// Plan is the purchase plan of a marketplace image. Images with a plan can
+// only be deployed once their terms are accepted for the subscription.
+type Plan struct {
+	Name      string
+	Publisher string
+	Product   string
+}
+
+// imageReference returns the image reference of the storage profile of the
+// VM. ImageID takes precedence over the marketplace image.
+func (vm *VM) imageReference() map[string]interface{} {
+	id := strings.ToLower(vm.ImageID)
+	switch {
+	case strings.HasPrefix(id, "/communitygalleries/"):
+		return map[string]interface{}{"communityGalleryImageId": vm.ImageID}
+	case strings.HasPrefix(id, "/sharedgalleries/"):
+		return map[string]interface{}{"sharedGalleryImageId": vm.ImageID}
+	case id != "":
+		return map[string]interface{}{"id": vm.ImageID}
+	}
+
+	version := vm.ImageVersion
+	if version == "" {
+		version = "latest"
+	}
+	return map[string]interface{}{
+		"publisher": vm.ImagePublisher,
+		"offer":     vm.ImageOffer,
+		"sku":       vm.ImageSku,
+		"version":   version,
+	}
+}
+
+// plan returns the purchase plan of the VM, which is empty if the image has
+// none.
+func (vm *VM) plan() map[string]interface{} {
+	if vm.Plan == nil {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"name":      vm.Plan.Name,
+		"publisher": vm.Plan.Publisher,
+		"product":   vm.Plan.Product,
+	}
+}
+
+// validateImage validates the image and plan of the given VM.
+func validateImage(vm *VM) error {
+	if vm.ImageID == "" {
+		if vm.ImagePublisher == "" {
+			return fmt.Errorf("an image publisher must be specified")
+		}
+
+		if vm.ImageOffer == "" {
+			return fmt.Errorf("an image offer must be specified")
+		}
+
+		if vm.ImageSku == "" {
+			return fmt.Errorf("an image sku must be specified")
+		}
+	}
+
+	if vm.Plan != nil {
+		if vm.Plan.Name == "" || vm.Plan.Publisher == "" || vm.Plan.Product == "" {
+			return fmt.Errorf("a plan must have a name, publisher and product")
+		}
+	} else if vm.AcceptPlanTerms {
+		return fmt.Errorf("a plan must be specified to accept its terms")
+	}
+
+	return nil
+}
+
+// acceptPlanTerms accepts the marketplace terms of the plan of the VM for the
+// subscription, unless they are already accepted.
+func (vm *VM) acceptPlanTerms() error {
+	path := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.MarketplaceOrdering/offerTypes/virtualmachine/publishers/%s/offers/%s/plans/%s/agreements/current",
+		vm.Creds.SubscriptionID, vm.Plan.Publisher, vm.Plan.Product, vm.Plan.Name)
+
+	var agreement struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if _, err := vm.sendRequest("GET", path, marketplaceOrderingAPIVersion, nil, &agreement); err != nil {
+		return fmt.Errorf("failed to get the terms of plan %q: %v", vm.Plan.Name, err)
+	}
+
+	if accepted, _ := agreement.Properties["accepted"].(bool); accepted {
+		return nil
+	}
+
+	if agreement.Properties == nil {
+		agreement.Properties = make(map[string]interface{})
+	}
+	agreement.Properties["accepted"] = true
+	if _, err := vm.sendRequest("PUT", path, marketplaceOrderingAPIVersion, agreement, nil); err != nil {
+		return fmt.Errorf("failed to accept the terms of plan %q: %v", vm.Plan.Name, err)
+	}
+	return nil
+}
+
+// CreateImage generalizes the VM and captures it to a managed image with the
+// given name in the VM's resource group. It returns the resource ID of the
+// image. The guest must be deprovisioned first, such as with
+// "waagent -deprovision+user", and the VM cannot be started afterwards.
+func (vm *VM) CreateImage(name string) (string, error) {
+	location, generation, err := vm.generalize()
+	if err != nil {
+		return "", err
+	}
+
+	path := vm.resourceID("Microsoft.Compute/images", name)
+	body := map[string]interface{}{
+		"location": location,
+		"properties": map[string]interface{}{
+			"sourceVirtualMachine": map[string]interface{}{
+				"id": vm.resourceID("Microsoft.Compute/virtualMachines", vm.Name),
+			},
+			"hyperVGeneration": generation,
+		},
+	}
+	if _, err := vm.sendRequest("PUT", path, computeAPIVersion, body, nil); err != nil {
+		return "", fmt.Errorf("failed to create image %q: %v", name, err)
+	}
+	if err := vm.waitForProvisioning(path, computeAPIVersion, CaptureTimeout); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// CreateGalleryImageVersion generalizes the VM and captures it to a new
+// version of the gallery image with the given resource ID, whose definition
+// must match the OS and generation of the VM. It returns the resource ID of
+// the image version. The guest must be deprovisioned first, and the VM cannot
+// be started afterwards.
+func (vm *VM) CreateGalleryImageVersion(galleryImageID string, version string) (string, error) {
+	location, _, err := vm.generalize()
+	if err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSuffix(galleryImageID, "/") + "/versions/" + version
+	body := map[string]interface{}{
+		"location": location,
+		"properties": map[string]interface{}{
+			"storageProfile": map[string]interface{}{
+				"source": map[string]interface{}{
+					"id": vm.resourceID("Microsoft.Compute/virtualMachines", vm.Name),
+				},
+			},
+		},
+	}
+	if _, err := vm.sendRequest("PUT", path, galleryAPIVersion, body, nil); err != nil {
+		return "", fmt.Errorf("failed to create gallery image version %q: %v", version, err)
+	}
+	if err := vm.waitForProvisioning(path, galleryAPIVersion, CaptureTimeout); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// generalize deallocates and generalizes the VM. It returns the location and
+// the Hyper-V generation of the VM.
+func (vm *VM) generalize() (string, string, error) {
+	path := vm.resourceID("Microsoft.Compute/virtualMachines", vm.Name)
+
+	var result struct {
+		Location string `json:"location"`
+	}
+	if _, err := vm.sendRequest("GET", path, computeAPIVersion, nil, &result); err != nil {
+		return "", "", err
+	}
+
+	var instanceView struct {
+		HyperVGeneration string `json:"hyperVGeneration"`
+	}
+	if _, err := vm.sendRequest("GET", path+"/instanceView", computeAPIVersion, nil, &instanceView); err != nil {
+		return "", "", err
+	}
+	if instanceView.HyperVGeneration == "" {
+		instanceView.HyperVGeneration = "V1"
+	}
+
+	authorizer, err := vm.getAuthorizer()
+	if err != nil {
+		return "", "", err
+	}
+
+	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
+	virtualMachinesClient.Authorizer = authorizer
+
+	_, errc := virtualMachinesClient.Deallocate(vm.ResourceGroup, vm.Name, nil)
+	if err := <-errc; err != nil {
+		return "", "", fmt.Errorf("failed to deallocate the VM: %v", err)
+	}
+
+	if _, err := virtualMachinesClient.Generalize(vm.ResourceGroup, vm.Name); err != nil {
+		return "", "", fmt.Errorf("failed to generalize the VM: %v", err)
+	}
+
+	return result.Location, instanceView.HyperVGeneration, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"fmt"
+	"net"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+)
+
+const (
+	// AllocationDynamic allocates the public IP address when the VM starts
+	// and releases it when the VM is deallocated.
+	AllocationDynamic = "Dynamic"
+
+	// AllocationStatic allocates the public IP address when it is created,
+	// so that it does not change until it is deleted.
+	AllocationStatic = "Static"
+
+	// PublicIPSKUBasic is the SKU of basic public IP addresses.
+	PublicIPSKUBasic = "Basic"
+
+	// PublicIPSKUStandard is the SKU of standard public IP addresses, which
+	// are always static and closed to inbound traffic unless a network
+	// security group allows it.
+	PublicIPSKUStandard = "Standard"
+)
+
+var _ lvm.Addresser = (*VM)(nil)
+
+// armPublicIP is an element of the public_ips parameter of the arm template.
+type armPublicIP struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	DNSLabel string `json:"dns_label"`
+}
+
+// ipv6PublicIPName returns the name of the IPv6 public IP address of the VM.
+func (vm *VM) ipv6PublicIPName() string {
+	return vm.PublicIP + "-v6"
+}
+
+// armPublicIPs returns the public IP addresses to create for the VM.
+func (vm *VM) armPublicIPs() []armPublicIP {
+	label := vm.DNSLabel
+	if label == "" {
+		label = vm.PublicIP
+	}
+
+	ips := []armPublicIP{{Name: vm.PublicIP, Version: string(network.IPv4), DNSLabel: label}}
+	if vm.IPv6 {
+		ips = append(ips, armPublicIP{Name: vm.ipv6PublicIPName(), Version: string(network.IPv6)})
+	}
+	return ips
+}
+
+// publicIPSKU returns the SKU of the public IP addresses of the VM.
+func (vm *VM) publicIPSKU() string {
+	if vm.PublicIPSKU == "" {
+		return PublicIPSKUBasic
+	}
+	return vm.PublicIPSKU
+}
+
+// publicIPAllocation returns the allocation method of the public IP addresses
+// of the VM.
+func (vm *VM) publicIPAllocation() string {
+	switch {
+	case vm.PublicIPAllocation != "":
+		return vm.PublicIPAllocation
+	case vm.publicIPSKU() == PublicIPSKUStandard:
+		return AllocationStatic
+	default:
+		return AllocationDynamic
+	}
+}
+
+// ipConfigurations returns the IP configurations of a network interface on
+// the given subnet. Only the primary network interface has public IPs.
+func (vm *VM) ipConfigurations(subnet string, primary bool) []interface{} {
+	config := func(name string, version network.IPVersion, publicIP string) map[string]interface{} {
+		properties := map[string]interface{}{
+			"primary":                   version == network.IPv4,
+			"privateIPAllocationMethod": AllocationDynamic,
+			"privateIPAddressVersion":   version,
+			"subnet":                    map[string]interface{}{"id": subnet},
+		}
+		if primary {
+			properties["publicIPAddress"] = map[string]interface{}{
+				"id": vm.resourceID("Microsoft.Network/publicIPAddresses", publicIP),
+			}
+		}
+		return map[string]interface{}{"name": name, "properties": properties}
+	}
+
+	configs := []interface{}{config("ipconfig", network.IPv4, vm.PublicIP)}
+	if vm.IPv6 {
+		configs = append(configs, config("ipconfig-v6", network.IPv6, vm.ipv6PublicIPName()))
+	}
+	return configs
+}
+
+// validatePublicIP validates the public IP options of the given VM.
+func validatePublicIP(vm *VM) error {
+	switch vm.publicIPSKU() {
+	case PublicIPSKUBasic, PublicIPSKUStandard:
+	default:
+		return fmt.Errorf("unknown public IP SKU %q", vm.PublicIPSKU)
+	}
+
+	switch vm.publicIPAllocation() {
+	case AllocationDynamic:
+		if vm.publicIPSKU() == PublicIPSKUStandard {
+			return fmt.Errorf("standard public IPs must be static")
+		}
+	case AllocationStatic:
+	default:
+		return fmt.Errorf("unknown public IP allocation %q", vm.PublicIPAllocation)
+	}
+
+	if vm.IPv6 && vm.publicIPSKU() != PublicIPSKUStandard {
+		return fmt.Errorf("IPv6 requires standard public IPs")
+	}
+	return nil
+}
+
+// GetFQDN returns the fully qualified domain name of the public IP address of
+// the VM.
+func (vm *VM) GetFQDN() (string, error) {
+	authorizer, err := vm.getAuthorizer()
+	if err != nil {
+		return "", err
+	}
+
+	publicIPAddressesClient := network.NewPublicIPAddressesClient(vm.Creds.SubscriptionID)
+	publicIPAddressesClient.Authorizer = authorizer
+
+	resPublicIP, err := publicIPAddressesClient.Get(vm.ResourceGroup, vm.PublicIP, "")
+	if err != nil {
+		return "", err
+	}
+
+	props := resPublicIP.PublicIPAddressPropertiesFormat
+	if props == nil || props.DNSSettings == nil || props.DNSSettings.Fqdn == nil {
+		return "", fmt.Errorf("VM has no DNS name")
+	}
+	return *props.DNSSettings.Fqdn, nil
+}
+
+// GetAddresses returns the public IPv4 address and the private addresses of
+// the primary network interface of the VM, as well as its IPv6 addresses if
+// IPv6 is enabled.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	authorizer, err := vm.getAuthorizer()
+	if err != nil {
+		return nil, err
+	}
+
+	publicIPAddressesClient := network.NewPublicIPAddressesClient(vm.Creds.SubscriptionID)
+	publicIPAddressesClient.Authorizer = authorizer
+
+	var addresses []lvm.Address
+	names := []string{vm.PublicIP}
+	if vm.IPv6 {
+		names = append(names, vm.ipv6PublicIPName())
+	}
+	for _, name := range names {
+		resPublicIP, err := publicIPAddressesClient.Get(vm.ResourceGroup, name, "")
+		if err != nil {
+			return nil, err
+		}
+		props := resPublicIP.PublicIPAddressPropertiesFormat
+		if props != nil && props.IPAddress != nil && *props.IPAddress != "" {
+			addresses = append(addresses, lvm.Address{IP: net.ParseIP(*props.IPAddress), Public: true})
+		}
+	}
+
+	interfaceClient := network.NewInterfacesClient(vm.Creds.SubscriptionID)
+	interfaceClient.Authorizer = authorizer
+
+	iface, err := interfaceClient.Get(vm.ResourceGroup, vm.Nic, "")
+	if err != nil {
+		return nil, err
+	}
+	if iface.InterfacePropertiesFormat != nil && iface.InterfacePropertiesFormat.IPConfigurations != nil {
+		for _, config := range *iface.InterfacePropertiesFormat.IPConfigurations {
+			props := config.InterfaceIPConfigurationPropertiesFormat
+			if props != nil && props.PrivateIPAddress != nil {
+				addresses = append(addresses, lvm.Address{IP: net.ParseIP(*props.PrivateIPAddress)})
+			}
+		}
+	}
+
+	return addresses, nil
+}
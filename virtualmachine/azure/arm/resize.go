@@ -0,0 +1,77 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+
+	"github.com/Azure/azure-sdk-for-go/arm/compute"
+)
+
+// ResizeTimeout is the maximum time to wait for a VM to be resized. This is
+// not thread-safe.
+var ResizeTimeout = 10 * time.Minute
+
+// Resize changes the size of the VM. If the hardware cluster hosting the VM
+// does not offer the size, the VM is deallocated, resized and started again
+// if it was running.
+func (vm *VM) Resize(size string) error {
+	authorizer, err := vm.getAuthorizer()
+	if err != nil {
+		return err
+	}
+
+	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
+	virtualMachinesClient.Authorizer = authorizer
+
+	sizes, err := virtualMachinesClient.ListAvailableSizes(vm.ResourceGroup, vm.Name)
+	if err != nil {
+		return fmt.Errorf("failed to list the available sizes: %v", err)
+	}
+
+	available := false
+	if sizes.Value != nil {
+		for _, s := range *sizes.Value {
+			if s.Name != nil && strings.EqualFold(*s.Name, size) {
+				available = true
+				break
+			}
+		}
+	}
+
+	var state string
+	if !available {
+		state, err = vm.GetState()
+		if err != nil {
+			return err
+		}
+
+		_, errc := virtualMachinesClient.Deallocate(vm.ResourceGroup, vm.Name, nil)
+		if err := <-errc; err != nil {
+			return fmt.Errorf("failed to deallocate the VM: %v", err)
+		}
+	}
+
+	path := vm.resourceID("Microsoft.Compute/virtualMachines", vm.Name)
+	body := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"hardwareProfile": map[string]interface{}{"vmSize": size},
+		},
+	}
+	if _, err := vm.sendRequest("PATCH", path, computeAPIVersion, body, nil); err != nil {
+		return fmt.Errorf("failed to resize the VM: %v", err)
+	}
+	if err := vm.waitForProvisioning(path, computeAPIVersion, ResizeTimeout); err != nil {
+		return err
+	}
+	vm.Size = size
+
+	if !available && state == lvm.VMRunning {
+		return vm.Start()
+	}
+	return nil
+}
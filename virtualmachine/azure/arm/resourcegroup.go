@@ -0,0 +1,111 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// diskAPIVersion is the API version of the managed disks.
+const diskAPIVersion = "2022-07-02"
+
+// ErrResourceGroupExists is returned when CreateResourceGroup is set and the
+// resource group of the VM exists already. Destroy deletes the resource group
+// it creates, so it never reuses one.
+var ErrResourceGroupExists = errors.New("Resource group exists already")
+
+// armTags returns the tags of the resources of the VM.
+func (vm *VM) armTags() map[string]string {
+	if vm.Tags == nil {
+		return map[string]string{}
+	}
+	return vm.Tags
+}
+
+// validateResourceGroup validates the resource group options of the given VM.
+// A resource group created for the VM holds no network, so the subnet and
+// network security group must be given by resource ID.
+func validateResourceGroup(vm *VM) error {
+	if !vm.CreateResourceGroup {
+		return nil
+	}
+
+	if vm.Location == "" {
+		return fmt.Errorf("a location must be specified to create a resource group")
+	}
+
+	if vm.SubnetID == "" {
+		return fmt.Errorf("a subnet id must be specified with a new resource group")
+	}
+
+	if vm.NetworkSecurityGroup != "" && !strings.HasPrefix(vm.NetworkSecurityGroup, "/") {
+		return fmt.Errorf("the network security group must be a resource id with a new resource group")
+	}
+
+	if vm.ProximityPlacementGroup != "" && !strings.HasPrefix(vm.ProximityPlacementGroup, "/") {
+		return fmt.Errorf("the proximity placement group must be a resource id with a new resource group")
+	}
+
+	return nil
+}
+
+// createResourceGroup creates the resource group of the VM with the tags of
+// the VM, and returns ErrResourceGroupExists if it exists already.
+func (vm *VM) createResourceGroup(authorizer autorest.Authorizer) error {
+	groupsClient := resources.NewGroupsClient(vm.Creds.SubscriptionID)
+	groupsClient.Authorizer = authorizer
+
+	resp, err := groupsClient.CheckExistence(vm.ResourceGroup)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return ErrResourceGroupExists
+	}
+
+	tags := make(map[string]*string)
+	for k, v := range vm.Tags {
+		v := v
+		tags[k] = &v
+	}
+
+	_, err = groupsClient.CreateOrUpdate(vm.ResourceGroup, resources.Group{
+		Location: &vm.Location,
+		Tags:     &tags,
+	})
+	return err
+}
+
+// deleteResourceGroup deletes the resource group of the VM with all of its
+// resources, returns an error if the operation does not succeed.
+func (vm *VM) deleteResourceGroup(authorizer autorest.Authorizer) error {
+	groupsClient := resources.NewGroupsClient(vm.Creds.SubscriptionID)
+	groupsClient.Authorizer = authorizer
+
+	_, errc := groupsClient.Delete(vm.ResourceGroup, nil)
+	return <-errc
+}
+
+// tagDisks sets the tags of the VM on its managed disks, which do not inherit
+// the tags of the VM they are created with.
+func (vm *VM) tagDisks() error {
+	disks := []string{vm.OsFile}
+	if vm.DiskSize > 0 {
+		disks = append(disks, vm.DiskFile)
+	}
+
+	body := map[string]interface{}{"tags": vm.armTags()}
+	for _, disk := range disks {
+		path := vm.resourceID("Microsoft.Compute/disks", disk)
+		if _, err := vm.sendRequest("PATCH", path, diskAPIVersion, body, nil); err != nil {
+			return fmt.Errorf("failed to tag disk %q: %v", disk, err)
+		}
+	}
+	return nil
+}
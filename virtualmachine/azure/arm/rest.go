@@ -0,0 +1,93 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package arm
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const (
+	// computeAPIVersion is the API version of the compute resources, as used
+	// by the arm template.
+	computeAPIVersion = "2022-08-01"
+
+	// galleryAPIVersion is the API version of the compute gallery resources.
+	galleryAPIVersion = "2022-03-03"
+)
+
+// sendRequest sends a request of the given method to the path of the Azure
+// Resource Manager API, for the operations which the vendored SDK lacks. The
+// request body is the JSON encoding of in, if not nil, and the JSON response
+// is decoded into out, if not nil.
+func (vm *VM) sendRequest(method string, path string, apiVersion string, in interface{}, out interface{}) (*http.Response, error) {
+	authorizer, err := vm.getAuthorizer()
+	if err != nil {
+		return nil, err
+	}
+
+	decorators := []autorest.PrepareDecorator{
+		autorest.WithMethod(method),
+		autorest.WithBaseURL(azure.PublicCloud.ResourceManagerEndpoint),
+		autorest.WithPath(path),
+		autorest.WithQueryParameters(map[string]interface{}{"api-version": apiVersion}),
+		authorizer.WithAuthorization(),
+	}
+	if in != nil {
+		decorators = append(decorators, autorest.AsJSON(), autorest.WithJSON(in))
+	}
+
+	req, err := autorest.Prepare(&http.Request{}, decorators...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := autorest.NewClientWithUserAgent("libretto")
+	resp, err := autorest.SendWithSender(client, req)
+	if err != nil {
+		return resp, err
+	}
+
+	responders := []autorest.RespondDecorator{
+		azure.WithErrorUnlessStatusCode(http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent),
+	}
+	if out != nil {
+		responders = append(responders, autorest.ByUnmarshallingJSON(out))
+	}
+	responders = append(responders, autorest.ByClosing())
+
+	return resp, autorest.Respond(resp, responders...)
+}
+
+// waitForProvisioning waits until the provisioning state of the resource at
+// the path is succeeded, and returns an error if provisioning fails or does
+// not succeed within timeout.
+func (vm *VM) waitForProvisioning(path string, apiVersion string, timeout time.Duration) error {
+	start := time.Now()
+	for {
+		var result struct {
+			Properties struct {
+				ProvisioningState string `json:"provisioningState"`
+			} `json:"properties"`
+		}
+		if _, err := vm.sendRequest("GET", path, apiVersion, nil, &result); err != nil {
+			return err
+		}
+
+		switch state := result.Properties.ProvisioningState; state {
+		case succeeded:
+			return nil
+		case "Failed", "Canceled":
+			return fmt.Errorf("provisioning of %s ended in state %s", path, state)
+		}
+
+		if time.Since(start) >= timeout {
+			return ErrActionTimeout
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -11,43 +11,46 @@ const Linux = `{
     "password": {
       "type": "string"
     },
-    "image_publisher": {
-      "type": "string"
+    "image_reference": {
+      "type": "object"
     },
-    "image_offer": {
-      "type": "string"
+    "plan": {
+      "type": "object"
     },
-    "image_sku": {
+    "network_security_group": {
       "type": "string"
     },
-    "network_security_group": {
+    "nics": {
+      "type": "array"
+    },
+    "os_file": {
       "type": "string"
     },
-    "nic": {
+    "os_disk_size": {
       "type": "string"
     },
-    "os_file": {
+    "os_disk_sku": {
       "type": "string"
     },
     "disk_file": {
       "type": "string"
     },
-    "public_ip": {
+    "disk_sku": {
       "type": "string"
     },
-    "ssh_authorized_key": {
+    "disk_encryption_set": {
       "type": "string"
     },
-    "storage_account": {
-      "type": "string"
+    "public_ips": {
+      "type": "array"
     },
-    "storage_container": {
+    "public_ip_sku": {
       "type": "string"
     },
-    "subnet": {
+    "public_ip_allocation": {
       "type": "string"
     },
-    "virtual_network": {
+    "ssh_authorized_key": {
       "type": "string"
     },
     "vm_size": {
@@ -61,19 +64,53 @@ const Linux = `{
     },
     "additional_disk": {
       "type": "string"
+    },
+    "zone": {
+      "type": "string"
+    },
+    "proximity_placement_group": {
+      "type": "string"
+    },
+    "priority": {
+      "type": "string"
+    },
+    "eviction_policy": {
+      "type": "string"
+    },
+    "max_price": {
+      "type": "string"
+    },
+    "custom_data": {
+      "type": "securestring"
+    },
+    "extensions": {
+      "type": "secureObject"
+    },
+    "system_assigned_identity": {
+      "type": "bool"
+    },
+    "boot_diagnostics": {
+      "type": "bool"
+    },
+    "boot_diagnostics_storage_uri": {
+      "type": "string"
+    },
+    "tags": {
+      "type": "object"
     }
   },
   "variables": {
     "diskAttachment": {
       "true": {
         "disks": [{
-          "name": "datadisk1",
-          "diskSizeGB": "[parameters('disk_size')]",
+          "name": "[parameters('disk_file')]",
+          "diskSizeGB": "[int(parameters('disk_size'))]",
           "lun": 0,
-          "vhd": {
-            "uri": "[concat('http://',parameters('storage_account'),'.blob.core.windows.net/',parameters('storage_container'),'/', parameters('disk_file'))]"
-          },
-          "createOption": "Empty"
+          "createOption": "Empty",
+          "managedDisk": {
+            "storageAccountType": "[if(empty(parameters('disk_sku')), json('null'), parameters('disk_sku'))]",
+            "diskEncryptionSet": "[variables('disk_encryption_set')]"
+          }
         }]
       },
       "false": {
@@ -82,67 +119,78 @@ const Linux = `{
     },
     "disksSettings": "[variables('diskAttachment')[parameters('additional_disk')]]",
     "disksArray": "[variables('disksSettings').disks]",
-    "api_version": "2015-06-15",
-    "location": "[resourceGroup().location]",
-    "subnet_ref": "[concat(variables('vnet_id'),'/subnets/',parameters('subnet'))]",
-    "vnet_id": "[resourceId('Microsoft.Network/virtualNetworks', parameters('virtual_network'))]"
+    "disk_encryption_set": "[if(empty(parameters('disk_encryption_set')), json('null'), createObject('id', parameters('disk_encryption_set')))]",
+    "network_security_group": "[if(empty(parameters('network_security_group')), json('null'), createObject('id', parameters('network_security_group')))]",
+    "api_version": "2020-06-01",
+    "compute_api_version": "2022-08-01",
+    "location": "[resourceGroup().location]"
   },
   "resources": [
     {
       "apiVersion": "[variables('api_version')]",
       "type": "Microsoft.Network/publicIPAddresses",
-      "name": "[parameters('public_ip')]",
+      "name": "[parameters('public_ips')[copyIndex()].name]",
       "location": "[variables('location')]",
+      "tags": "[parameters('tags')]",
+      "copy": {
+        "name": "public_ips",
+        "count": "[length(parameters('public_ips'))]"
+      },
+      "sku": {
+        "name": "[parameters('public_ip_sku')]"
+      },
       "properties": {
-        "publicIPAllocationMethod": "Dynamic",
-        "dnsSettings": {
-          "domainNameLabel": "[parameters('public_ip')]"
-        }
+        "publicIPAllocationMethod": "[parameters('public_ip_allocation')]",
+        "publicIPAddressVersion": "[parameters('public_ips')[copyIndex()].version]",
+        "dnsSettings": "[if(empty(parameters('public_ips')[copyIndex()].dns_label), json('null'), createObject('domainNameLabel', parameters('public_ips')[copyIndex()].dns_label))]"
       }
     },
     {
       "apiVersion": "[variables('api_version')]",
       "type": "Microsoft.Network/networkInterfaces",
-      "name": "[parameters('nic')]",
+      "name": "[parameters('nics')[copyIndex()].name]",
       "location": "[variables('location')]",
+      "tags": "[parameters('tags')]",
+      "copy": {
+        "name": "nics",
+        "count": "[length(parameters('nics'))]"
+      },
       "dependsOn": [
-        "[concat('Microsoft.Network/publicIPAddresses/', parameters('public_ip'))]"
+        "public_ips"
       ],
       "properties": {
-        "ipConfigurations": [
-          {
-            "name": "ipconfig",
-            "properties": {
-              "privateIPAllocationMethod": "Dynamic",
-              "publicIPAddress": {
-                "id": "[resourceId('Microsoft.Network/publicIPAddresses', parameters('public_ip'))]"
-              },
-              "subnet": {
-                "id": "[variables('subnet_ref')]"
-              }
-            }
-          }
-        ],
-        "networkSecurityGroup": {
-          "id": "[resourceId('Microsoft.Network/networkSecurityGroups', parameters('network_security_group'))]"
-        }
+        "enableAcceleratedNetworking": "[parameters('nics')[copyIndex()].accelerated_networking]",
+        "ipConfigurations": "[parameters('nics')[copyIndex()].ip_configurations]",
+        "networkSecurityGroup": "[variables('network_security_group')]"
       }
     },
     {
-      "apiVersion": "[variables('api_version')]",
+      "apiVersion": "[variables('compute_api_version')]",
       "type": "Microsoft.Compute/virtualMachines",
       "name": "[parameters('vm_name')]",
       "location": "[variables('location')]",
+      "tags": "[parameters('tags')]",
+      "plan": "[if(empty(parameters('plan')), json('null'), parameters('plan'))]",
+      "zones": "[if(empty(parameters('zone')), json('null'), createArray(parameters('zone')))]",
+      "identity": "[if(parameters('system_assigned_identity'), createObject('type', 'SystemAssigned'), json('null'))]",
       "dependsOn": [
-        "[concat('Microsoft.Network/networkInterfaces/', parameters('nic'))]"
+        "nics"
       ],
       "properties": {
         "hardwareProfile": {
           "vmSize": "[parameters('vm_size')]"
         },
+        "priority": "[if(empty(parameters('priority')), json('null'), parameters('priority'))]",
+        "evictionPolicy": "[if(empty(parameters('eviction_policy')), json('null'), parameters('eviction_policy'))]",
+        "billingProfile": "[if(equals(parameters('priority'), 'Spot'), createObject('maxPrice', json(parameters('max_price'))), json('null'))]",
+        "proximityPlacementGroup": "[if(empty(parameters('proximity_placement_group')), json('null'), createObject('id', parameters('proximity_placement_group')))]",
+        "additionalCapabilities": {
+          "ultraSSDEnabled": "[equals(parameters('disk_sku'), 'UltraSSD_LRS')]"
+        },
         "osProfile": {
           "computerName": "[parameters('vm_name')]",
           "adminUsername": "[parameters('username')]",
+          "customData": "[if(empty(parameters('custom_data')), json('null'), parameters('custom_data'))]",
           "linuxConfiguration": {
             "disablePasswordAuthentication": true,
             "ssh": {
@@ -156,35 +204,57 @@ const Linux = `{
           }
         },
         "storageProfile": {
-          "imageReference": {
-            "publisher": "[parameters('image_publisher')]",
-            "offer": "[parameters('image_offer')]",
-            "sku": "[parameters('image_sku')]",
-            "version": "latest"
-          },
+          "imageReference": "[parameters('image_reference')]",
           "dataDisks": "[variables('disksArray')]",
           "osDisk": {
-            "name": "osdisk",
-            "vhd": {
-              "uri": "[concat('http://',parameters('storage_account'),'.blob.core.windows.net/',parameters('storage_container'),'/', parameters('os_file'))]"
-            },
+            "name": "[parameters('os_file')]",
+            "diskSizeGB": "[if(equals(parameters('os_disk_size'), '0'), json('null'), int(parameters('os_disk_size')))]",
             "caching": "ReadWrite",
-            "createOption": "FromImage"
+            "createOption": "FromImage",
+            "managedDisk": {
+              "storageAccountType": "[if(empty(parameters('os_disk_sku')), json('null'), parameters('os_disk_sku'))]",
+              "diskEncryptionSet": "[variables('disk_encryption_set')]"
+            }
           }
         },
         "networkProfile": {
-          "networkInterfaces": [
+          "copy": [
             {
-              "id": "[resourceId('Microsoft.Network/networkInterfaces', parameters('nic'))]"
+              "name": "networkInterfaces",
+              "count": "[length(parameters('nics'))]",
+              "input": {
+                "id": "[resourceId('Microsoft.Network/networkInterfaces', parameters('nics')[copyIndex('networkInterfaces')].name)]",
+                "properties": {
+                  "primary": "[equals(copyIndex('networkInterfaces'), 0)]"
+                }
+              }
             }
           ]
         },
         "diagnosticsProfile": {
           "bootDiagnostics": {
-             "enabled": false
+            "enabled": "[parameters('boot_diagnostics')]",
+            "storageUri": "[if(empty(parameters('boot_diagnostics_storage_uri')), json('null'), parameters('boot_diagnostics_storage_uri'))]"
           }
         }
       }
+    },
+    {
+      "apiVersion": "[variables('compute_api_version')]",
+      "type": "Microsoft.Compute/virtualMachines/extensions",
+      "name": "[concat(parameters('vm_name'), '/', parameters('extensions').list[copyIndex()].name)]",
+      "location": "[variables('location')]",
+      "tags": "[parameters('tags')]",
+      "copy": {
+        "name": "extensions",
+        "count": "[length(parameters('extensions').list)]",
+        "mode": "serial",
+        "batchSize": 1
+      },
+      "dependsOn": [
+        "[resourceId('Microsoft.Compute/virtualMachines', parameters('vm_name'))]"
+      ],
+      "properties": "[parameters('extensions').list[copyIndex()].properties]"
     }
   ]
 }`
@@ -8,53 +8,63 @@ import (
 	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
-	armStorage "github.com/Azure/azure-sdk-for-go/arm/storage"
 	lvm "github.com/apcera/libretto/virtualmachine"
 
+	"github.com/Azure/azure-sdk-for-go/arm/disk"
 	"github.com/Azure/azure-sdk-for-go/arm/network"
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	armStorage "github.com/Azure/azure-sdk-for-go/arm/storage"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/adal"
-	"github.com/Azure/go-autorest/autorest/azure"
 )
 
-// getServicePrincipalToken retrieves a new ServicePrincipalToken using values of the
-// passed credentials map.
-func getServicePrincipalToken(creds *OAuthCredentials, scope string) (*adal.ServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, creds.TenantID)
-	if err != nil {
-		return nil, err
-	}
-	return adal.NewServicePrincipalToken(*oauthConfig, creds.ClientID, creds.ClientSecret, scope)
+type armParameter struct {
+	Value interface{} `json:"value"`
 }
 
-type armParameter struct {
-	Value string `json:"value"`
+// armNic is an element of the nics parameter of the arm template. The first
+// one is the primary network interface.
+type armNic struct {
+	Name                  string        `json:"name"`
+	AcceleratedNetworking bool          `json:"accelerated_networking"`
+	IPConfigurations      []interface{} `json:"ip_configurations"`
 }
 
 type armParameters struct {
 	AdminUsername        *armParameter `json:"username,omitempty"`
 	AdminPassword        *armParameter `json:"password,omitempty"`
-	ImageOffer           *armParameter `json:"image_offer,omitempty"`
-	ImagePublisher       *armParameter `json:"image_publisher,omitempty"`
-	ImageSku             *armParameter `json:"image_sku,omitempty"`
+	ImageReference       *armParameter `json:"image_reference,omitempty"`
+	Plan                 *armParameter `json:"plan,omitempty"`
 	NetworkSecurityGroup *armParameter `json:"network_security_group,omitempty"`
-	NicName              *armParameter `json:"nic,omitempty"`
+	Nics                 *armParameter `json:"nics,omitempty"`
 	OSFileName           *armParameter `json:"os_file,omitempty"`
-	PublicIPName         *armParameter `json:"public_ip,omitempty"`
+	OSDiskSize           *armParameter `json:"os_disk_size,omitempty"`
+	OSDiskSKU            *armParameter `json:"os_disk_sku,omitempty"`
+	PublicIPs            *armParameter `json:"public_ips,omitempty"`
+	PublicIPSKU          *armParameter `json:"public_ip_sku,omitempty"`
+	PublicIPAllocation   *armParameter `json:"public_ip_allocation,omitempty"`
 	SSHAuthorizedKey     *armParameter `json:"ssh_authorized_key,omitempty"`
-	SubnetName           *armParameter `json:"subnet,omitempty"`
-	VirtualNetworkName   *armParameter `json:"virtual_network,omitempty"`
-	StorageAccountName   *armParameter `json:"storage_account,omitempty"`
-	StorageContainerName *armParameter `json:"storage_container,omitempty"`
 	VMSize               *armParameter `json:"vm_size,omitempty"`
 	VMName               *armParameter `json:"vm_name,omitempty"`
 	DiskSize             *armParameter `json:"disk_size,omitempty"`
 	DiskFile             *armParameter `json:"disk_file,omitempty"`
+	DiskSKU              *armParameter `json:"disk_sku,omitempty"`
+	DiskEncryptionSet    *armParameter `json:"disk_encryption_set,omitempty"`
 	AdditionalDisk       *armParameter `json:"additional_disk,omitempty"`
+	Zone                 *armParameter `json:"zone,omitempty"`
+	ProximityPlacement   *armParameter `json:"proximity_placement_group,omitempty"`
+	Priority             *armParameter `json:"priority,omitempty"`
+	EvictionPolicy       *armParameter `json:"eviction_policy,omitempty"`
+	MaxPrice             *armParameter `json:"max_price,omitempty"`
+	CustomData           *armParameter `json:"custom_data,omitempty"`
+	Extensions           *armParameter `json:"extensions,omitempty"`
+	SystemIdentity       *armParameter `json:"system_assigned_identity,omitempty"`
+	BootDiagnostics      *armParameter `json:"boot_diagnostics,omitempty"`
+	BootDiagnosticsURI   *armParameter `json:"boot_diagnostics_storage_uri,omitempty"`
+	Tags                 *armParameter `json:"tags,omitempty"`
 }
 
 // Translates the given VM to arm parameters
@@ -62,23 +72,39 @@ func (vm *VM) toARMParameters() *armParameters {
 	out := &armParameters{
 		AdminUsername:        &armParameter{vm.SSHCreds.SSHUser},
 		AdminPassword:        &armParameter{vm.SSHCreds.SSHPassword},
-		ImageOffer:           &armParameter{vm.ImageOffer},
-		ImagePublisher:       &armParameter{vm.ImagePublisher},
-		ImageSku:             &armParameter{vm.ImageSku},
-		NetworkSecurityGroup: &armParameter{vm.NetworkSecurityGroup},
-		NicName:              &armParameter{vm.Nic},
+		ImageReference:       &armParameter{vm.imageReference()},
+		Plan:                 &armParameter{vm.plan()},
+		NetworkSecurityGroup: &armParameter{vm.resourceID("Microsoft.Network/networkSecurityGroups", vm.NetworkSecurityGroup)},
+		Nics:                 &armParameter{vm.armNics()},
 		OSFileName:           &armParameter{vm.OsFile},
-		PublicIPName:         &armParameter{vm.PublicIP},
+		OSDiskSize:           &armParameter{strconv.Itoa(vm.OSDiskSize)},
+		OSDiskSKU:            &armParameter{vm.OSDiskSKU},
+		PublicIPs:            &armParameter{vm.armPublicIPs()},
+		PublicIPSKU:          &armParameter{vm.publicIPSKU()},
+		PublicIPAllocation:   &armParameter{vm.publicIPAllocation()},
 		SSHAuthorizedKey:     &armParameter{vm.SSHPublicKey},
-		StorageAccountName:   &armParameter{vm.StorageAccount},
-		StorageContainerName: &armParameter{vm.StorageContainer},
-		SubnetName:           &armParameter{vm.Subnet},
-		VirtualNetworkName:   &armParameter{vm.VirtualNetwork},
 		VMSize:               &armParameter{vm.Size},
 		VMName:               &armParameter{vm.Name},
 		DiskSize:             &armParameter{strconv.Itoa(vm.DiskSize)},
 		DiskFile:             &armParameter{vm.DiskFile},
+		DiskSKU:              &armParameter{vm.DiskSKU},
+		DiskEncryptionSet:    &armParameter{vm.DiskEncryptionSetID},
 		AdditionalDisk:       &armParameter{"false"},
+		Zone:                 &armParameter{vm.Zone},
+		ProximityPlacement:   &armParameter{vm.resourceID("Microsoft.Compute/proximityPlacementGroups", vm.ProximityPlacementGroup)},
+		Priority:             &armParameter{vm.Priority},
+		EvictionPolicy:       &armParameter{vm.EvictionPolicy},
+		MaxPrice:             &armParameter{"-1"},
+		CustomData:           &armParameter{vm.customData()},
+		Extensions:           &armParameter{vm.armExtensions()},
+		SystemIdentity:       &armParameter{vm.SystemAssignedIdentity},
+		BootDiagnostics:      &armParameter{vm.BootDiagnostics},
+		BootDiagnosticsURI:   &armParameter{vm.BootDiagnosticsStorageURI},
+		Tags:                 &armParameter{vm.armTags()},
+	}
+
+	if vm.MaxPrice > 0 {
+		out.MaxPrice = &armParameter{strconv.FormatFloat(vm.MaxPrice, 'f', -1, 64)}
 	}
 
 	if vm.DiskSize > 0 {
@@ -88,72 +114,163 @@ func (vm *VM) toARMParameters() *armParameters {
 	return out
 }
 
+// resourceID returns the resource ID of a resource of the given type, which
+// may be given by name within the VM's resource group or by resource ID.
+func (vm *VM) resourceID(resourceType string, nameOrID string) string {
+	if nameOrID == "" || strings.HasPrefix(nameOrID, "/") {
+		return nameOrID
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/%s/%s",
+		vm.Creds.SubscriptionID, vm.ResourceGroup, resourceType, nameOrID)
+}
+
+// subnetID returns the resource ID of the subnet of the primary network
+// interface of the VM.
+func (vm *VM) subnetID() string {
+	if vm.SubnetID != "" {
+		return vm.SubnetID
+	}
+	return vm.resourceID("Microsoft.Network/virtualNetworks", vm.VirtualNetwork) + "/subnets/" + vm.Subnet
+}
+
+// armNics returns the network interfaces of the VM, the primary one first.
+func (vm *VM) armNics() []armNic {
+	nics := []armNic{{
+		Name:                  vm.Nic,
+		AcceleratedNetworking: vm.AcceleratedNetworking,
+		IPConfigurations:      vm.ipConfigurations(vm.subnetID(), true),
+	}}
+	for _, nic := range vm.NetworkInterfaces {
+		subnet := nic.SubnetID
+		if subnet == "" {
+			subnet = vm.subnetID()
+		}
+		nics = append(nics, armNic{
+			Name:                  nic.Name,
+			AcceleratedNetworking: nic.AcceleratedNetworking,
+			IPConfigurations:      vm.ipConfigurations(subnet, false),
+		})
+	}
+	return nics
+}
+
 // validateVM validates the members of given VM object
 func validateVM(vm *VM) error {
 	// Validate the OAUTH Credentials
-	if vm.Creds.ClientID == "" {
-		return fmt.Errorf("a client id must be specified")
+	if err := validateCreds(&vm.Creds); err != nil {
+		return err
 	}
 
-	if vm.Creds.ClientSecret == "" {
-		return fmt.Errorf("a client secret must be specified")
+	// Validate the image
+	if err := validateImage(vm); err != nil {
+		return err
 	}
 
-	if vm.Creds.TenantID == "" {
-		return fmt.Errorf("a tenant id must be specified")
+	// Validate the deployment
+	if vm.ResourceGroup == "" {
+		return fmt.Errorf("a resource group must be specified")
 	}
 
-	if vm.Creds.SubscriptionID == "" {
-		return fmt.Errorf("a subscription id must be specified")
+	if err := validateResourceGroup(vm); err != nil {
+		return err
 	}
 
-	// Validate the image
-	if vm.ImagePublisher == "" {
-		return fmt.Errorf("an image publisher must be specified")
+	// Validate the disks
+	if vm.StorageAccount != "" || vm.StorageContainer != "" {
+		return fmt.Errorf("VHD-backed disks are no longer supported, the storage account and container must not be specified")
 	}
 
-	if vm.ImageOffer == "" {
-		return fmt.Errorf("an image offer must be specified")
+	if vm.OSDiskSize < 0 || vm.DiskSize < 0 {
+		return fmt.Errorf("disk sizes must not be negative")
 	}
 
-	if vm.ImageSku == "" {
-		return fmt.Errorf("an image sku must be specified")
+	if vm.OSDiskSKU == DiskSKUUltra {
+		return fmt.Errorf("the OS disk cannot be an ultra disk")
 	}
 
-	// Validate the deployment
-	if vm.ResourceGroup == "" {
-		return fmt.Errorf("a resource group must be specified")
+	// Validate the placement
+	switch vm.Zone {
+	case "", "1", "2", "3":
+	default:
+		return fmt.Errorf("zone must be 1, 2 or 3")
+	}
+
+	// Validate the priority
+	switch vm.Priority {
+	case "", PriorityRegular:
+		if vm.EvictionPolicy != "" || vm.MaxPrice != 0 {
+			return fmt.Errorf("an eviction policy and a max price require the spot priority")
+		}
+	case PrioritySpot:
+		if vm.MaxPrice < 0 && vm.MaxPrice != -1 {
+			return fmt.Errorf("max price must be positive or -1")
+		}
+	default:
+		return fmt.Errorf("unknown priority %q", vm.Priority)
 	}
 
-	if vm.StorageAccount == "" {
-		return fmt.Errorf("a storage account must be specified")
+	switch vm.EvictionPolicy {
+	case "", EvictionPolicyDeallocate, EvictionPolicyDelete:
+	default:
+		return fmt.Errorf("unknown eviction policy %q", vm.EvictionPolicy)
 	}
 
-	// Validate the network
-	if vm.NetworkSecurityGroup == "" {
-		return fmt.Errorf("a network security group must be specified")
+	// Validate the extensions
+	if err := validateExtensions(vm); err != nil {
+		return err
 	}
 
-	if vm.Subnet == "" {
-		return fmt.Errorf("a subnet must be specified")
+	if vm.BootDiagnosticsStorageURI != "" && !vm.BootDiagnostics {
+		return fmt.Errorf("a boot diagnostics storage URI requires boot diagnostics")
 	}
 
-	if vm.VirtualNetwork == "" {
-		return fmt.Errorf("a virtual network must be specified")
+	// Validate the network
+	if err := validatePublicIP(vm); err != nil {
+		return err
+	}
+
+	if vm.SubnetID == "" {
+		if vm.Subnet == "" {
+			return fmt.Errorf("a subnet must be specified")
+		}
+
+		if vm.VirtualNetwork == "" {
+			return fmt.Errorf("a virtual network must be specified")
+		}
 	}
 
 	return nil
 }
 
 // deploy deploys the given VM based on the default Linux arm template over the
-// VM's resource group.
+// VM's resource group, creating the resource group first if requested. The
+// created resource group is deleted if the deployment fails.
 func (vm *VM) deploy() error {
-	// Get the auth token.
-	tok, err := getServicePrincipalToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	// Get the authorizer.
+	authorizer, err := vm.getAuthorizer()
 	if err != nil {
 		return err
 	}
 
+	if !vm.CreateResourceGroup {
+		return vm.deployTemplate(authorizer)
+	}
+
+	if err := vm.createResourceGroup(authorizer); err != nil {
+		return err
+	}
+	if err := vm.deployTemplate(authorizer); err != nil {
+		if errDelete := vm.deleteResourceGroup(authorizer); errDelete != nil {
+			return lvm.WrapErrors(err, errDelete)
+		}
+		return err
+	}
+	return nil
+}
+
+// deployTemplate deploys the default Linux arm template for the VM and waits
+// for the deployment to succeed.
+func (vm *VM) deployTemplate(authorizer autorest.Authorizer) error {
 	// Pass the parameters to the arm templacte
 	vmParams := vm.toARMParameters()
 	deployment, err := createDeployment(Linux, *vmParams)
@@ -163,7 +280,7 @@ func (vm *VM) deploy() error {
 
 	// Create and send the deployment to the resource group
 	deploymentsClient := resources.NewDeploymentsClient(vm.Creds.SubscriptionID)
-	deploymentsClient.Authorizer = autorest.NewBearerAuthorizer(tok)
+	deploymentsClient.Authorizer = authorizer
 
 	_, errc := deploymentsClient.CreateOrUpdate(vm.ResourceGroup, vm.DeploymentName, *deployment, nil)
 	if err := <-errc; err != nil {
@@ -219,15 +336,53 @@ func (vm *VM) getPrivateIP(authorizer autorest.Authorizer) (net.IP, error) {
 		len(*iface.InterfacePropertiesFormat.IPConfigurations) == 0 {
 		return nil, fmt.Errorf("VM has no private IP address")
 	}
-	ipConfigs := *iface.InterfacePropertiesFormat.IPConfigurations
+	var ipConfigs []network.InterfaceIPConfiguration
+	for _, ipConfig := range *iface.InterfacePropertiesFormat.IPConfigurations {
+		// IPv6 addresses are returned by GetAddresses.
+		if ipConfig.InterfaceIPConfigurationPropertiesFormat.PrivateIPAddressVersion != network.IPv6 {
+			ipConfigs = append(ipConfigs, ipConfig)
+		}
+	}
+	if len(ipConfigs) == 0 {
+		return nil, fmt.Errorf("VM has no private IP address")
+	}
 	if len(ipConfigs) > 1 {
 		return nil, fmt.Errorf("VM has multiple private IP addresses")
 	}
 	return net.ParseIP(*ipConfigs[0].InterfaceIPConfigurationPropertiesFormat.PrivateIPAddress), nil
 }
 
-// deleteOSFile deletes the OS file from the VM's storage account, returns an error if the operation
-// does not succeed.
+// deleteDisks deletes the managed OS disk and data disk of the given VM from the VM's resource
+// group, or its VHD blobs if it has VHD-backed disks, returns an error if the operation does not
+// succeed.
+func (vm *VM) deleteDisks(authorizer autorest.Authorizer) error {
+	if vm.StorageAccount != "" {
+		return vm.deleteVMFiles(authorizer)
+	}
+
+	disksClient := disk.NewDisksClient(vm.Creds.SubscriptionID)
+	disksClient.Authorizer = authorizer
+
+	// Delete the OS disk.
+	_, errc := disksClient.Delete(vm.ResourceGroup, vm.OsFile, nil)
+	err := <-errc
+
+	if vm.DiskSize <= 0 {
+		return err
+	}
+
+	// Delete the data disk.
+	_, errc = disksClient.Delete(vm.ResourceGroup, vm.DiskFile, nil)
+	dataDiskErr := <-errc
+	if err != nil {
+		return fmt.Errorf("failed to delete OS disk and data disk: %v, %v", err, dataDiskErr)
+	}
+
+	return dataDiskErr
+}
+
+// deleteVMFiles deletes the OS file and disk file from the VM's storage account, returns an error
+// if the operation does not succeed.
 func (vm *VM) deleteVMFiles(authorizer autorest.Authorizer) error {
 	storageAccountsClient := armStorage.NewAccountsClient(vm.Creds.SubscriptionID)
 	storageAccountsClient.Authorizer = authorizer
@@ -271,14 +426,30 @@ func (vm *VM) deleteVMFiles(authorizer autorest.Authorizer) error {
 	return diskFileErr
 }
 
-// deleteNic deletes the network interface for the given VM from the VM's resource group, returns an error
+// deleteNic deletes the network interfaces for the given VM from the VM's resource group, returns an error
 // if the operation does not succeed.
 func (vm *VM) deleteNic(authorizer autorest.Authorizer) error {
 	interfaceClient := network.NewInterfacesClient(vm.Creds.SubscriptionID)
 	interfaceClient.Authorizer = authorizer
 
+	// Delete the additional network interfaces first, so that the public IP
+	// is only deleted once all of them are.
+	var errs []error
+	for _, nic := range vm.NetworkInterfaces {
+		_, errc := interfaceClient.Delete(vm.ResourceGroup, nic.Name, nil)
+		if err := <-errc; err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	_, errc := interfaceClient.Delete(vm.ResourceGroup, vm.Nic, nil)
-	return <-errc
+	if err := <-errc; err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return lvm.WrapErrors(errs...)
+	}
+	return nil
 }
 
 // deletePublicIP deletes the reserved Public IP of the given VM from the VM's resource group, returns an error
@@ -289,7 +460,18 @@ func (vm *VM) deletePublicIP(authorizer autorest.Authorizer) error {
 	publicIPAddressesClient.Authorizer = authorizer
 
 	_, errc := publicIPAddressesClient.Delete(vm.ResourceGroup, vm.PublicIP, nil)
-	return <-errc
+	err := <-errc
+	if !vm.IPv6 {
+		return err
+	}
+
+	// Delete the IPv6 public IP of this VM
+	_, errc = publicIPAddressesClient.Delete(vm.ResourceGroup, vm.ipv6PublicIPName(), nil)
+	ipv6Err := <-errc
+	if err != nil {
+		return fmt.Errorf("failed to delete public IPs: %v, %v", err, ipv6Err)
+	}
+	return ipv6Err
 }
 
 // deleteDeployment deletes the deployed azure arm template for this vm.
@@ -356,6 +538,12 @@ func translateState(azureState string) string {
 		return lvm.VMRunning
 	case stopped:
 		return lvm.VMHalted
+	case deallocated:
+		return VMDeallocated
+	case starting:
+		return lvm.VMStarting
+	case stopping, deallocating:
+		return lvm.VMPending
 	default:
 		return lvm.VMUnknown
 	}
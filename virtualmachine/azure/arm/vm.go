@@ -15,8 +15,6 @@ import (
 	lvm "github.com/apcera/libretto/virtualmachine"
 
 	"github.com/Azure/azure-sdk-for-go/arm/compute"
-	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/azure"
 )
 
 var (
@@ -41,22 +39,99 @@ const (
 	// stopped is the status returned when the VM is halted
 	stopped = "VM stopped"
 
+	// deallocated is the status returned when the VM is deallocated
+	deallocated = "VM deallocated"
+
+	// starting is the status returned when the VM is starting
+	starting = "VM starting"
+
+	// stopping is the status returned when the VM is being halted
+	stopping = "VM stopping"
+
+	// deallocating is the status returned when the VM is being deallocated
+	deallocating = "VM deallocating"
+
 	// succeeded is the status returned when a deployment ends successfully
 	succeeded = "Succeeded"
 )
 
+// VMDeallocated is the state of a VM which is halted and deallocated, so that
+// its compute resources are released and no longer billed.
+const VMDeallocated = "deallocated"
+
+const (
+	// DiskSKUStandard is the SKU of standard HDD managed disks.
+	DiskSKUStandard = "Standard_LRS"
+
+	// DiskSKUStandardSSD is the SKU of standard SSD managed disks.
+	DiskSKUStandardSSD = "StandardSSD_LRS"
+
+	// DiskSKUPremium is the SKU of premium SSD managed disks.
+	DiskSKUPremium = "Premium_LRS"
+
+	// DiskSKUUltra is the SKU of ultra managed disks. It is only supported for
+	// data disks, on VM sizes and regions supporting ultra disks.
+	DiskSKUUltra = "UltraSSD_LRS"
+)
+
+const (
+	// PriorityRegular is the priority of regular VMs.
+	PriorityRegular = "Regular"
+
+	// PrioritySpot is the priority of spot VMs, which run on spare capacity
+	// at a discount and may be evicted at any time.
+	PrioritySpot = "Spot"
+
+	// EvictionPolicyDeallocate deallocates evicted spot VMs, keeping their
+	// disks. This is the default.
+	EvictionPolicyDeallocate = "Deallocate"
+
+	// EvictionPolicyDelete deletes evicted spot VMs and their disks.
+	EvictionPolicyDelete = "Delete"
+)
+
 // SSHTimeout is the maximum time to wait before failing to GetSSH. This is not
 // thread-safe.
 var SSHTimeout = 180 * time.Second
 
 var _ lvm.VirtualMachine = (*VM)(nil)
 
+var _ lvm.DashboardLinker = (*VM)(nil)
+
 // OAuthCredentials is the struct that stors OAUTH credentials
 type OAuthCredentials struct {
+	// ClientID is the application id of the service principal. With
+	// AuthManagedIdentity, it optionally selects a user-assigned identity.
 	ClientID       string
 	ClientSecret   string
 	TenantID       string
 	SubscriptionID string
+
+	// AuthMethod [optional] is one of the Auth* constants. It defaults to
+	// AuthClientCertificate if CertificatePath is set, and to
+	// AuthClientSecret otherwise.
+	AuthMethod string
+
+	// CertificatePath is the PKCS#12 or PEM file holding the certificate and
+	// RSA private key of the service principal, for AuthClientCertificate.
+	CertificatePath string
+	// CertificatePassword [optional] is the password of the PKCS#12 file.
+	CertificatePassword string
+}
+
+// NetworkInterface is an additional network interface of a VM.
+type NetworkInterface struct {
+	// Name [optional] is the name of the network interface. It defaults to
+	// the name of the primary network interface with an index suffix.
+	Name string
+
+	// SubnetID [optional] is the resource ID of the subnet of the network
+	// interface. It defaults to the subnet of the primary network interface.
+	SubnetID string
+
+	// AcceleratedNetworking enables accelerated networking on the network
+	// interface.
+	AcceleratedNetworking bool
 }
 
 // VM represents an Azure virtual machine.
@@ -68,34 +143,149 @@ type VM struct {
 	ImagePublisher string
 	ImageOffer     string
 	ImageSku       string
+	ImageVersion   string // defaults to "latest"
+
+	// ImageID [optional] is the image to use instead of a marketplace image:
+	// the resource ID of a managed image or of a gallery image or image
+	// version, or the ID of a community or shared gallery image, starting
+	// with /CommunityGalleries/ or /SharedGalleries/.
+	ImageID string
+
+	// Plan [optional] is the purchase plan of the image, required for
+	// marketplace images sold through a plan.
+	Plan *Plan
+
+	// AcceptPlanTerms accepts the marketplace terms of the plan for the
+	// subscription before creating the VM.
+	AcceptPlanTerms bool
 
 	// VM Properties
 	Size string
 	Name string
 
+	// DeallocateOnHalt makes Halt deallocate the VM instead of powering it
+	// off, so that its compute resources are no longer billed.
+	DeallocateOnHalt bool
+
+	// Zone [optional] is the availability zone of the VM, "1", "2" or "3".
+	// The managed disks of the VM are created in the same zone.
+	Zone string
+
+	// ProximityPlacementGroup [optional] is the name or resource ID of an
+	// existing proximity placement group to colocate the VM in. A name refers
+	// to a group of the VM's resource group.
+	ProximityPlacementGroup string
+
+	// Priority [optional] is PriorityRegular or PrioritySpot. It defaults to
+	// PriorityRegular.
+	Priority string
+
+	// EvictionPolicy [optional] is what happens to an evicted spot VM,
+	// EvictionPolicyDeallocate or EvictionPolicyDelete.
+	EvictionPolicy string
+
+	// MaxPrice [optional] is the maximum hourly price in US dollars to pay
+	// for a spot VM, which is evicted when the price goes above it. It
+	// defaults to -1, which pays up to the price of a regular VM.
+	MaxPrice float64
+
 	// SSH Properties
 	SSHCreds     ssh.Credentials // required
 	SSHPublicKey string
 
+	// CustomData [optional] is passed to the VM at boot, such as a
+	// cloud-init configuration. It must not exceed 65535 bytes.
+	CustomData []byte
+
+	// Extensions [optional] are installed on the VM after it boots, in order.
+	Extensions []Extension
+
+	// SystemAssignedIdentity enables the system-assigned managed identity of
+	// the VM, as required by AADLoginExtension.
+	SystemAssignedIdentity bool
+
+	// BootDiagnostics enables the boot diagnostics of the VM, which keep its
+	// serial console log and screenshot for GetConsoleOutput and
+	// GetScreenshotURL.
+	BootDiagnostics bool
+
+	// BootDiagnosticsStorageURI [optional] is the blob endpoint of the
+	// storage account keeping the boot diagnostics. They are kept in a
+	// managed storage account if it is empty.
+	BootDiagnosticsStorageURI string
+
 	// Deployment Properties
-	ResourceGroup    string
+	ResourceGroup string
+
+	// CreateResourceGroup creates ResourceGroup in Location on Provision,
+	// and makes Destroy delete it with everything in it. The resource group
+	// must not exist, and the subnet must be given by SubnetID.
+	CreateResourceGroup bool
+	Location            string
+
+	// Tags [optional] are set on the resources created for the VM, including
+	// its disks and the resource group created for it.
+	Tags map[string]string
+
+	// StorageAccount and StorageContainer are the storage account and container
+	// of the VHD blobs of VMs provisioned before disks became managed disks, so
+	// that Destroy deletes those blobs. They cannot be set to provision a VM.
 	StorageAccount   string
 	StorageContainer string
 
 	// VM OS Properties
-	OsFile string
+	OsFile     string // name of the managed OS disk
+	OSDiskSize int    // GB, defaults to the size of the image
+	OSDiskSKU  string // defaults to the SKU Azure picks for the VM size
 
 	// VM Disk Properties
-	DiskFile string
-	DiskSize int //GB
+	DiskFile string // name of the managed data disk
+	DiskSize int    // GB
+	DiskSKU  string // defaults to the SKU Azure picks for the VM size
+
+	// DiskEncryptionSetID [optional] is the resource ID of the disk encryption
+	// set encrypting the OS and data disks with customer-managed keys.
+	DiskEncryptionSetID string
 
 	// VM Network Properties
-	NetworkSecurityGroup string
+	NetworkSecurityGroup string // [optional] name or resource ID
 	Nic                  string
 	PublicIP             string
 	Subnet               string
 	VirtualNetwork       string
 
+	// SubnetID [optional] is the resource ID of an existing subnet of the
+	// primary network interface, which may belong to a virtual network of
+	// another resource group. It overrides Subnet and VirtualNetwork.
+	SubnetID string
+
+	// AcceleratedNetworking enables accelerated networking on the primary
+	// network interface. The VM size must support it.
+	AcceleratedNetworking bool
+
+	// PublicIPSKU [optional] is PublicIPSKUBasic or PublicIPSKUStandard. It
+	// defaults to PublicIPSKUBasic.
+	PublicIPSKU string
+
+	// PublicIPAllocation [optional] is AllocationDynamic or AllocationStatic.
+	// It defaults to AllocationStatic for standard public IPs and to
+	// AllocationDynamic otherwise.
+	PublicIPAllocation string
+
+	// DNSLabel [optional] is the DNS name label of the public IP, which must
+	// be unique in the region. It defaults to the name of the public IP.
+	// GetFQDN returns the resulting domain name.
+	DNSLabel string
+
+	// IPv6 adds IPv6 private and public addresses to the primary network
+	// interface. It requires standard public IPs and a subnet with an IPv6
+	// address space. GetAddresses returns the addresses.
+	IPv6 bool
+
+	// NetworkInterfaces [optional] are additional network interfaces of the
+	// VM. The VM size must support that many network interfaces.
+	NetworkInterfaces []NetworkInterface
+
 	// deployment
 	DeploymentName string
 }
@@ -114,13 +304,19 @@ func (vm *VM) Provision() error {
 		return err
 	}
 
+	if vm.AcceptPlanTerms {
+		if err := vm.acceptPlanTerms(); err != nil {
+			return err
+		}
+	}
+
 	// Set up private members of the VM
 	tempName := randStringRunes(5)
 	if vm.OsFile == "" {
-		vm.OsFile = tempName + "-os-disk.vhd"
+		vm.OsFile = tempName + "-os-disk"
 	}
 	if vm.DiskFile == "" {
-		vm.DiskFile = tempName + "-disk.vhd"
+		vm.DiskFile = tempName + "-disk"
 	}
 	if vm.PublicIP == "" {
 		vm.PublicIP = tempName + "-public-ip"
@@ -128,6 +324,11 @@ func (vm *VM) Provision() error {
 	if vm.Nic == "" {
 		vm.Nic = tempName + "-nic"
 	}
+	for i := range vm.NetworkInterfaces {
+		if vm.NetworkInterfaces[i].Name == "" {
+			vm.NetworkInterfaces[i].Name = fmt.Sprintf("%s-%d", vm.Nic, i+1)
+		}
+	}
 	if vm.DeploymentName == "" {
 		vm.DeploymentName = tempName + "-deploy"
 	}
@@ -137,6 +338,12 @@ func (vm *VM) Provision() error {
 		return err
 	}
 
+	if len(vm.Tags) > 0 {
+		if err := vm.tagDisks(); err != nil {
+			return err
+		}
+	}
+
 	cli, err := vm.GetSSH(ssh.Options{KeepAlive: 2})
 	if err != nil {
 		return err
@@ -150,11 +357,10 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	ips := make([]net.IP, 2)
 
 	// Set up the auth token.
-	tok, err := getServicePrincipalToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	authorizer, err := vm.getAuthorizer()
 	if err != nil {
 		return nil, err
 	}
-	authorizer := autorest.NewBearerAuthorizer(tok)
 
 	// Get the Public IP
 	ip, err := vm.getPublicIP(authorizer)
@@ -190,17 +396,29 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	return &client, nil
 }
 
+// GetDashboardURL returns the URL of the VM overview page in the Azure portal.
+func (vm *VM) GetDashboardURL() (string, error) {
+	if vm.Creds.SubscriptionID == "" || vm.ResourceGroup == "" || vm.Name == "" {
+		return "", errors.New("subscription id, resource group and name are required to build the dashboard URL")
+	}
+
+	return fmt.Sprintf("https://portal.azure.com/#resource/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s/overview",
+		vm.Creds.SubscriptionID, vm.ResourceGroup, vm.Name), nil
+}
+
 // GetState returns the status of the Azure VM. The status will be one of the
 // following:
 //     "running"
-//     "stopped"
+//     "halted"
+//     "deallocated"
+//     "starting"
+//     "pending"
 func (vm *VM) GetState() (string, error) {
 	// Set up the authorizer
-	tok, err := getServicePrincipalToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	authorizer, err := vm.getAuthorizer()
 	if err != nil {
 		return "", err
 	}
-	authorizer := autorest.NewBearerAuthorizer(tok)
 
 	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
 	virtualMachinesClient.Authorizer = authorizer
@@ -218,14 +436,18 @@ func (vm *VM) GetState() (string, error) {
 	return "", errors.New("failed to get VM status")
 }
 
-// Destroy deletes the VM on Azure.
+// Destroy deletes the VM on Azure, with the resource group created for it if
+// CreateResourceGroup is set.
 func (vm *VM) Destroy() error {
 	// Set up the authorizer
-	tok, err := getServicePrincipalToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	authorizer, err := vm.getAuthorizer()
 	if err != nil {
 		return err
 	}
-	authorizer := autorest.NewBearerAuthorizer(tok)
+
+	if vm.CreateResourceGroup {
+		return vm.deleteResourceGroup(authorizer)
+	}
 
 	// Delete the VM
 	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
@@ -257,8 +479,8 @@ func (vm *VM) Destroy() error {
 	}
 
 	var errors []error
-	// Delete the files of this VM
-	err = vm.deleteVMFiles(authorizer)
+	// Delete the disks of this VM
+	err = vm.deleteDisks(authorizer)
 	if err != nil {
 		errors = append(errors, err)
 	}
@@ -297,14 +519,18 @@ func (vm *VM) Destroy() error {
 	return returnedErr
 }
 
-// Halt shuts down the VM.
+// Halt shuts down the VM. The VM keeps its compute resources, which are still
+// billed, unless DeallocateOnHalt is set.
 func (vm *VM) Halt() error {
+	if vm.DeallocateOnHalt {
+		return vm.Deallocate()
+	}
+
 	// Set up the authorizer
-	tok, err := getServicePrincipalToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	authorizer, err := vm.getAuthorizer()
 	if err != nil {
 		return err
 	}
-	authorizer := autorest.NewBearerAuthorizer(tok)
 
 	// Poweroff the VM
 	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
@@ -330,14 +556,46 @@ func (vm *VM) Halt() error {
 	return ErrActionTimeout
 }
 
-// Start boots a stopped VM.
+// Deallocate shuts down the VM and releases its compute resources, so that
+// they are no longer billed. Dynamic public IPs are released too.
+func (vm *VM) Deallocate() error {
+	// Set up the authorizer
+	authorizer, err := vm.getAuthorizer()
+	if err != nil {
+		return err
+	}
+
+	// Deallocate the VM
+	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
+	virtualMachinesClient.Authorizer = authorizer
+
+	_, errc := virtualMachinesClient.Deallocate(vm.ResourceGroup, vm.Name, nil)
+	if err := <-errc; err != nil {
+		return err
+	}
+
+	// Make sure the VM is deallocated
+	for i := 0; i < actionTimeout; i++ {
+		state, err := vm.GetState()
+		if err != nil {
+			return err
+		}
+		if state == VMDeallocated {
+			return nil
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+	return ErrActionTimeout
+}
+
+// Start boots a stopped or deallocated VM.
 func (vm *VM) Start() error {
 	// Set up the authorizer
-	tok, err := getServicePrincipalToken(&vm.Creds, azure.PublicCloud.ResourceManagerEndpoint)
+	authorizer, err := vm.getAuthorizer()
 	if err != nil {
 		return err
 	}
-	authorizer := autorest.NewBearerAuthorizer(tok)
 
 	// Start the VM
 	virtualMachinesClient := compute.NewVirtualMachinesClient(vm.Creds.SubscriptionID)
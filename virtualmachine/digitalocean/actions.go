@@ -0,0 +1,117 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package digitalocean
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Snapshot is a snapshot image of a droplet
+type Snapshot struct {
+	ID            int       `json:"id,omitempty"`
+	Name          string    `json:"name,omitempty"`
+	Regions       []string  `json:"regions,omitempty"`
+	MinDiskSize   int       `json:"min_disk_size,omitempty"`
+	SizeGigabytes float64   `json:"size_gigabytes,omitempty"`
+	CreatedAt     time.Time `json:"created_at,omitempty"`
+}
+
+// snapshotsResponse is the API response containing a page of the snapshots of
+// a droplet
+type snapshotsResponse struct {
+	Snapshots []*Snapshot `json:"snapshots"`
+	Links     struct {
+		Pages struct {
+			Next string `json:"next,omitempty"`
+		} `json:"pages,omitempty"`
+	} `json:"links,omitempty"`
+}
+
+// dropletAction is the payload of droplet actions
+type dropletAction struct {
+	Type  string `json:"type"`
+	Name  string `json:"name,omitempty"`
+	Image int    `json:"image,omitempty"`
+	Size  string `json:"size,omitempty"`
+	Disk  bool   `json:"disk,omitempty"`
+}
+
+// TakeSnapshot takes a snapshot of the droplet with the given name, and returns
+// it once it is taken. The droplet should be powered off for the snapshot to
+// be consistent.
+func (vm *VM) TakeSnapshot(name string) (*Snapshot, error) {
+	if err := vm.dropletAction(dropletAction{Type: "snapshot", Name: name}); err != nil {
+		return nil, err
+	}
+	snapshots, err := vm.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Name == name {
+			return snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("snapshot %s of droplet %d not found", name, vm.Droplet.ID)
+}
+
+// ListSnapshots returns the snapshots of the droplet.
+func (vm *VM) ListSnapshots() ([]*Snapshot, error) {
+	if vm.Droplet == nil || vm.Droplet.ID == 0 {
+		return nil, ErrNoInstanceID
+	}
+	var snapshots []*Snapshot
+	path := fmt.Sprintf("%s/%d/snapshots?per_page=200", apiDropletURL, vm.Droplet.ID)
+	for path != "" {
+		r := &snapshotsResponse{}
+		if err := apiRequest(vm.APIToken, "GET", path, nil, r); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, r.Snapshots...)
+		// The next page is an absolute URL of the API.
+		path = strings.TrimPrefix(r.Links.Pages.Next, apiBaseURL)
+	}
+	return snapshots, nil
+}
+
+// RestoreSnapshot restores the droplet to the snapshot or backup image with
+// the given ID.
+func (vm *VM) RestoreSnapshot(id int) error {
+	return vm.dropletAction(dropletAction{Type: "restore", Image: id})
+}
+
+// EnableBackups enables the automatic backups of the droplet.
+func (vm *VM) EnableBackups() error {
+	return vm.dropletAction(dropletAction{Type: "enable_backups"})
+}
+
+// DisableBackups disables the automatic backups of the droplet.
+func (vm *VM) DisableBackups() error {
+	return vm.dropletAction(dropletAction{Type: "disable_backups"})
+}
+
+// Resize resizes the droplet to the size with the given slug, such as
+// "s-2vcpu-4gb". Only the CPUs and memory are resized unless disk is set, in
+// which case the disk is grown too and the droplet cannot be resized down
+// anymore. The droplet must be powered off.
+func (vm *VM) Resize(size string, disk bool) error {
+	if err := vm.dropletAction(dropletAction{Type: "resize", Size: size, Disk: disk}); err != nil {
+		return err
+	}
+	vm.Config.Size = size
+	return nil
+}
+
+// dropletAction runs an action on the droplet and waits until it completes.
+func (vm *VM) dropletAction(payload dropletAction) error {
+	if vm.Droplet == nil || vm.Droplet.ID == 0 {
+		return ErrNoInstanceID
+	}
+	r := &ActionResponse{}
+	if err := apiRequest(vm.APIToken, "POST", fmt.Sprintf("%s/%d/actions", apiDropletURL, vm.Droplet.ID), payload, r); err != nil {
+		return fmt.Errorf("error running %s on droplet %d: %s", payload.Type, vm.Droplet.ID, err)
+	}
+	return waitForAction(vm.APIToken, r.Action.ID)
+}
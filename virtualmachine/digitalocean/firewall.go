@@ -0,0 +1,186 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package digitalocean
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Directions of firewall rules
+const (
+	FirewallInbound  = "inbound"
+	FirewallOutbound = "outbound"
+)
+
+// FirewallRule is a rule of the firewall managed by libretto for a droplet.
+type FirewallRule struct {
+	// Direction is either FirewallInbound or FirewallOutbound.
+	Direction string
+	// Protocol is "tcp", "udp" or "icmp".
+	Protocol string
+	// Ports is a port, such as "22", a range, such as "8000-9000", or "all".
+	// It is ignored for ICMP.
+	Ports string
+	// Addresses are the IPs and CIDRs the traffic comes from, for inbound
+	// rules, or goes to, for outbound rules.
+	Addresses []string
+}
+
+// firewallAddresses are the sources or destinations of a firewall rule
+type firewallAddresses struct {
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// firewallRule is a firewall rule of the API
+type firewallRule struct {
+	Protocol     string             `json:"protocol"`
+	Ports        string             `json:"ports,omitempty"`
+	Sources      *firewallAddresses `json:"sources,omitempty"`
+	Destinations *firewallAddresses `json:"destinations,omitempty"`
+}
+
+// Firewall is a cloud firewall
+type Firewall struct {
+	ID            string         `json:"id,omitempty"`
+	Name          string         `json:"name,omitempty"`
+	Status        string         `json:"status,omitempty"`
+	InboundRules  []firewallRule `json:"inbound_rules,omitempty"`
+	OutboundRules []firewallRule `json:"outbound_rules,omitempty"`
+	DropletIDs    []int          `json:"droplet_ids,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`
+}
+
+// firewallResponse is the API response containing one firewall
+type firewallResponse struct {
+	Firewall *Firewall `json:"firewall,omitempty"`
+}
+
+// tagResource is a resource of a tag
+type tagResource struct {
+	ResourceID   string `json:"resource_id"`
+	ResourceType string `json:"resource_type"`
+}
+
+// AddToFirewall adds the droplet to the cloud firewall with the given ID.
+func (vm *VM) AddToFirewall(id string) error {
+	return vm.firewallDroplets("POST", id)
+}
+
+// RemoveFromFirewall removes the droplet from the cloud firewall with the given
+// ID.
+func (vm *VM) RemoveFromFirewall(id string) error {
+	return vm.firewallDroplets("DELETE", id)
+}
+
+// firewallDroplets adds the droplet to, or removes it from, a firewall.
+func (vm *VM) firewallDroplets(method, id string) error {
+	if vm.Droplet == nil || vm.Droplet.ID == 0 {
+		return ErrNoInstanceID
+	}
+	payload := map[string][]int{"droplet_ids": {vm.Droplet.ID}}
+	if err := apiRequest(vm.APIToken, method, apiFirewallURL+"/"+id+"/droplets", payload, nil); err != nil {
+		return fmt.Errorf("error updating the droplets of firewall %s: %s", id, err)
+	}
+	return nil
+}
+
+// AddTags tags the droplet with the given tags, which are created if they do
+// not exist.
+func (vm *VM) AddTags(tags ...string) error {
+	for _, tag := range tags {
+		if err := apiRequest(vm.APIToken, "POST", apiTagURL, map[string]string{"name": tag}, nil); err != nil {
+			return fmt.Errorf("error creating tag %s: %s", tag, err)
+		}
+		if err := vm.tagResources("POST", tag); err != nil {
+			return err
+		}
+	}
+	vm.Config.Tags = append(vm.Config.Tags, tags...)
+	return nil
+}
+
+// RemoveTags untags the droplet.
+func (vm *VM) RemoveTags(tags ...string) error {
+	for _, tag := range tags {
+		if err := vm.tagResources("DELETE", tag); err != nil {
+			return err
+		}
+	}
+	var kept []string
+	for _, tag := range vm.Config.Tags {
+		if !contains(tags, tag) {
+			kept = append(kept, tag)
+		}
+	}
+	vm.Config.Tags = kept
+	return nil
+}
+
+// tagResources tags or untags the droplet.
+func (vm *VM) tagResources(method, tag string) error {
+	if vm.Droplet == nil || vm.Droplet.ID == 0 {
+		return ErrNoInstanceID
+	}
+	payload := map[string][]tagResource{
+		"resources": {{ResourceID: strconv.Itoa(vm.Droplet.ID), ResourceType: "droplet"}},
+	}
+	if err := apiRequest(vm.APIToken, method, apiTagURL+"/"+tag+"/resources", payload, nil); err != nil {
+		return fmt.Errorf("error updating the resources of tag %s: %s", tag, err)
+	}
+	return nil
+}
+
+// createFirewall creates the firewall of FirewallRules for the droplet.
+func (vm *VM) createFirewall() error {
+	fw := Firewall{
+		Name:       "libretto-" + vm.Config.Name,
+		DropletIDs: []int{vm.Droplet.ID},
+	}
+	for _, rule := range vm.FirewallRules {
+		r := firewallRule{Protocol: rule.Protocol, Ports: rule.Ports}
+		if rule.Protocol == "icmp" {
+			r.Ports = ""
+		}
+		addresses := &firewallAddresses{Addresses: rule.Addresses}
+		switch rule.Direction {
+		case FirewallInbound:
+			r.Sources = addresses
+			fw.InboundRules = append(fw.InboundRules, r)
+		case FirewallOutbound:
+			r.Destinations = addresses
+			fw.OutboundRules = append(fw.OutboundRules, r)
+		default:
+			return fmt.Errorf("invalid firewall rule direction %q", rule.Direction)
+		}
+	}
+
+	r := &firewallResponse{}
+	if err := apiRequest(vm.APIToken, "POST", apiFirewallURL, fw, r); err != nil {
+		return fmt.Errorf("error creating firewall %s: %s", fw.Name, err)
+	}
+	vm.FirewallID = r.Firewall.ID
+	return nil
+}
+
+// deleteFirewall deletes the firewall created for the droplet, if any.
+func (vm *VM) deleteFirewall() error {
+	if vm.FirewallID == "" {
+		return nil
+	}
+	if err := apiRequest(vm.APIToken, "DELETE", apiFirewallURL+"/"+vm.FirewallID, nil, nil); err != nil {
+		return fmt.Errorf("error deleting firewall %s: %s", vm.FirewallID, err)
+	}
+	vm.FirewallID = ""
+	return nil
+}
+
+// contains returns whether the string is in the slice.
+func contains(slice []string, s string) bool {
+	for _, e := range slice {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
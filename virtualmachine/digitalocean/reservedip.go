@@ -0,0 +1,73 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package digitalocean
+
+import (
+	"fmt"
+)
+
+// ReservedIP is a reserved (floating) IP, which can be moved between droplets
+// of a region.
+type ReservedIP struct {
+	IP      string   `json:"ip,omitempty"`
+	Region  *Region  `json:"region,omitempty"`
+	Droplet *Droplet `json:"droplet,omitempty"`
+}
+
+// reservedIPResponse is the API response containing one reserved IP
+type reservedIPResponse struct {
+	ReservedIP *ReservedIP `json:"reserved_ip,omitempty"`
+	Links      struct {
+		Actions []*Action `json:"actions,omitempty"`
+	} `json:"links,omitempty"`
+}
+
+// reservedIPAction is the payload of reserved IP actions
+type reservedIPAction struct {
+	Type      string `json:"type"`
+	DropletID int    `json:"droplet_id,omitempty"`
+}
+
+// assignReservedIP assigns ReservedIP to the droplet, or a new reserved IP if
+// CreateReservedIP is set.
+func (vm *VM) assignReservedIP() error {
+	if vm.ReservedIP != "" {
+		return vm.reservedIPAction(reservedIPAction{Type: "assign", DropletID: vm.Droplet.ID})
+	}
+
+	r := &reservedIPResponse{}
+	payload := map[string]int{"droplet_id": vm.Droplet.ID}
+	if err := apiRequest(vm.APIToken, "POST", apiReservedIPURL, payload, r); err != nil {
+		return fmt.Errorf("error creating a reserved IP: %s", err)
+	}
+	vm.ReservedIP = r.ReservedIP.IP
+	for _, action := range r.Links.Actions {
+		if err := waitForAction(vm.APIToken, action.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteReservedIP deletes ReservedIP if it was created for the droplet. It is
+// unassigned when the droplet is deleted, so a reserved IP which was not
+// created for the droplet is left as it is.
+func (vm *VM) deleteReservedIP() error {
+	if vm.ReservedIP == "" || !vm.CreateReservedIP {
+		return nil
+	}
+	if err := apiRequest(vm.APIToken, "DELETE", apiReservedIPURL+"/"+vm.ReservedIP, nil, nil); err != nil {
+		return fmt.Errorf("error deleting the reserved IP %s: %s", vm.ReservedIP, err)
+	}
+	vm.ReservedIP = ""
+	return nil
+}
+
+// reservedIPAction runs an action on ReservedIP and waits until it completes.
+func (vm *VM) reservedIPAction(payload reservedIPAction) error {
+	r := &ActionResponse{}
+	if err := apiRequest(vm.APIToken, "POST", apiReservedIPURL+"/"+vm.ReservedIP+"/actions", payload, r); err != nil {
+		return fmt.Errorf("error running %s on the reserved IP %s: %s", payload.Type, vm.ReservedIP, err)
+	}
+	return waitForAction(vm.APIToken, r.Action.ID)
+}
@@ -3,13 +3,44 @@
 package digitalocean
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
+// Action statuses
+const (
+	actionInProgress = "in-progress"
+	actionCompleted  = "completed"
+	actionErrored    = "errored"
+)
+
+var (
+	// actionPollInterval is the interval actions are polled at until they
+	// complete.
+	actionPollInterval = 5 * time.Second
+	// actionTimeout is the time actions are waited on.
+	actionTimeout = 10 * time.Minute
+)
+
+// Action is an asynchronous action on a DigitalOcean resource.
+type Action struct {
+	ID           int    `json:"id,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Type         string `json:"type,omitempty"`
+	ResourceID   int    `json:"resource_id,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+}
+
+// ActionResponse is the API response containing one action
+type ActionResponse struct {
+	Action *Action `json:"action,omitempty"`
+}
+
 // BuildRequest builds an http request for this provider.
 func BuildRequest(token, method, url string, body io.Reader) (*http.Request, error) {
 	req, err := http.NewRequest(method, url, body)
@@ -22,6 +53,83 @@ func BuildRequest(token, method, url string, body io.Reader) (*http.Request, err
 	return req, nil
 }
 
+// apiRequest sends a request with the JSON of in as the body, if it is not nil,
+// to the given path of the API, and decodes the JSON response into out, if it
+// is not nil.
+func apiRequest(token, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	client := &http.Client{}
+	req, err := BuildRequest(token, method, apiBaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.Status[0] != StatusOk {
+		return fmt.Errorf("Error: %s: %s", rsp.Status, string(b))
+	}
+
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// waitForAction waits until the action with the given ID completes.
+func waitForAction(token string, id int) error {
+	deadline := time.Now().Add(actionTimeout)
+	for {
+		r := &ActionResponse{}
+		if err := apiRequest(token, "GET", fmt.Sprintf("%s/%d", apiActionURL, id), nil, r); err != nil {
+			return err
+		}
+		switch r.Action.Status {
+		case actionCompleted:
+			return nil
+		case actionErrored:
+			return fmt.Errorf("action %d (%s) errored", id, r.Action.Type)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for action %d (%s)", id, r.Action.Type)
+		}
+		time.Sleep(actionPollInterval)
+	}
+}
+
+// waitUntilActive waits until the droplet is active, as some actions fail
+// while it is still being created.
+func (vm *VM) waitUntilActive() error {
+	deadline := time.Now().Add(actionTimeout)
+	for {
+		state, err := vm.GetState()
+		if err != nil {
+			return err
+		}
+		if state == "active" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for droplet %d to be active", vm.Droplet.ID)
+		}
+		time.Sleep(actionPollInterval)
+	}
+}
+
 // Update vm.Droplet values. This occurs in GetState(), so we call that and
 // ignore the state string.
 func (vm *VM) Update() error {
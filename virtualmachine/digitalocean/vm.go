@@ -25,8 +25,13 @@ var (
 
 // Base API URL strings
 const (
-	apiBaseURL    = "https://api.digitalocean.com"
-	apiDropletURL = "/v2/droplets"
+	apiBaseURL       = "https://api.digitalocean.com"
+	apiDropletURL    = "/v2/droplets"
+	apiActionURL     = "/v2/actions"
+	apiVolumeURL     = "/v2/volumes"
+	apiReservedIPURL = "/v2/reserved_ips"
+	apiFirewallURL   = "/v2/firewalls"
+	apiTagURL        = "/v2/tags"
 )
 
 // VM struct represents a full DigitalOcean VM in libretto. It contains the
@@ -37,10 +42,31 @@ type VM struct {
 	Credentials libssh.Credentials
 	Config      Config
 	Droplet     *Droplet
+	// ReservedIP is a reserved (floating) IP assigned to the droplet once it
+	// is provisioned. It is unassigned when the VM is destroyed.
+	ReservedIP string
+	// CreateReservedIP creates a new reserved IP for the droplet once it is
+	// provisioned, if ReservedIP is empty. ReservedIP is set to it, and it is
+	// deleted when the VM is destroyed.
+	CreateReservedIP bool
+	// Firewalls are the IDs of the cloud firewalls the droplet is added to
+	// once it is provisioned.
+	Firewalls []string
+	// FirewallRules are the rules of a firewall created for the droplet once
+	// it is provisioned, if any. All outbound traffic is blocked unless there
+	// are outbound rules.
+	FirewallRules []FirewallRule
+	// FirewallID is the ID of the firewall created for the droplet. It is
+	// deleted when the VM is destroyed.
+	FirewallID string
 }
 
 var _ lvm.VirtualMachine = (*VM)(nil)
 
+var _ lvm.DashboardLinker = (*VM)(nil)
+
+var _ lvm.Addresser = (*VM)(nil)
+
 // Config is the new droplet payload
 type Config struct {
 	Name              string   `json:"name,omitempty"`   // required
@@ -49,9 +75,19 @@ type Config struct {
 	Image             string   `json:"image,omitempty"`  // required
 	SSHKeys           []string `json:"ssh_keys,omitempty"`
 	Backups           bool     `json:"backups,omitempty"`
-	IPv6              bool     `json:"ipv6,omitempty"`
+	IPv6              bool     `json:"ipv6,omitempty"` // see GetAddresses
 	PrivateNetworking bool     `json:"private_networking,omitempty"`
-	UserData          string   `json:"user_data,omitempty"`
+	UserData          string   `json:"user_data,omitempty"`  // cloud-init user data
+	Monitoring        bool     `json:"monitoring,omitempty"` // monitoring agent
+	// Volumes are the IDs of the block storage volumes attached to the
+	// droplet at creation.
+	Volumes []string `json:"volumes,omitempty"`
+	// VPCUUID is the UUID of the VPC the droplet is placed in. The default
+	// VPC of the region is used if it is empty.
+	VPCUUID string `json:"vpc_uuid,omitempty"`
+	// Tags are the tags of the droplet, which are created if they do not
+	// exist.
+	Tags []string `json:"tags,omitempty"`
 }
 
 // DropletsResponse is the API response containing multiple droplets
@@ -193,7 +229,25 @@ func (vm *VM) Provision() error {
 		return err
 	}
 	vm.Droplet = r.Droplet
-	return nil
+
+	for _, id := range vm.Firewalls {
+		if err := vm.AddToFirewall(id); err != nil {
+			return err
+		}
+	}
+	if len(vm.FirewallRules) > 0 {
+		if err := vm.createFirewall(); err != nil {
+			return err
+		}
+	}
+
+	if vm.ReservedIP == "" && !vm.CreateReservedIP {
+		return nil
+	}
+	if err := vm.waitUntilActive(); err != nil {
+		return err
+	}
+	return vm.assignReservedIP()
 }
 
 // GetIPs returns a list of ip addresses associated with the VM
@@ -202,6 +256,9 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	if err := vm.Update(); err != nil {
 		return nil, err
 	}
+	if vm.ReservedIP != "" {
+		ips = append(ips, net.ParseIP(vm.ReservedIP))
+	}
 	for _, ip := range vm.Droplet.Networks.V4 {
 		ips = append(ips, net.ParseIP(ip.IPAddress))
 	}
@@ -211,6 +268,29 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	return ips, nil
 }
 
+// GetAddresses returns the IPv4 addresses of the droplet, its reserved IP and
+// its IPv6 address if IPv6 is enabled.
+func (vm *VM) GetAddresses() ([]lvm.Address, error) {
+	if err := vm.Update(); err != nil {
+		return nil, err
+	}
+
+	var addrs []lvm.Address
+	if vm.ReservedIP != "" {
+		addrs = append(addrs, lvm.Address{IP: net.ParseIP(vm.ReservedIP), Public: true})
+	}
+	if vm.Droplet.Networks == nil {
+		return addrs, nil
+	}
+	for _, n := range vm.Droplet.Networks.V4 {
+		addrs = append(addrs, lvm.Address{IP: net.ParseIP(n.IPAddress), Public: n.Type == "public"})
+	}
+	for _, n := range vm.Droplet.Networks.V6 {
+		addrs = append(addrs, lvm.Address{IP: net.ParseIP(n.IPAddress), Public: n.Type == "public"})
+	}
+	return addrs, nil
+}
+
 // GetSSH returns an ssh client for the the vm.
 func (vm *VM) GetSSH(options libssh.Options) (libssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
@@ -222,7 +302,18 @@ func (vm *VM) GetSSH(options libssh.Options) (libssh.Client, error) {
 	return &client, nil
 }
 
-// Destroy powers off the VM and deletes its files from disk
+// GetDashboardURL returns the URL of the droplet page in the DigitalOcean
+// control panel.
+func (vm *VM) GetDashboardURL() (string, error) {
+	if vm.Droplet == nil || vm.Droplet.ID == 0 {
+		return "", ErrNoInstanceID
+	}
+
+	return fmt.Sprintf("https://cloud.digitalocean.com/droplets/%d", vm.Droplet.ID), nil
+}
+
+// Destroy powers off the VM and deletes its files from disk. The reserved IP
+// and firewall created for the droplet are deleted once the droplet is.
 func (vm *VM) Destroy() error {
 	id := fmt.Sprintf("%v", vm.Droplet.ID)
 	if id == "" {
@@ -247,6 +338,16 @@ func (vm *VM) Destroy() error {
 		return fmt.Errorf("Error: %s: %s", rsp.Status, string(b))
 	}
 
+	var errs []error
+	if err := vm.deleteReservedIP(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := vm.deleteFirewall(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return util.CombineErrors(": ", errs...)
+	}
 	return nil
 }
 
@@ -0,0 +1,95 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package digitalocean
+
+import (
+	"time"
+)
+
+// VolumeConfig is the new volume payload
+type VolumeConfig struct {
+	Name          string `json:"name,omitempty"`   // required
+	Region        string `json:"region,omitempty"` // required
+	SizeGigabytes int64  `json:"size_gigabytes,omitempty"`
+	Description   string `json:"description,omitempty"`
+	// SnapshotID is the ID of the volume snapshot the volume is created
+	// from, instead of SizeGigabytes.
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	// FilesystemType is the filesystem the volume is formatted with, either
+	// "ext4" or "xfs". It is not formatted if it is empty.
+	FilesystemType string `json:"filesystem_type,omitempty"`
+}
+
+// VolumeResponse is the API response containing one volume
+type VolumeResponse struct {
+	Volume *Volume `json:"volume,omitempty"`
+}
+
+// Volume is a block storage volume
+type Volume struct {
+	ID             string    `json:"id,omitempty"`
+	Name           string    `json:"name,omitempty"`
+	Region         *Region   `json:"region,omitempty"`
+	SizeGigabytes  int64     `json:"size_gigabytes,omitempty"`
+	Description    string    `json:"description,omitempty"`
+	DropletIDs     []int     `json:"droplet_ids,omitempty"`
+	FilesystemType string    `json:"filesystem_type,omitempty"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+}
+
+// volumeAction is the payload of volume actions
+type volumeAction struct {
+	Type      string `json:"type"`
+	DropletID int    `json:"droplet_id"`
+	Region    string `json:"region,omitempty"`
+}
+
+// CreateVolume creates a new block storage volume.
+func CreateVolume(token string, config VolumeConfig) (*Volume, error) {
+	r := &VolumeResponse{}
+	if err := apiRequest(token, "POST", apiVolumeURL, config, r); err != nil {
+		return nil, err
+	}
+	return r.Volume, nil
+}
+
+// GetVolume returns a single volume
+func GetVolume(token, id string) (*Volume, error) {
+	r := &VolumeResponse{}
+	if err := apiRequest(token, "GET", apiVolumeURL+"/"+id, nil, r); err != nil {
+		return nil, err
+	}
+	return r.Volume, nil
+}
+
+// DeleteVolume deletes a volume, which must not be attached to any droplet.
+func DeleteVolume(token, id string) error {
+	return apiRequest(token, "DELETE", apiVolumeURL+"/"+id, nil, nil)
+}
+
+// AttachVolume attaches the volume with the given ID to the droplet, and waits
+// until it is attached.
+func (vm *VM) AttachVolume(id string) error {
+	return vm.volumeAction(id, "attach")
+}
+
+// DetachVolume detaches the volume with the given ID from the droplet, and
+// waits until it is detached.
+func (vm *VM) DetachVolume(id string) error {
+	return vm.volumeAction(id, "detach")
+}
+
+// volumeAction runs an action on the volume with the given ID for the droplet,
+// and waits until it completes.
+func (vm *VM) volumeAction(id, action string) error {
+	if vm.Droplet == nil || vm.Droplet.ID == 0 {
+		return ErrNoInstanceID
+	}
+
+	payload := volumeAction{Type: action, DropletID: vm.Droplet.ID, Region: vm.Config.Region}
+	r := &ActionResponse{}
+	if err := apiRequest(vm.APIToken, "POST", apiVolumeURL+"/"+id+"/actions", payload, r); err != nil {
+		return err
+	}
+	return waitForAction(vm.APIToken, r.Action.ID)
+}
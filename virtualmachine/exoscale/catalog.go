@@ -0,0 +1,85 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+)
+
+// InstanceType is a Exoscale instance type, also known as service offering
+type InstanceType struct {
+	ID       string `json:"id,omitempty"`
+	Family   string `json:"family,omitempty"`
+	Size     string `json:"size,omitempty"`
+	CPUs     int    `json:"cpus,omitempty"`
+	MemoryMB int    `json:"memorymb,omitempty"`
+}
+
+// ListInstanceTypes returns the instance types available in the zone.
+func ListInstanceTypes(config Config, zoneName string) ([]InstanceType, error) {
+	client, err := getExoClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceTypes, err := client.ListInstanceTypes(context.Background(), zoneName)
+	if err != nil {
+		return nil, fmt.Errorf("Listing instance types: %s", err)
+	}
+
+	types := make([]InstanceType, 0, len(instanceTypes))
+	for _, t := range instanceTypes {
+		if t.Authorized != nil && !*t.Authorized {
+			continue
+		}
+		instanceType := InstanceType{
+			ID:     stringValue(t.ID),
+			Family: stringValue(t.Family),
+			Size:   stringValue(t.Size),
+		}
+		if t.CPUs != nil {
+			instanceType.CPUs = int(*t.CPUs)
+		}
+		if t.Memory != nil {
+			instanceType.MemoryMB = int(*t.Memory >> 20)
+		}
+		types = append(types, instanceType)
+	}
+	return types, nil
+}
+
+// ListTemplates returns the public templates of the zone, or of all zones if
+// zoneName is empty.
+func ListTemplates(config Config, zoneName string) ([]Template, error) {
+	client, err := getExoClient(config)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	zones := []string{zoneName}
+	if zoneName == "" {
+		if zones, err = client.ListZones(ctx); err != nil {
+			return nil, fmt.Errorf("Listing zones: %s", err)
+		}
+	}
+
+	var list []Template
+	for _, zone := range zones {
+		templates, err := client.ListTemplates(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("Listing templates of zone %q: %s", zone, err)
+		}
+		for _, template := range templates {
+			t := Template{
+				ID:       stringValue(template.ID),
+				Name:     stringValue(template.Name),
+				ZoneName: zone,
+			}
+			if template.Size != nil {
+				t.StorageGB = int(*template.Size >> 30)
+			}
+			list = append(list, t)
+		}
+	}
+	return list, nil
+}
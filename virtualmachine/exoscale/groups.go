@@ -0,0 +1,200 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+// JobTimeout is the maximum time to wait for the asynchronous operations of
+// the API, such as creating a virtual machine or a snapshot. This is not
+// thread-safe.
+var JobTimeout = 5 * time.Minute
+
+// SecurityGroupRule is an ingress rule of a security group created by libretto
+type SecurityGroupRule struct {
+	Protocol string // "TCP", "UDP" or "ICMP"
+	Port     int    // port of TCP and UDP rules
+	CIDR     string // source network, such as "0.0.0.0/0"
+}
+
+// AntiAffinityGroup is a Exoscale anti-affinity group. Virtual machines of the
+// same anti-affinity group run on different hosts.
+type AntiAffinityGroup struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Create creates the group at provisioning, and deletes it when the
+	// virtual machine is destroyed, so it must not be shared with virtual
+	// machines which outlive it.
+	Create bool `json:"create,omitempty"`
+}
+
+// PrivateNetwork is a Exoscale private network (privnet), attached to the
+// virtual machine as an additional NIC once it is created.
+type PrivateNetwork struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Create creates the private network at provisioning, and deletes it
+	// when the virtual machine is destroyed.
+	Create bool `json:"create,omitempty"`
+}
+
+// createSecurityGroups creates the security groups which are to be created.
+func (vm *VM) createSecurityGroups(ctx context.Context, client *egoscale.Client) error {
+	for i, sg := range vm.SecurityGroups {
+		if !sg.Create || sg.ID != "" {
+			continue
+		}
+
+		name := sg.Name
+		created, err := client.CreateSecurityGroup(ctx, vm.zone(), &egoscale.SecurityGroup{Name: &name})
+		if err != nil {
+			return fmt.Errorf("Creating security group %q: %s", sg.Name, err)
+		}
+		vm.SecurityGroups[i].ID = *created.ID
+
+		for _, rule := range sg.Rules {
+			r, err := newSecurityGroupRule(rule)
+			if err != nil {
+				return fmt.Errorf("Adding rule to security group %q: %s", sg.Name, err)
+			}
+			if _, err := client.CreateSecurityGroupRule(ctx, vm.zone(), created, r); err != nil {
+				return fmt.Errorf("Adding rule to security group %q: %s", sg.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newSecurityGroupRule returns the API v2 ingress rule of the rule.
+func newSecurityGroupRule(rule SecurityGroupRule) (*egoscale.SecurityGroupRule, error) {
+	_, network, err := net.ParseCIDR(rule.CIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := "ingress"
+	protocol := strings.ToLower(rule.Protocol)
+	r := &egoscale.SecurityGroupRule{
+		FlowDirection: &direction,
+		Protocol:      &protocol,
+		Network:       network,
+	}
+	if protocol == "icmp" {
+		// Echo requests
+		icmpType, icmpCode := int64(8), int64(0)
+		r.ICMPType = &icmpType
+		r.ICMPCode = &icmpCode
+	} else {
+		port := uint16(rule.Port)
+		r.StartPort = &port
+		r.EndPort = &port
+	}
+	return r, nil
+}
+
+// createAntiAffinityGroups creates the anti-affinity groups which are to be
+// created, and fills the identifiers of the others based on name.
+func (vm *VM) createAntiAffinityGroups(ctx context.Context, client *egoscale.Client) error {
+	for i, ag := range vm.AntiAffinityGroups {
+		if ag.ID != "" {
+			continue
+		}
+
+		if !ag.Create {
+			found, err := client.FindAntiAffinityGroup(ctx, vm.zone(), ag.Name)
+			if err != nil {
+				return fmt.Errorf("Could not find anti-affinity group ID for %q: %s", ag.Name, err)
+			}
+			vm.AntiAffinityGroups[i].ID = *found.ID
+			continue
+		}
+
+		name := ag.Name
+		created, err := client.CreateAntiAffinityGroup(ctx, vm.zone(), &egoscale.AntiAffinityGroup{Name: &name})
+		if err != nil {
+			return fmt.Errorf("Creating anti-affinity group %q: %s", ag.Name, err)
+		}
+		vm.AntiAffinityGroups[i].ID = *created.ID
+	}
+	return nil
+}
+
+// createPrivateNetworks creates the private networks which are to be created,
+// and fills the identifiers of the others based on name.
+func (vm *VM) createPrivateNetworks(ctx context.Context, client *egoscale.Client) error {
+	for i, pn := range vm.PrivateNetworks {
+		if pn.ID != "" {
+			continue
+		}
+
+		if !pn.Create {
+			found, err := client.FindPrivateNetwork(ctx, vm.zone(), pn.Name)
+			if err != nil {
+				return fmt.Errorf("Could not find private network ID for %q: %s", pn.Name, err)
+			}
+			vm.PrivateNetworks[i].ID = *found.ID
+			continue
+		}
+
+		name := pn.Name
+		created, err := client.CreatePrivateNetwork(ctx, vm.zone(), &egoscale.PrivateNetwork{Name: &name})
+		if err != nil {
+			return fmt.Errorf("Creating private network %q: %s", pn.Name, err)
+		}
+		vm.PrivateNetworks[i].ID = *created.ID
+	}
+	return nil
+}
+
+// attachPrivateNetworks adds a NIC on each private network to the virtual
+// machine.
+func (vm *VM) attachPrivateNetworks(ctx context.Context, client *egoscale.Client) error {
+	instance := &egoscale.Instance{ID: &vm.ID}
+	for _, pn := range vm.PrivateNetworks {
+		id := pn.ID
+		if err := client.AttachInstanceToPrivateNetwork(ctx, vm.zone(), instance, &egoscale.PrivateNetwork{ID: &id}); err != nil {
+			return fmt.Errorf("Attaching private network %q: %s", pn.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteCreatedGroups deletes the security groups, anti-affinity groups and
+// private networks created for the virtual machine, once it is destroyed.
+func (vm *VM) deleteCreatedGroups(ctx context.Context, client *egoscale.Client) error {
+	for _, sg := range vm.SecurityGroups {
+		if !sg.Create || sg.ID == "" {
+			continue
+		}
+		id := sg.ID
+		if err := client.DeleteSecurityGroup(ctx, vm.zone(), &egoscale.SecurityGroup{ID: &id}); err != nil {
+			return fmt.Errorf("Deleting security group %q: %s", sg.Name, err)
+		}
+	}
+
+	for _, ag := range vm.AntiAffinityGroups {
+		if !ag.Create || ag.ID == "" {
+			continue
+		}
+		id := ag.ID
+		if err := client.DeleteAntiAffinityGroup(ctx, vm.zone(), &egoscale.AntiAffinityGroup{ID: &id}); err != nil {
+			return fmt.Errorf("Deleting anti-affinity group %q: %s", ag.Name, err)
+		}
+	}
+
+	for _, pn := range vm.PrivateNetworks {
+		if !pn.Create || pn.ID == "" {
+			continue
+		}
+		id := pn.ID
+		if err := client.DeletePrivateNetwork(ctx, vm.zone(), &egoscale.PrivateNetwork{ID: &id}); err != nil {
+			return fmt.Errorf("Deleting private network %q: %s", pn.Name, err)
+		}
+	}
+	return nil
+}
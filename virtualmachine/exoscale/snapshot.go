@@ -0,0 +1,133 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	egoscale "github.com/exoscale/egoscale/v2"
+)
+
+// Snapshot is a snapshot of the root volume of a virtual machine
+type Snapshot struct {
+	ID      string    `json:"id,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	State   string    `json:"state,omitempty"`
+	Created time.Time `json:"created,omitempty"`
+}
+
+// SnapshotExport is a snapshot exported for download
+type SnapshotExport struct {
+	URL    string `json:"presignedurl,omitempty"` // temporary download URL
+	MD5Sum string `json:"md5sum,omitempty"`
+}
+
+// newSnapshot returns the snapshot of the API v2 snapshot.
+func newSnapshot(s *egoscale.Snapshot) *Snapshot {
+	snapshot := &Snapshot{
+		ID:    stringValue(s.ID),
+		Name:  stringValue(s.Name),
+		State: stringValue(s.State),
+	}
+	if s.CreatedAt != nil {
+		snapshot.Created = *s.CreatedAt
+	}
+	return snapshot
+}
+
+// CreateSnapshot takes a snapshot of the root volume of the virtual machine
+// and waits until it is taken.
+func (vm *VM) CreateSnapshot() (*Snapshot, error) {
+	if vm.ID == "" {
+		return nil, fmt.Errorf("Need an ID to snapshot the virtual machine")
+	}
+
+	client, err := vm.getExoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := client.CreateInstanceSnapshot(context.Background(), vm.zone(), &egoscale.Instance{ID: &vm.ID})
+	if err != nil {
+		return nil, fmt.Errorf("Creating snapshot of virtual machine %q: %s", vm.ID, err)
+	}
+	return newSnapshot(snapshot), nil
+}
+
+// ListSnapshots returns the snapshots of the root volume of the virtual
+// machine.
+func (vm *VM) ListSnapshots() ([]*Snapshot, error) {
+	if vm.ID == "" {
+		return nil, fmt.Errorf("Need an ID to list the snapshots of the virtual machine")
+	}
+
+	client, err := vm.getExoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := client.ListSnapshots(context.Background(), vm.zone())
+	if err != nil {
+		return nil, fmt.Errorf("Listing snapshots of virtual machine %q: %s", vm.ID, err)
+	}
+
+	var list []*Snapshot
+	for _, snapshot := range snapshots {
+		if stringValue(snapshot.InstanceID) == vm.ID {
+			list = append(list, newSnapshot(snapshot))
+		}
+	}
+	return list, nil
+}
+
+// RevertSnapshot reverts the root volume of the virtual machine to the
+// snapshot. The virtual machine must be stopped.
+func (vm *VM) RevertSnapshot(id string) error {
+	if vm.ID == "" {
+		return fmt.Errorf("Need an ID to revert the virtual machine")
+	}
+
+	client, err := vm.getExoClient()
+	if err != nil {
+		return err
+	}
+
+	instance := &egoscale.Instance{ID: &vm.ID}
+	if err := client.RevertInstanceToSnapshot(context.Background(), vm.zone(), instance, &egoscale.Snapshot{ID: &id}); err != nil {
+		return fmt.Errorf("Reverting snapshot %q: %s", id, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes the snapshot.
+func (vm *VM) DeleteSnapshot(id string) error {
+	client, err := vm.getExoClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.DeleteSnapshot(context.Background(), vm.zone(), &egoscale.Snapshot{ID: &id}); err != nil {
+		return fmt.Errorf("Deleting snapshot %q: %s", id, err)
+	}
+	return nil
+}
+
+// ExportSnapshot exports the snapshot and returns its temporary download URL.
+func (vm *VM) ExportSnapshot(id string) (*SnapshotExport, error) {
+	client, err := vm.getExoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	export, err := client.ExportSnapshot(context.Background(), vm.zone(), &egoscale.Snapshot{ID: &id})
+	if err != nil {
+		return nil, fmt.Errorf("Exporting snapshot %q: %s", id, err)
+	}
+	if export.PresignedURL == nil {
+		return nil, fmt.Errorf("Exporting snapshot %q: no download URL", id)
+	}
+	return &SnapshotExport{
+		URL:    *export.PresignedURL,
+		MD5Sum: stringValue(export.MD5sum),
+	}, nil
+}
@@ -1,130 +1,101 @@
 package exoscale
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net"
-	"net/url"
 	"strings"
 	"time"
 
-	"github.com/pyr/egoscale"
+	"github.com/apcera/libretto/virtualmachine"
+	egoscale "github.com/exoscale/egoscale/v2"
 )
 
-func (vm *VM) getExoClient() *egoscale.Client {
-	return egoscale.NewClient(vm.Config.Endpoint, vm.Config.APIKey, vm.Config.APISecret)
-}
-
-// WaitVMCreation waits for the virtual machine to be created, and stores the virtual machine ID
-// VM structure must contain a valid JobID.
-func (vm *VM) WaitVMCreation(timeoutSeconds int, pollIntervalSeconds int) error {
+// getExoClient returns a client of the Exoscale API v2. Its operations wait
+// up to JobTimeout.
+func getExoClient(config Config) (*egoscale.Client, error) {
+	opts := []egoscale.ClientOpt{egoscale.ClientOptWithTimeout(JobTimeout)}
+	if config.Endpoint != "" {
+		opts = append(opts, egoscale.ClientOptWithAPIEndpoint(config.Endpoint))
+	}
 
-	if vm.JobID == "" {
-		return fmt.Errorf("No JobID informed. Cannot poll machine creation state")
+	client, err := egoscale.NewClient(config.APIKey, config.APISecret, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Creating Exoscale client: %s", err)
 	}
+	return client, nil
+}
 
-	jobCh := make(chan *egoscale.QueryAsyncJobResultResponse, 1)
-	errCh := make(chan error, 1)
+func (vm *VM) getExoClient() (*egoscale.Client, error) {
+	return getExoClient(vm.Config)
+}
 
-	go func() {
-		params := url.Values{}
-		params.Set("jobid", vm.JobID)
+// zone returns the name of the zone of the virtual machine, which defaults to
+// the zone of its template.
+func (vm *VM) zone() string {
+	if vm.Zone.Name != "" {
+		return strings.ToLower(vm.Zone.Name)
+	}
+	return strings.ToLower(vm.Template.ZoneName)
+}
 
-		for {
-			client := vm.getExoClient()
-			resp, err := client.Request("queryAsyncJobResult", params)
-			if err != nil {
-				errCh <- err
-			}
+// WaitVMCreation waits for the virtual machine created by Provision to be
+// running. VM structure must contain a valid ID.
+func (vm *VM) WaitVMCreation(timeoutSeconds int, pollIntervalSeconds int) error {
 
-			jobResult := &egoscale.QueryAsyncJobResultResponse{}
-			err = json.Unmarshal(resp, jobResult)
-			if err != nil {
-				errCh <- err
-			}
+	if vm.ID == "" {
+		return fmt.Errorf("No ID informed. Cannot poll machine creation state")
+	}
 
-			if jobResult.Jobstatus == 1 {
-				jobCh <- jobResult
-				break
-			}
-			time.Sleep(time.Duration(pollIntervalSeconds) * time.Second)
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for {
+		state, err := vm.GetState()
+		if err != nil {
+			return err
+		}
+		switch state {
+		case virtualmachine.VMRunning:
+			return nil
+		case virtualmachine.VMError:
+			return fmt.Errorf("Virtual machine %q failed to start", vm.ID)
 		}
-	}()
 
-	select {
-	case jobResult := <-jobCh:
-		var vmWrap egoscale.DeployVirtualMachineWrappedResponse
-		if err := json.Unmarshal(jobResult.Jobresult, &vmWrap); err != nil {
-			return err
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Virtual machine %q is not running after %d seconds", vm.ID, timeoutSeconds)
 		}
-		vm.ID = vmWrap.Wrapped.Id
-	case err := <-errCh:
-		return err
-	case <-time.After(time.Duration(timeoutSeconds) * time.Second):
-		return fmt.Errorf("Create VM Job has not completed after %d seconds", timeoutSeconds)
+		time.Sleep(time.Duration(pollIntervalSeconds) * time.Second)
 	}
-
-	return nil
 }
 
-// fillTemplateID fills the template identifier based on name, storage and zone name.
+// fillTemplateID fills the template identifier based on name and zone name.
 // If no matching template is found, ID remains unchanged and an error is returned.
-func (vm *VM) fillTemplateID() error {
+func (vm *VM) fillTemplateID(ctx context.Context, client *egoscale.Client) error {
 
-	params := url.Values{}
-	params.Set("Name", vm.Template.Name)
-	params.Set("templatefilter", "featured")
-
-	client := vm.getExoClient()
-	resp, err := client.Request("listTemplates", params)
+	templates, err := client.ListTemplates(ctx, vm.zone())
 	if err != nil {
-		return fmt.Errorf("Getting template ID for '%s/%d/%s': %s", vm.Template.Name, vm.Template.StorageGB, vm.Template.ZoneName, err)
-	}
-
-	templates := &egoscale.ListTemplatesResponse{}
-	if err := json.Unmarshal(resp, templates); err != nil {
-		return fmt.Errorf("Decoding response for template '%s/%d/%s': %s", vm.Template.Name, vm.Template.StorageGB, vm.Template.ZoneName, err)
+		return fmt.Errorf("Getting template ID for '%s/%s': %s", vm.Template.Name, vm.Template.ZoneName, err)
 	}
 
-	// iterate templates to get ID matching size and zone name
-	storage := int64(vm.Template.StorageGB) << 30
-	zoneName := strings.ToLower(vm.Template.ZoneName)
-
-	for _, template := range templates.Templates {
-		if template.Size == storage {
-			if template.Zonename == zoneName {
-				vm.Template.ID = template.Id
-				return nil
-			}
+	for _, template := range templates {
+		if stringValue(template.Name) == vm.Template.Name {
+			vm.Template.ID = *template.ID
+			return nil
 		}
 	}
 
-	return fmt.Errorf("Template ID for '%s/%d/%s' could not be found", vm.Template.Name, vm.Template.StorageGB, vm.Template.ZoneName)
+	return fmt.Errorf("Template ID for '%s/%s' could not be found", vm.Template.Name, vm.Template.ZoneName)
 }
 
 // fillServiceOfferingID fills the service offering identifier based on name.
 // If no matching service offering is found, ID remains unchanged and an error is returned.
-func (vm *VM) fillServiceOfferingID() error {
-
-	params := url.Values{}
-	params.Set("name", strings.ToLower(string(vm.ServiceOffering.Name)))
+func (vm *VM) fillServiceOfferingID(ctx context.Context, client *egoscale.Client) error {
 
-	client := vm.getExoClient()
-	resp, err := client.Request("listServiceOfferings", params)
+	instanceType, err := client.FindInstanceType(ctx, vm.zone(), string(vm.ServiceOffering.Name))
 	if err != nil {
 		return fmt.Errorf("Getting service offering ID for %q: %s", vm.ServiceOffering.Name, err)
 	}
 
-	so := &egoscale.ListServiceOfferingsResponse{}
-	if err := json.Unmarshal(resp, so); err != nil {
-		return fmt.Errorf("Decoding response for service offering %q: %s", vm.ServiceOffering.Name, err)
-	}
-
-	if so.Count != 1 {
-		return fmt.Errorf("Expected 1 service offering matching %q, but returned %d", vm.ServiceOffering.Name, so.Count)
-	}
-
-	vm.ServiceOffering.ID = so.ServiceOfferings[0].Id
+	vm.ServiceOffering.ID = *instanceType.ID
 
 	return nil
 }
@@ -132,20 +103,7 @@ func (vm *VM) fillServiceOfferingID() error {
 // fillSecurityGroupsID fills the security group identifiers based on name.
 // If a security group already has the ID, it will remain unchanged.
 // If any of the security groups is not founds error is returned.
-func (vm *VM) fillSecurityGroupsID() error {
-
-	params := url.Values{}
-
-	client := vm.getExoClient()
-	resp, err := client.Request("listSecurityGroups", params)
-	if err != nil {
-		return fmt.Errorf("Getting security groups: %s", err)
-	}
-
-	sgRemotes := &egoscale.ListSecurityGroupsResponse{}
-	if err := json.Unmarshal(resp, sgRemotes); err != nil {
-		return fmt.Errorf("Decoding response for security groups: %s", err)
-	}
+func (vm *VM) fillSecurityGroupsID(ctx context.Context, client *egoscale.Client) error {
 
 	for i, sg := range vm.SecurityGroups {
 
@@ -153,98 +111,73 @@ func (vm *VM) fillSecurityGroupsID() error {
 			continue
 		}
 
-		newID := ""
-		for _, sgRemote := range sgRemotes.SecurityGroups {
-			if sg.Name == sgRemote.Name {
-				newID = sgRemote.Id
-				break
-			}
-		}
-
-		if newID == "" {
-			return fmt.Errorf("Could not find security group ID for %q", sg.Name)
+		sgRemote, err := client.FindSecurityGroup(ctx, vm.zone(), sg.Name)
+		if err != nil {
+			return fmt.Errorf("Could not find security group ID for %q: %s", sg.Name, err)
 		}
-		vm.SecurityGroups[i].ID = newID
+		vm.SecurityGroups[i].ID = *sgRemote.ID
 	}
 
 	return nil
 }
 
-// fillZoneID fills the zone identifier based on name.
-// If no matching zone is found, ID remains unchanged and an error is returned.
-func (vm *VM) fillZoneID() error {
-
-	params := url.Values{}
-	params.Set("name", strings.ToLower(string(vm.Zone.Name)))
-
-	client := vm.getExoClient()
-	resp, err := client.Request("listZones", params)
-	if err != nil {
-		return fmt.Errorf("Getting zones ID for %q: %s", vm.ServiceOffering.Name, err)
-	}
-
-	zones := &egoscale.ListZonesResponse{}
-	if err := json.Unmarshal(resp, zones); err != nil {
-		return fmt.Errorf("Decoding response for zones list: %s", err)
-	}
-
-	for _, zone := range zones.Zones {
-		if zone.Name == vm.Zone.Name {
-			vm.Zone.ID = zone.Id
-			return nil
-		}
-	}
-
-	return fmt.Errorf("Zone ID for %q could not be found", vm.Zone.Name)
-}
-
 func (vm *VM) updateInfo() error {
 
 	if vm.ID == "" {
 		return fmt.Errorf("Need an ID to retrieve virtual machine Info")
 	}
 
-	params := url.Values{}
-	params.Set("id", vm.ID)
-
-	client := vm.getExoClient()
-	resp, err := client.Request("listVirtualMachines", params)
+	client, err := vm.getExoClient()
 	if err != nil {
-		return fmt.Errorf("Listing virtual machine %q to update info: %s", vm.ID, err)
-	}
-
-	listVM := &egoscale.ListVirtualMachinesResponse{}
-	if err := json.Unmarshal(resp, listVM); err != nil {
-		return fmt.Errorf("Listing virtual machine %q to update info: %s", vm.ID, err)
+		return err
 	}
 
-	if listVM.Count != 1 {
-		return fmt.Errorf("Expected 1 virtual machine in listing matching %q, but returned %d", vm.ID, listVM.Count)
+	instance, err := client.GetInstance(context.Background(), vm.zone(), vm.ID)
+	if err != nil {
+		return fmt.Errorf("Getting virtual machine %q to update info: %s", vm.ID, err)
 	}
 
-	vm.Template.ID = listVM.VirtualMachines[0].Templateid
-	vm.Template.Name = listVM.VirtualMachines[0].Templatename
-	vm.Template.ZoneName = listVM.VirtualMachines[0].Zonename
-
-	vm.ServiceOffering.ID = listVM.VirtualMachines[0].Serviceofferingid
-	vm.ServiceOffering.Name = ServiceOfferingType(listVM.VirtualMachines[0].Serviceofferingname)
-
-	vm.Name = listVM.VirtualMachines[0].Displayname
+	vm.Template.ID = stringValue(instance.TemplateID)
+	vm.ServiceOffering.ID = stringValue(instance.InstanceTypeID)
+	vm.Name = stringValue(instance.Name)
 
-	// suppose 1 ip per nic, and grow capacity using append if necessary
-	ips := make([]net.IP, 0, len(listVM.VirtualMachines[0].Nic))
-	for _, nic := range listVM.VirtualMachines[0].Nic {
-		if nic.Ipaddress != "" {
-			ips = append(ips, net.ParseIP(nic.Ipaddress))
-		}
-		if nic.Ip6address != "" {
-			ips = append(ips, net.ParseIP(nic.Ip6address))
-		}
+	ips := make([]net.IP, 0, 2)
+	if instance.PublicIPAddress != nil {
+		ips = append(ips, *instance.PublicIPAddress)
+	}
+	if instance.IPv6Address != nil {
+		ips = append(ips, *instance.IPv6Address)
 	}
 
 	vm.ips = ips
-	vm.KeypairName = listVM.VirtualMachines[0].Keypair
-	vm.state = listVM.VirtualMachines[0].State
+	vm.KeypairName = stringValue(instance.SSHKey)
+	vm.state = mapState(stringValue(instance.State))
 
 	return nil
 }
+
+// mapState maps the state of a Exoscale instance to a VM state.
+func mapState(state string) string {
+	switch state {
+	case "running":
+		return virtualmachine.VMRunning
+	case "starting":
+		return virtualmachine.VMStarting
+	case "stopped":
+		return virtualmachine.VMHalted
+	case "stopping", "destroying", "migrating":
+		return virtualmachine.VMPending
+	case "error":
+		return virtualmachine.VMError
+	}
+	return virtualmachine.VMUnknown
+}
+
+// stringValue returns the string the pointer of a API v2 field points to, or
+// an empty string if it is nil.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
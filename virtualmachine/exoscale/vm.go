@@ -2,16 +2,16 @@
 package exoscale
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"net"
-	"net/url"
 	"time"
 
 	"github.com/apcera/libretto/ssh"
 	"github.com/apcera/libretto/util"
 	"github.com/apcera/libretto/virtualmachine"
-	"github.com/pyr/egoscale"
+	egoscale "github.com/exoscale/egoscale/v2"
 )
 
 // VM represents an Exoscale virtual machine.
@@ -24,10 +24,12 @@ type VM struct {
 	SecurityGroups  []SecurityGroup // list of security groups associated with the virtual machine
 	KeypairName     string          // SSH Keypair identifier to use
 	Userdata        string          // User data sent to the virtual machine
-	Zone            Zone            // Zone identifier
+	Zone            Zone            // Zone of the virtual machine, defaults to the zone of the template
 
-	ID    string // Virtual machine ID.
-	JobID string // virtual machine creation job ID
+	AntiAffinityGroups []AntiAffinityGroup // anti-affinity groups of the virtual machine
+	PrivateNetworks    []PrivateNetwork    // private networks attached by Provision
+
+	ID string // Virtual machine ID.
 
 	SSHCreds ssh.Credentials // SSH credentials required to connect to machine
 
@@ -35,22 +37,30 @@ type VM struct {
 	state string   // machine state
 }
 
-// Config is the new droplet payload
+// Config is the configuration of the Exoscale API v2 client
 type Config struct {
-	Endpoint  string `json:"endpoint,omitempty"`  // required
+	Endpoint  string `json:"endpoint,omitempty"`  // defaults to https://api.exoscale.com/
 	APIKey    string `json:"apikey,omitempty"`    // required
 	APISecret string `json:"apisecret,omitempty"` // required
 }
 
 // Template is the base image for Exoscale virtual machines
 type Template struct {
-	ID        string `json:"id,omitempty"`
-	Name      string `json:"name,omitempty"`
-	ZoneName  string `json:"zonename,omitempty"`
-	StorageGB int    `json:"storagegb,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	ZoneName string `json:"zonename,omitempty"`
+	// StorageGB is the size of the root disk of the virtual machine, which
+	// defaults to DefaultStorageGB.
+	StorageGB int `json:"storagegb,omitempty"`
 }
 
-// ServiceOffering is a Exoscale machine type offering
+// DefaultStorageGB is the size of the root disk of virtual machines whose
+// template has no StorageGB.
+const DefaultStorageGB = 10
+
+// ServiceOffering is a Exoscale machine type offering, also known as instance
+// type. The name is the size of the instance type, optionally prefixed with
+// its family, such as "gpu.small".
 type ServiceOffering struct {
 	ID   string              `json:"id,omitempty"`
 	Name ServiceOfferingType `json:"name,omitempty"`
@@ -60,9 +70,14 @@ type ServiceOffering struct {
 type SecurityGroup struct {
 	ID   string `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
+	// Create creates the security group with Rules at provisioning, and
+	// deletes it when the virtual machine is destroyed.
+	Create bool                `json:"create,omitempty"`
+	Rules  []SecurityGroupRule `json:"rules,omitempty"`
 }
 
-// Zone is a Exoscale zone
+// Zone is a Exoscale zone. The API v2 identifies zones by name, the ID is
+// not used.
 type Zone struct {
 	ID   string `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
@@ -101,33 +116,47 @@ func (vm *VM) GetName() string {
 	return vm.Name
 }
 
-// Provision creates a virtual machine on exoscale.
-// A JobID is informed that can be used to poll the VM creation process (see WaitVMCreation)
+// Provision creates a virtual machine on exoscale and attaches its private
+// networks. Security groups, anti-affinity groups and private networks to be
+// created are created first.
 func (vm *VM) Provision() error {
 
+	client, err := vm.getExoClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
 	if vm.Template.ID == "" {
-		if err := vm.fillTemplateID(); err != nil {
+		if err := vm.fillTemplateID(ctx, client); err != nil {
 			return err
 		}
 	}
 
 	if vm.ServiceOffering.ID == "" {
-		if err := vm.fillServiceOfferingID(); err != nil {
+		if err := vm.fillServiceOfferingID(ctx, client); err != nil {
 			return err
 		}
 	}
 
-	for _, sg := range vm.SecurityGroups {
-		if sg.ID == "" {
-			vm.fillSecurityGroupsID()
-			break
-		}
+	if err := vm.createSecurityGroups(ctx, client); err != nil {
+		return err
 	}
 
-	if vm.Zone.ID == "" {
-		if err := vm.fillZoneID(); err != nil {
-			return err
-		}
+	if err := vm.fillSecurityGroupsID(ctx, client); err != nil {
+		return err
+	}
+
+	if err := vm.createAntiAffinityGroups(ctx, client); err != nil {
+		return err
+	}
+	if err := vm.createPrivateNetworks(ctx, client); err != nil {
+		return err
+	}
+
+	affinityGroups := make([]string, len(vm.AntiAffinityGroups))
+	for i := range vm.AntiAffinityGroups {
+		affinityGroups[i] = vm.AntiAffinityGroups[i].ID
 	}
 
 	securityGroups := make([]string, len(vm.SecurityGroups))
@@ -135,25 +164,35 @@ func (vm *VM) Provision() error {
 		securityGroups[i] = vm.SecurityGroups[i].ID
 	}
 
-	profile := egoscale.MachineProfile{
-		Template:        vm.Template.ID,
-		ServiceOffering: vm.ServiceOffering.ID,
-		SecurityGroups:  securityGroups,
-		Keypair:         vm.KeypairName,
-		Userdata:        vm.Userdata,
-		Zone:            vm.Zone.ID,
-		Name:            vm.Name,
+	diskSize := int64(vm.Template.StorageGB)
+	if diskSize == 0 {
+		diskSize = DefaultStorageGB
 	}
 
-	client := vm.getExoClient()
-	jobID, err := client.CreateVirtualMachine(profile)
+	instance := &egoscale.Instance{
+		Name:                 &vm.Name,
+		TemplateID:           &vm.Template.ID,
+		InstanceTypeID:       &vm.ServiceOffering.ID,
+		DiskSize:             &diskSize,
+		SecurityGroupIDs:     &securityGroups,
+		AntiAffinityGroupIDs: &affinityGroups,
+	}
+	if vm.KeypairName != "" {
+		instance.SSHKey = &vm.KeypairName
+	}
+	if vm.Userdata != "" {
+		userData := base64.StdEncoding.EncodeToString([]byte(vm.Userdata))
+		instance.UserData = &userData
+	}
+
+	created, err := client.CreateInstance(ctx, vm.zone(), instance)
 	if err != nil {
-		return err
+		return fmt.Errorf("Creating virtual machine %q: %s", vm.Name, err)
 	}
 
-	vm.JobID = jobID
+	vm.ID = *created.ID
 
-	return nil
+	return vm.attachPrivateNetworks(ctx, client)
 }
 
 // GetIPs returns the list of ip addresses associated with the VM
@@ -173,23 +212,19 @@ func (vm *VM) Destroy() error {
 		return fmt.Errorf("Need an ID to destroy the virtual machine")
 	}
 
-	params := url.Values{}
-	params.Set("id", vm.ID)
-
-	client := vm.getExoClient()
-	resp, err := client.Request("destroyVirtualMachine", params)
+	client, err := vm.getExoClient()
 	if err != nil {
-		return fmt.Errorf("Destroying virtual machine %q: %s", vm.ID, err)
+		return err
 	}
+	ctx := context.Background()
 
-	destroy := &egoscale.DestroyVirtualMachineResponse{}
-	if err := json.Unmarshal(resp, destroy); err != nil {
+	// The instance is gone once DeleteInstance returns, so that its groups
+	// can be deleted.
+	if err := client.DeleteInstance(ctx, vm.zone(), &egoscale.Instance{ID: &vm.ID}); err != nil {
 		return fmt.Errorf("Destroying virtual machine %q: %s", vm.ID, err)
 	}
 
-	vm.JobID = destroy.JobID
-
-	return nil
+	return vm.deleteCreatedGroups(ctx, client)
 }
 
 // GetState returns virtual machine state
@@ -223,22 +258,15 @@ func (vm *VM) Halt() error {
 		return fmt.Errorf("Need an ID to stop the virtual machine")
 	}
 
-	params := url.Values{}
-	params.Set("id", vm.ID)
-
-	client := vm.getExoClient()
-	resp, err := client.Request("stopVirtualMachine", params)
+	client, err := vm.getExoClient()
 	if err != nil {
-		return fmt.Errorf("Stopping virtual machine %q: %s", vm.ID, err)
+		return err
 	}
 
-	stop := &egoscale.StopVirtualMachineResponse{}
-	if err := json.Unmarshal(resp, stop); err != nil {
+	if err := client.StopInstance(context.Background(), vm.zone(), &egoscale.Instance{ID: &vm.ID}); err != nil {
 		return fmt.Errorf("Stopping virtual machine %q: %s", vm.ID, err)
 	}
 
-	vm.JobID = stop.JobID
-
 	return nil
 
 }
@@ -250,22 +278,15 @@ func (vm *VM) Start() error {
 		return fmt.Errorf("Need an ID to start the virtual machine")
 	}
 
-	params := url.Values{}
-	params.Set("id", vm.ID)
-
-	client := vm.getExoClient()
-	resp, err := client.Request("startVirtualMachine", params)
+	client, err := vm.getExoClient()
 	if err != nil {
-		return fmt.Errorf("Starting virtual machine %q: %s", vm.ID, err)
+		return err
 	}
 
-	start := &egoscale.StartVirtualMachineResponse{}
-	if err := json.Unmarshal(resp, start); err != nil {
+	if err := client.StartInstance(context.Background(), vm.zone(), &egoscale.Instance{ID: &vm.ID}); err != nil {
 		return fmt.Errorf("Starting virtual machine %q: %s", vm.ID, err)
 	}
 
-	vm.JobID = start.JobID
-
 	return nil
 }
 
@@ -0,0 +1,365 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package gcp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	googlecloud "google.golang.org/api/compute/v1"
+)
+
+// GroupTimeout is the maximum time to wait for the instances of a managed
+// instance group to be created or recreated. This is not thread-safe.
+var GroupTimeout = 10 * time.Minute
+
+// ErrGroupTimeout is returned when the instances of a managed instance group
+// are not created or recreated in time.
+var ErrGroupTimeout = errors.New("Timed out waiting for the instance group")
+
+// Group defines a GCE managed instance group, whose instances are created
+// from an instance template and recreated by GCE when they fail.
+type Group struct {
+	// Name is the name of the managed instance group. Its instance template
+	// is named Name-template.
+	Name string
+
+	// VM is the configuration of the instances of the group, whose names
+	// start with VM.Name. VM.Zone is the zone of a zonal group, and
+	// determines the region of a regional group. All the disks of VM are
+	// created for each instance, so additional disks are never reused.
+	VM *VM
+
+	// Regional spreads the instances over the zones of the region of
+	// VM.Zone, so the group survives the failure of a zone.
+	Regional bool
+
+	// TargetSize is the number of instances of the group.
+	TargetSize int
+}
+
+// templateName returns the name of the instance template of the group.
+func (g *Group) templateName() string {
+	return g.Name + "-template"
+}
+
+// Provision creates the instance template and the managed instance group, and
+// waits for the instances of the group to be created.
+func (g *Group) Provision() error {
+	s, err := g.VM.getService()
+	if err != nil {
+		return err
+	}
+
+	if g.VM.OSLogin {
+		if err := s.importOSLoginKey(g.VM.SSHPublicKey); err != nil {
+			return err
+		}
+	} else if g.VM.ProjectSSHKey {
+		if err := s.addProjectSSHKey(g.VM.SSHPublicKey); err != nil {
+			return err
+		}
+	}
+
+	template, err := g.instanceTemplate(s)
+	if err != nil {
+		return err
+	}
+
+	var op *googlecloud.Operation
+	if len(g.VM.NodeAffinities) > 0 {
+		var body map[string]interface{}
+		body, err = g.VM.withNodeAffinities(template, "properties", "scheduling")
+		if err != nil {
+			return err
+		}
+		op = &googlecloud.Operation{}
+		err = s.sendRequest("POST", fmt.Sprintf("projects/%s/global/instanceTemplates", g.VM.Project), body, op)
+	} else {
+		op, err = s.service.InstanceTemplates.Insert(g.VM.Project, template).Do()
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.waitForGlobalOperationReady(op.Name); err != nil {
+		return err
+	}
+
+	manager := &googlecloud.InstanceGroupManager{
+		Name:             g.Name,
+		Description:      g.VM.Description,
+		BaseInstanceName: g.VM.Name,
+		InstanceTemplate: template.SelfLink,
+		TargetSize:       int64(g.TargetSize),
+	}
+	if manager.InstanceTemplate == "" {
+		manager.InstanceTemplate = fmt.Sprintf("projects/%s/global/instanceTemplates/%s", g.VM.Project, template.Name)
+	}
+
+	if g.Regional {
+		op, err = s.service.RegionInstanceGroupManagers.Insert(g.VM.Project, g.VM.region(), manager).Do()
+	} else {
+		op, err = s.service.InstanceGroupManagers.Insert(g.VM.Project, g.VM.Zone, manager).Do()
+	}
+	if err != nil {
+		return err
+	}
+	if err := g.waitForOperation(s, op); err != nil {
+		return err
+	}
+
+	return g.waitForStable(s)
+}
+
+// instanceTemplate returns the instance template of the group, whose
+// properties are those of the instance of VM.
+func (g *Group) instanceTemplate(s *googleService) (*googlecloud.InstanceTemplate, error) {
+	instance, err := s.newInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	disks, err := g.templateDisks(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &googlecloud.InstanceTemplate{
+		Name:        g.templateName(),
+		Description: g.VM.Description,
+		Properties: &googlecloud.InstanceProperties{
+			CanIpForward:      instance.CanIpForward,
+			Description:       instance.Description,
+			Disks:             disks,
+			Labels:            instance.Labels,
+			MachineType:       g.VM.MachineType,
+			Metadata:          instance.Metadata,
+			NetworkInterfaces: instance.NetworkInterfaces,
+			Scheduling:        instance.Scheduling,
+			ServiceAccounts:   instance.ServiceAccounts,
+			Tags:              instance.Tags,
+		},
+	}, nil
+}
+
+// templateDisks returns the disks of the instance template, which are all
+// created with each instance.
+func (g *Group) templateDisks(s *googleService) ([]*googlecloud.AttachedDisk, error) {
+	if len(g.VM.Disks) == 0 {
+		return nil, errors.New("no disks were found")
+	}
+
+	image, err := s.getImage()
+	if err != nil {
+		return nil, err
+	}
+
+	var disks []*googlecloud.AttachedDisk
+	for i, disk := range g.VM.Disks {
+		d := &googlecloud.AttachedDisk{
+			Type:       "PERSISTENT",
+			Mode:       "READ_WRITE",
+			Boot:       i == 0,
+			AutoDelete: disk.AutoDelete,
+			InitializeParams: &googlecloud.AttachedDiskInitializeParams{
+				DiskSizeGb: int64(disk.DiskSizeGb),
+				DiskType:   disk.diskType(),
+			},
+		}
+		if i == 0 {
+			d.InitializeParams.SourceImage = image.SelfLink
+		} else if disk.Name != "" {
+			d.DeviceName = disk.Name
+		}
+		disks = append(disks, d)
+	}
+	return append(disks, g.VM.localSSDs("local-ssd")...), nil
+}
+
+// Scale sets the number of instances of the group, and waits for the
+// instances to be created or deleted.
+func (g *Group) Scale(n int) error {
+	s, err := g.VM.getService()
+	if err != nil {
+		return err
+	}
+
+	var op *googlecloud.Operation
+	if g.Regional {
+		op, err = s.service.RegionInstanceGroupManagers.Resize(g.VM.Project, g.VM.region(), g.Name, int64(n)).Do()
+	} else {
+		op, err = s.service.InstanceGroupManagers.Resize(g.VM.Project, g.VM.Zone, g.Name, int64(n)).Do()
+	}
+	if err != nil {
+		return err
+	}
+	if err := g.waitForOperation(s, op); err != nil {
+		return err
+	}
+
+	g.TargetSize = n
+	return g.waitForStable(s)
+}
+
+// Recreate recreates the instances of the group one at a time, waiting for
+// each instance to be recreated before the next one, so the group keeps
+// serving during the update.
+func (g *Group) Recreate() error {
+	s, err := g.VM.getService()
+	if err != nil {
+		return err
+	}
+
+	instances, err := g.managedInstances(s)
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		var op *googlecloud.Operation
+		if g.Regional {
+			op, err = s.service.RegionInstanceGroupManagers.RecreateInstances(g.VM.Project, g.VM.region(), g.Name,
+				&googlecloud.RegionInstanceGroupManagersRecreateRequest{Instances: []string{instance.Instance}}).Do()
+		} else {
+			op, err = s.service.InstanceGroupManagers.RecreateInstances(g.VM.Project, g.VM.Zone, g.Name,
+				&googlecloud.InstanceGroupManagersRecreateInstancesRequest{Instances: []string{instance.Instance}}).Do()
+		}
+		if err != nil {
+			return fmt.Errorf("error while recreating instance %s: %v", instance.Instance, err)
+		}
+		if err := g.waitForOperation(s, op); err != nil {
+			return err
+		}
+		if err := g.waitForStable(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetInstances returns the VMs of the instances of the group, with the name
+// and zone of each instance and the project, credentials and SSH settings of
+// VM, so they can be inspected and connected to.
+func (g *Group) GetInstances() ([]*VM, error) {
+	s, err := g.VM.getService()
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := g.managedInstances(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var vms []*VM
+	for _, instance := range instances {
+		// The instance URL ends with zones/ZONE/instances/NAME.
+		parts := strings.Split(instance.Instance, "/")
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("unexpected instance URL %s", instance.Instance)
+		}
+
+		vms = append(vms, &VM{
+			Name:        parts[len(parts)-1],
+			Zone:        parts[len(parts)-3],
+			Project:     g.VM.Project,
+			Scopes:      g.VM.Scopes,
+			AccountFile: g.VM.AccountFile,
+			SSHCreds: ssh.Credentials{
+				SSHUser:        g.VM.SSHCreds.SSHUser,
+				SSHPassword:    g.VM.SSHCreds.SSHPassword,
+				SSHPrivateKey:  g.VM.SSHCreds.SSHPrivateKey,
+				SSHCertificate: g.VM.SSHCreds.SSHCertificate,
+			},
+			SSHPublicKey:  g.VM.SSHPublicKey,
+			OSLogin:       g.VM.OSLogin,
+			ProjectSSHKey: g.VM.ProjectSSHKey,
+		})
+	}
+	return vms, nil
+}
+
+// Destroy deletes the managed instance group with its instances, and its
+// instance template.
+func (g *Group) Destroy() error {
+	s, err := g.VM.getService()
+	if err != nil {
+		return err
+	}
+
+	var op *googlecloud.Operation
+	if g.Regional {
+		op, err = s.service.RegionInstanceGroupManagers.Delete(g.VM.Project, g.VM.region(), g.Name).Do()
+	} else {
+		op, err = s.service.InstanceGroupManagers.Delete(g.VM.Project, g.VM.Zone, g.Name).Do()
+	}
+	if err != nil {
+		return err
+	}
+	if err := g.waitForOperation(s, op); err != nil {
+		return err
+	}
+
+	op, err = s.service.InstanceTemplates.Delete(g.VM.Project, g.templateName()).Do()
+	if err != nil {
+		return err
+	}
+	return s.waitForGlobalOperationReady(op.Name)
+}
+
+// managedInstances returns the instances of the group.
+func (g *Group) managedInstances(s *googleService) ([]*googlecloud.ManagedInstance, error) {
+	if g.Regional {
+		resp, err := s.service.RegionInstanceGroupManagers.ListManagedInstances(g.VM.Project, g.VM.region(), g.Name).Do()
+		if err != nil {
+			return nil, err
+		}
+		return resp.ManagedInstances, nil
+	}
+
+	resp, err := s.service.InstanceGroupManagers.ListManagedInstances(g.VM.Project, g.VM.Zone, g.Name).Do()
+	if err != nil {
+		return nil, err
+	}
+	return resp.ManagedInstances, nil
+}
+
+// waitForOperation waits for the zonal or regional operation of the group to
+// finish.
+func (g *Group) waitForOperation(s *googleService, op *googlecloud.Operation) error {
+	if g.Regional {
+		return s.waitForRegionOperationReady(op.Name)
+	}
+	return s.waitForOperationReady(op.Name)
+}
+
+// waitForStable waits until no instance of the group is being created,
+// recreated or deleted.
+func (g *Group) waitForStable(s *googleService) error {
+	start := time.Now()
+	for {
+		var manager *googlecloud.InstanceGroupManager
+		var err error
+		if g.Regional {
+			manager, err = s.service.RegionInstanceGroupManagers.Get(g.VM.Project, g.VM.region(), g.Name).Do()
+		} else {
+			manager, err = s.service.InstanceGroupManagers.Get(g.VM.Project, g.VM.Zone, g.Name).Do()
+		}
+		if err != nil {
+			return err
+		}
+
+		if a := manager.CurrentActions; a == nil || a.Abandoning+a.Creating+a.CreatingWithoutRetries+
+			a.Deleting+a.Recreating+a.Refreshing+a.Restarting == 0 {
+			return nil
+		}
+
+		if time.Since(start) >= GroupTimeout {
+			return ErrGroupTimeout
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
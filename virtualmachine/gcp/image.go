@@ -0,0 +1,77 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package gcp
+
+import (
+	"fmt"
+
+	googlecloud "google.golang.org/api/compute/v1"
+)
+
+// ImageOperationTimeout represents Maximum time(Second) to wait for a machine
+// image or snapshot to be created.
+const ImageOperationTimeout = 1800
+
+// CreateMachineImage creates a machine image with the given name from the
+// instance, which captures all of its disks and configuration, so baked
+// instances can be created from it. It returns the URL of the machine image.
+func (vm *VM) CreateMachineImage(name string) (string, error) {
+	s, err := vm.getService()
+	if err != nil {
+		return "", err
+	}
+
+	return s.createMachineImage(name)
+}
+
+// CreateDiskSnapshot creates a snapshot with the given name of the disk of
+// the instance with the given name. It returns the URL of the snapshot.
+func (vm *VM) CreateDiskSnapshot(disk string, name string) (string, error) {
+	s, err := vm.getService()
+	if err != nil {
+		return "", err
+	}
+
+	return s.createDiskSnapshot(disk, name)
+}
+
+// createMachineImage creates a machine image of the instance.
+func (svc *googleService) createMachineImage(name string) (string, error) {
+	body := map[string]string{
+		"name":           name,
+		"sourceInstance": fmt.Sprintf("projects/%s/zones/%s/instances/%s", svc.vm.Project, svc.vm.Zone, svc.vm.Name),
+	}
+
+	op := &googlecloud.Operation{}
+	if err := svc.sendRequest("POST", fmt.Sprintf("projects/%s/global/machineImages", svc.vm.Project), body, op); err != nil {
+		return "", fmt.Errorf("error while creating machine image %s: %v", name, err)
+	}
+
+	err := waitForOperation(ImageOperationTimeout, func() (*googlecloud.Operation, error) {
+		return svc.service.GlobalOperations.Get(svc.vm.Project, op.Name).Do()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return op.TargetLink, nil
+}
+
+// createDiskSnapshot creates a snapshot of the disk.
+func (svc *googleService) createDiskSnapshot(disk string, name string) (string, error) {
+	op, err := svc.service.Disks.CreateSnapshot(svc.vm.Project, svc.vm.Zone, disk, &googlecloud.Snapshot{
+		Name: name,
+	}).Do()
+	if err != nil {
+		return "", fmt.Errorf("error while creating snapshot %s of disk %s: %v", name, disk, err)
+	}
+
+	err = waitForOperation(ImageOperationTimeout, func() (*googlecloud.Operation, error) {
+		return svc.service.ZoneOperations.Get(svc.vm.Project, svc.vm.Zone, op.Name).Do()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("projects/%s/global/snapshots/%s", svc.vm.Project, name), nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package gcp
+
+import (
+	"sort"
+
+	googlecloud "google.golang.org/api/compute/v1"
+)
+
+const (
+	// startupScriptMetadataKey is the metadata key of the script run by the
+	// guest environment each time the instance boots.
+	startupScriptMetadataKey = "startup-script"
+	// shutdownScriptMetadataKey is the metadata key of the script run by the
+	// guest environment when the instance stops.
+	shutdownScriptMetadataKey = "shutdown-script"
+)
+
+// setMetadataItem sets the metadata item with the key to the value, adding
+// it if it does not exist.
+func setMetadataItem(md *googlecloud.Metadata, key string, value string) {
+	for _, item := range md.Items {
+		if item.Key == key {
+			item.Value = &value
+			return
+		}
+	}
+	md.Items = append(md.Items, &googlecloud.MetadataItems{Key: key, Value: &value})
+}
+
+// deleteMetadataItem deletes the metadata item with the key, if it exists.
+func deleteMetadataItem(md *googlecloud.Metadata, key string) {
+	for i, item := range md.Items {
+		if item.Key == key {
+			md.Items = append(md.Items[:i], md.Items[i+1:]...)
+			return
+		}
+	}
+}
+
+// setMetadataItems sets the metadata items in sorted key order, deleting
+// those whose value is empty.
+func setMetadataItems(md *googlecloud.Metadata, items map[string]string) {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if items[key] == "" {
+			deleteMetadataItem(md, key)
+		} else {
+			setMetadataItem(md, key, items[key])
+		}
+	}
+}
+
+// metadata returns the metadata of a new instance, with the SSH key of the
+// VM unless it is granted access through OS Login or the project metadata.
+func (svc *googleService) metadata() *googlecloud.Metadata {
+	md := &googlecloud.Metadata{}
+	setMetadataItems(md, svc.vm.Metadata)
+	if svc.vm.StartupScript != "" {
+		setMetadataItem(md, startupScriptMetadataKey, svc.vm.StartupScript)
+	}
+	if svc.vm.ShutdownScript != "" {
+		setMetadataItem(md, shutdownScriptMetadataKey, svc.vm.ShutdownScript)
+	}
+
+	switch {
+	case svc.vm.OSLogin:
+		setMetadataItem(md, osLoginMetadataKey, "TRUE")
+	case !svc.vm.ProjectSSHKey && svc.vm.SSHPublicKey != "":
+		addSSHKey(md, svc.sshKeyLine(svc.vm.SSHPublicKey))
+	}
+	if svc.vm.BlockProjectSSHKeys {
+		setMetadataItem(md, blockProjectSSHKeysMetadataKey, "TRUE")
+	}
+	return md
+}
+
+// GetMetadata returns the metadata of the instance.
+func (vm *VM) GetMetadata() (map[string]string, error) {
+	s, err := vm.getService()
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := s.getInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]string)
+	if instance.Metadata != nil {
+		for _, item := range instance.Metadata.Items {
+			if item.Value != nil {
+				items[item.Key] = *item.Value
+			}
+		}
+	}
+	return items, nil
+}
+
+// SetMetadata sets the metadata items of the instance, such as
+// "startup-script", keeping the other items. Items with an empty value are
+// deleted. Scripts set after boot run on the next boot or shutdown.
+func (vm *VM) SetMetadata(items map[string]string) error {
+	s, err := vm.getService()
+	if err != nil {
+		return err
+	}
+
+	instance, err := s.getInstance()
+	if err != nil {
+		return err
+	}
+
+	md := instance.Metadata
+	if md == nil {
+		md = &googlecloud.Metadata{}
+	}
+	setMetadataItems(md, items)
+
+	op, err := s.service.Instances.SetMetadata(vm.Project, vm.Zone, vm.Name, md).Do()
+	if err != nil {
+		return err
+	}
+
+	return s.waitForOperationReady(op.Name)
+}
@@ -0,0 +1,209 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package gcp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	googlecloud "google.golang.org/api/compute/v1"
+)
+
+// isSelfLink returns whether the network or subnetwork is given by URL, such
+// as projects/PROJECT/global/networks/NAME, rather than by name.
+func isSelfLink(s string) bool {
+	return strings.Contains(s, "/")
+}
+
+// networkInterface returns the network interface of a new instance. The
+// network and subnetwork are looked up in the project of the VM when given by
+// name, and used as they are when given by URL, such as those of a Shared VPC
+// host project.
+func (svc *googleService) networkInterface() (*googlecloud.NetworkInterface, error) {
+	networkName := svc.vm.Network
+	if networkName == "" {
+		networkName = "default"
+	}
+
+	nic := &googlecloud.NetworkInterface{
+		Network:    networkName,
+		Subnetwork: svc.vm.Subnetwork,
+		NetworkIP:  svc.vm.PrivateIPAddress,
+	}
+
+	if !isSelfLink(networkName) {
+		network, err := svc.service.Networks.Get(svc.vm.Project, networkName).Do()
+		if err != nil {
+			return nil, err
+		}
+
+		// validate network
+		if !network.AutoCreateSubnetworks && len(network.Subnetworks) > 0 {
+			// Network appears to be in "custom" mode, so a subnetwork is required
+			// libretto doesn't handle the network creation
+			if svc.vm.Subnetwork == "" {
+				return nil, fmt.Errorf("a subnetwork must be specified")
+			}
+		}
+		nic.Network = network.SelfLink
+	}
+
+	if svc.vm.Subnetwork != "" && !isSelfLink(svc.vm.Subnetwork) {
+		subnetwork, err := svc.service.Subnetworks.Get(svc.vm.Project, svc.vm.region(), svc.vm.Subnetwork).Do()
+		if err != nil {
+			return nil, err
+		}
+		nic.Subnetwork = subnetwork.SelfLink
+	}
+
+	if !svc.vm.NoExternalIP {
+		nic.AccessConfigs = []*googlecloud.AccessConfig{
+			{
+				Name: "External NAT for Libretto",
+				Type: "ONE_TO_ONE_NAT",
+			},
+		}
+	}
+
+	return nic, nil
+}
+
+// iapTunnelClient is an SSH client connected through an Identity-Aware Proxy
+// TCP forwarding tunnel, which is closed on Disconnect.
+type iapTunnelClient struct {
+	*ssh.SSHClient
+	tunnel *exec.Cmd
+	// cleanup removes the temporary credential file of the tunnel.
+	cleanup func()
+}
+
+// Disconnect closes the SSH connection and the tunnel.
+func (c *iapTunnelClient) Disconnect() {
+	c.SSHClient.Disconnect()
+	if c.tunnel.Process != nil {
+		c.tunnel.Process.Kill()
+		c.tunnel.Wait()
+	}
+	c.cleanup()
+}
+
+// getIAPTunnelClient starts an IAP tunnel from a local port, chosen by gcloud,
+// to port 22 of the instance and returns an SSH client connected through it.
+// It requires the gcloud CLI, and a firewall rule allowing SSH from the IAP
+// range 35.235.240.0/20. gcloud authenticates with AccountFile if it is set.
+func (vm *VM) getIAPTunnelClient(options ssh.Options) (ssh.Client, error) {
+	credentialFile, cleanup, err := vm.credentialFile()
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel := exec.Command("gcloud", "compute", "start-iap-tunnel", vm.Name, "22",
+		"--local-host-port=localhost:0",
+		"--zone", vm.Zone, "--project", vm.Project)
+	if credentialFile != "" {
+		tunnel.Env = append(os.Environ(), "CLOUDSDK_AUTH_CREDENTIAL_FILE_OVERRIDE="+credentialFile)
+	}
+	stderr, err := tunnel.StderrPipe()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := tunnel.Start(); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to start IAP tunnel: %v", err)
+	}
+
+	port, err := readTunnelPort(stderr, SSHTimeout)
+	if err != nil {
+		tunnel.Process.Kill()
+		tunnel.Wait()
+		cleanup()
+		return nil, err
+	}
+
+	client := &iapTunnelClient{
+		SSHClient: &ssh.SSHClient{
+			Creds:   &vm.SSHCreds,
+			IP:      net.IPv4(127, 0, 0, 1),
+			Options: options,
+			Port:    port,
+		},
+		tunnel:  tunnel,
+		cleanup: cleanup,
+	}
+	if err := client.WaitForSSH(SSHTimeout); err != nil {
+		client.Disconnect()
+		return nil, err
+	}
+	return client, nil
+}
+
+// credentialFile returns the path of the account file of the VM for gcloud,
+// or an empty path if it has none. An account file given as JSON is written to
+// a temporary file, which the returned function removes.
+func (vm *VM) credentialFile() (string, func(), error) {
+	noop := func() {}
+	if vm.AccountFile == "" {
+		return "", noop, nil
+	}
+	var account accountFile
+	if parseAccountJSON(&account, vm.AccountFile) != nil {
+		return vm.AccountFile, noop, nil
+	}
+
+	f, err := ioutil.TempFile("", "libretto-gcp-account")
+	if err != nil {
+		return "", noop, fmt.Errorf("failed to write the account file for gcloud: %v", err)
+	}
+	_, err = f.WriteString(vm.AccountFile)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", noop, fmt.Errorf("failed to write the account file for gcloud: %v", err)
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
+
+// tunnelPortRegexp matches the line of gcloud with the local port the tunnel
+// listens on.
+var tunnelPortRegexp = regexp.MustCompile(`Listening on port \[(\d+)\]`)
+
+// readTunnelPort reads the output of an IAP tunnel until the local port it
+// listens on is printed, and returns it. The rest of the output is discarded.
+func readTunnelPort(r io.Reader, timeout time.Duration) (int, error) {
+	ports := make(chan int, 1)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if m := tunnelPortRegexp.FindStringSubmatch(scanner.Text()); m != nil {
+				port, _ := strconv.Atoi(m[1])
+				ports <- port
+				break
+			}
+		}
+		close(ports)
+		io.Copy(ioutil.Discard, r)
+	}()
+
+	select {
+	case port, ok := <-ports:
+		if !ok {
+			return 0, fmt.Errorf("IAP tunnel ended before listening on a local port")
+		}
+		return port, nil
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("timed out waiting for the IAP tunnel to listen on a local port")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	googlecloud "google.golang.org/api/compute/v1"
+)
+
+const (
+	// OnHostMaintenanceMigrate live migrates the instance during host
+	// maintenance, the default of standard instances.
+	OnHostMaintenanceMigrate = "MIGRATE"
+	// OnHostMaintenanceTerminate stops the instance during host maintenance,
+	// which is required for preemptible instances and GPUs.
+	OnHostMaintenanceTerminate = "TERMINATE"
+
+	// NodeAffinityIn requires the node label to have one of the values.
+	NodeAffinityIn = "IN"
+	// NodeAffinityNotIn requires the node label to have none of the values.
+	NodeAffinityNotIn = "NOT_IN"
+
+	// NodeGroupAffinityKey is the node affinity key of the name of the
+	// sole-tenant node group.
+	NodeGroupAffinityKey = "compute.googleapis.com/node-group-name"
+	// NodeAffinityKey is the node affinity key of the name of the
+	// sole-tenant node.
+	NodeAffinityKey = "compute.googleapis.com/node-name"
+)
+
+// NodeAffinity is a rule placing an instance on the sole-tenant nodes whose
+// label Key matches the Values according to the Operator.
+type NodeAffinity struct {
+	Key      string
+	Operator string // NodeAffinityIn or NodeAffinityNotIn
+	Values   []string
+}
+
+// scheduling returns the scheduling options of a new instance. Preemptible
+// instances are terminated on host maintenance and never restarted.
+func (vm *VM) scheduling() *googlecloud.Scheduling {
+	s := &googlecloud.Scheduling{
+		Preemptible:       vm.Preemptible,
+		OnHostMaintenance: vm.OnHostMaintenance,
+		AutomaticRestart:  vm.AutomaticRestart,
+	}
+	if vm.Preemptible {
+		restart := false
+		s.OnHostMaintenance = OnHostMaintenanceTerminate
+		s.AutomaticRestart = &restart
+	}
+	return s
+}
+
+// validateScheduling validates the scheduling options of the VM.
+func (vm *VM) validateScheduling() error {
+	switch vm.OnHostMaintenance {
+	case "", OnHostMaintenanceMigrate, OnHostMaintenanceTerminate:
+	default:
+		return fmt.Errorf("invalid on host maintenance policy %s", vm.OnHostMaintenance)
+	}
+
+	for _, affinity := range vm.NodeAffinities {
+		if affinity.Key == "" || len(affinity.Values) == 0 {
+			return fmt.Errorf("a node affinity must have a key and values")
+		}
+		if affinity.Operator != NodeAffinityIn && affinity.Operator != NodeAffinityNotIn {
+			return fmt.Errorf("invalid node affinity operator %s", affinity.Operator)
+		}
+	}
+	return nil
+}
+
+// withNodeAffinities returns the JSON object of v with the node affinities of
+// the VM added to its scheduling, at the given path of objects, as the
+// vendored API lacks them.
+func (vm *VM) withNodeAffinities(v interface{}, path ...string) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var root map[string]interface{}
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+
+	obj := root
+	for _, key := range path {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			obj[key] = child
+		}
+		obj = child
+	}
+
+	var affinities []map[string]interface{}
+	for _, affinity := range vm.NodeAffinities {
+		affinities = append(affinities, map[string]interface{}{
+			"key":      affinity.Key,
+			"operator": affinity.Operator,
+			"values":   affinity.Values,
+		})
+	}
+	obj["nodeAffinities"] = affinities
+
+	return root, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package gcp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	googlecloud "google.golang.org/api/compute/v1"
+	oslogin "google.golang.org/api/oslogin/v1alpha"
+)
+
+const (
+	// sshKeysMetadataKey is the metadata key of the SSH keys of the users.
+	sshKeysMetadataKey = "ssh-keys"
+	// osLoginMetadataKey is the metadata key which enables OS Login.
+	osLoginMetadataKey = "enable-oslogin"
+	// blockProjectSSHKeysMetadataKey is the metadata key which makes an
+	// instance ignore the SSH keys of the project metadata.
+	blockProjectSSHKeysMetadataKey = "block-project-ssh-keys"
+)
+
+// ErrNoOSLoginAccount is returned when OS Login is enabled without an account
+// file, whose service account the SSH key is imported for.
+var ErrNoOSLoginAccount = errors.New("OS Login requires an account file")
+
+// addSSHKey adds the SSH key line, of the form "user:key", to the SSH keys
+// of the metadata unless they contain it already. It returns whether the
+// metadata changed.
+func addSSHKey(md *googlecloud.Metadata, line string) bool {
+	var keys []string
+	for _, item := range md.Items {
+		if item.Key != sshKeysMetadataKey || item.Value == nil {
+			continue
+		}
+		for _, key := range strings.Split(*item.Value, "\n") {
+			if strings.TrimSpace(key) == line {
+				return false
+			}
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	keys = append(keys, line)
+	setMetadataItem(md, sshKeysMetadataKey, strings.Join(keys, "\n"))
+	return true
+}
+
+// sshKeyLine returns the SSH keys metadata line of the public key for the
+// SSH user of the VM.
+func (svc *googleService) sshKeyLine(publicKey string) string {
+	return fmt.Sprintf("%s:%s", svc.vm.SSHCreds.SSHUser, strings.TrimSpace(publicKey))
+}
+
+// addInstanceSSHKey adds the public key to the metadata of the instance.
+func (svc *googleService) addInstanceSSHKey(publicKey string) error {
+	instance, err := svc.getInstance()
+	if err != nil {
+		return err
+	}
+
+	md := instance.Metadata
+	if md == nil {
+		md = &googlecloud.Metadata{}
+	}
+	if !addSSHKey(md, svc.sshKeyLine(publicKey)) {
+		return nil
+	}
+
+	op, err := svc.service.Instances.SetMetadata(svc.vm.Project, svc.vm.Zone, svc.vm.Name, md).Do()
+	if err != nil {
+		return err
+	}
+
+	return svc.waitForOperationReady(op.Name)
+}
+
+// addProjectSSHKey adds the public key to the metadata of the project, which
+// grants access to all the instances which do not block project keys.
+func (svc *googleService) addProjectSSHKey(publicKey string) error {
+	project, err := svc.service.Projects.Get(svc.vm.Project).Do()
+	if err != nil {
+		return err
+	}
+
+	md := project.CommonInstanceMetadata
+	if md == nil {
+		md = &googlecloud.Metadata{}
+	}
+	if !addSSHKey(md, svc.sshKeyLine(publicKey)) {
+		return nil
+	}
+
+	op, err := svc.service.Projects.SetCommonInstanceMetadata(svc.vm.Project, md).Do()
+	if err != nil {
+		return err
+	}
+
+	return svc.waitForGlobalOperationReady(op.Name)
+}
+
+// importOSLoginKey imports the public key into the OS Login profile of the
+// service account of the account file, and sets the SSH user of the VM to
+// the POSIX username of the profile unless it is already set.
+func (svc *googleService) importOSLoginKey(publicKey string) error {
+	email := svc.vm.account.ClientEmail
+	if email == "" {
+		return ErrNoOSLoginAccount
+	}
+
+	s, err := oslogin.New(svc.client)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Users.ImportSshPublicKey("users/"+email, &oslogin.SshPublicKey{
+		Key: strings.TrimSpace(publicKey),
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("error while importing the SSH key to OS Login: %v", err)
+	}
+
+	if svc.vm.SSHCreds.SSHUser != "" {
+		return nil
+	}
+
+	if resp.LoginProfile != nil {
+		for _, account := range resp.LoginProfile.PosixAccounts {
+			if account.Primary && account.Username != "" {
+				svc.vm.SSHCreds.SSHUser = account.Username
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no POSIX account found in the OS Login profile of %s", email)
+}
+
+// insertSSHKey grants access to the instance with the public key, through OS
+// Login, the project metadata or the instance metadata.
+func (svc *googleService) insertSSHKey(publicKey string) error {
+	switch {
+	case svc.vm.OSLogin:
+		return svc.importOSLoginKey(publicKey)
+	case svc.vm.ProjectSSHKey:
+		return svc.addProjectSSHKey(publicKey)
+	default:
+		return svc.addInstanceSSHKey(publicKey)
+	}
+}
@@ -3,10 +3,12 @@
 package gcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -19,16 +21,22 @@ import (
 	"golang.org/x/oauth2/jwt"
 
 	googlecloud "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 var (
 	// OAuth token url.
 	tokenURL = "https://accounts.google.com/o/oauth2/token"
+
+	// computeURL is the base URL of the Compute Engine API, for the
+	// operations which the vendored API lacks.
+	computeURL = "https://compute.googleapis.com/compute/v1/"
 )
 
 type googleService struct {
 	vm      *VM
 	service *googlecloud.Service
+	client  *http.Client
 }
 
 // accountFile represents the structure of the account file JSON file.
@@ -69,7 +77,43 @@ func (vm *VM) getService() (*googleService, error) {
 		return nil, err
 	}
 
-	return &googleService{vm, svc}, nil
+	return &googleService{vm, svc, client}, nil
+}
+
+// sendRequest sends a request of the given method to the path of the Compute
+// Engine API. The request body is the JSON encoding of in, if not nil, and
+// the JSON response is decoded into out, if not nil.
+func (svc *googleService) sendRequest(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, computeURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := svc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
 }
 
 // get instance from current VM definition.
@@ -107,6 +151,21 @@ func (svc *googleService) waitForOperationReady(operation string) error {
 	})
 }
 
+// waitForRegionOperationReady waits for the operation of the region of the
+// zone of the VM to finish.
+func (svc *googleService) waitForRegionOperationReady(operation string) error {
+	return waitForOperation(OperationTimeout, func() (*googlecloud.Operation, error) {
+		return svc.service.RegionOperations.Get(svc.vm.Project, svc.vm.region(), operation).Do()
+	})
+}
+
+// waitForGlobalOperationReady waits for the global operation to finish.
+func (svc *googleService) waitForGlobalOperationReady(operation string) error {
+	return waitForOperation(OperationTimeout, func() (*googlecloud.Operation, error) {
+		return svc.service.GlobalOperations.Get(svc.vm.Project, operation).Do()
+	})
+}
+
 func (svc *googleService) getImage() (*googlecloud.Image, error) {
 	for _, img := range svc.vm.ImageProjects {
 		image, err := svc.service.Images.Get(img, svc.vm.SourceImage).Do()
@@ -132,54 +191,127 @@ func (svc *googleService) createDisks() (disks []*googlecloud.AttachedDisk, err
 	}
 
 	for i, disk := range svc.vm.Disks {
+		name := svc.vm.diskName(i)
 		if i == 0 {
 			// First one is booted device, it will created in VM provision stage
 			disks = append(disks, &googlecloud.AttachedDisk{
+				DeviceName: disk.Name,
 				Type:       "PERSISTENT",
 				Mode:       "READ_WRITE",
 				Kind:       "compute#attachedDisk",
 				Boot:       true,
 				AutoDelete: disk.AutoDelete,
 				InitializeParams: &googlecloud.AttachedDiskInitializeParams{
+					DiskName:    disk.Name,
 					SourceImage: image.SelfLink,
 					DiskSizeGb:  int64(disk.DiskSizeGb),
-					DiskType:    fmt.Sprintf("zones/%s/diskTypes/%s", svc.vm.Zone, disk.DiskType),
+					DiskType:    svc.diskTypeURL(disk.diskType()),
 				},
 			})
 			continue
 		}
 
 		// Reuse the existing disk, create non-booted devices if it does not exist
-		searchDisk, _ := svc.getDisk(disk.Name)
+		searchDisk, _ := svc.getDisk(name)
 		if searchDisk == nil {
 			d := &googlecloud.Disk{
-				Name:   disk.Name,
+				Name:   name,
 				SizeGb: int64(disk.DiskSizeGb),
-				Type:   fmt.Sprintf("zones/%s/diskTypes/%s", svc.vm.Zone, disk.DiskType),
+				Type:   svc.diskTypeURL(disk.diskType()),
 			}
 
 			op, err := svc.service.Disks.Insert(svc.vm.Project, svc.vm.Zone, d).Do()
 			if err != nil {
-				return disks, fmt.Errorf("error while creating disk %s: %v", disk.Name, err)
+				return disks, fmt.Errorf("error while creating disk %s: %v", name, err)
 			}
 
 			err = svc.waitForOperationReady(op.Name)
 			if err != nil {
-				return disks, fmt.Errorf("error while waiting for the disk %s ready, error: %v", disk.Name, err)
+				return disks, fmt.Errorf("error while waiting for the disk %s ready, error: %v", name, err)
 			}
 		}
 
 		disks = append(disks, &googlecloud.AttachedDisk{
-			DeviceName: disk.Name,
+			DeviceName: name,
 			Type:       "PERSISTENT",
 			Mode:       "READ_WRITE",
 			Boot:       false,
 			AutoDelete: disk.AutoDelete,
-			Source:     fmt.Sprintf("projects/%s/zones/%s/disks/%s", svc.vm.Project, svc.vm.Zone, disk.Name),
+			Source:     fmt.Sprintf("projects/%s/zones/%s/disks/%s", svc.vm.Project, svc.vm.Zone, name),
+		})
+	}
+
+	return append(disks, svc.vm.localSSDs(svc.diskTypeURL("local-ssd"))...), nil
+}
+
+// diskType returns the type of the disk.
+func (disk Disk) diskType() string {
+	if disk.DiskType == "" {
+		return DiskTypeStandard
+	}
+	return disk.DiskType
+}
+
+// diskName returns the name of the disk of the VM at the index.
+func (vm *VM) diskName(i int) string {
+	switch {
+	case vm.Disks[i].Name != "":
+		return vm.Disks[i].Name
+	case i == 0:
+		return vm.Name
+	default:
+		return fmt.Sprintf("%s-disk-%d", vm.Name, i)
+	}
+}
+
+// diskTypeURL returns the partial URL of the disk type in the zone of the VM.
+func (svc *googleService) diskTypeURL(diskType string) string {
+	return fmt.Sprintf("zones/%s/diskTypes/%s", svc.vm.Zone, diskType)
+}
+
+// localSSDs returns the local SSDs of the VM, of the given disk type.
+func (vm *VM) localSSDs(diskType string) []*googlecloud.AttachedDisk {
+	iface := vm.LocalSSDInterface
+	if iface == "" {
+		iface = LocalSSDInterfaceNVMe
+	}
+
+	var disks []*googlecloud.AttachedDisk
+	for i := 0; i < vm.LocalSSDs; i++ {
+		disks = append(disks, &googlecloud.AttachedDisk{
+			Type:       "SCRATCH",
+			Mode:       "READ_WRITE",
+			Interface:  iface,
+			AutoDelete: true,
+			InitializeParams: &googlecloud.AttachedDiskInitializeParams{
+				DiskType: diskType,
+			},
 		})
 	}
+	return disks
+}
+
+// setDiskAutoDelete sets whether the disk with the name is deleted with the
+// instance.
+func (svc *googleService) setDiskAutoDelete(name string, autoDelete bool) error {
+	instance, err := svc.getInstance()
+	if err != nil {
+		return err
+	}
+
+	for _, disk := range instance.Disks {
+		if !strings.HasSuffix(disk.Source, "/disks/"+name) {
+			continue
+		}
+
+		op, err := svc.service.Instances.SetDiskAutoDelete(svc.vm.Project, svc.vm.Zone, svc.vm.Name, autoDelete, disk.DeviceName).Do()
+		if err != nil {
+			return err
+		}
+		return svc.waitForOperationReady(op.Name)
+	}
 
-	return disks, nil
+	return fmt.Errorf("no disk %s attached to instance %s", name, svc.vm.Name)
 }
 
 // getDisk retrieves the Disk object.
@@ -199,8 +331,8 @@ func (svc *googleService) deleteDisk(name string) error {
 
 // deleteDisks deletes all the persistent disk.
 func (svc *googleService) deleteDisks() (errs []error) {
-	for _, disk := range svc.vm.Disks {
-		err := svc.deleteDisk(disk.Name)
+	for i := range svc.vm.Disks {
+		err := svc.deleteDisk(svc.vm.diskName(i))
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -208,7 +340,8 @@ func (svc *googleService) deleteDisks() (errs []error) {
 	return errs
 }
 
-// getIPs returns the IP addresses of the GCE instance.
+// getIPs returns the IP addresses of the GCE instance. The public IP is nil
+// if the instance has no external IP.
 func (svc *googleService) getIPs() ([]net.IP, error) {
 	instance, err := svc.service.Instances.Get(svc.vm.Project, svc.vm.Zone, svc.vm.Name).Do()
 	if err != nil {
@@ -218,8 +351,11 @@ func (svc *googleService) getIPs() ([]net.IP, error) {
 	ips := make([]net.IP, 2)
 	nic := instance.NetworkInterfaces[0]
 
-	publicIP := nic.AccessConfigs[0].NatIP
-	if publicIP == "" {
+	publicIP := ""
+	if len(nic.AccessConfigs) > 0 {
+		publicIP = nic.AccessConfigs[0].NatIP
+	}
+	if publicIP == "" && !svc.vm.NoExternalIP {
 		return nil, errors.New("error while retrieving public IP")
 	}
 
@@ -236,99 +372,83 @@ func (svc *googleService) getIPs() ([]net.IP, error) {
 
 // provision a new googlecloud VM instance.
 func (svc *googleService) provision() error {
-	zone, err := svc.service.Zones.Get(svc.vm.Project, svc.vm.Zone).Do()
-	if err != nil {
-		return err
+	if svc.vm.OSLogin {
+		if err := svc.importOSLoginKey(svc.vm.SSHPublicKey); err != nil {
+			return err
+		}
+	} else if svc.vm.ProjectSSHKey {
+		if err := svc.addProjectSSHKey(svc.vm.SSHPublicKey); err != nil {
+			return err
+		}
 	}
 
-	machineType, err := svc.service.MachineTypes.Get(svc.vm.Project, zone.Name, svc.vm.MachineType).Do()
+	instance, err := svc.newInstance()
 	if err != nil {
 		return err
 	}
 
-	network, err := svc.service.Networks.Get(svc.vm.Project, svc.vm.Network).Do()
+	instance.Disks, err = svc.createDisks()
 	if err != nil {
 		return err
 	}
 
-	// validate network
-	if !network.AutoCreateSubnetworks && len(network.Subnetworks) > 0 {
-		// Network appears to be in "custom" mode, so a subnetwork is required
-		// libretto doesn't handle the network creation
-		if svc.vm.Subnetwork == "" {
-			return fmt.Errorf("a subnetwork must be specified")
-		}
-	}
-
-	subnetworkSelfLink := ""
-	if svc.vm.Subnetwork != "" {
-		subnetwork, err := svc.service.Subnetworks.Get(svc.vm.Project, svc.vm.region(), svc.vm.Subnetwork).Do()
+	var op *googlecloud.Operation
+	if len(svc.vm.NodeAffinities) > 0 {
+		var body map[string]interface{}
+		body, err = svc.vm.withNodeAffinities(instance, "scheduling")
 		if err != nil {
 			return err
 		}
-		subnetworkSelfLink = subnetwork.SelfLink
+		op = &googlecloud.Operation{}
+		err = svc.sendRequest("POST", fmt.Sprintf("projects/%s/zones/%s/instances", svc.vm.Project, svc.vm.Zone), body, op)
+	} else {
+		op, err = svc.service.Instances.Insert(svc.vm.Project, svc.vm.Zone, instance).Do()
+	}
+	if err != nil {
+		return err
 	}
 
-	accessconfig := googlecloud.AccessConfig{
-		Name: "External NAT for Libretto",
-		Type: "ONE_TO_ONE_NAT",
+	if err = svc.waitForOperationReady(op.Name); err != nil {
+		return err
 	}
 
-	md := svc.getSSHKey()
+	_, err = svc.getInstance()
+	return err
+}
 
-	disks, err := svc.createDisks()
-	if err != nil {
-		return err
+// newInstance returns the instance of the VM to insert, without its disks.
+func (svc *googleService) newInstance() (*googlecloud.Instance, error) {
+	if err := svc.vm.validateScheduling(); err != nil {
+		return nil, err
 	}
 
-	instance := &googlecloud.Instance{
-		Name:        svc.vm.Name,
-		Description: svc.vm.Description,
-		Disks:       disks,
-		MachineType: machineType.SelfLink,
-		Metadata: &googlecloud.Metadata{
-			Items: []*googlecloud.MetadataItems{
-				{
-					Key:   "sshKeys",
-					Value: &md,
-				},
-			},
-		},
-		NetworkInterfaces: []*googlecloud.NetworkInterface{
-			{
-				AccessConfigs: []*googlecloud.AccessConfig{
-					&accessconfig,
-				},
-				Network:    network.SelfLink,
-				Subnetwork: subnetworkSelfLink,
-				NetworkIP:  svc.vm.PrivateIPAddress,
-			},
-		},
-		Scheduling: &googlecloud.Scheduling{
-			Preemptible: svc.vm.Preemptible,
-		},
-		ServiceAccounts: []*googlecloud.ServiceAccount{
-			{
-				Email:  "default",
-				Scopes: svc.vm.Scopes,
-			},
-		},
-		Tags: &googlecloud.Tags{
-			Items: svc.vm.Tags,
-		},
+	zone, err := svc.service.Zones.Get(svc.vm.Project, svc.vm.Zone).Do()
+	if err != nil {
+		return nil, err
 	}
 
-	op, err := svc.service.Instances.Insert(svc.vm.Project, zone.Name, instance).Do()
+	machineType, err := svc.service.MachineTypes.Get(svc.vm.Project, zone.Name, svc.vm.MachineType).Do()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err = svc.waitForOperationReady(op.Name); err != nil {
-		return err
+	nic, err := svc.networkInterface()
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = svc.getInstance()
-	return err
+	return &googlecloud.Instance{
+		Name:              svc.vm.Name,
+		Description:       svc.vm.Description,
+		MachineType:       machineType.SelfLink,
+		Metadata:          svc.metadata(),
+		NetworkInterfaces: []*googlecloud.NetworkInterface{nic},
+		Scheduling:        svc.vm.scheduling(),
+		ServiceAccounts:   svc.serviceAccounts(),
+		Tags: &googlecloud.Tags{
+			Items: svc.vm.Tags,
+		},
+	}, nil
 }
 
 // start starts a stopped GCE instance.
@@ -370,6 +490,40 @@ func (svc *googleService) stop() error {
 	return svc.waitForOperationReady(op.Name)
 }
 
+// suspend suspends a running GCE instance.
+func (svc *googleService) suspend() error {
+	return svc.instanceAction("suspend")
+}
+
+// resume resumes a suspended GCE instance.
+func (svc *googleService) resume() error {
+	return svc.instanceAction("resume")
+}
+
+// instanceAction runs the action, such as suspend, on the GCE instance and
+// waits for it to finish.
+func (svc *googleService) instanceAction(action string) error {
+	op := &googlecloud.Operation{}
+	path := fmt.Sprintf("projects/%s/zones/%s/instances/%s/%s", svc.vm.Project, svc.vm.Zone, svc.vm.Name, action)
+	if err := svc.sendRequest("POST", path, nil, op); err != nil {
+		return err
+	}
+
+	return svc.waitForOperationReady(op.Name)
+}
+
+// setMachineType changes the machine type of a stopped GCE instance.
+func (svc *googleService) setMachineType(machineType string) error {
+	op, err := svc.service.Instances.SetMachineType(svc.vm.Project, svc.vm.Zone, svc.vm.Name, &googlecloud.InstancesSetMachineTypeRequest{
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", svc.vm.Zone, machineType),
+	}).Do()
+	if err != nil {
+		return err
+	}
+
+	return svc.waitForOperationReady(op.Name)
+}
+
 // deletes the GCE instance.
 func (svc *googleService) delete() error {
 	op, err := svc.service.Instances.Delete(svc.vm.Project, svc.vm.Zone, svc.vm.Name).Do()
@@ -410,29 +564,25 @@ func parseAccountFile(file *accountFile, account string) error {
 	return nil
 }
 
-func (svc *googleService) getSSHKey() string {
-	return fmt.Sprintf("%s:%s\n", svc.vm.SSHCreds.SSHUser, svc.vm.SSHPublicKey)
-}
+// serviceAccounts returns the service accounts attached to a new instance.
+func (svc *googleService) serviceAccounts() []*googlecloud.ServiceAccount {
+	if svc.vm.NoServiceAccount {
+		return nil
+	}
 
-func (svc *googleService) insertSSHKey() error {
-	md := svc.getSSHKey()
-	instance, err := svc.getInstance()
-	if err != nil {
-		return err
+	email := svc.vm.ServiceAccount
+	if email == "" {
+		email = "default"
+	}
+	scopes := svc.vm.ServiceAccountScopes
+	if len(scopes) == 0 {
+		scopes = svc.vm.Scopes
 	}
 
-	op, err := svc.service.Instances.SetMetadata(svc.vm.Project, svc.vm.Zone, svc.vm.Name, &googlecloud.Metadata{
-		Fingerprint: instance.Metadata.Fingerprint,
-		Items: []*googlecloud.MetadataItems{
-			{
-				Key:   "sshKeys",
-				Value: &md,
-			},
+	return []*googlecloud.ServiceAccount{
+		{
+			Email:  email,
+			Scopes: scopes,
 		},
-	}).Do()
-	if err != nil {
-		return err
 	}
-
-	return svc.waitForOperationReady(op.Name)
 }
@@ -4,6 +4,7 @@ package gcp
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"time"
 
@@ -23,6 +24,23 @@ const (
 	OperationTimeout = 180
 )
 
+const (
+	// DiskTypeStandard is the type of standard persistent disks, the default.
+	DiskTypeStandard = "pd-standard"
+	// DiskTypeBalanced is the type of balanced persistent disks.
+	DiskTypeBalanced = "pd-balanced"
+	// DiskTypeSSD is the type of SSD persistent disks.
+	DiskTypeSSD = "pd-ssd"
+	// DiskTypeExtreme is the type of extreme persistent disks, which are
+	// only available on some machine types.
+	DiskTypeExtreme = "pd-extreme"
+
+	// LocalSSDInterfaceNVMe is the NVMe interface of local SSDs, the default.
+	LocalSSDInterfaceNVMe = "NVME"
+	// LocalSSDInterfaceSCSI is the SCSI interface of local SSDs.
+	LocalSSDInterfaceSCSI = "SCSI"
+)
+
 // SSHTimeout is the maximum time to wait before failing to GetSSH. This is not
 // thread-safe.
 var SSHTimeout = 3 * time.Minute
@@ -30,6 +48,9 @@ var SSHTimeout = 3 * time.Minute
 var (
 	// Compiler will complain if google.VM doesn't implement VirtualMachine interface.
 	_ virtualmachine.VirtualMachine = (*VM)(nil)
+
+	// Compiler will complain if google.VM doesn't implement DashboardLinker interface.
+	_ virtualmachine.DashboardLinker = (*VM)(nil)
 )
 
 // VM defines a GCE virtual machine.
@@ -40,19 +61,76 @@ type VM struct {
 	MachineType string
 	Preemptible bool // Preemptible instances will be terminates after they run for 24 hours.
 
+	// OnHostMaintenance [optional] is what happens to the instance during
+	// host maintenance, see the OnHostMaintenance constants. AutomaticRestart
+	// [optional] restarts the instance when Compute Engine terminates it,
+	// true if nil.
+	OnHostMaintenance string
+	AutomaticRestart  *bool
+
+	// NodeAffinities [optional] place the instance on sole-tenant nodes, such
+	// as those of the node group named by NodeGroupAffinityKey.
+	NodeAffinities []NodeAffinity
+
 	SourceImage   string   //Required
 	ImageProjects []string //Required
 
 	Disks []Disk // At least one disk is required, the first one is booted device
 
+	// LocalSSDs [optional] is the number of 375 GB local SSDs attached to the
+	// instance, whose data is lost when the instance stops. LocalSSDInterface
+	// [optional] is their interface, NVMe if empty.
+	LocalSSDs         int
+	LocalSSDInterface string
+
+	// Network and Subnetwork are names in Project or URLs, such as
+	// projects/HOST/regions/REGION/subnetworks/NAME for a Shared VPC. The
+	// network is "default" if empty.
 	Network          string
 	Subnetwork       string
-	UseInternalIP    bool
+	UseInternalIP    bool // Connect SSH to the private IP
 	PrivateIPAddress string
 
+	// NoExternalIP creates the instance without an external IP. SSH then
+	// connects to the private IP, or through IAP if IAPTunnel is set.
+	NoExternalIP bool
+
+	// IAPTunnel connects SSH through an Identity-Aware Proxy tunnel started
+	// with the gcloud CLI, which reaches instances without external IP.
+	IAPTunnel bool
+
 	Scopes  []string //Access scopes
 	Project string   //GCE project
-	Tags    []string //Instance Tags
+	Tags    []string //Network tags, which firewall rules apply to
+
+	// ServiceAccount [optional] is the email of the service account attached
+	// to the instance, the Compute Engine default service account if empty.
+	// ServiceAccountScopes [optional] are its access scopes, Scopes if empty.
+	// NoServiceAccount creates the instance without a service account.
+	ServiceAccount       string
+	ServiceAccountScopes []string
+	NoServiceAccount     bool
+
+	// OSLogin enables OS Login on the instance, which grants SSH access to IAM
+	// accounts instead of metadata keys. SSHPublicKey is imported into the
+	// login profile of the service account of AccountFile by Provision, and
+	// SSHCreds.SSHUser is set to its POSIX username if it is empty. Scopes
+	// must include the cloud-platform scope.
+	OSLogin bool
+
+	// Metadata [optional] are the metadata items of the instance, which the
+	// guest can read from the metadata server. StartupScript and
+	// ShutdownScript [optional] are the scripts run by the guest environment
+	// at boot and shutdown, the "startup-script" and "shutdown-script" items.
+	Metadata       map[string]string
+	StartupScript  string
+	ShutdownScript string
+
+	// ProjectSSHKey adds SSHPublicKey to the project metadata instead of the
+	// instance metadata, which grants access to all the instances of the
+	// project. BlockProjectSSHKeys makes the instance ignore the project keys.
+	ProjectSSHKey       bool
+	BlockProjectSSHKeys bool
 
 	AccountFile  string
 	account      accountFile
@@ -63,8 +141,12 @@ type VM struct {
 // Disk represents the GCP Disk.
 // See https://cloud.google.com/compute/docs/disks/?hl=en_US&_ga=1.115106433.702756738.1463769954
 type Disk struct {
+	// Name [optional] is the name of the disk, and its device name on the
+	// instance. It is the name of the instance for the boot disk and
+	// INSTANCE-disk-N for additional disks if empty. An additional disk
+	// which exists already is attached instead of created.
 	Name       string
-	DiskType   string
+	DiskType   string // pd-standard if empty, see the DiskType constants
 	DiskSizeGb int
 	AutoDelete bool // Auto delete disk
 }
@@ -124,19 +206,81 @@ func (vm *VM) GetState() (string, error) {
 		return virtualmachine.VMRunning, nil
 	case "STOPPING", "STOPPED", "TERMINATED":
 		return virtualmachine.VMHalted, nil
+	case "SUSPENDING", "SUSPENDED":
+		return virtualmachine.VMSuspended, nil
 	default:
 		return virtualmachine.VMUnknown, nil
 	}
 }
 
-// Suspend is not supported, return the error.
+// Suspend suspends a running GCE instance, which keeps its memory and
+// disks. Instances with local SSDs or GPUs cannot be suspended.
 func (vm *VM) Suspend() error {
-	return errors.New("Suspend action not supported by GCE")
+	s, err := vm.getService()
+	if err != nil {
+		return err
+	}
+
+	return s.suspend()
 }
 
-// Resume is not supported, return the error.
+// Resume resumes a suspended GCE instance.
 func (vm *VM) Resume() error {
-	return errors.New("Resume action not supported by GCE")
+	s, err := vm.getService()
+	if err != nil {
+		return err
+	}
+
+	return s.resume()
+}
+
+// Resize changes the machine type of the GCE instance. A running instance is
+// stopped, resized and started again, and Resize waits until SSH is
+// available. It is started again with its previous machine type if the
+// machine type cannot be changed.
+func (vm *VM) Resize(machineType string) error {
+	s, err := vm.getService()
+	if err != nil {
+		return err
+	}
+
+	instance, err := s.getInstance()
+	if err != nil {
+		return err
+	}
+
+	running := instance.Status == "RUNNING"
+	if running {
+		if err := s.stop(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.setMachineType(machineType); err != nil {
+		// Restart the instance with its previous machine type.
+		if running {
+			if errStart := s.start(); errStart != nil {
+				return fmt.Errorf("%s, and failed to restart the instance: %s", err, errStart)
+			}
+		}
+		return err
+	}
+	vm.MachineType = machineType
+
+	if !running {
+		return nil
+	}
+
+	if err := s.start(); err != nil {
+		return err
+	}
+
+	client, err := vm.GetSSH(ssh.Options{})
+	if err != nil {
+		return err
+	}
+	client.Disconnect()
+	return nil
 }
 
 // Halt stops a GCE instance.
@@ -159,16 +303,37 @@ func (vm *VM) Start() error {
 	return s.start()
 }
 
-// GetSSH returns an SSH client connected to the instance.
+// GetDashboardURL returns the URL of the instance details page in the Google
+// Cloud console.
+func (vm *VM) GetDashboardURL() (string, error) {
+	if vm.Project == "" || vm.Zone == "" || vm.Name == "" {
+		return "", errors.New("project, zone and name are required to build the dashboard URL")
+	}
+
+	return fmt.Sprintf("https://console.cloud.google.com/compute/instancesDetail/zones/%s/instances/%s?project=%s",
+		vm.Zone, vm.Name, vm.Project), nil
+}
+
+// GetSSH returns an SSH client connected to the instance, through its public
+// IP, its private IP or an IAP tunnel.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	if vm.IAPTunnel {
+		return vm.getIAPTunnelClient(options)
+	}
+
 	ips, err := vm.GetIPs()
 	if err != nil {
 		return nil, err
 	}
 
+	ip := ips[PublicIP]
+	if vm.UseInternalIP || vm.NoExternalIP {
+		ip = ips[PrivateIP]
+	}
+
 	client := &ssh.SSHClient{
 		Creds:   &vm.SSHCreds,
-		IP:      ips[PublicIP],
+		IP:      ip,
 		Options: options,
 		Port:    22,
 	}
@@ -180,14 +345,27 @@ func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	return client, nil
 }
 
-// InsertSSHKey uploads new ssh key into the GCE instance.
+// InsertSSHKey grants the SSH user access to the GCE instance with the public
+// key, through OS Login if it is enabled, otherwise through the project or
+// instance metadata. The existing keys are kept.
 func (vm *VM) InsertSSHKey(publicKey string) error {
 	s, err := vm.getService()
 	if err != nil {
 		return err
 	}
 
-	return s.insertSSHKey()
+	return s.insertSSHKey(publicKey)
+}
+
+// SetDiskAutoDelete sets whether the disk with the given name is deleted with
+// the instance.
+func (vm *VM) SetDiskAutoDelete(name string, autoDelete bool) error {
+	s, err := vm.getService()
+	if err != nil {
+		return err
+	}
+
+	return s.setDiskAutoDelete(name, autoDelete)
 }
 
 // DeleteDisks cleans up all the disks attached to the GCE instance.
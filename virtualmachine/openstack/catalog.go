@@ -0,0 +1,176 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
+)
+
+// Flavor represents an Openstack flavor.
+type Flavor struct {
+	ID   string
+	Name string
+	// VCPUs is the number of virtual CPUs.
+	VCPUs int
+	// RAM is the amount of memory in MB.
+	RAM int
+	// Disk is the size of the root disk in GB.
+	Disk     int
+	IsPublic bool
+}
+
+// FlavorFilter selects flavors. Zero values match all the flavors.
+type FlavorFilter struct {
+	// MinVCPUs is the minimum number of virtual CPUs.
+	MinVCPUs int
+	// MinRAM is the minimum amount of memory in MB.
+	MinRAM int
+	// MinDisk is the minimum size of the root disk in GB.
+	MinDisk int
+}
+
+// ListFlavors returns the flavors matching the filter, using the credentials
+// and region of the given VM. The flavors are sorted from the smallest to the
+// largest, by VCPUs, then RAM, then disk, so the first one is the smallest
+// flavor matching the filter.
+func ListFlavors(vm *VM, filter FlavorFilter) ([]Flavor, error) {
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return nil, fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	page, err := flavors.ListDetail(client, flavors.ListOpts{
+		MinRAM:  filter.MinRAM,
+		MinDisk: filter.MinDisk,
+	}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("error on retrieving flavor pages: %s", err)
+	}
+
+	flavorList, err := flavors.ExtractFlavors(page)
+	if err != nil {
+		return nil, fmt.Errorf("error on extracting flavor list: %s", err)
+	}
+
+	return filterFlavors(flavorList, filter), nil
+}
+
+// filterFlavors returns the flavors matching the filter, from the smallest to
+// the largest.
+func filterFlavors(flavorList []flavors.Flavor, filter FlavorFilter) []Flavor {
+	var result []Flavor
+	for _, f := range flavorList {
+		if f.VCPUs < filter.MinVCPUs || f.RAM < filter.MinRAM || f.Disk < filter.MinDisk {
+			continue
+		}
+		result = append(result, Flavor{
+			ID:       f.ID,
+			Name:     f.Name,
+			VCPUs:    f.VCPUs,
+			RAM:      f.RAM,
+			Disk:     f.Disk,
+			IsPublic: f.IsPublic,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.VCPUs != b.VCPUs {
+			return a.VCPUs < b.VCPUs
+		}
+		if a.RAM != b.RAM {
+			return a.RAM < b.RAM
+		}
+		if a.Disk != b.Disk {
+			return a.Disk < b.Disk
+		}
+		return a.Name < b.Name
+	})
+	return result
+}
+
+// Image represents an Openstack image.
+type Image struct {
+	ID     string
+	Name   string
+	Status string
+	// MinDisk is the minimum root disk size in GB of a flavor to boot the image.
+	MinDisk int
+	// MinRAM is the minimum amount of memory in MB of a flavor to boot the image.
+	MinRAM int
+	// Created is the time the image was created, in ISO 8601 format.
+	Created  string
+	Metadata map[string]interface{}
+}
+
+// ImageFilter selects images. Zero values match all the images.
+type ImageFilter struct {
+	// Name is the exact name of the image.
+	Name string
+	// Status is the status of the image, such as "ACTIVE".
+	Status string
+	// Metadata are the metadata the image must have, such as "os_distro".
+	Metadata map[string]string
+}
+
+// ListImages returns the images matching the filter, using the credentials and
+// region of the given VM. The images are sorted by name, newest first for
+// images of the same name.
+func ListImages(vm *VM, filter ImageFilter) ([]Image, error) {
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return nil, fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	page, err := images.ListDetail(client, images.ListOpts{
+		Name:   filter.Name,
+		Status: filter.Status,
+	}).AllPages()
+	if err != nil {
+		return nil, fmt.Errorf("error on retrieving image pages: %s", err)
+	}
+
+	imageList, err := images.ExtractImages(page)
+	if err != nil {
+		return nil, fmt.Errorf("error on extracting image list: %s", err)
+	}
+
+	var result []Image
+	for _, i := range imageList {
+		if !hasMetadata(i.Metadata, filter.Metadata) {
+			continue
+		}
+		result = append(result, Image{
+			ID:       i.ID,
+			Name:     i.Name,
+			Status:   i.Status,
+			MinDisk:  i.MinDisk,
+			MinRAM:   i.MinRAM,
+			Created:  i.Created,
+			Metadata: i.Metadata,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Created > b.Created
+	})
+	return result, nil
+}
+
+// hasMetadata returns whether the metadata contain all the wanted values.
+func hasMetadata(metadata map[string]interface{}, want map[string]string) bool {
+	for k, v := range want {
+		if fmt.Sprint(metadata[k]) != v {
+			return false
+		}
+	}
+	return true
+}
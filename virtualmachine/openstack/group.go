@@ -0,0 +1,217 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apcera/libretto/ssh"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+// Group maintains a number of identical VMs, without relying on Senlin or
+// Heat. Members are created from a template VM and spread over availability
+// zones. It is safe for concurrent use.
+type Group struct {
+	// Template is the VM the members are created from. It is never
+	// provisioned itself. Its Name is used as the prefix of the member names.
+	// Volumes are created per member, unless the template has the ID of an
+	// existing multiattach volume.
+	Template *VM
+	// Zones [optional] are the availability zones to spread the members over.
+	// New members are placed in the zone with the fewest members. If empty,
+	// the AvailabilityZone of the template is used.
+	Zones []string
+
+	mu      sync.Mutex
+	members []*VM
+	next    int
+}
+
+// Members returns the current members of the group, oldest first.
+func (g *Group) Members() []*VM {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*VM(nil), g.members...)
+}
+
+// Scale provisions or destroys members until the group has n members. The
+// newest members are destroyed first. It stops at the first error.
+func (g *Group) Scale(n int) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if n < 0 {
+		return fmt.Errorf("invalid group size %d", n)
+	}
+
+	for len(g.members) < n {
+		vm := g.newMember(fmt.Sprintf("%s-%d", g.Template.Name, g.next), g.nextZone())
+		g.next++
+
+		if err := vm.Provision(); err != nil {
+			return fmt.Errorf("failed to provision group member %s: %s", vm.Name, err)
+		}
+		g.members = append(g.members, vm)
+	}
+
+	for len(g.members) > n {
+		vm := g.members[len(g.members)-1]
+		if vm.InstanceID == "" {
+			// Destroyed by a Heal which failed to replace it
+			g.members = g.members[:len(g.members)-1]
+			continue
+		}
+		if err := vm.Destroy(); err != nil {
+			return fmt.Errorf("failed to destroy group member %s: %s", vm.Name, err)
+		}
+		g.members = g.members[:len(g.members)-1]
+	}
+
+	return nil
+}
+
+// Heal replaces the members in the ERROR state with new members of the same
+// name and availability zone. A member whose replacement fails is kept without
+// an instance, and replaced by the next Heal. It returns the errors of all the
+// replacements.
+func (g *Group) Heal() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var errs []string
+	for i, vm := range g.members {
+		// Members destroyed by a previous Heal have no instance left
+		if vm.InstanceID != "" {
+			state, err := vm.GetState()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", vm.Name, err))
+				continue
+			}
+			if state != lvm.VMError {
+				continue
+			}
+
+			if err = vm.Destroy(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", vm.Name, err))
+				continue
+			}
+			vm.InstanceID = ""
+		}
+
+		// Provision destroys what it created of the replacement on failure
+		replacement := g.newMember(vm.Name, vm.AvailabilityZone)
+		if err := replacement.Provision(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", vm.Name, err))
+			continue
+		}
+		g.members[i] = replacement
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to heal group: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Destroy destroys all the members of the group.
+func (g *Group) Destroy() error {
+	return g.Scale(0)
+}
+
+// nextZone returns the zone with the fewest members, in the order of Zones on
+// ties.
+func (g *Group) nextZone() string {
+	if len(g.Zones) == 0 {
+		return g.Template.AvailabilityZone
+	}
+
+	counts := make(map[string]int)
+	for _, vm := range g.members {
+		counts[vm.AvailabilityZone]++
+	}
+
+	zone := g.Zones[0]
+	for _, z := range g.Zones[1:] {
+		if counts[z] < counts[zone] {
+			zone = z
+		}
+	}
+	return zone
+}
+
+// newMember returns a new VM with the spec of the template. Resources owned
+// by a provisioned VM, such as its instance, floating IP or trunk, are not
+// copied.
+func (g *Group) newMember(name string, zone string) *VM {
+	t := g.Template
+	vm := &VM{
+		IdentityEndpoint:       t.IdentityEndpoint,
+		Username:               t.Username,
+		Password:               t.Password,
+		Region:                 t.Region,
+		TenantName:             t.TenantName,
+		DashboardEndpoint:      t.DashboardEndpoint,
+		CACertFile:             t.CACertFile,
+		ClientCertFile:         t.ClientCertFile,
+		ClientKeyFile:          t.ClientKeyFile,
+		Insecure:               t.Insecure,
+		ComputeMicroversion:    t.ComputeMicroversion,
+		FlavorName:             t.FlavorName,
+		AvailabilityZone:       zone,
+		ImageID:                t.ImageID,
+		ImageMetadata:          t.ImageMetadata,
+		ImagePath:              t.ImagePath,
+		DeleteImageOnDestroy:   t.DeleteImageOnDestroy,
+		Volume:                 t.Volume,
+		Name:                   name,
+		Networks:               t.Networks,
+		FloatingIPPool:         t.FloatingIPPool,
+		SecurityGroup:          t.SecurityGroup,
+		UserData:               t.UserData,
+		AdminPassword:          t.AdminPassword,
+		SSHPublicKey:           t.SSHPublicKey,
+		CreateKeyPair:          t.CreateKeyPair,
+		InjectSSHKey:           t.InjectSSHKey,
+		ServerGroupID:          t.ServerGroupID,
+		DeleteEmptyServerGroup: t.DeleteEmptyServerGroup,
+		Credentials: ssh.Credentials{
+			SSHUser:        t.Credentials.SSHUser,
+			SSHPassword:    t.Credentials.SSHPassword,
+			SSHPrivateKey:  t.Credentials.SSHPrivateKey,
+			SSHCertificate: t.Credentials.SSHCertificate,
+		},
+	}
+
+	// Only existing key pairs are shared, transient ones are per member
+	if !t.KeyPairCreated {
+		vm.KeyPairName = t.KeyPairName
+	}
+
+	// A volume ID of the template is attached to all the members, which
+	// requires a multiattach volume
+	vm.Volume.Existing = false
+
+	// Each member gets its own network, router and subnet from the CIDR
+	if t.PrivateNetwork != nil {
+		vm.PrivateNetwork = &PrivateNetwork{
+			CIDR:              t.PrivateNetwork.CIDR,
+			DNSNameservers:    t.PrivateNetwork.DNSNameservers,
+			ExternalNetworkID: t.PrivateNetwork.ExternalNetworkID,
+		}
+	}
+
+	if t.Trunk != nil {
+		vm.Trunk = &Trunk{NetworkID: t.Trunk.NetworkID}
+		for _, sub := range t.Trunk.SubPorts {
+			vm.Trunk.SubPorts = append(vm.Trunk.SubPorts, SubPort{
+				NetworkID:      sub.NetworkID,
+				SegmentationID: sub.SegmentationID,
+			})
+		}
+	}
+
+	return vm
+}
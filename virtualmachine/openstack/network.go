@@ -0,0 +1,155 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+)
+
+// PrivateNetwork describes a private network, with a subnet and a router to
+// the external network, created for a VM which has no Networks. It is deleted
+// on Destroy.
+type PrivateNetwork struct {
+	// CIDR is the address CIDR of the subnet, such as "192.168.10.0/24".
+	CIDR string
+	// DNSNameservers [optional] are the nameservers handed out by DHCP.
+	DNSNameservers []string
+	// ExternalNetworkID [optional] is the ID of the external network the router
+	// is attached to. If not set, the first external network is used.
+	ExternalNetworkID string
+
+	// NetworkID is the ID of the network. Set after provisioning.
+	NetworkID string
+	// SubnetID is the ID of the subnet. Set after provisioning.
+	SubnetID string
+	// RouterID is the ID of the router. Set after provisioning.
+	RouterID string
+}
+
+// createPrivateNetwork creates the network, the subnet and the router of the
+// private network of the VM. Everything created so far is deleted if something
+// goes wrong.
+func createPrivateNetwork(vm *VM) error {
+	client, err := getNetworkClient(vm)
+	if err != nil {
+		return err
+	}
+
+	pn := vm.PrivateNetwork
+	name := fmt.Sprintf("libretto-%s", vm.Name)
+
+	// Cleanup the network if something goes wrong
+	var cleanup = func(err error) error {
+		if errDelete := deletePrivateNetwork(vm); errDelete != nil {
+			return fmt.Errorf("%s %s", err, errDelete)
+		}
+		return err
+	}
+
+	externalNetworkID := pn.ExternalNetworkID
+	if externalNetworkID == "" {
+		externalNetworkID, err = findExternalNetworkID(client)
+		if err != nil {
+			return err
+		}
+	}
+
+	network, err := networks.Create(client, networks.CreateOpts{Name: name}).Extract()
+	if err != nil {
+		return fmt.Errorf("unable to create the private network: %s", err)
+	}
+	pn.NetworkID = network.ID
+
+	subnet, err := subnets.Create(client, subnets.CreateOpts{
+		NetworkID:      network.ID,
+		CIDR:           pn.CIDR,
+		Name:           name,
+		IPVersion:      gophercloud.IPv4,
+		DNSNameservers: pn.DNSNameservers,
+	}).Extract()
+	if err != nil {
+		return cleanup(fmt.Errorf("unable to create the private subnet: %s", err))
+	}
+	pn.SubnetID = subnet.ID
+
+	router, err := routers.Create(client, routers.CreateOpts{
+		Name:        name,
+		GatewayInfo: &routers.GatewayInfo{NetworkID: externalNetworkID},
+	}).Extract()
+	if err != nil {
+		return cleanup(fmt.Errorf("unable to create the router: %s", err))
+	}
+	pn.RouterID = router.ID
+
+	_, err = routers.AddInterface(client, router.ID, routers.AddInterfaceOpts{SubnetID: subnet.ID}).Extract()
+	if err != nil {
+		return cleanup(fmt.Errorf("unable to attach the private subnet to the router: %s", err))
+	}
+
+	return nil
+}
+
+// deletePrivateNetwork deletes the router, the subnet and the network of the
+// private network of the VM, as far as they were created. The network must not
+// be in use.
+func deletePrivateNetwork(vm *VM) error {
+	pn := vm.PrivateNetwork
+	if pn == nil {
+		return nil
+	}
+
+	client, err := getNetworkClient(vm)
+	if err != nil {
+		return err
+	}
+
+	if pn.RouterID != "" {
+		if pn.SubnetID != "" {
+			_, err = routers.RemoveInterface(client, pn.RouterID, routers.RemoveInterfaceOpts{SubnetID: pn.SubnetID}).Extract()
+			if _, ok := err.(gophercloud.ErrDefault404); err != nil && !ok {
+				return fmt.Errorf("unable to detach the private subnet from the router: %s", err)
+			}
+		}
+
+		err = routers.Delete(client, pn.RouterID).ExtractErr()
+		if err != nil {
+			return fmt.Errorf("unable to delete the router: %s", err)
+		}
+		pn.RouterID = ""
+	}
+
+	// Deleting the network deletes its subnet as well
+	if pn.NetworkID != "" {
+		err = networks.Delete(client, pn.NetworkID).ExtractErr()
+		if err != nil {
+			return fmt.Errorf("unable to delete the private network: %s", err)
+		}
+		pn.NetworkID = ""
+		pn.SubnetID = ""
+	}
+
+	return nil
+}
+
+// findExternalNetworkID returns the ID of the first external network.
+func findExternalNetworkID(client *gophercloud.ServiceClient) (string, error) {
+	var resp struct {
+		Networks []struct {
+			ID string `json:"id"`
+		} `json:"networks"`
+	}
+	_, err := client.Get(client.ServiceURL("networks")+"?router:external=true", &resp, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to list the external networks: %s", err)
+	}
+
+	if len(resp.Networks) == 0 {
+		return "", fmt.Errorf("no external network found")
+	}
+	return resp.Networks[0].ID, nil
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/servergroups"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+)
+
+// Server group policies.
+const (
+	// PolicyAffinity places all the members of a server group on the same host.
+	PolicyAffinity = "affinity"
+	// PolicyAntiAffinity places each member of a server group on a different host.
+	PolicyAntiAffinity = "anti-affinity"
+	// PolicySoftAffinity places the members of a server group on the same host
+	// if possible.
+	PolicySoftAffinity = "soft-affinity"
+	// PolicySoftAntiAffinity places the members of a server group on different
+	// hosts if possible.
+	PolicySoftAntiAffinity = "soft-anti-affinity"
+)
+
+// softPolicyMicroversion is the compute API microversion introducing the soft
+// policies.
+const softPolicyMicroversion = "2.15"
+
+// CreateServerGroup creates a Nova server group with the given policy, using
+// the credentials and region of the given VM, and returns its ID.
+func CreateServerGroup(vm *VM, name string, policy string) (string, error) {
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return "", fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	switch policy {
+	case PolicyAffinity, PolicyAntiAffinity:
+	case PolicySoftAffinity, PolicySoftAntiAffinity:
+		// Soft policies are not known to the base microversion.
+		if client.Microversion == "" {
+			softClient := *client
+			softClient.Microversion = softPolicyMicroversion
+			client = &softClient
+		}
+	default:
+		return "", fmt.Errorf("unknown server group policy %q", policy)
+	}
+
+	group, err := servergroups.Create(client, servergroups.CreateOpts{
+		Name:     name,
+		Policies: []string{policy},
+	}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("unable to create server group: %s", err)
+	}
+
+	return group.ID, nil
+}
+
+// DeleteServerGroup deletes the Nova server group with the given ID, using the
+// credentials and region of the given VM.
+func DeleteServerGroup(vm *VM, groupID string) error {
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	return servergroups.Delete(client, groupID).ExtractErr()
+}
+
+// ProvisionServerGroup creates a server group with the given policy and
+// provisions the VMs in it, one after the other. The group is deleted when its
+// last VM is destroyed. If a VM fails to provision, the VMs provisioned so far
+// and the group are destroyed. It returns the ID of the group.
+func ProvisionServerGroup(name string, policy string, vms ...*VM) (string, error) {
+	if len(vms) == 0 {
+		return "", fmt.Errorf("no VMs to provision in server group %s", name)
+	}
+
+	groupID, err := CreateServerGroup(vms[0], name, policy)
+	if err != nil {
+		return "", err
+	}
+
+	for i, vm := range vms {
+		vm.ServerGroupID = groupID
+		vm.DeleteEmptyServerGroup = true
+
+		err = vm.Provision()
+		if err == nil {
+			continue
+		}
+
+		for _, provisioned := range vms[:i] {
+			if errDestroy := provisioned.Destroy(); errDestroy != nil {
+				err = fmt.Errorf("%s %s", err, errDestroy)
+			}
+		}
+
+		// The group may already be deleted along with its last member.
+		errDelete := DeleteServerGroup(vms[0], groupID)
+		if _, ok := errDelete.(gophercloud.ErrDefault404); errDelete != nil && !ok {
+			err = fmt.Errorf("%s %s", err, errDelete)
+		}
+		return "", err
+	}
+
+	return groupID, nil
+}
+
+// deleteEmptyServerGroup deletes the server group of the VM if the VM, which
+// is being deleted, is its only remaining member. Members which are being
+// deleted themselves are not counted, as they stay in the group until they are
+// gone.
+func deleteEmptyServerGroup(vm *VM, client *gophercloud.ServiceClient) error {
+	group, err := servergroups.Get(client, vm.ServerGroupID).Extract()
+	if _, ok := err.(gophercloud.ErrDefault404); ok {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get server group: %s", err)
+	}
+
+	for _, member := range group.Members {
+		if member == vm.InstanceID {
+			continue
+		}
+
+		var s struct {
+			Server *ServerState `json:"server"`
+		}
+		err = servers.Get(client, member).ExtractInto(&s)
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to get server group member %s: %s", member, err)
+		}
+		if s.Server.Status != "DELETED" && s.Server.TaskState != "deleting" {
+			return nil
+		}
+	}
+
+	err = servergroups.Delete(client, vm.ServerGroupID).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("unable to delete server group: %s", err)
+	}
+	return nil
+}
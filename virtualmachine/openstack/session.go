@@ -0,0 +1,120 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// session is a cached provider client. Its lock serializes the
+// authentication and the re-authentications of the client, without blocking
+// the sessions of other keys. The client is only used under the lock, VMs get
+// copies of it.
+type session struct {
+	sync.Mutex
+	client *gophercloud.ProviderClient
+}
+
+// sessions caches the authenticated provider clients, so that VMs using the
+// same endpoint, credentials, project and TLS settings share a Keystone token
+// instead of authenticating for every operation. The lock only guards the
+// map, it is not held while authenticating.
+var sessions = struct {
+	sync.Mutex
+	clients map[string]*session
+}{clients: make(map[string]*session)}
+
+// getSession returns a copy of the cached provider client for the VM and the
+// given authentication options, authenticating a new one if there is none.
+// The token of the copy is refreshed from the session when it expires, so the
+// copy must not be shared between goroutines.
+func getSession(vm *VM, opts gophercloud.AuthOptions) (*gophercloud.ProviderClient, error) {
+	key := sessionKey(vm, opts)
+
+	sessions.Lock()
+	s, ok := sessions.clients[key]
+	if !ok {
+		s = &session{}
+		sessions.clients[key] = s
+	}
+	sessions.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.client == nil {
+		// The session is kept without a client if this fails, so that the
+		// next call authenticates again.
+		opts.AllowReauth = true
+		client, err := newProviderClient(vm, opts)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+
+	client := *s.client
+	client.ReauthFunc = func() error {
+		tokenID, locator, err := s.reauth(client.TokenID)
+		if err != nil {
+			return err
+		}
+		client.TokenID = tokenID
+		client.EndpointLocator = locator
+		return nil
+	}
+	return &client, nil
+}
+
+// reauth re-authenticates the client of the session, unless its token was
+// already refreshed since staleTokenID was issued. It returns the current token
+// and endpoint locator of the client.
+func (s *session) reauth(staleTokenID string) (string, gophercloud.EndpointLocator, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.client.TokenID != staleTokenID && s.client.TokenID != "" {
+		return s.client.TokenID, s.client.EndpointLocator, nil
+	}
+
+	// gophercloud re-authenticates on a 401 of the token request too, so the
+	// client has no ReauthFunc while re-authenticating to fail instead of
+	// recursing. A successful re-authentication sets a new ReauthFunc.
+	reauth := s.client.ReauthFunc
+	if reauth == nil {
+		return "", nil, ErrAuthenticatingClient
+	}
+	s.client.ReauthFunc = nil
+	err := reauth()
+	if s.client.ReauthFunc == nil {
+		s.client.ReauthFunc = reauth
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return s.client.TokenID, s.client.EndpointLocator, nil
+}
+
+// ClearSessions drops the cached provider clients, e.g. after credentials
+// were rotated. Clients in use by VMs keep working until they are dropped
+// from the VMs.
+func ClearSessions() {
+	sessions.Lock()
+	defer sessions.Unlock()
+	sessions.clients = make(map[string]*session)
+}
+
+// sessionKey returns the cache key of the provider client for the VM and the
+// given authentication options. Secrets are hashed.
+func sessionKey(vm *VM, opts gophercloud.AuthOptions) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %q %q %q %q %q %q %q %q %q %t",
+		opts.IdentityEndpoint, opts.Username, opts.UserID, opts.Password,
+		opts.DomainID, opts.DomainName, opts.TenantID, opts.TenantName, opts.TokenID,
+		vm.CACertFile, vm.ClientCertFile, vm.ClientKeyFile, vm.Insecure)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
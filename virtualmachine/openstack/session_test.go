@@ -0,0 +1,129 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// keystone is a fake Keystone v2 endpoint. It issues a new token for every
+// token request, or a 401 once the credentials are revoked, and serves a
+// resource that only accepts the last token.
+type keystone struct {
+	sync.Mutex
+	tokens  int
+	revoked bool
+}
+
+func (k *keystone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.Lock()
+	defer k.Unlock()
+
+	switch r.URL.Path {
+	case "/v2.0/tokens":
+		if k.revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		k.tokens++
+		fmt.Fprintf(w, `{"access": {"token": {"id": "token%d", "expires": "2100-01-01T00:00:00.000000Z"}, "serviceCatalog": []}}`, k.tokens)
+	case "/resource":
+		if r.Header.Get("X-Auth-Token") != fmt.Sprintf("token%d", k.tokens) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// request makes a request for the resource with the client, failing the test
+// if it does not return.
+func request(t *testing.T, client *gophercloud.ProviderClient, url string) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Request("GET", url, &gophercloud.RequestOpts{OkCodes: []int{http.StatusOK}})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the request to return")
+		return nil
+	}
+}
+
+// TestSessionReauth makes sure the copies of a session share its token, and
+// that a 401 while re-authenticating fails instead of blocking.
+func TestSessionReauth(t *testing.T) {
+	k := &keystone{}
+	server := httptest.NewServer(k)
+	defer server.Close()
+	defer ClearSessions()
+
+	vm := &VM{}
+	opts := gophercloud.AuthOptions{
+		IdentityEndpoint: server.URL + "/v2.0/",
+		Username:         "user",
+		Password:         "password",
+	}
+	first, err := getSession(vm, opts)
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	second, err := getSession(vm, opts)
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if first == second || first.TokenID != "token1" || second.TokenID != "token1" {
+		t.Fatalf("Expected copies of the session, got: %q, %q", first.TokenID, second.TokenID)
+	}
+
+	// Expire the token, the second copy reuses the token of the first one
+	k.Lock()
+	k.tokens++
+	k.Unlock()
+	if err = request(t, first, server.URL+"/resource"); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if err = request(t, second, server.URL+"/resource"); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if first.TokenID != "token3" || second.TokenID != "token3" {
+		t.Fatalf("Expected a single re-authentication, got: %q, %q", first.TokenID, second.TokenID)
+	}
+
+	// Expire the token and revoke the credentials
+	k.Lock()
+	k.tokens++
+	k.revoked = true
+	k.Unlock()
+	if err = request(t, first, server.URL+"/resource"); err == nil {
+		t.Fatal("Expected an error when the re-authentication fails")
+	}
+	if err = request(t, second, server.URL+"/resource"); err == nil {
+		t.Fatal("Expected an error when the re-authentication fails")
+	}
+
+	// The session re-authenticates once the credentials are valid again
+	k.Lock()
+	k.revoked = false
+	k.Unlock()
+	third, err := getSession(vm, opts)
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if err = request(t, third, server.URL+"/resource"); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+}
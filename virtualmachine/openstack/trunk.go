@@ -0,0 +1,152 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+// Trunk represents a Neutron trunk port. The VM is attached to the parent port
+// and receives the traffic of each subport tagged with its VLAN ID.
+type Trunk struct {
+	// NetworkID is the ID of the network of the parent port, whose traffic is
+	// untagged.
+	NetworkID string
+	// SubPorts are the subports of the trunk.
+	SubPorts []SubPort
+
+	// ID is the ID of the trunk. Set after provisioning.
+	ID string
+	// PortID is the ID of the parent port. Set after provisioning.
+	PortID string
+}
+
+// SubPort represents a subport of a trunk, carrying the traffic of a network
+// tagged with a VLAN ID.
+type SubPort struct {
+	// NetworkID is the ID of the network of the subport.
+	NetworkID string
+	// SegmentationID is the VLAN ID the traffic of the subport is tagged with.
+	SegmentationID int
+
+	// PortID is the ID of the subport. Set after provisioning.
+	PortID string
+}
+
+// createTrunk creates the parent port, the subports and the trunk of the VM.
+// Everything created so far is deleted if something goes wrong.
+func createTrunk(vm *VM) error {
+	client, err := getNetworkClient(vm)
+	if err != nil {
+		return err
+	}
+
+	trunk := vm.Trunk
+	name := fmt.Sprintf("libretto-%s", vm.Name)
+
+	// Cleanup the ports if something goes wrong
+	var cleanup = func(err error) error {
+		if errDelete := deleteTrunk(vm); errDelete != nil {
+			return fmt.Errorf("%s %s", err, errDelete)
+		}
+		return err
+	}
+
+	parent, err := ports.Create(client, ports.CreateOpts{
+		NetworkID: trunk.NetworkID,
+		Name:      name,
+	}).Extract()
+	if err != nil {
+		return fmt.Errorf("unable to create the trunk parent port: %s", err)
+	}
+	trunk.PortID = parent.ID
+
+	// Subports share the MAC address of the parent port, so the guest sees
+	// the tagged traffic on the same interface.
+	var subPorts []map[string]interface{}
+	for i, sub := range trunk.SubPorts {
+		port, err := ports.Create(client, ports.CreateOpts{
+			NetworkID:  sub.NetworkID,
+			Name:       fmt.Sprintf("%s-vlan%d", name, sub.SegmentationID),
+			MACAddress: parent.MACAddress,
+		}).Extract()
+		if err != nil {
+			return cleanup(fmt.Errorf("unable to create the trunk subport for VLAN %d: %s", sub.SegmentationID, err))
+		}
+		trunk.SubPorts[i].PortID = port.ID
+
+		subPorts = append(subPorts, map[string]interface{}{
+			"port_id":           port.ID,
+			"segmentation_type": "vlan",
+			"segmentation_id":   sub.SegmentationID,
+		})
+	}
+
+	body := map[string]interface{}{
+		"trunk": map[string]interface{}{
+			"name":      name,
+			"port_id":   parent.ID,
+			"sub_ports": subPorts,
+		},
+	}
+	var resp struct {
+		Trunk struct {
+			ID string `json:"id"`
+		} `json:"trunk"`
+	}
+	_, err = client.Post(client.ServiceURL("trunks"), body, &resp, &gophercloud.RequestOpts{
+		OkCodes: []int{201},
+	})
+	if err != nil {
+		return cleanup(fmt.Errorf("unable to create the trunk: %s", err))
+	}
+	trunk.ID = resp.Trunk.ID
+
+	return nil
+}
+
+// deleteTrunk deletes the trunk, the subports and the parent port of the VM,
+// as far as they were created. The parent port must not be in use.
+func deleteTrunk(vm *VM) error {
+	trunk := vm.Trunk
+	if trunk == nil {
+		return nil
+	}
+
+	client, err := getNetworkClient(vm)
+	if err != nil {
+		return err
+	}
+
+	if trunk.ID != "" {
+		_, err = client.Delete(client.ServiceURL("trunks", trunk.ID), nil)
+		if err != nil {
+			return fmt.Errorf("unable to delete the trunk: %s", err)
+		}
+		trunk.ID = ""
+	}
+
+	for i, sub := range trunk.SubPorts {
+		if sub.PortID == "" {
+			continue
+		}
+		err = ports.Delete(client, sub.PortID).ExtractErr()
+		if err != nil {
+			return fmt.Errorf("unable to delete the trunk subport for VLAN %d: %s", sub.SegmentationID, err)
+		}
+		trunk.SubPorts[i].PortID = ""
+	}
+
+	if trunk.PortID != "" {
+		err = ports.Delete(client, trunk.PortID).ExtractErr()
+		if err != nil {
+			return fmt.Errorf("unable to delete the trunk parent port: %s", err)
+		}
+		trunk.PortID = ""
+	}
+
+	return nil
+}
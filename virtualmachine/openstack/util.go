@@ -4,12 +4,17 @@ package openstack
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -17,9 +22,11 @@ import (
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v1/volumes"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/images"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	gossh "golang.org/x/crypto/ssh"
 
 	"github.com/apcera/libretto/ssh"
 	lvm "github.com/apcera/libretto/virtualmachine"
@@ -45,14 +52,84 @@ func getProviderClient(vm *VM) (*gophercloud.ProviderClient, error) {
 		}
 	}
 
-	providerClient, err := openstack.AuthenticatedClient(opts)
-	if providerClient == nil || err != nil {
+	return getSession(vm, opts)
+}
+
+// newProviderClient creates a provider client for the VM and authenticates it with the given
+// options.
+func newProviderClient(vm *VM, opts gophercloud.AuthOptions) (*gophercloud.ProviderClient, error) {
+	providerClient, err := openstack.NewClient(opts.IdentityEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the client: %s", err)
+	}
+
+	tlsConfig, err := getTLSConfig(vm)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		providerClient.HTTPClient = http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	}
+
+	err = openstack.Authenticate(providerClient, opts)
+	if err != nil {
 		return nil, fmt.Errorf("failed to authenticate the client")
 	}
 
 	return providerClient, nil
 }
 
+// getTLSConfig returns the TLS configuration to connect to the Openstack endpoints with. It
+// returns nil if the default configuration should be used.
+func getTLSConfig(vm *VM) (*tls.Config, error) {
+	caCertFile := vm.CACertFile
+	if caCertFile == "" {
+		caCertFile = os.Getenv("OS_CACERT")
+	}
+	clientCertFile := vm.ClientCertFile
+	if clientCertFile == "" {
+		clientCertFile = os.Getenv("OS_CERT")
+	}
+	clientKeyFile := vm.ClientKeyFile
+	if clientKeyFile == "" {
+		clientKeyFile = os.Getenv("OS_KEY")
+	}
+
+	if caCertFile == "" && clientCertFile == "" && !vm.Insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: vm.Insecure}
+
+	if caCertFile != "" {
+		caCert, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA certificate file: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in the CA certificate file %s", caCertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if clientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
 func getComputeClient(vm *VM) (*gophercloud.ServiceClient, error) {
 	if vm.computeClient != nil {
 		return vm.computeClient, nil
@@ -71,11 +148,39 @@ func getComputeClient(vm *VM) (*gophercloud.ServiceClient, error) {
 	if err != nil {
 		return nil, ErrInvalidRegion
 	}
+	client.Microversion = vm.ComputeMicroversion
 
 	vm.computeClient = client
 	return client, nil
 }
 
+// getActiveComputeClient returns the compute client for the VM after making sure the instance
+// is in running state. An error is returned if the instance ID is missing or if the instance
+// is not running.
+func getActiveComputeClient(vm *VM) (*gophercloud.ServiceClient, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return nil, fmt.Errorf("compute client is not set for the VM, %s", err)
+	}
+
+	// Take a look at the initial state of the VM. Make sure it is in ACTIVE state
+	state, err := vm.GetState()
+	if err != nil {
+		return nil, err
+	}
+
+	if state != lvm.VMRunning {
+		return nil, fmt.Errorf("the VM is not active")
+	}
+
+	return client, nil
+}
+
 func getNetworkClient(vm *VM) (*gophercloud.ServiceClient, error) {
 	provider, err := getProviderClient(vm)
 	if err != nil {
@@ -114,7 +219,7 @@ func getBlockStorageClient(vm *VM) (*gophercloud.ServiceClient, error) {
 // imageEndpoint has version info. If it is not, then a Get request is sent to imageEndpoint to
 // fetch supported APIs. If any V2 api is supported then it returns 2, else If any V1 api is
 // supported then it returns 1. Otherwise, it returns an error.
-func findImageAPIVersion(tokenID string, imageEndpoint string) (int, error) {
+func findImageAPIVersion(httpClient *http.Client, tokenID string, imageEndpoint string) (int, error) {
 	// Try to fetch image API version from the imageEndpoint
 	if strings.HasSuffix(imageEndpoint, "/v1/") {
 		return 1, nil
@@ -130,10 +235,9 @@ func findImageAPIVersion(tokenID string, imageEndpoint string) (int, error) {
 	}
 
 	versionReq.Header.Add("X-Auth-Token", tokenID)
-	versionClient := &http.Client{}
 
 	// Send the request to upload the image
-	resp, err := versionClient.Do(versionReq)
+	resp, err := httpClient.Do(versionReq)
 	if err != nil {
 		return 0, fmt.Errorf("failed to send a image API version request")
 	}
@@ -163,17 +267,20 @@ func imageVersionEncoded(imageEndpoint string) bool {
 	return false
 }
 
+// imageCollectionURI returns the URI of the images collection at the image endpoint.
+func imageCollectionURI(imageEndpoint string, imageApiVersion int) string {
+	if imageVersionEncoded(imageEndpoint) {
+		return fmt.Sprintf("%simages", imageEndpoint)
+	}
+	return fmt.Sprintf("%sv%d/images", imageEndpoint, imageApiVersion)
+}
+
 // Reserves an Image ID at the specified image endpoint using the information in given imageMetadata
 // Returns the reserved Image ID if reservation is successful, otherwise returns an error.
 // Requires client's token to reserve the image.
-func reserveImage(tokenID string, imageEndpoint string, imageMetadata ImageMetadata, imageApiVersion int) (string, error) {
+func reserveImage(httpClient *http.Client, tokenID string, imageEndpoint string, imageMetadata ImageMetadata, imageApiVersion int) (string, error) {
 	// Form the URI to create the image
-	imagesURI := ""
-	if imageVersionEncoded(imageEndpoint) {
-		imagesURI = fmt.Sprintf("%simages", imageEndpoint)
-	} else {
-		imagesURI = fmt.Sprintf("%sv%d/images", imageEndpoint, imageApiVersion)
-	}
+	imagesURI := imageCollectionURI(imageEndpoint, imageApiVersion)
 
 	// Prepare the request to create the image
 	var createReq *http.Request
@@ -205,7 +312,6 @@ func reserveImage(tokenID string, imageEndpoint string, imageMetadata ImageMetad
 	}
 
 	// Send the request to create the image
-	httpClient := &http.Client{}
 	resp, err := httpClient.Do(createReq)
 	if err != nil {
 		return "", fmt.Errorf("failed to send a image reserve request")
@@ -243,7 +349,7 @@ func reserveImage(tokenID string, imageEndpoint string, imageMetadata ImageMetad
 // Uploads the image to an reserved image location at the imageEndpoint using the reserved image ID and imageMetadata.
 // Returns nil error if the upload is successful, otherwise returns an error.
 // Requires client's token to upload the image.
-func uploadImage(tokenID string, imageEndpoint string, imageID string, imagePath string, imageApiVersion int) error {
+func uploadImage(httpClient *http.Client, tokenID string, imageEndpoint string, imageID string, imagePath string, imageApiVersion int) error {
 	// Read the image file
 	file, err := os.Open(imagePath)
 	if err != nil {
@@ -277,10 +383,8 @@ func uploadImage(tokenID string, imageEndpoint string, imageID string, imagePath
 	uploadReq.Header.Add("X-Auth-Token", tokenID)
 	uploadReq.Header.Add("Content-Length", fmt.Sprintf("%d", imageFileSize))
 
-	uploadClient := &http.Client{}
-
 	// Send the request to upload the image
-	resp, err := uploadClient.Do(uploadReq)
+	resp, err := httpClient.Do(uploadReq)
 	if err != nil {
 		return fmt.Errorf("failed to send a upload image request")
 	}
@@ -313,19 +417,19 @@ func createImage(vm *VM) (string, error) {
 	}
 
 	// Find the Image API version number
-	version, err := findImageAPIVersion(provider.TokenID, imageEndpoint)
+	version, err := findImageAPIVersion(&provider.HTTPClient, provider.TokenID, imageEndpoint)
 	if err != nil {
 		return "", err
 	}
 
 	// Reserve an ImageID at imageEndpoint using the given image metadata
-	imageID, err := reserveImage(provider.TokenID, imageEndpoint, vm.ImageMetadata, version)
+	imageID, err := reserveImage(&provider.HTTPClient, provider.TokenID, imageEndpoint, vm.ImageMetadata, version)
 	if err != nil {
 		return "", err
 	}
 
 	// Upload the image to the imageEndpoint with reserved ImageID using the given image path
-	err = uploadImage(provider.TokenID, imageEndpoint, imageID, vm.ImagePath, version)
+	err = uploadImage(&provider.HTTPClient, provider.TokenID, imageEndpoint, imageID, vm.ImagePath, version)
 	if err != nil {
 		return "", err
 	}
@@ -333,6 +437,244 @@ func createImage(vm *VM) (string, error) {
 	return imageID, nil
 }
 
+// deleteImage deletes the image with the given ID from the image service.
+func deleteImage(vm *VM, imageID string) error {
+	provider, err := getProviderClient(vm)
+	if err != nil {
+		return ErrAuthenticatingClient
+	}
+
+	imageEndpoint, err := findImageEndpoint(provider, gophercloud.EndpointOpts{Region: vm.Region})
+	if err != nil {
+		return err
+	}
+
+	version, err := findImageAPIVersion(&provider.HTTPClient, provider.TokenID, imageEndpoint)
+	if err != nil {
+		return err
+	}
+
+	deleteReq, err := http.NewRequest("DELETE", fmt.Sprintf("%s/%s", imageCollectionURI(imageEndpoint, version), imageID), nil)
+	if err != nil {
+		return err
+	}
+	deleteReq.Header.Add("X-Auth-Token", provider.TokenID)
+
+	resp, err := provider.HTTPClient.Do(deleteReq)
+	if err != nil {
+		return fmt.Errorf("failed to send a delete image request")
+	}
+	defer resp.Body.Close()
+
+	// The image is already gone if it is not found
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("delete image request returned bad response, %s", string(body))
+	}
+
+	return nil
+}
+
+// sshPublicKey returns the SSH public key of the VM, deriving it from the SSH private key of the
+// credentials if it is not set. It returns an empty string if no key is available.
+func sshPublicKey(vm *VM) (string, error) {
+	if vm.SSHPublicKey != "" {
+		return strings.TrimSpace(vm.SSHPublicKey), nil
+	}
+	if vm.Credentials.SSHPrivateKey == "" {
+		return "", nil
+	}
+
+	signer, err := gossh.ParsePrivateKey([]byte(vm.Credentials.SSHPrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse the SSH private key: %s", err)
+	}
+	return strings.TrimSpace(string(gossh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// sshKeyUserData returns the user data to boot the VM with. If InjectSSHKey is set, the SSH
+// public key is added to it as a cloud-config ssh_authorized_keys entry.
+func sshKeyUserData(vm *VM) ([]byte, error) {
+	if !vm.InjectSSHKey {
+		return vm.UserData, nil
+	}
+
+	publicKey, err := sshPublicKey(vm)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey == "" {
+		return nil, ErrNoSSHKey
+	}
+
+	userData := strings.TrimRight(string(vm.UserData), "\n")
+	if userData == "" {
+		userData = "#cloud-config"
+	}
+	if !strings.HasPrefix(userData, "#cloud-config") {
+		return nil, fmt.Errorf("unable to inject the SSH key: user data is not a cloud-config document")
+	}
+	if strings.Contains(userData, "ssh_authorized_keys:") {
+		return nil, fmt.Errorf("unable to inject the SSH key: user data already has ssh_authorized_keys")
+	}
+
+	return []byte(fmt.Sprintf("%s\nssh_authorized_keys:\n  - %s\n", userData, publicKey)), nil
+}
+
+// createKeyPair creates a transient key pair for the VM from its SSH public key if CreateKeyPair
+// is set, unless a key pair name is given, the key is injected through user data or there is no
+// SSH key.
+func createKeyPair(vm *VM, client *gophercloud.ServiceClient) error {
+	if !vm.CreateKeyPair || vm.KeyPairName != "" || vm.InjectSSHKey {
+		return nil
+	}
+
+	publicKey, err := sshPublicKey(vm)
+	if err != nil || publicKey == "" {
+		return err
+	}
+
+	name := fmt.Sprintf("libretto-%s-%d", vm.Name, time.Now().UnixNano())
+	_, err = keypairs.Create(client, keypairs.CreateOpts{
+		Name:      name,
+		PublicKey: publicKey,
+	}).Extract()
+	if err != nil {
+		return fmt.Errorf("unable to create a key pair: %s", err)
+	}
+
+	vm.KeyPairName = name
+	vm.KeyPairCreated = true
+	return nil
+}
+
+// deleteKeyPair deletes the key pair of the VM if it was created by Provision.
+func deleteKeyPair(vm *VM, client *gophercloud.ServiceClient) error {
+	if !vm.KeyPairCreated {
+		return nil
+	}
+
+	err := keypairs.Delete(client, vm.KeyPairName).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("unable to delete key pair: %s", err)
+	}
+
+	vm.KeyPairName = ""
+	vm.KeyPairCreated = false
+	return nil
+}
+
+// getConsoleURL returns the URL of the remote console of the given type for the server.
+func getConsoleURL(client *gophercloud.ServiceClient, serverID string, consoleType string) (string, error) {
+	var action string
+	switch consoleType {
+	case ConsoleNoVNC, ConsoleXVPVNC:
+		action = "os-getVNCConsole"
+	case ConsoleSpice:
+		action = "os-getSPICEConsole"
+	case ConsoleSerial:
+		action = "os-getSerialConsole"
+	default:
+		return "", fmt.Errorf("unknown console type %q", consoleType)
+	}
+
+	body := map[string]interface{}{
+		action: map[string]string{"type": consoleType},
+	}
+	var resp struct {
+		Console struct {
+			URL string `json:"url"`
+		} `json:"console"`
+	}
+	_, err := client.Post(client.ServiceURL("servers", serverID, "action"), body, &resp, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s console: %s", consoleType, err)
+	}
+
+	return resp.Console.URL, nil
+}
+
+// getConsoleOutput returns the last lines of the console log of the server, or the whole log
+// if lines is not positive.
+func getConsoleOutput(client *gophercloud.ServiceClient, serverID string, lines int) (string, error) {
+	opts := map[string]interface{}{}
+	if lines > 0 {
+		opts["length"] = lines
+	}
+
+	body := map[string]interface{}{
+		"os-getConsoleOutput": opts,
+	}
+	var resp struct {
+		Output string `json:"output"`
+	}
+	_, err := client.Post(client.ServiceURL("servers", serverID, "action"), body, &resp, &gophercloud.RequestOpts{
+		OkCodes: []int{200},
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to get console output: %s", err)
+	}
+
+	return resp.Output, nil
+}
+
+// faultError returns a FaultError with the fault of the server if it is in the ERROR state, or
+// nil otherwise.
+func faultError(vm *VM, state *ServerState) error {
+	if state.Status != StateError || state.Fault == nil {
+		return nil
+	}
+	return FaultError{InstanceID: vm.InstanceID, Fault: *state.Fault}
+}
+
+// parseAddresses returns the IPs of the server addresses, which map network names to lists of
+// addresses, in the order GetIPs returns them. Addresses without a type are fixed IPs, unless
+// they are the given floating IP. Networks are visited in the order of their names. The public
+// IP is nil if there is no floating IP.
+func parseAddresses(addresses map[string]interface{}, floatingIP string) []net.IP {
+	var names []string
+	for name := range addresses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var public net.IP
+	var fixed []net.IP
+	for _, name := range names {
+		addressSlice, _ := addresses[name].([]interface{})
+		for _, addressElement := range addressSlice {
+			addressBlock, ok := addressElement.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			addr, _ := addressBlock["addr"].(string)
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				continue
+			}
+
+			ipType, _ := addressBlock["OS-EXT-IPS:type"].(string)
+			if ipType == "floating" || (ipType == "" && addr == floatingIP) {
+				if public == nil {
+					public = ip
+				}
+				continue
+			}
+			fixed = append(fixed, ip)
+		}
+	}
+
+	ips := make([]net.IP, 2)
+	if len(fixed) > 0 {
+		ips[PrivateIP] = fixed[0]
+		ips = append(ips, fixed[1:]...)
+	}
+	ips[PublicIP] = public
+	return ips
+}
+
 // getServer returns the Openstack server object for the VM. An error is returned
 // if the instance ID is missing, if there was a problem querying Openstack, or if
 // there is no instances with the given VM ID.
@@ -355,6 +697,71 @@ func getServer(vm *VM) (*servers.Server, error) {
 	return status, nil
 }
 
+// dashboardEndpoint returns the base URL of the Horizon dashboard for the VM, without a trailing slash.
+func dashboardEndpoint(vm *VM) (string, error) {
+	if vm.DashboardEndpoint != "" {
+		return strings.TrimSuffix(vm.DashboardEndpoint, "/"), nil
+	}
+
+	identityEndpoint := vm.IdentityEndpoint
+	if identityEndpoint == "" {
+		identityEndpoint = os.Getenv("OS_AUTH_URL")
+	}
+
+	u, err := url.Parse(identityEndpoint)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("unable to derive the dashboard endpoint from identity endpoint %q", identityEndpoint)
+	}
+	return fmt.Sprintf("%s://%s/dashboard", u.Scheme, u.Hostname()), nil
+}
+
+// getServerState returns the detailed state of the Openstack server for the VM. An error is
+// returned if the instance ID is missing or if there was a problem querying Openstack.
+func getServerState(vm *VM) (*ServerState, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return nil, ErrNoInstanceID
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	var s struct {
+		Server *ServerState `json:"server"`
+	}
+	err = servers.Get(client, vm.InstanceID).ExtractInto(&s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the server state for VM: %s", err)
+	}
+
+	return s.Server, nil
+}
+
+// translateState maps the detailed state of an Openstack server to a libretto VM state.
+func translateState(state *ServerState) string {
+	switch state.Status {
+	case StateActive:
+		if state.TaskState != "" {
+			return lvm.VMPending
+		}
+		return lvm.VMRunning
+	case StateBuild:
+		return lvm.VMStarting
+	case StateReboot, StateHardReboot, StateRebuild, StateResize, StateVerifyResize,
+		StateRevertResize, StateMigrating, StatePassword, StateRescue:
+		return lvm.VMPending
+	case StatePaused, StateSuspended:
+		return lvm.VMSuspended
+	case StateShutOff, StateShelved, StateShelvedOffloaded:
+		return lvm.VMHalted
+	case StateError:
+		return lvm.VMError
+	}
+	return lvm.VMUnknown
+}
+
 // Finds the image endpoint in the given openstack Region. Region is passed within gophercloud.EndpointOpts
 func findImageEndpoint(client *gophercloud.ProviderClient, eo gophercloud.EndpointOpts) (string, error) {
 	eo.ApplyDefaults("image")
@@ -368,18 +775,21 @@ func findImageEndpoint(client *gophercloud.ProviderClient, eo gophercloud.Endpoi
 // Waits until the given VM becomes in requested state in given ActionTimeout seconds
 func waitUntil(vm *VM, state string) error {
 	var curState string
-	var err error
 	for i := 0; i < ActionTimeout; i++ {
-		curState, err = vm.GetState()
+		serverState, err := vm.GetServerState()
 		if err != nil {
 			return err
 		}
 
+		curState = translateState(serverState)
 		if curState == state {
 			break
 		}
 
 		if curState == lvm.VMError {
+			if err := faultError(vm, serverState); err != nil {
+				return err
+			}
 			return fmt.Errorf("failed to bring the VM to state: %s", state)
 		}
 
@@ -391,6 +801,56 @@ func waitUntil(vm *VM, state string) error {
 	return nil
 }
 
+// waitUntilStatus waits until the server of the VM reports the given status, such as RESCUE.
+func waitUntilStatus(vm *VM, status string) error {
+	for i := 0; i < ActionTimeout; i++ {
+		state, err := vm.GetServerState()
+		if err != nil {
+			return err
+		}
+
+		if state.Status == status && state.TaskState == "" {
+			return nil
+		}
+		if state.Status == StateError {
+			if err := faultError(vm, state); err != nil {
+				return err
+			}
+			return fmt.Errorf("failed to bring the VM to status: %s", status)
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+	return ErrActionTimeout
+}
+
+// rescueOpts adds the rescue image to the rescue options.
+type rescueOpts struct {
+	AdminPass string `json:"adminPass,omitempty"`
+	ImageRef  string `json:"rescue_image_ref,omitempty"`
+}
+
+// ToServerRescueMap formats the rescue options as a request body for the Rescue request.
+func (opts rescueOpts) ToServerRescueMap() (map[string]interface{}, error) {
+	return gophercloud.BuildRequestBody(opts, "rescue")
+}
+
+// waitUntilDeleted waits until the server with the given ID is deleted.
+func waitUntilDeleted(client *gophercloud.ServiceClient, serverID string) error {
+	for i := 0; i < ActionTimeout; i++ {
+		_, err := servers.Get(client, serverID).Extract()
+		if _, ok := err.(gophercloud.ErrDefault404); ok {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retrieve the server for VM: %s", err)
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+	return ErrActionTimeout
+}
+
 // Waits until the given VM becomes ready. Basically, waits until vm can be sshed.
 func waitUntilSSHReady(vm *VM) error {
 	client, err := vm.GetSSH(ssh.Options{})
@@ -407,19 +867,26 @@ func createAndAttachVolume(vm *VM) error {
 		return ErrNoInstanceID
 	}
 
-	cClient, err := getComputeClient(vm)
-	if err != nil {
-		return fmt.Errorf("compute client is not set for the VM, %s", err)
-	}
-
 	bsClient, err := getBlockStorageClient(vm)
 	if err != nil {
 		return err
 	}
 
-	// Creates a new Volume for this VM
+	// Creates a new Volume for this VM, populated from the source image or snapshot if any
 	volume := vm.Volume
-	vOpts := volumes.CreateOpts{Size: volume.Size, Name: volume.Name, VolumeType: volume.Type}
+	if volume.SourceImageID != "" && volume.SourceSnapshotID != "" {
+		return ErrVolumeSources
+	}
+	vOpts := multiattachCreateOpts{
+		CreateOpts: volumes.CreateOpts{
+			Size:       volume.Size,
+			Name:       volume.Name,
+			VolumeType: volume.Type,
+			ImageID:    volume.SourceImageID,
+			SnapshotID: volume.SourceSnapshotID,
+		},
+		Multiattach: volume.Multiattach,
+	}
 	vol, err := volumes.Create(bsClient, vOpts).Extract()
 	if err != nil {
 		return fmt.Errorf("failed to create a new volume for the VM: %s", err)
@@ -441,33 +908,80 @@ func createAndAttachVolume(vm *VM) error {
 	}
 
 	// Attach the new volume to this VM
-	vaOpts := volumeattach.CreateOpts{Device: volume.Device, VolumeID: vol.ID}
+	device, err := attachVolume(vm, vol.ID, volume.Device)
+	if err != nil {
+		return cleanup(err)
+	}
+
+	vm.Volume.ID = vol.ID
+	vm.Volume.Device = device
+
+	return nil
+}
+
+// attachVolume attaches the volume with the given ID to the given VM and waits until the volume
+// is in use. It returns the device the volume is attached as.
+func attachVolume(vm *VM, volumeID string, device string) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	if volumeID == "" {
+		return "", ErrNoVolumeID
+	}
+
+	cClient, err := getComputeClient(vm)
+	if err != nil {
+		return "", fmt.Errorf("compute client is not set for the VM, %s", err)
+	}
+
+	bsClient, err := getBlockStorageClient(vm)
+	if err != nil {
+		return "", err
+	}
+
+	// Multiattach volumes can only be attached with a recent microversion
+	multiattach, err := isMultiattach(bsClient, volumeID)
+	if err != nil {
+		return "", err
+	}
+	if multiattach && cClient.Microversion == "" {
+		multiattachClient := *cClient
+		multiattachClient.Microversion = multiattachMicroversion
+		cClient = &multiattachClient
+	}
+
+	// Attach the volume to this VM
+	vaOpts := volumeattach.CreateOpts{Device: device, VolumeID: volumeID}
 	va, err := volumeattach.Create(cClient, vm.InstanceID, vaOpts).Extract()
 	if err != nil {
-		return cleanup(fmt.Errorf("failed to attach the volume to the VM: %s", err))
+		return "", fmt.Errorf("failed to attach the volume to the VM: %s", err)
 	}
 
 	// Wait until Volume is attached to the VM
-	err = waitUntilVolume(bsClient, vol.ID, volumeStateInUse)
+	err = waitUntilVolumeAttachment(bsClient, volumeID, vm.InstanceID, true)
 	if err != nil {
-		errVaDelete := volumeattach.Delete(cClient, vm.InstanceID, vol.ID).ExtractErr()
+		errVaDelete := volumeattach.Delete(cClient, vm.InstanceID, volumeID).ExtractErr()
 		err = fmt.Errorf("%s %s", err, errVaDelete)
-		return cleanup(fmt.Errorf("failed to attach the volume to the VM: %s", err))
+		return "", fmt.Errorf("failed to attach the volume to the VM: %s", err)
 	}
 
-	vm.Volume.ID = vol.ID
-	vm.Volume.Device = va.Device
-
-	return nil
+	return va.Device, nil
 }
 
-// deattachAndDeleteVolume deattaches the volume from the given VM and then completely deletes the volume.
-func deattachAndDeleteVolume(vm *VM) error {
+// detachVolume detaches the volume with the given ID from the given VM and waits until the volume
+// becomes available again.
+func detachVolume(vm *VM, volumeID string) error {
 	if vm.InstanceID == "" {
 		// Probably need to call Provision first.
 		return ErrNoInstanceID
 	}
 
+	if volumeID == "" {
+		return ErrNoVolumeID
+	}
+
 	cClient, err := getComputeClient(vm)
 	if err != nil {
 		return fmt.Errorf("compute client is not set for the VM, %s", err)
@@ -479,17 +993,33 @@ func deattachAndDeleteVolume(vm *VM) error {
 	}
 
 	// Deattach the volume from the VM
-	err = volumeattach.Delete(cClient, vm.InstanceID, vm.Volume.ID).ExtractErr()
+	err = volumeattach.Delete(cClient, vm.InstanceID, volumeID).ExtractErr()
 	if err != nil {
 		return fmt.Errorf("failed to deattach volume from the VM: %s", err)
 	}
 
-	// Wait until Volume is de-attached from the VM
-	err = waitUntilVolume(bsClient, vm.Volume.ID, volumeStateAvailable)
+	// Wait until Volume is de-attached from the VM. Multiattach volumes may
+	// still be in use by other VMs.
+	err = waitUntilVolumeAttachment(bsClient, volumeID, vm.InstanceID, false)
 	if err != nil {
 		return fmt.Errorf("failed to deattach volume from the VM: %s", err)
 	}
 
+	return nil
+}
+
+// deattachAndDeleteVolume deattaches the volume from the given VM and then completely deletes the volume.
+func deattachAndDeleteVolume(vm *VM) error {
+	err := detachVolume(vm, vm.Volume.ID)
+	if err != nil {
+		return err
+	}
+
+	bsClient, err := getBlockStorageClient(vm)
+	if err != nil {
+		return err
+	}
+
 	// Delete the volume
 	err = volumes.Delete(bsClient, vm.Volume.ID).ExtractErr()
 	if err != nil {
@@ -566,6 +1096,79 @@ func waitUntilVolume(blockStorateClient *gophercloud.ServiceClient, volumeID str
 	return ErrActionTimeout
 }
 
+// waitUntilVolumeAttachment waits until the volume with the given ID is attached to or detached
+// from the given server and is no longer attaching or detaching.
+func waitUntilVolumeAttachment(blockStorateClient *gophercloud.ServiceClient, volumeID string, serverID string, attached bool) error {
+	for i := 0; i < VolumeActionTimeout; i++ {
+		vol, err := volumes.Get(blockStorateClient, volumeID).Extract()
+		if vol == nil || err != nil {
+			return fmt.Errorf("failed on getting volume Status: %s", err)
+		}
+		if vol.Status == lvm.VMError {
+			return fmt.Errorf("failed to attach or detach the volume, ended up at state %s", vol.Status)
+		}
+
+		found := false
+		for _, attachment := range vol.Attachments {
+			if id, _ := attachment["server_id"].(string); id == serverID {
+				found = true
+			}
+		}
+		if found == attached && (vol.Status == volumeStateInUse || vol.Status == volumeStateAvailable) {
+			return nil
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return ErrActionTimeout
+}
+
+// multiattachCreateOpts adds the multiattach option to the volume creation options.
+type multiattachCreateOpts struct {
+	volumes.CreateOpts
+	Multiattach bool
+}
+
+// ToVolumeCreateMap assembles a request body based on the contents of the creation options.
+func (opts multiattachCreateOpts) ToVolumeCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToVolumeCreateMap()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Multiattach {
+		b["volume"].(map[string]interface{})["multiattach"] = true
+	}
+	return b, nil
+}
+
+// isMultiattach returns whether the volume with the given ID can be attached to several servers.
+func isMultiattach(blockStorateClient *gophercloud.ServiceClient, volumeID string) (bool, error) {
+	var s struct {
+		Volume struct {
+			Multiattach bool `json:"multiattach"`
+		} `json:"volume"`
+	}
+	err := volumes.Get(blockStorateClient, volumeID).ExtractInto(&s)
+	if err != nil {
+		return false, fmt.Errorf("failed on getting volume: %s", err)
+	}
+	return s.Volume.Multiattach, nil
+}
+
+// hasVolumeSerial returns whether the output of lsblk -dn -o NAME,SERIAL lists a device with the
+// serial of the volume with the given ID. The serial may be truncated to 20 characters.
+func hasVolumeSerial(lsblk string, volumeID string) bool {
+	for _, line := range strings.Split(lsblk, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if serial := fields[1]; serial == volumeID || (len(serial) >= 20 && strings.HasPrefix(volumeID, serial)) {
+			return true
+		}
+	}
+	return false
+}
+
 // NewDefaultImageMetadata creates a ImageMetadata with default values
 func NewDefaultImageMetadata() ImageMetadata {
 	return ImageMetadata{
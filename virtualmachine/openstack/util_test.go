@@ -0,0 +1,125 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package openstack
+
+import (
+	"testing"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
+)
+
+// TestTranslateState tests that Openstack server states are mapped to the
+// expected libretto VM states.
+func TestTranslateState(t *testing.T) {
+	tests := []struct {
+		state    ServerState
+		expected string
+	}{
+		{ServerState{Status: StateActive}, lvm.VMRunning},
+		{ServerState{Status: StateActive, TaskState: "powering-off"}, lvm.VMPending},
+		{ServerState{Status: StateBuild, TaskState: "spawning"}, lvm.VMStarting},
+		{ServerState{Status: StateReboot}, lvm.VMPending},
+		{ServerState{Status: StateHardReboot}, lvm.VMPending},
+		{ServerState{Status: StateResize}, lvm.VMPending},
+		{ServerState{Status: StateVerifyResize}, lvm.VMPending},
+		{ServerState{Status: StateRescue}, lvm.VMPending},
+		{ServerState{Status: StatePaused}, lvm.VMSuspended},
+		{ServerState{Status: StateSuspended}, lvm.VMSuspended},
+		{ServerState{Status: StateShutOff}, lvm.VMHalted},
+		{ServerState{Status: StateShelved}, lvm.VMHalted},
+		{ServerState{Status: StateShelvedOffloaded}, lvm.VMHalted},
+		{ServerState{Status: StateError}, lvm.VMError},
+		{ServerState{Status: "DELETED"}, lvm.VMUnknown},
+	}
+
+	for _, test := range tests {
+		state := test.state
+		if s := translateState(&state); s != test.expected {
+			t.Fatalf("Expected state %q for %+v, got %q", test.expected, test.state, s)
+		}
+	}
+}
+
+// TestSSHKeyUserData tests that the SSH public key is appended to cloud-config
+// user data and that other user data is rejected.
+func TestSSHKeyUserData(t *testing.T) {
+	key := "ssh-rsa AAAA test@libretto"
+	vm := &VM{SSHPublicKey: key, InjectSSHKey: true}
+
+	userData, err := sshKeyUserData(vm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "#cloud-config\nssh_authorized_keys:\n  - " + key + "\n"
+	if string(userData) != expected {
+		t.Fatalf("Expected user data %q, got %q", expected, userData)
+	}
+
+	vm.UserData = []byte("#cloud-config\npackages:\n  - git\n")
+	userData, err = sshKeyUserData(vm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected = "#cloud-config\npackages:\n  - git\nssh_authorized_keys:\n  - " + key + "\n"
+	if string(userData) != expected {
+		t.Fatalf("Expected user data %q, got %q", expected, userData)
+	}
+
+	vm.UserData = []byte("#!/bin/sh\necho hello\n")
+	if _, err = sshKeyUserData(vm); err == nil {
+		t.Fatal("Expected an error for a shell script")
+	}
+}
+
+// TestParseAddresses tests that server addresses are returned in the order
+// GetIPs documents, with or without the address type extension.
+func TestParseAddresses(t *testing.T) {
+	addresses := map[string]interface{}{
+		"private": []interface{}{
+			map[string]interface{}{"addr": "10.0.0.5", "OS-EXT-IPS:type": "fixed"},
+			map[string]interface{}{"addr": "10.0.0.6", "OS-EXT-IPS:type": "fixed"},
+			map[string]interface{}{"addr": "172.24.4.10", "OS-EXT-IPS:type": "floating"},
+		},
+	}
+	ips := parseAddresses(addresses, "")
+	expected := []string{"172.24.4.10", "10.0.0.5", "10.0.0.6"}
+	if len(ips) != len(expected) {
+		t.Fatalf("Expected %d IPs, got %v", len(expected), ips)
+	}
+	for i, ip := range expected {
+		if ips[i].String() != ip {
+			t.Fatalf("Expected IP %s at index %d, got %s", ip, i, ips[i])
+		}
+	}
+
+	// Provider network without the extension and without a floating IP
+	addresses = map[string]interface{}{
+		"provider": []interface{}{
+			map[string]interface{}{"addr": "203.0.113.7", "version": 4},
+		},
+	}
+	ips = parseAddresses(addresses, "")
+	if ips[PublicIP] != nil || ips[PrivateIP].String() != "203.0.113.7" {
+		t.Fatalf("Expected no public IP and the fixed IP as private IP, got %v", ips)
+	}
+}
+
+// TestFilterFlavors tests that flavors are filtered and sorted from the
+// smallest to the largest.
+func TestFilterFlavors(t *testing.T) {
+	flavorList := []flavors.Flavor{
+		{ID: "3", Name: "m1.large", VCPUs: 4, RAM: 8192, Disk: 80},
+		{ID: "1", Name: "m1.tiny", VCPUs: 1, RAM: 512, Disk: 1},
+		{ID: "5", Name: "r1.medium", VCPUs: 2, RAM: 16384, Disk: 40},
+		{ID: "2", Name: "m1.medium", VCPUs: 2, RAM: 4096, Disk: 40},
+	}
+
+	result := filterFlavors(flavorList, FlavorFilter{MinRAM: 8192})
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 flavors, got %+v", result)
+	}
+	if result[0].Name != "r1.medium" || result[1].Name != "m1.large" {
+		t.Fatalf("Expected r1.medium and m1.large, got %+v", result)
+	}
+}
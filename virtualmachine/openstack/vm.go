@@ -3,6 +3,7 @@
 package openstack
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,15 +15,21 @@ import (
 	lvm "github.com/apcera/libretto/virtualmachine"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/floatingips"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/keypairs"
+	pu "github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/pauseunpause"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/schedulerhints"
 	ss "github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/startstop"
+	sr "github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/suspendresume"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/flavors"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
-	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 )
 
 // Compiler will complain if openstack.VM doesn't implement VirtualMachine interface.
 var _ lvm.VirtualMachine = (*VM)(nil)
 
+// Compiler will complain if openstack.VM doesn't implement DashboardLinker interface.
+var _ lvm.DashboardLinker = (*VM)(nil)
+
 var (
 	// ErrAuthOptions is returned if the credentials are not set properly as a environment variable
 	ErrAuthOptions = errors.New("Openstack credentials (username and password) are not set properly")
@@ -46,6 +53,14 @@ var (
 	ErrActionTimeout = errors.New("Openstack action timeout")
 	// ErrNoIPs is returned when no IP addresses are found for an instance.
 	ErrNoIPs = errors.New("No IPs found for instance")
+	// ErrNoVolumeID is returned when attempting to perform an operation on a volume, but the ID is missing.
+	ErrNoVolumeID = errors.New("Missing volume ID")
+	// ErrNoImageID is returned when the image ID is missing.
+	ErrNoImageID = errors.New("Missing image ID")
+	// ErrNoSSHKey is returned when an SSH key is to be injected but none is set.
+	ErrNoSSHKey = errors.New("Missing SSH public key or private key")
+	// ErrVolumeSources is returned when a volume has both a source image and a source snapshot.
+	ErrVolumeSources = errors.New("Volume can not have both a source image and a source snapshot")
 )
 
 const (
@@ -70,6 +85,44 @@ const (
 	StateShutOff = "SHUTOFF"
 	// StateError is the state Openstack reports when the given action fails on VM.
 	StateError = "ERROR"
+	// StateBuild is the state Openstack reports when the VM is being built.
+	StateBuild = "BUILD"
+	// StateReboot is the state Openstack reports when the VM is soft rebooting.
+	StateReboot = "REBOOT"
+	// StateHardReboot is the state Openstack reports when the VM is hard rebooting.
+	StateHardReboot = "HARD_REBOOT"
+	// StateRebuild is the state Openstack reports when the VM is being rebuilt.
+	StateRebuild = "REBUILD"
+	// StateResize is the state Openstack reports when the VM is being resized or migrated.
+	StateResize = "RESIZE"
+	// StateVerifyResize is the state Openstack reports when a resize waits for confirmation.
+	StateVerifyResize = "VERIFY_RESIZE"
+	// StateRevertResize is the state Openstack reports when a resize is being reverted.
+	StateRevertResize = "REVERT_RESIZE"
+	// StateMigrating is the state Openstack reports when the VM is being live migrated.
+	StateMigrating = "MIGRATING"
+	// StatePassword is the state Openstack reports when the admin password is being changed.
+	StatePassword = "PASSWORD"
+	// StatePaused is the state Openstack reports when the VM is paused.
+	StatePaused = "PAUSED"
+	// StateSuspended is the state Openstack reports when the VM is suspended.
+	StateSuspended = "SUSPENDED"
+	// StateShelved is the state Openstack reports when the VM is shelved.
+	StateShelved = "SHELVED"
+	// StateShelvedOffloaded is the state Openstack reports when the VM is shelved and
+	// removed from its hypervisor.
+	StateShelvedOffloaded = "SHELVED_OFFLOADED"
+	// StateRescue is the state Openstack reports when the VM is in rescue mode.
+	StateRescue = "RESCUE"
+
+	// ConsoleNoVNC is the console type of the noVNC web console.
+	ConsoleNoVNC = "novnc"
+	// ConsoleXVPVNC is the console type of the XVP VNC console.
+	ConsoleXVPVNC = "xvpvnc"
+	// ConsoleSpice is the console type of the SPICE HTML5 web console.
+	ConsoleSpice = "spice-html5"
+	// ConsoleSerial is the console type of the websocket serial console.
+	ConsoleSerial = "serial"
 
 	// volumeStateAvailable is the state Openstack reports when the volume is created
 	volumeStateAvailable = "available"
@@ -81,6 +134,10 @@ const (
 	volumeStateErrorDeleting = "error_deleting"
 	// imageQueued is the state Openstack reports when the image is first created
 	imageQueued = "queued"
+
+	// multiattachMicroversion is the compute API microversion required to attach
+	// multiattach volumes.
+	multiattachMicroversion = "2.60"
 )
 
 // SSHTimeout is the maximum time to wait before failing to GetSSH. This is not
@@ -113,6 +170,63 @@ type Volume struct {
 	Size int
 	// Type represents the ID of the volume type that will be attached to this VM
 	Type string
+	// SourceImageID [optional] is the ID of a Glance image to populate the new volume from.
+	// Size must be at least the size of the image.
+	SourceImageID string
+	// SourceSnapshotID [optional] is the ID of a Cinder snapshot to populate the new volume
+	// from. Size must be at least the size of the snapshot.
+	SourceSnapshotID string
+	// Multiattach creates a volume which can be attached to several VMs at once. On clouds
+	// which only support multiattach volume types, Type must be such a type instead.
+	Multiattach bool
+	// WaitForDevice makes Provision wait until the volume is visible in the guest, as a
+	// volume in use may not be attached in the guest yet.
+	WaitForDevice bool
+	// Existing is set when the volume was not created by libretto, i.e. ID was given
+	// before provisioning. An existing volume is detached, but not deleted, on Destroy.
+	Existing bool
+}
+
+// ServerState represents the detailed state of an Openstack instance.
+type ServerState struct {
+	// Status is the status of the instance, such as ACTIVE or BUILD.
+	Status string `json:"status"`
+	// TaskState is the task in progress on the instance, such as "spawning".
+	// It is empty when no task is in progress.
+	TaskState string `json:"OS-EXT-STS:task_state"`
+	// VMState is the state of the instance as tracked by Nova, such as "active".
+	VMState string `json:"OS-EXT-STS:vm_state"`
+	// PowerState is the power state of the instance as reported by the hypervisor.
+	// 0 is "no state", 1 running, 3 paused, 4 shutdown, 6 crashed and 7 suspended.
+	PowerState int `json:"OS-EXT-STS:power_state"`
+	// Fault is the last fault of the instance. It is only reported in the ERROR state.
+	Fault *ServerFault `json:"fault"`
+}
+
+// ServerFault represents the fault Openstack records when an instance fails.
+type ServerFault struct {
+	// Code is the HTTP status code of the fault, such as 500.
+	Code int `json:"code"`
+	// Message is the reason of the fault, such as "No valid host was found".
+	Message string `json:"message"`
+	// Details contains further details, such as a stack trace. It is only
+	// reported to administrators.
+	Details string `json:"details"`
+	// Created is the time the fault occurred, in ISO 8601 format.
+	Created string `json:"created"`
+}
+
+// FaultError is returned when an instance is in the ERROR state. It tells you
+// why the instance failed.
+type FaultError struct {
+	InstanceID string
+	Fault      ServerFault
+}
+
+// Error returns a summarized string version of FaultError. More details about
+// the fault can be accessed through the struct.
+func (e FaultError) Error() string {
+	return fmt.Sprintf("instance %s failed: %s (code %d)", e.InstanceID, e.Fault.Message, e.Fault.Code)
 }
 
 // VM represents an Openstack EC2 virtual machine.
@@ -127,10 +241,33 @@ type VM struct {
 	Region string
 	// TenantName represents the Openstack tenant name that this VM belnogs to
 	TenantName string
+	// DashboardEndpoint [optional] is the base URL of the Horizon dashboard. If not
+	// set, it is assumed to be served under /dashboard on the identity endpoint host.
+	DashboardEndpoint string
+
+	// CACertFile [optional] is the path of a PEM encoded CA bundle to verify the
+	// Openstack endpoints with. Defaults to the OS_CACERT environment variable.
+	CACertFile string
+	// ClientCertFile [optional] is the path of a PEM encoded client certificate to
+	// authenticate to the Openstack endpoints with. Defaults to the OS_CERT
+	// environment variable.
+	ClientCertFile string
+	// ClientKeyFile [optional] is the path of the PEM encoded private key of the
+	// client certificate. Defaults to the OS_KEY environment variable.
+	ClientKeyFile string
+	// Insecure allows connecting to the Openstack endpoints without cert
+	// validation when set to true.
+	Insecure bool
+	// ComputeMicroversion [optional] is the compute API microversion to request,
+	// such as "2.60". The base version is used if it is not set.
+	ComputeMicroversion string
 
 	// FlavorName represents the flavor that will be used by th VM.
 	FlavorName string
 
+	// AvailabilityZone [optional] is the availability zone to launch the VM in.
+	AvailabilityZone string
+
 	// ImageID represents the image that will be used (or being used) by the VM
 	ImageID string
 	// Metadata contains the necessary image upload information (metadata and path)
@@ -138,6 +275,12 @@ type VM struct {
 	ImageMetadata ImageMetadata
 	// ImagePath is the path that Image will be read from
 	ImagePath string
+	// ImageCreated is set when the image was uploaded from ImagePath by Provision,
+	// i.e. the image is owned by this VM.
+	ImageCreated bool
+	// DeleteImageOnDestroy deletes the image on Destroy if it was uploaded by
+	// Provision. Images which already existed are never deleted.
+	DeleteImageOnDestroy bool
 
 	// Volume represents the volume that will be attached to this VM on provision.
 	Volume Volume
@@ -150,6 +293,9 @@ type VM struct {
 
 	// List of network UUIDs that this VM will be attached to
 	Networks []string
+	// PrivateNetwork [optional] is created on Provision and the VM attached to it if
+	// Networks is empty, e.g. in empty projects. It is deleted on Destroy.
+	PrivateNetwork *PrivateNetwork
 
 	// Pool to choose a floating IP for this VM, it is required to assign an external IP
 	// to the VM.
@@ -168,6 +314,31 @@ type VM struct {
 	// will be created by OpenStack API.
 	AdminPassword string
 
+	// SSHPublicKey [optional] is the OpenSSH formatted public key authorized to log in to the VM.
+	// If it is not set, it is derived from Credentials.SSHPrivateKey when a key pair needs to
+	// be created.
+	SSHPublicKey string
+	// KeyPairName [optional] is the name of an existing Nova key pair to boot the VM with.
+	KeyPairName string
+	// CreateKeyPair creates a transient key pair from the SSH public key on Provision, unless
+	// KeyPairName is set, and deletes it on Destroy.
+	CreateKeyPair bool
+	// KeyPairCreated is set when the key pair was created by Provision.
+	KeyPairCreated bool
+	// InjectSSHKey injects the SSH public key through cloud-init user data instead of a Nova
+	// key pair. UserData must be empty or a #cloud-config document without ssh_authorized_keys.
+	InjectSSHKey bool
+
+	// Trunk [optional] attaches the VM to a trunk port, in addition to Networks, to receive
+	// tagged VLAN traffic. The trunk and its ports are created on Provision and deleted on
+	// Destroy.
+	Trunk *Trunk
+
+	// ServerGroupID [optional] is the ID of the Nova server group to place the VM in.
+	ServerGroupID string
+	// DeleteEmptyServerGroup deletes the server group on Destroy if the VM was its last member.
+	DeleteEmptyServerGroup bool
+
 	// Credentials are the credentials to use when connecting to the VM over SSH
 	Credentials ssh.Credentials
 
@@ -185,58 +356,96 @@ func (vm *VM) MarshalJSON() ([]byte, error) {
 	type (
 		// credsAlias prevents a mutex in ssh.Credentials from being copied.
 		credsAlias struct {
-			SSHUser       string
-			SSHPassword   string
-			SSHPrivateKey string
+			SSHUser        string
+			SSHPassword    string
+			SSHPrivateKey  string
+			SSHCertificate string
 		}
 		vmAlias struct {
-			IdentityEndpoint string
-			Username         string
-			Password         string
-			Region           string
-			TenantName       string
-			FlavorName       string
-			ImageID          string
-			ImageMetadata    ImageMetadata
-			ImagePath        string
-			Volume           Volume
-			InstanceID       string
-			Name             string
-			Networks         []string
-			FloatingIPPool   string
-			FloatingIP       *floatingips.FloatingIP
-			SecurityGroup    string
-			UserData         []byte
-			AdminPassword    string
-			Credentials      credsAlias
+			IdentityEndpoint       string
+			Username               string
+			Password               string
+			Region                 string
+			TenantName             string
+			DashboardEndpoint      string
+			CACertFile             string
+			ClientCertFile         string
+			ClientKeyFile          string
+			Insecure               bool
+			ComputeMicroversion    string
+			FlavorName             string
+			AvailabilityZone       string
+			ImageID                string
+			ImageMetadata          ImageMetadata
+			ImagePath              string
+			ImageCreated           bool
+			DeleteImageOnDestroy   bool
+			Volume                 Volume
+			InstanceID             string
+			Name                   string
+			Networks               []string
+			PrivateNetwork         *PrivateNetwork
+			FloatingIPPool         string
+			FloatingIP             *floatingips.FloatingIP
+			SecurityGroup          string
+			UserData               []byte
+			AdminPassword          string
+			SSHPublicKey           string
+			KeyPairName            string
+			CreateKeyPair          bool
+			KeyPairCreated         bool
+			InjectSSHKey           bool
+			Trunk                  *Trunk
+			ServerGroupID          string
+			DeleteEmptyServerGroup bool
+			Credentials            credsAlias
 		}
 	)
 
 	// Creating the alias in this way avoids copying the mutex in
 	// ssh.Credentials, which go vet doesn't like.
 	alias := vmAlias{
-		IdentityEndpoint: vm.IdentityEndpoint,
-		Username:         vm.Username,
-		Password:         vm.Password,
-		Region:           vm.Region,
-		TenantName:       vm.TenantName,
-		FlavorName:       vm.FlavorName,
-		ImageID:          vm.ImageID,
-		ImageMetadata:    vm.ImageMetadata,
-		ImagePath:        vm.ImagePath,
-		Volume:           vm.Volume,
-		InstanceID:       vm.InstanceID,
-		Name:             vm.Name,
-		Networks:         vm.Networks,
-		FloatingIPPool:   vm.FloatingIPPool,
-		FloatingIP:       vm.FloatingIP,
-		SecurityGroup:    vm.SecurityGroup,
-		UserData:         vm.UserData,
-		AdminPassword:    vm.AdminPassword,
+		IdentityEndpoint:       vm.IdentityEndpoint,
+		Username:               vm.Username,
+		Password:               vm.Password,
+		Region:                 vm.Region,
+		TenantName:             vm.TenantName,
+		DashboardEndpoint:      vm.DashboardEndpoint,
+		CACertFile:             vm.CACertFile,
+		ClientCertFile:         vm.ClientCertFile,
+		ClientKeyFile:          vm.ClientKeyFile,
+		Insecure:               vm.Insecure,
+		ComputeMicroversion:    vm.ComputeMicroversion,
+		FlavorName:             vm.FlavorName,
+		AvailabilityZone:       vm.AvailabilityZone,
+		ImageID:                vm.ImageID,
+		ImageMetadata:          vm.ImageMetadata,
+		ImagePath:              vm.ImagePath,
+		ImageCreated:           vm.ImageCreated,
+		DeleteImageOnDestroy:   vm.DeleteImageOnDestroy,
+		Volume:                 vm.Volume,
+		InstanceID:             vm.InstanceID,
+		Name:                   vm.Name,
+		Networks:               vm.Networks,
+		PrivateNetwork:         vm.PrivateNetwork,
+		FloatingIPPool:         vm.FloatingIPPool,
+		FloatingIP:             vm.FloatingIP,
+		SecurityGroup:          vm.SecurityGroup,
+		UserData:               vm.UserData,
+		AdminPassword:          vm.AdminPassword,
+		SSHPublicKey:           vm.SSHPublicKey,
+		KeyPairName:            vm.KeyPairName,
+		CreateKeyPair:          vm.CreateKeyPair,
+		KeyPairCreated:         vm.KeyPairCreated,
+		InjectSSHKey:           vm.InjectSSHKey,
+		Trunk:                  vm.Trunk,
+		ServerGroupID:          vm.ServerGroupID,
+		DeleteEmptyServerGroup: vm.DeleteEmptyServerGroup,
 		Credentials: credsAlias{
-			SSHUser:       vm.Credentials.SSHUser,
-			SSHPassword:   vm.Credentials.SSHPassword,
-			SSHPrivateKey: vm.Credentials.SSHPrivateKey,
+			SSHUser:        vm.Credentials.SSHUser,
+			SSHPassword:    vm.Credentials.SSHPassword,
+			SSHPrivateKey:  vm.Credentials.SSHPrivateKey,
+			SSHCertificate: vm.Credentials.SSHCertificate,
 		},
 	}
 
@@ -295,6 +504,7 @@ func (vm *VM) Provision() error {
 			if err != nil {
 				return err
 			}
+			vm.ImageCreated = true
 		}
 		vm.ImageID = imageID
 	} else {
@@ -312,19 +522,72 @@ func (vm *VM) Provision() error {
 		listOfNetworks = append(listOfNetworks, servers.Network{UUID: networkID})
 	}
 
+	userData, err := sshKeyUserData(vm)
+	if err != nil {
+		return err
+	}
+
+	// Release the network resources and the key pair if the server is not created
+	var release = func(err error) error {
+		if errKeyPair := deleteKeyPair(vm, client); errKeyPair != nil {
+			err = fmt.Errorf("%s %s", err, errKeyPair)
+		}
+		if errTrunk := deleteTrunk(vm); errTrunk != nil {
+			err = fmt.Errorf("%s %s", err, errTrunk)
+		}
+		if errNetwork := deletePrivateNetwork(vm); errNetwork != nil {
+			err = fmt.Errorf("%s %s", err, errNetwork)
+		}
+		return err
+	}
+
+	// Create a private network if no network is given
+	if len(vm.Networks) == 0 && vm.PrivateNetwork != nil {
+		err = createPrivateNetwork(vm)
+		if err != nil {
+			return err
+		}
+		listOfNetworks = append(listOfNetworks, servers.Network{UUID: vm.PrivateNetwork.NetworkID})
+	}
+
+	// Attach the VM to its trunk port, if there is one
+	if vm.Trunk != nil {
+		err = createTrunk(vm)
+		if err != nil {
+			return release(err)
+		}
+		listOfNetworks = append(listOfNetworks, servers.Network{Port: vm.Trunk.PortID})
+	}
+
 	createOpts := servers.CreateOpts{
-		Name:           vm.Name,
-		FlavorRef:      flavorID,
-		ImageRef:       imageID,
-		Networks:       listOfNetworks,
-		SecurityGroups: []string{securityGroup},
-		UserData:       vm.UserData,
-		AdminPass:      vm.AdminPassword,
+		Name:             vm.Name,
+		FlavorRef:        flavorID,
+		ImageRef:         imageID,
+		Networks:         listOfNetworks,
+		SecurityGroups:   []string{securityGroup},
+		UserData:         userData,
+		AdminPass:        vm.AdminPassword,
+		AvailabilityZone: vm.AvailabilityZone,
 	}
 
-	server, err := servers.Create(client, createOpts).Extract()
+	// Boot with a key pair, creating a transient one if none is given
+	err = createKeyPair(vm, client)
 	if err != nil {
-		return err
+		return release(err)
+	}
+
+	// Place the VM in its server group, if there is one
+	schedulerOpts := schedulerhints.CreateOptsExt{
+		CreateOptsBuilder: createOpts,
+		SchedulerHints:    schedulerhints.SchedulerHints{Group: vm.ServerGroupID},
+	}
+
+	server, err := servers.Create(client, keypairs.CreateOptsExt{
+		CreateOptsBuilder: schedulerOpts,
+		KeyName:           vm.KeyPairName,
+	}).Extract()
+	if err != nil {
+		return release(err)
 	}
 
 	// Cleanup VM if something goes wrong
@@ -371,20 +634,38 @@ func (vm *VM) Provision() error {
 		return cleanup(err)
 	}
 
-	// Create and attach a volume to this VM, if the volume size is > 0
-	if vm.Volume.Size > 0 {
+	// Attach the existing volume if an ID is given, otherwise create and attach
+	// a volume to this VM, if the volume size is > 0
+	if vm.Volume.ID != "" {
+		vm.Volume.Existing = true
+		device, err := attachVolume(vm, vm.Volume.ID, vm.Volume.Device)
+		if err != nil {
+			return cleanup(err)
+		}
+		vm.Volume.Device = device
+	} else if vm.Volume.Size > 0 {
 		err = createAndAttachVolume(vm)
 		if err != nil {
 			return cleanup(err)
 		}
 	}
 
+	// Wait until the volume shows up in the guest
+	if vm.Volume.ID != "" && vm.Volume.WaitForDevice {
+		err = vm.WaitForVolumeDevice(vm.Volume.ID)
+		if err != nil {
+			return cleanup(err)
+		}
+	}
+
 	return nil
 }
 
 // GetIPs returns a slice of IP addresses assigned to the VM. The PublicIP or
-// PrivateIP consts can be used to retrieve respective IP address type. It
-// returns nil if there was an error obtaining the IPs.
+// PrivateIP consts can be used to retrieve respective IP address type. Any
+// further fixed IPs follow them. The public IP is nil if the VM has no
+// floating IP, such as on provider networks. It returns nil if there was an
+// error obtaining the IPs.
 func (vm *VM) GetIPs() ([]net.IP, error) {
 	server, err := getServer(vm)
 	if server == nil || err != nil {
@@ -392,33 +673,11 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 		return nil, err
 	}
 
-	client, err := getNetworkClient(vm)
-	if client == nil || err != nil {
-		// Probably need to create some network first.
-		return nil, err
-	}
-	ips := make([]net.IP, 2)
-	for _, networkID := range vm.Networks {
-		network, err := networks.Get(client, networkID).Extract()
-		if err != nil {
-			return nil, err
-		}
-
-		addressSlice := server.Addresses[network.Name].([]interface{})
-		for _, addressElement := range addressSlice {
-			addressBlock := addressElement.(map[string]interface{})
-			ipType := addressBlock["OS-EXT-IPS:type"].(string)
-			ip := addressBlock["addr"].(string)
-			if ipType == "floating" {
-				ips[PublicIP] = net.ParseIP(ip)
-			}
-			if ipType == "fixed" {
-				ips[PrivateIP] = net.ParseIP(ip)
-			}
-		}
+	var floatingIP string
+	if vm.FloatingIP != nil {
+		floatingIP = vm.FloatingIP.IP
 	}
-
-	return ips, nil
+	return parseAddresses(server.Addresses, floatingIP), nil
 }
 
 // Destroy terminates the VM on Openstack. It returns an error if there is no instance ID.
@@ -448,9 +707,14 @@ func (vm *VM) Destroy() error {
 		}
 	}
 
-	// De-attach and delete the volume, if there is an attached one
+	// De-attach and delete the volume, if there is an attached one. Existing
+	// volumes are only de-attached.
 	if vm.Volume.ID != "" {
-		err = deattachAndDeleteVolume(vm)
+		if vm.Volume.Existing {
+			err = detachVolume(vm, vm.Volume.ID)
+		} else {
+			err = deattachAndDeleteVolume(vm)
+		}
 		if err != nil {
 			errors = append(errors, err)
 		}
@@ -462,6 +726,45 @@ func (vm *VM) Destroy() error {
 		errors = append(errors, err)
 	}
 
+	// Delete the trunk and the private network once the instance is gone, as
+	// they can not be deleted while in use
+	hasTrunk := vm.Trunk != nil && vm.Trunk.PortID != ""
+	hasPrivateNetwork := vm.PrivateNetwork != nil && vm.PrivateNetwork.NetworkID != ""
+	if hasTrunk || hasPrivateNetwork {
+		err = waitUntilDeleted(client, vm.InstanceID)
+		if err == nil {
+			err = deleteTrunk(vm)
+		}
+		if err == nil {
+			err = deletePrivateNetwork(vm)
+		}
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	// Delete the server group, if the VM was its last member
+	if vm.ServerGroupID != "" && vm.DeleteEmptyServerGroup {
+		err = deleteEmptyServerGroup(vm, client)
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+
+	// Delete the transient key pair, if there is one
+	err = deleteKeyPair(vm, client)
+	if err != nil {
+		errors = append(errors, err)
+	}
+
+	// Delete the image, if it was uploaded by Provision and is not to be kept
+	if vm.ImageCreated && vm.DeleteImageOnDestroy {
+		err = vm.DeleteImage()
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+
 	// Return all the errors
 	var returnedErr error
 	if len(errors) > 0 {
@@ -479,40 +782,172 @@ func (vm *VM) Destroy() error {
 	return returnedErr
 }
 
-// GetSSH returns an SSH client that can be used to connect to a VM. An error is
-// returned if the VM has no IPs.
+// AttachVolume attaches the existing volume with the given ID to the VM as the
+// given device, or as an automatically chosen device if device is empty. It
+// waits until the volume is in use and returns the device the volume is
+// attached as.
+func (vm *VM) AttachVolume(volumeID string, device string) (string, error) {
+	return attachVolume(vm, volumeID, device)
+}
+
+// WaitForVolumeDevice waits until the attached volume with the given ID is
+// visible as a block device in the guest, checking its serial with lsblk over
+// SSH. It returns ErrActionTimeout if the device does not show up in time.
+func (vm *VM) WaitForVolumeDevice(volumeID string) error {
+	if volumeID == "" {
+		return ErrNoVolumeID
+	}
+
+	client, err := vm.GetSSH(ssh.Options{})
+	if err != nil {
+		return err
+	}
+	if err = client.Connect(); err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	for i := 0; i < VolumeActionTimeout; i++ {
+		var stdout bytes.Buffer
+		err = client.Run("lsblk -dn -o NAME,SERIAL", &stdout, &bytes.Buffer{})
+		if err == nil && hasVolumeSerial(stdout.String(), volumeID) {
+			return nil
+		}
+		time.Sleep(1 * time.Second)
+	}
+	return ErrActionTimeout
+}
+
+// DetachVolume detaches the volume with the given ID from the VM and waits until
+// the volume is available, so that it can be attached to another VM. The volume
+// is not deleted. If the volume is the one in vm.Volume, vm.Volume is reset so
+// that Destroy no longer touches it.
+func (vm *VM) DetachVolume(volumeID string) error {
+	if err := detachVolume(vm, volumeID); err != nil {
+		return err
+	}
+
+	if vm.Volume.ID == volumeID {
+		vm.Volume.ID = ""
+		vm.Volume.Device = ""
+		vm.Volume.Existing = false
+	}
+	return nil
+}
+
+// GetDashboardURL returns the URL of the instance details page in the Horizon
+// dashboard. An error is returned if the instance ID is missing.
+func (vm *VM) GetDashboardURL() (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	dashboard, err := dashboardEndpoint(vm)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/project/instances/%s/", dashboard, vm.InstanceID), nil
+}
+
+// GetConsoleURL returns the URL of a remote console of the given type, such as
+// ConsoleNoVNC or ConsoleSerial, to reach the VM without SSH. An error is
+// returned if the instance ID is missing or if the console type is not
+// supported by the cloud.
+func (vm *VM) GetConsoleURL(consoleType string) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return "", fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	return getConsoleURL(client, vm.InstanceID, consoleType)
+}
+
+// GetConsoleOutput returns the last lines of the console log of the VM, or the
+// whole log if lines is not positive. An error is returned if the instance ID
+// is missing.
+func (vm *VM) GetConsoleOutput(lines int) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return "", fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	return getConsoleOutput(client, vm.InstanceID, lines)
+}
+
+// GetSSH returns an SSH client that can be used to connect to a VM, through its
+// public IP, or its private IP if it has no floating IP. An error is returned
+// if the VM has no IPs.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
 		return nil, err
 	}
 
-	client := ssh.SSHClient{Creds: &vm.Credentials, IP: ips[PublicIP], Port: 22, Options: options}
+	ip := ips[PublicIP]
+	if ip == nil && len(ips) > PrivateIP {
+		ip = ips[PrivateIP]
+	}
+	if ip == nil {
+		return nil, lvm.ErrVMNoIP
+	}
+
+	client := ssh.SSHClient{Creds: &vm.Credentials, IP: ip, Port: 22, Options: options}
 	return &client, nil
 }
 
-// GetState returns the state of the VM, such as "ACTIVE". An error is returned
-// if the instance ID is missing, if there was a problem querying Openstack, or if
-// there are no instances.
+// GetState returns the state of the VM, such as "running". Transitional
+// states, such as BUILD or REBOOT, and ACTIVE instances with a task in
+// progress are reported as "starting" or "pending". Use GetServerState for the
+// states reported by Openstack. An error is returned if the instance ID is
+// missing, if there was a problem querying Openstack, or if there are no
+// instances.
 func (vm *VM) GetState() (string, error) {
-	server, err := getServer(vm)
+	state, err := vm.GetServerState()
 	if err != nil {
 		return "", err
 	}
 
-	if server == nil {
+	return translateState(state), nil
+}
+
+// GetServerState returns the detailed state Openstack reports for the VM,
+// including the task and power states. An error is returned if the instance ID
+// is missing, if there was a problem querying Openstack, or if there are no
+// instances.
+func (vm *VM) GetServerState() (*ServerState, error) {
+	state, err := getServerState(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil {
 		// VM state "unknown"
-		return "", lvm.ErrVMInfoFailed
+		return nil, lvm.ErrVMInfoFailed
 	}
+	return state, nil
+}
 
-	if server.Status == StateActive {
-		return lvm.VMRunning, nil
-	} else if server.Status == StateShutOff {
-		return lvm.VMHalted, nil
-	} else if server.Status == StateError {
-		return lvm.VMError, nil
+// GetLastError returns a FaultError with the fault Openstack recorded for the
+// VM if it is in the ERROR state, or nil otherwise. An error is also returned
+// if the instance ID is missing or if there was a problem querying Openstack.
+func (vm *VM) GetLastError() error {
+	state, err := vm.GetServerState()
+	if err != nil {
+		return err
 	}
-	return lvm.VMUnknown, nil
+
+	return faultError(vm, state)
 }
 
 // Halt shuts down the insance on Openstack.
@@ -579,14 +1014,161 @@ func (vm *VM) Start() error {
 	return waitUntilSSHReady(vm)
 }
 
-// Suspend always returns an error since we do not support for Openstack for now.
-// TODO Remove this error message, when suspend is supported by libretto in the future.
+// Rescue boots the instance into rescue mode, from the image with the given ID
+// or from its own image if imageID is empty, with its root disk attached as a
+// secondary disk so it can be repaired. It returns the password of the rescue
+// system, which is adminPass if it is not empty or a generated one otherwise.
+func (vm *VM) Rescue(imageID string, adminPass string) (string, error) {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return "", ErrNoInstanceID
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return "", fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	password, err := servers.Rescue(client, vm.InstanceID, rescueOpts{
+		AdminPass: adminPass,
+		ImageRef:  imageID,
+	}).Extract()
+	if err != nil {
+		return "", fmt.Errorf("failed to rescue the instance: %s", err)
+	}
+
+	// Wait until VM is in rescue mode
+	return password, waitUntilStatus(vm, StateRescue)
+}
+
+// Unrescue reboots the instance from its root disk after a Rescue.
+func (vm *VM) Unrescue() error {
+	if vm.InstanceID == "" {
+		// Probably need to call Provision first.
+		return ErrNoInstanceID
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return fmt.Errorf("compute client is not set for the VM: %s", err)
+	}
+
+	_, err = client.Post(client.ServiceURL("servers", vm.InstanceID, "action"), map[string]interface{}{"unrescue": nil}, nil, &gophercloud.RequestOpts{
+		OkCodes: []int{202},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unrescue the instance: %s", err)
+	}
+
+	// Wait until VM is running again
+	return waitUntil(vm, lvm.VMRunning)
+}
+
+// Suspend suspends the instance on Openstack. The state of the instance is
+// saved to disk and its resources are released on the hypervisor.
 func (vm *VM) Suspend() error {
-	return lvm.ErrSuspendNotSupported
+	client, err := getActiveComputeClient(vm)
+	if err != nil {
+		return err
+	}
+
+	// Suspend the VM (instance)
+	err = sr.Suspend(client, vm.InstanceID).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("failed to suspend the instance: %s", err)
+	}
+
+	// Wait until VM is suspended
+	return waitUntil(vm, lvm.VMSuspended)
 }
 
-// Resume always returns an error since we do not support for Openstack for now.
-// TODO Remove this error message, when resume is supported by libretto in the future.
+// Resume resumes a suspended or paused instance on Openstack.
 func (vm *VM) Resume() error {
-	return lvm.ErrResumeNotSupported
+	state, err := vm.GetServerState()
+	if err != nil {
+		return err
+	}
+
+	if state.Status == StatePaused {
+		return vm.Unpause()
+	}
+
+	if state.Status != StateSuspended {
+		return fmt.Errorf("VM is not in suspended state")
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return fmt.Errorf("compute client is not set for the VM, %s", err)
+	}
+
+	// Resume the VM (instance)
+	err = sr.Resume(client, vm.InstanceID).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("failed to resume the instance: %s", err)
+	}
+
+	// Wait until VM runs
+	return waitUntil(vm, lvm.VMRunning)
+}
+
+// Pause pauses the instance on Openstack. The state of the instance is kept in
+// memory on the hypervisor.
+func (vm *VM) Pause() error {
+	client, err := getActiveComputeClient(vm)
+	if err != nil {
+		return err
+	}
+
+	// Pause the VM (instance)
+	err = pu.Pause(client, vm.InstanceID).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("failed to pause the instance: %s", err)
+	}
+
+	// Wait until VM is paused
+	return waitUntil(vm, lvm.VMSuspended)
+}
+
+// Unpause unpauses a paused instance on Openstack.
+func (vm *VM) Unpause() error {
+	state, err := vm.GetServerState()
+	if err != nil {
+		return err
+	}
+
+	if state.Status != StatePaused {
+		return fmt.Errorf("VM is not in paused state")
+	}
+
+	client, err := getComputeClient(vm)
+	if err != nil {
+		return fmt.Errorf("compute client is not set for the VM, %s", err)
+	}
+
+	// Unpause the VM (instance)
+	err = pu.Unpause(client, vm.InstanceID).ExtractErr()
+	if err != nil {
+		return fmt.Errorf("failed to unpause the instance: %s", err)
+	}
+
+	// Wait until VM runs
+	return waitUntil(vm, lvm.VMRunning)
+}
+
+// DeleteImage deletes the image of the VM from the image service, e.g. to clean
+// up an image uploaded by Provision. The image must not be used by other VMs.
+func (vm *VM) DeleteImage() error {
+	if vm.ImageID == "" {
+		return ErrNoImageID
+	}
+
+	err := deleteImage(vm, vm.ImageID)
+	if err != nil {
+		return err
+	}
+
+	vm.ImageID = ""
+	vm.ImageCreated = false
+	return nil
 }
@@ -0,0 +1,93 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import "fmt"
+
+// Box is a Vagrant box installed on the host.
+type Box struct {
+	Name     string
+	Provider string
+	Version  string
+}
+
+// boxArgs returns the arguments which select the version and provider of a
+// box.
+func boxArgs(version string, provider string) []string {
+	var args []string
+	if version != "" {
+		args = append(args, "--box-version", version)
+	}
+	if provider != "" {
+		args = append(args, "--provider", provider)
+	}
+	return args
+}
+
+// AddBox adds the box with the given name, such as "ubuntu/xenial64", or URL.
+// The latest version is added if version is empty, and the provider is picked
+// by Vagrant if provider is empty.
+func AddBox(name string, version string, provider string) error {
+	args := append([]string{"box", "add", name}, boxArgs(version, provider)...)
+	if _, err := runner.RunCombinedError("", args...); err != nil {
+		return fmt.Errorf("error adding box %s: %s", name, err)
+	}
+	return nil
+}
+
+// UpdateBox adds the latest version of the box with the given name, for the
+// given provider, or for all its providers if provider is empty.
+func UpdateBox(name string, provider string) error {
+	args := append([]string{"box", "update", "--box", name}, boxArgs("", provider)...)
+	if _, err := runner.RunCombinedError("", args...); err != nil {
+		return fmt.Errorf("error updating box %s: %s", name, err)
+	}
+	return nil
+}
+
+// RemoveBox removes the given version of the box with the given name, for the
+// given provider. Empty versions and providers select the only version and
+// provider of the box.
+func RemoveBox(name string, version string, provider string) error {
+	args := append([]string{"box", "remove", name, "--force"}, boxArgs(version, provider)...)
+	if _, err := runner.RunCombinedError("", args...); err != nil {
+		return fmt.Errorf("error removing box %s: %s", name, err)
+	}
+	return nil
+}
+
+// PruneBoxes removes the versions of the boxes which are older than their
+// latest version, unless machines use them.
+func PruneBoxes() error {
+	if _, err := runner.RunCombinedError("", "box", "prune", "--force", "--keep-active-boxes"); err != nil {
+		return fmt.Errorf("error pruning boxes: %s", err)
+	}
+	return nil
+}
+
+// ListBoxes returns the boxes installed on the host.
+func ListBoxes() ([]Box, error) {
+	stdout, err := runner.RunCombinedError("", "box", "list", "--machine-readable")
+	if err != nil {
+		return nil, fmt.Errorf("error listing boxes: %s", err)
+	}
+
+	// Each box is reported by a box-name line, followed by its box-provider
+	// and box-version lines.
+	var boxes []Box
+	for _, fields := range parseMachineReadable(stdout) {
+		switch fields[2] {
+		case "box-name":
+			boxes = append(boxes, Box{Name: fields[3]})
+		case "box-provider":
+			if len(boxes) > 0 {
+				boxes[len(boxes)-1].Provider = fields[3]
+			}
+		case "box-version":
+			if len(boxes) > 0 {
+				boxes[len(boxes)-1].Version = fields[3]
+			}
+		}
+	}
+	return boxes, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import (
+	"reflect"
+	"testing"
+)
+
+// mockRunner is a Runner which returns the given output.
+type mockRunner struct {
+	stdout string
+	args   []string
+}
+
+func (m *mockRunner) Run(dir string, args ...string) (string, string, error) {
+	m.args = args
+	return m.stdout, "", nil
+}
+
+func (m *mockRunner) RunCombinedError(dir string, args ...string) (string, error) {
+	stdout, _, err := m.Run(dir, args...)
+	return stdout, err
+}
+
+func TestListBoxes(t *testing.T) {
+	tests := []struct {
+		name     string
+		stdout   string
+		expected []Box
+	}{
+		{
+			name:   "no boxes",
+			stdout: "1500000000,,ui,info,There are no installed boxes!\n",
+		},
+		{
+			name: "several boxes",
+			stdout: `1500000000,,box-name,centos/7
+1500000000,,box-provider,virtualbox
+1500000000,,box-version,1905.1
+1500000000,,box-name,ubuntu/xenial64
+1500000000,,box-provider,libvirt
+1500000000,,box-version,20190101.0.0
+`,
+			expected: []Box{
+				{Name: "centos/7", Provider: "virtualbox", Version: "1905.1"},
+				{Name: "ubuntu/xenial64", Provider: "libvirt", Version: "20190101.0.0"},
+			},
+		},
+		{
+			name:     "escaped data",
+			stdout:   "1500000000,,box-name,my%!(VAGRANT_COMMA)box\n1500000000,,box-provider,virtualbox\n",
+			expected: []Box{{Name: "my,box", Provider: "virtualbox"}},
+		},
+		{
+			name:   "provider without box",
+			stdout: "1500000000,,box-provider,virtualbox\n",
+		},
+	}
+
+	defer func(r Runner) { runner = r }(runner)
+	for _, test := range tests {
+		m := &mockRunner{stdout: test.stdout}
+		runner = m
+		boxes, err := ListBoxes()
+		if err != nil {
+			t.Fatalf("%s: expected no error, got: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(boxes, test.expected) {
+			t.Errorf("%s: expected %+v, got: %+v", test.name, test.expected, boxes)
+		}
+		if expected := []string{"box", "list", "--machine-readable"}; !reflect.DeepEqual(m.args, expected) {
+			t.Errorf("%s: expected the arguments %q, got: %q", test.name, expected, m.args)
+		}
+	}
+}
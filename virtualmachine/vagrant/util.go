@@ -0,0 +1,87 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SSHConfig is the SSH config of a machine, as reported by vagrant ssh-config.
+type SSHConfig struct {
+	// Host is the name of the machine.
+	Host          string
+	HostName      string
+	User          string
+	Port          int
+	IdentityFiles []string
+}
+
+// ParseSSHConfig parses the output of vagrant ssh-config, which has a Host
+// section for each machine.
+func ParseSSHConfig(output string) []SSHConfig {
+	var configs []SSHConfig
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		key, value := fields[0], strings.Trim(strings.Join(fields[1:], " "), `"`)
+		if strings.EqualFold(key, "Host") {
+			configs = append(configs, SSHConfig{Host: value, Port: 22})
+			continue
+		}
+		if len(configs) == 0 {
+			continue
+		}
+		config := &configs[len(configs)-1]
+		switch strings.ToLower(key) {
+		case "hostname":
+			config.HostName = value
+		case "user":
+			config.User = value
+		case "port":
+			if port, err := strconv.Atoi(value); err == nil {
+				config.Port = port
+			}
+		case "identityfile":
+			config.IdentityFiles = append(config.IdentityFiles, value)
+		}
+	}
+	return configs
+}
+
+// machineReadableReplacer decodes the commas and line breaks Vagrant escapes
+// in the fields of its machine-readable output.
+var machineReadableReplacer = strings.NewReplacer(`%!(VAGRANT_COMMA)`, ",", `\n`, "\n", `\r`, "\r")
+
+// parseMachineReadable parses the machine-readable output of a vagrant
+// command, whose lines are of the form "timestamp,target,type,data", into the
+// decoded fields of each line. Data with several values is left joined by
+// commas.
+func parseMachineReadable(output string) [][]string {
+	var lines [][]string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ",", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		for i, f := range fields {
+			fields[i] = machineReadableReplacer.Replace(f)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+// parseStatus parses the output of vagrant status --machine-readable into the
+// VM states of the machines, by machine name.
+func parseStatus(output string) map[string]string {
+	states := map[string]string{}
+	for _, fields := range parseMachineReadable(output) {
+		if fields[1] != "" && fields[2] == "state" {
+			states[fields[1]] = mapState(fields[3])
+		}
+	}
+	return states
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import (
+	"reflect"
+	"testing"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+func TestParseSSHConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []SSHConfig
+	}{
+		{
+			name:   "empty",
+			output: "",
+		},
+		{
+			name: "one machine",
+			output: `Host default
+  HostName 127.0.0.1
+  User vagrant
+  Port 2222
+  UserKnownHostsFile /dev/null
+  IdentityFile "/home/me/my project/.vagrant/machines/default/virtualbox/private_key"
+  IdentitiesOnly yes
+`,
+			expected: []SSHConfig{{
+				Host:          "default",
+				HostName:      "127.0.0.1",
+				User:          "vagrant",
+				Port:          2222,
+				IdentityFiles: []string{"/home/me/my project/.vagrant/machines/default/virtualbox/private_key"},
+			}},
+		},
+		{
+			name: "several machines",
+			output: `Host web
+  hostname 192.168.33.10
+  user ubuntu
+  identityfile /keys/web
+  identityfile /keys/shared
+
+Host db
+  HostName 192.168.33.11
+  Port invalid
+`,
+			expected: []SSHConfig{
+				{Host: "web", HostName: "192.168.33.10", User: "ubuntu", Port: 22, IdentityFiles: []string{"/keys/web", "/keys/shared"}},
+				{Host: "db", HostName: "192.168.33.11", Port: 22},
+			},
+		},
+		{
+			name:   "options before the first host",
+			output: "User vagrant\nHost default\n",
+			expected: []SSHConfig{
+				{Host: "default", Port: 22},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if got := ParseSSHConfig(test.output); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %+v, got: %+v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected map[string]string
+	}{
+		{
+			name:     "empty",
+			output:   "",
+			expected: map[string]string{},
+		},
+		{
+			name: "several machines",
+			output: `1500000000,web,metadata,provider,virtualbox
+1500000000,web,provider-name,virtualbox
+1500000000,web,state,running
+1500000000,db,state,poweroff
+1500000000,cache,state,saved
+1500000000,new,state,not_created
+1500000000,,ui,info,Current machine states:
+`,
+			expected: map[string]string{
+				"web":   lvm.VMRunning,
+				"db":    lvm.VMHalted,
+				"cache": lvm.VMSuspended,
+				"new":   lvm.VMUnknown,
+			},
+		},
+		{
+			name:     "escaped data",
+			output:   "1500000000,web%!(VAGRANT_COMMA)1,state,running\r\n",
+			expected: map[string]string{"web,1": lvm.VMRunning},
+		},
+	}
+
+	for _, test := range tests {
+		if got := parseStatus(test.output); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected %v, got: %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestParseMachineReadable(t *testing.T) {
+	output := "1500000000,,ui,info,Line one\\nLine two%!(VAGRANT_COMMA) with a comma\ninvalid\n"
+	expected := [][]string{{"1500000000", "", "ui", "info,Line one\nLine two, with a comma"}}
+	if got := parseMachineReadable(output); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %q, got: %q", expected, got)
+	}
+}
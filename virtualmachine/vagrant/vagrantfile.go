@@ -0,0 +1,146 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Network types of machines
+const (
+	NetworkPrivate       = "private_network"
+	NetworkPublic        = "public_network"
+	NetworkForwardedPort = "forwarded_port"
+)
+
+// Vagrantfile is a minimal multi-machine Vagrantfile.
+type Vagrantfile struct {
+	Machines []Machine
+}
+
+// Machine is a machine of a Vagrantfile.
+type Machine struct {
+	// Name is the name of the machine. It is "default" if it is empty.
+	Name string
+	// Box is the name of the box of the machine, such as "ubuntu/xenial64".
+	Box        string
+	BoxVersion string
+	Hostname   string
+	// CPUs and MemoryMB are set for the VirtualBox, libvirt and VMware
+	// providers when they are set.
+	CPUs          int
+	MemoryMB      int
+	Networks      []Network
+	SyncedFolders []SyncedFolder
+}
+
+// Network is a network of a machine.
+type Network struct {
+	// Type is one of the Network constants.
+	Type string
+	// IP is the static IP of private and public networks. They use DHCP if
+	// it is empty.
+	IP string
+	// Bridge is the host interface of public networks, such as "en0".
+	Bridge string
+	// GuestPort and HostPort are the ports of forwarded ports.
+	GuestPort int
+	HostPort  int
+}
+
+// SyncedFolder is a folder of the host synced to a machine.
+type SyncedFolder struct {
+	HostPath  string
+	GuestPath string
+	// Type is the type of the synced folder, such as "nfs" or "rsync". The
+	// default of the provider is used if it is empty.
+	Type     string
+	Disabled bool
+}
+
+// rubyString returns the Ruby single-quoted string literal of s.
+func rubyString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// String returns the content of the Vagrantfile.
+func (v Vagrantfile) String() string {
+	var b bytes.Buffer
+	b.WriteString("Vagrant.configure('2') do |config|\n")
+	for _, m := range v.Machines {
+		name := m.Name
+		if name == "" {
+			name = defaultMachine
+		}
+		fmt.Fprintf(&b, "  config.vm.define %s do |m|\n", rubyString(name))
+		fmt.Fprintf(&b, "    m.vm.box = %s\n", rubyString(m.Box))
+		if m.BoxVersion != "" {
+			fmt.Fprintf(&b, "    m.vm.box_version = %s\n", rubyString(m.BoxVersion))
+		}
+		if m.Hostname != "" {
+			fmt.Fprintf(&b, "    m.vm.hostname = %s\n", rubyString(m.Hostname))
+		}
+
+		for _, n := range m.Networks {
+			fmt.Fprintf(&b, "    m.vm.network %s", rubyString(n.Type))
+			switch n.Type {
+			case NetworkForwardedPort:
+				fmt.Fprintf(&b, ", guest: %d, host: %d", n.GuestPort, n.HostPort)
+			default:
+				if n.IP != "" {
+					fmt.Fprintf(&b, ", ip: %s", rubyString(n.IP))
+				} else {
+					b.WriteString(", type: 'dhcp'")
+				}
+				if n.Bridge != "" {
+					fmt.Fprintf(&b, ", bridge: %s", rubyString(n.Bridge))
+				}
+			}
+			b.WriteString("\n")
+		}
+
+		for _, f := range m.SyncedFolders {
+			fmt.Fprintf(&b, "    m.vm.synced_folder %s, %s", rubyString(f.HostPath), rubyString(f.GuestPath))
+			if f.Type != "" {
+				fmt.Fprintf(&b, ", type: %s", rubyString(f.Type))
+			}
+			if f.Disabled {
+				b.WriteString(", disabled: true")
+			}
+			b.WriteString("\n")
+		}
+
+		if m.CPUs > 0 || m.MemoryMB > 0 {
+			for _, provider := range []string{ProviderVirtualBox, ProviderLibvirt} {
+				fmt.Fprintf(&b, "    m.vm.provider %s do |p|\n", rubyString(provider))
+				if m.CPUs > 0 {
+					fmt.Fprintf(&b, "      p.cpus = %d\n", m.CPUs)
+				}
+				if m.MemoryMB > 0 {
+					fmt.Fprintf(&b, "      p.memory = %d\n", m.MemoryMB)
+				}
+				b.WriteString("    end\n")
+			}
+			fmt.Fprintf(&b, "    m.vm.provider %s do |p|\n", rubyString(ProviderVMware))
+			if m.CPUs > 0 {
+				fmt.Fprintf(&b, "      p.vmx['numvcpus'] = '%d'\n", m.CPUs)
+			}
+			if m.MemoryMB > 0 {
+				fmt.Fprintf(&b, "      p.vmx['memsize'] = '%d'\n", m.MemoryMB)
+			}
+			b.WriteString("    end\n")
+		}
+		b.WriteString("  end\n")
+	}
+	b.WriteString("end\n")
+	return b.String()
+}
+
+// Write writes the Vagrantfile to the given directory.
+func (v Vagrantfile) Write(dir string) error {
+	return ioutil.WriteFile(filepath.Join(dir, "Vagrantfile"), []byte(v.String()), 0644)
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import "testing"
+
+func TestVagrantfileString(t *testing.T) {
+	tests := []struct {
+		name        string
+		vagrantfile Vagrantfile
+		expected    string
+	}{
+		{
+			name:     "no machines",
+			expected: "Vagrant.configure('2') do |config|\nend\n",
+		},
+		{
+			name: "default machine",
+			vagrantfile: Vagrantfile{Machines: []Machine{{
+				Box:      "ubuntu/xenial64",
+				Hostname: "it's-me",
+			}}},
+			expected: `Vagrant.configure('2') do |config|
+  config.vm.define 'default' do |m|
+    m.vm.box = 'ubuntu/xenial64'
+    m.vm.hostname = 'it\'s-me'
+  end
+end
+`,
+		},
+		{
+			name: "networks, folders and resources",
+			vagrantfile: Vagrantfile{Machines: []Machine{{
+				Name:       "web",
+				Box:        "centos/7",
+				BoxVersion: "1905.1",
+				CPUs:       2,
+				MemoryMB:   1024,
+				Networks: []Network{
+					{Type: NetworkPrivate, IP: "192.168.33.10"},
+					{Type: NetworkPublic, Bridge: "en0"},
+					{Type: NetworkForwardedPort, GuestPort: 80, HostPort: 8080},
+				},
+				SyncedFolders: []SyncedFolder{
+					{HostPath: `C:\src`, GuestPath: "/src", Type: "rsync"},
+					{HostPath: ".", GuestPath: "/vagrant", Disabled: true},
+				},
+			}}},
+			expected: `Vagrant.configure('2') do |config|
+  config.vm.define 'web' do |m|
+    m.vm.box = 'centos/7'
+    m.vm.box_version = '1905.1'
+    m.vm.network 'private_network', ip: '192.168.33.10'
+    m.vm.network 'public_network', type: 'dhcp', bridge: 'en0'
+    m.vm.network 'forwarded_port', guest: 80, host: 8080
+    m.vm.synced_folder 'C:\\src', '/src', type: 'rsync'
+    m.vm.synced_folder '.', '/vagrant', disabled: true
+    m.vm.provider 'virtualbox' do |p|
+      p.cpus = 2
+      p.memory = 1024
+    end
+    m.vm.provider 'libvirt' do |p|
+      p.cpus = 2
+      p.memory = 1024
+    end
+    m.vm.provider 'vmware_desktop' do |p|
+      p.vmx['numvcpus'] = '2'
+      p.vmx['memsize'] = '1024'
+    end
+  end
+end
+`,
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.vagrantfile.String(); got != test.expected {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", test.name, test.expected, got)
+		}
+	}
+}
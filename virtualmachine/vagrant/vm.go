@@ -0,0 +1,249 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vagrant
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strings"
+
+	libssh "github.com/apcera/libretto/ssh"
+	"github.com/apcera/libretto/util"
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+// Providers of Vagrant machines
+const (
+	ProviderVirtualBox = "virtualbox"
+	ProviderLibvirt    = "libvirt"
+	ProviderVMware     = "vmware_desktop"
+)
+
+// defaultMachine is the name of the machine of single-machine Vagrantfiles.
+const defaultMachine = "default"
+
+// Runner is an encapsulation around the vagrant utility.
+type Runner interface {
+	Run(dir string, args ...string) (string, string, error)
+	RunCombinedError(dir string, args ...string) (string, error)
+}
+
+// vagrantRunner implements the Runner interface.
+type vagrantRunner struct {
+}
+
+var runner Runner = vagrantRunner{}
+
+// Run runs a vagrant command in the given directory.
+func (f vagrantRunner) Run(dir string, args ...string) (string, string, error) {
+	cmd := exec.Command("vagrant", args...)
+	cmd.Dir = dir
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+// RunCombinedError runs a vagrant command in the given directory. The output
+// is stdout and the combined err/stderr from the command.
+func (f vagrantRunner) RunCombinedError(dir string, args ...string) (string, error) {
+	wout, werr, err := f.Run(dir, args...)
+	if err != nil {
+		if werr != "" {
+			return wout, fmt.Errorf("%s: %s", err, werr)
+		}
+		return wout, err
+	}
+
+	return wout, nil
+}
+
+// VM represents a machine of a Vagrantfile.
+type VM struct {
+	// Dir is the directory of the Vagrantfile.
+	Dir string
+	// Machine is the name of the machine in a multi-machine Vagrantfile. The
+	// machine of a single-machine Vagrantfile is used if it is empty.
+	Machine string
+	// Provider is the provider of the machine, one of the Provider constants.
+	// Vagrant picks the provider if it is empty.
+	Provider string
+	// Credentials override the SSH user and private key of the SSH config of
+	// the machine when they are set.
+	Credentials libssh.Credentials
+	ips         []net.IP
+}
+
+// Compile-time check that VM implements the VirtualMachine interface.
+var _ lvm.VirtualMachine = (*VM)(nil)
+
+// run runs a vagrant command on the machine of the VM.
+func (vm *VM) run(command string, args ...string) (string, error) {
+	args = append([]string{command}, args...)
+	if vm.Machine != "" {
+		args = append(args, vm.Machine)
+	}
+	return runner.RunCombinedError(vm.Dir, args...)
+}
+
+// GetName returns the name of the machine.
+func (vm *VM) GetName() string {
+	if vm.Machine == "" {
+		return defaultMachine
+	}
+	return vm.Machine
+}
+
+// Provision brings the machine up with its provider.
+func (vm *VM) Provision() error {
+	if vm.Dir == "" {
+		return lvm.ErrSourceNotSpecified
+	}
+	var args []string
+	if vm.Provider != "" {
+		args = append(args, "--provider", vm.Provider)
+	}
+	if _, err := vm.run("up", args...); err != nil {
+		return lvm.WrapErrors(lvm.ErrCreatingVM, err)
+	}
+	return nil
+}
+
+// GetIPs returns the IP the SSH config of the machine connects to.
+func (vm *VM) GetIPs() ([]net.IP, error) {
+	config, err := vm.SSHConfig()
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.LookupIP(config.HostName)
+	if err != nil {
+		return nil, err
+	}
+	vm.ips = ips[:1]
+	return vm.ips, nil
+}
+
+// Destroy destroys the machine and deletes its resources.
+func (vm *VM) Destroy() error {
+	if _, err := vm.run("destroy", "--force"); err != nil {
+		return lvm.WrapErrors(lvm.ErrDeletingVM, err)
+	}
+	return nil
+}
+
+// GetState returns the state of the machine.
+func (vm *VM) GetState() (string, error) {
+	states, err := vm.MachineStates()
+	if err != nil {
+		return "", err
+	}
+	state, ok := states[vm.GetName()]
+	if !ok {
+		return lvm.VMUnknown, lvm.ErrVMStateFailed
+	}
+	return state, nil
+}
+
+// MachineStates returns the states of all the machines of the Vagrantfile of
+// the VM, by machine name.
+func (vm *VM) MachineStates() (map[string]string, error) {
+	stdout, err := runner.RunCombinedError(vm.Dir, "status", "--machine-readable")
+	if err != nil {
+		return nil, lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	return parseStatus(stdout), nil
+}
+
+// Suspend suspends the machine.
+func (vm *VM) Suspend() error {
+	if _, err := vm.run("suspend"); err != nil {
+		return lvm.WrapErrors(lvm.ErrSuspendingVM, err)
+	}
+	return nil
+}
+
+// Resume resumes the suspended machine.
+func (vm *VM) Resume() error {
+	if _, err := vm.run("resume"); err != nil {
+		return lvm.WrapErrors(lvm.ErrResumingVM, err)
+	}
+	return nil
+}
+
+// Halt shuts the machine down.
+func (vm *VM) Halt() error {
+	if _, err := vm.run("halt"); err != nil {
+		return lvm.WrapErrors(lvm.ErrStoppingVM, err)
+	}
+	return nil
+}
+
+// Start brings the halted machine up, without provisioning it again.
+func (vm *VM) Start() error {
+	args := []string{"--no-provision"}
+	if vm.Provider != "" {
+		args = append(args, "--provider", vm.Provider)
+	}
+	if _, err := vm.run("up", args...); err != nil {
+		return lvm.WrapErrors(lvm.ErrStartingVM, err)
+	}
+	return nil
+}
+
+// SSHConfig returns the SSH config of the machine.
+func (vm *VM) SSHConfig() (*SSHConfig, error) {
+	stdout, err := vm.run("ssh-config")
+	if err != nil {
+		return nil, err
+	}
+	configs := ParseSSHConfig(stdout)
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no ssh config for machine %s", vm.GetName())
+	}
+	return &configs[0], nil
+}
+
+// GetSSH returns an SSH client connected as in the SSH config of the machine.
+func (vm *VM) GetSSH(options libssh.Options) (libssh.Client, error) {
+	config, err := vm.SSHConfig()
+	if err != nil {
+		return nil, err
+	}
+	ips, err := util.GetVMIPs(vm, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if vm.Credentials.SSHUser == "" {
+		vm.Credentials.SSHUser = config.User
+	}
+	if vm.Credentials.SSHPrivateKey == "" && vm.Credentials.SSHPassword == "" && len(config.IdentityFiles) > 0 {
+		key, err := ioutil.ReadFile(config.IdentityFiles[0])
+		if err != nil {
+			return nil, err
+		}
+		vm.Credentials.SSHPrivateKey = string(key)
+	}
+
+	client := libssh.SSHClient{Creds: &vm.Credentials, IP: ips[0], Port: config.Port, Options: options}
+	return &client, nil
+}
+
+// mapState maps the state of a Vagrant machine to a VM state. Machines which
+// are not created are in an unknown state.
+func mapState(state string) string {
+	switch strings.ToLower(state) {
+	case "running", "active":
+		return lvm.VMRunning
+	case "poweroff", "shutoff", "stopped", "not_running", "aborted":
+		return lvm.VMHalted
+	case "saved", "suspended", "paused":
+		return lvm.VMSuspended
+	}
+	return lvm.VMUnknown
+}
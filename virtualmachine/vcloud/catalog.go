@@ -0,0 +1,251 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vcloud
+
+import (
+	"archive/tar"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// uploadVAppTemplateParams is the request to upload a vApp template to a
+// catalog.
+type uploadVAppTemplateParams struct {
+	XMLName     xml.Name `xml:"UploadVAppTemplateParams"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	Name        string   `xml:"name,attr"`
+	Description string   `xml:"Description,omitempty"`
+}
+
+// mediaParams is the request to upload a media to a catalog.
+type mediaParams struct {
+	XMLName     xml.Name `xml:"Media"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	Name        string   `xml:"name,attr"`
+	ImageType   string   `xml:"imageType,attr"`
+	Size        int64    `xml:"size,attr"`
+	Description string   `xml:"Description,omitempty"`
+}
+
+// captureVAppParams is the request to capture a vApp to a catalog.
+type captureVAppParams struct {
+	XMLName     xml.Name  `xml:"CaptureVAppParams"`
+	Xmlns       string    `xml:"xmlns,attr"`
+	Name        string    `xml:"name,attr"`
+	Description string    `xml:"Description,omitempty"`
+	Source      reference `xml:"Source"`
+}
+
+// uploadEntity is a vApp template or a media being uploaded.
+type uploadEntity struct {
+	Href  string `xml:"href,attr"`
+	Name  string `xml:"name,attr"`
+	Tasks []task `xml:"Tasks>Task"`
+	Files []struct {
+		Name  string `xml:"name,attr"`
+		Links []link `xml:"Link"`
+	} `xml:"Files>File"`
+}
+
+// uploadLink returns the upload link of the file of the entity with the given
+// name.
+func (e *uploadEntity) uploadLink(name string) (string, bool) {
+	for _, f := range e.Files {
+		if f.Name != name {
+			continue
+		}
+		for _, l := range f.Links {
+			if l.Rel == "upload:default" {
+				return l.Href, true
+			}
+		}
+	}
+	return "", false
+}
+
+// getCatalog returns the client and the catalog of the organization with the
+// given name.
+func (vm *VM) getCatalog(name string) (*client, *catalog, error) {
+	c, err := vm.session()
+	if err != nil {
+		return nil, nil, err
+	}
+	o, err := c.org(vm.Org)
+	if err != nil {
+		return nil, nil, err
+	}
+	cat, err := c.catalog(o, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, cat, nil
+}
+
+// addCatalogItem posts the request to add an item to the catalog, and returns
+// the entity of the item being uploaded.
+func (c *client) addCatalogItem(cat *catalog, contentType string, params interface{}) (*uploadEntity, error) {
+	l, ok := findLink(cat.Links, contentType, "")
+	if !ok {
+		return nil, fmt.Errorf("catalog %s does not allow uploads", cat.Name)
+	}
+	item := &catalogItem{}
+	if _, err := c.do("POST", l.Href, contentType, params, item); err != nil {
+		return nil, err
+	}
+	entity := &uploadEntity{}
+	if _, err := c.do("GET", item.Entity.Href, "", nil, entity); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+// uploadFile uploads the file of the entity with the given name, waiting until
+// vCloud Director is ready to receive it.
+func (c *client) uploadFile(entity *uploadEntity, name string, r io.Reader, size int64) error {
+	deadline := time.Now().Add(TaskTimeout)
+	for {
+		if href, ok := entity.uploadLink(name); ok {
+			if _, err := c.send("PUT", href, "", r, size, nil); err != nil {
+				return fmt.Errorf("error uploading %s: %s", name, err)
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the upload link of %s", name)
+		}
+		time.Sleep(taskPollInterval)
+		if _, err := c.do("GET", entity.Href, "", nil, entity); err != nil {
+			return err
+		}
+	}
+}
+
+// waitUpload waits until the import of the uploaded entity succeeds.
+func (c *client) waitUpload(entity *uploadEntity) error {
+	if _, err := c.do("GET", entity.Href, "", nil, entity); err != nil {
+		return err
+	}
+	return c.waitTasks(entity.Tasks)
+}
+
+// UploadOVA uploads the OVA read from ova to the catalog as a vApp template
+// with the given name. The OVA is streamed once, so it can be read from the
+// network, and its OVF descriptor must be its first file as the OVF
+// specification requires.
+func (vm *VM) UploadOVA(catalogName, name, description string, ova io.Reader) error {
+	c, cat, err := vm.getCatalog(catalogName)
+	if err != nil {
+		return err
+	}
+
+	params := &uploadVAppTemplateParams{Xmlns: xmlnsVCloud, Name: name, Description: description}
+	entity, err := c.addCatalogItem(cat, typeUploadVAppTemplateParams, params)
+	if err != nil {
+		return fmt.Errorf("error adding template %s to catalog %s: %s", name, catalogName, err)
+	}
+
+	tr := tar.NewReader(ova)
+	first := true
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading OVA: %s", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		fileName := path.Base(hdr.Name)
+		if first {
+			if !strings.HasSuffix(strings.ToLower(fileName), ".ovf") {
+				return fmt.Errorf("OVA does not start with an OVF descriptor, got: %s", fileName)
+			}
+			// vCloud Director always names the descriptor this way.
+			fileName = "descriptor.ovf"
+			first = false
+		}
+		if strings.HasSuffix(strings.ToLower(fileName), ".mf") {
+			continue
+		}
+		if err := c.uploadFile(entity, fileName, tr, hdr.Size); err != nil {
+			return err
+		}
+	}
+	if first {
+		return fmt.Errorf("OVA has no OVF descriptor")
+	}
+	return c.waitUpload(entity)
+}
+
+// UploadMedia uploads the ISO image of the given size read from r to the
+// catalog as a media with the given name.
+func (vm *VM) UploadMedia(catalogName, name, description string, r io.Reader, size int64) error {
+	c, cat, err := vm.getCatalog(catalogName)
+	if err != nil {
+		return err
+	}
+
+	params := &mediaParams{Xmlns: xmlnsVCloud, Name: name, ImageType: "iso", Size: size, Description: description}
+	entity, err := c.addCatalogItem(cat, typeMedia, params)
+	if err != nil {
+		return fmt.Errorf("error adding media %s to catalog %s: %s", name, catalogName, err)
+	}
+	if err := c.uploadFile(entity, "file", r, size); err != nil {
+		return err
+	}
+	return c.waitUpload(entity)
+}
+
+// CaptureVApp captures the vApp to the catalog as a vApp template with the
+// given name. The machines should be powered off first.
+func (vm *VM) CaptureVApp(catalogName, name, description string) error {
+	if vm.Href == "" {
+		return ErrNoVApp
+	}
+	c, cat, err := vm.getCatalog(catalogName)
+	if err != nil {
+		return err
+	}
+	l, ok := findLink(cat.Links, typeCaptureVAppParams, "")
+	if !ok {
+		return fmt.Errorf("catalog %s does not allow capturing vApps", catalogName)
+	}
+
+	params := &captureVAppParams{
+		Xmlns:       xmlnsVCloud,
+		Name:        name,
+		Description: description,
+		Source:      reference{Href: vm.Href},
+	}
+	template := &vAppTemplate{}
+	if _, err := c.do("POST", l.Href, typeCaptureVAppParams, params, template); err != nil {
+		return fmt.Errorf("error capturing vApp %s: %s", vm.Name, err)
+	}
+	if err := c.waitTasks(template.Tasks); err != nil {
+		return fmt.Errorf("error capturing vApp %s: %s", vm.Name, err)
+	}
+	return nil
+}
+
+// DeleteCatalogItem deletes the item of the catalog with the given name, and
+// the vApp template or media it refers to.
+func (vm *VM) DeleteCatalogItem(catalogName, name string) error {
+	c, cat, err := vm.getCatalog(catalogName)
+	if err != nil {
+		return err
+	}
+	ref, ok := findRef(cat.Items, name)
+	if !ok {
+		return fmt.Errorf("item %q not found in catalog %s", name, catalogName)
+	}
+	if err := c.remove(ref.Href); err != nil {
+		return fmt.Errorf("error deleting item %s of catalog %s: %s", name, catalogName, err)
+	}
+	return nil
+}
@@ -0,0 +1,391 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vcloud
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiVersion is the version of the vCloud Director API used.
+const apiVersion = "31.0"
+
+// Namespaces of vCloud Director documents
+const (
+	xmlnsVCloud = "http://www.vmware.com/vcloud/v1.5"
+	xmlnsOVF    = "http://schemas.dmtf.org/ovf/envelope/1"
+)
+
+// Media types of vCloud Director documents
+const (
+	typeVDC               = "application/vnd.vmware.vcloud.vdc+xml"
+	typeCatalog           = "application/vnd.vmware.vcloud.catalog+xml"
+	typeComposeVAppParams = "application/vnd.vmware.vcloud.composeVAppParams+xml"
+	typeUndeployParams    = "application/vnd.vmware.vcloud.undeployVAppParams+xml"
+	typeDisk              = "application/vnd.vmware.vcloud.disk+xml"
+	typeDiskCreateParams  = "application/vnd.vmware.vcloud.diskCreateParams+xml"
+	typeDiskAttachParams  = "application/vnd.vmware.vcloud.diskAttachOrDetachParams+xml"
+	typeMedia             = "application/vnd.vmware.vcloud.media+xml"
+	typeCaptureVAppParams = "application/vnd.vmware.vcloud.captureVAppParams+xml"
+
+	typeUploadVAppTemplateParams = "application/vnd.vmware.vcloud.uploadVAppTemplateParams+xml"
+)
+
+// Statuses of vCloud Director tasks
+const (
+	taskSuccess  = "success"
+	taskError    = "error"
+	taskCanceled = "canceled"
+	taskAborted  = "aborted"
+)
+
+var (
+	// TaskTimeout is the maximum time to wait for a vCloud Director task.
+	// This is not thread-safe.
+	TaskTimeout = 30 * time.Minute
+
+	// taskPollInterval is the interval tasks are polled at.
+	taskPollInterval = 3 * time.Second
+)
+
+var (
+	// ErrNoVApp is returned when an operation needs the vApp, but the VM is
+	// not provisioned.
+	ErrNoVApp = errors.New("vApp not provisioned")
+	// ErrMachineNotFound is returned when a machine is not in the vApp.
+	ErrMachineNotFound = errors.New("machine not found in the vApp")
+)
+
+// link is a link of a vCloud Director entity to a related entity or action.
+type link struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Name string `xml:"name,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// reference is a reference to a vCloud Director entity.
+type reference struct {
+	Href string `xml:"href,attr"`
+	Name string `xml:"name,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// task is an asynchronous vCloud Director task.
+type task struct {
+	Href      string `xml:"href,attr"`
+	Status    string `xml:"status,attr"`
+	Operation string `xml:"operation,attr"`
+	Error     *struct {
+		Message string `xml:"message,attr"`
+	} `xml:"Error"`
+}
+
+// apiError is the error document of failed requests.
+type apiError struct {
+	Message        string `xml:"message,attr"`
+	MajorErrorCode int    `xml:"majorErrorCode,attr"`
+	MinorErrorCode string `xml:"minorErrorCode,attr"`
+}
+
+// org is a vCloud Director organization.
+type org struct {
+	Href  string `xml:"href,attr"`
+	Name  string `xml:"name,attr"`
+	Links []link `xml:"Link"`
+}
+
+// vdc is a virtual data center of an organization.
+type vdc struct {
+	Href              string      `xml:"href,attr"`
+	Name              string      `xml:"name,attr"`
+	Links             []link      `xml:"Link"`
+	ResourceEntities  []reference `xml:"ResourceEntities>ResourceEntity"`
+	AvailableNetworks []reference `xml:"AvailableNetworks>Network"`
+	StorageProfiles   []reference `xml:"VdcStorageProfiles>VdcStorageProfile"`
+}
+
+// catalog is a catalog of vApp templates and media.
+type catalog struct {
+	Href  string      `xml:"href,attr"`
+	Name  string      `xml:"name,attr"`
+	Links []link      `xml:"Link"`
+	Items []reference `xml:"CatalogItems>CatalogItem"`
+}
+
+// catalogItem is an item of a catalog, which refers to a vApp template or a
+// media.
+type catalogItem struct {
+	Href   string    `xml:"href,attr"`
+	Name   string    `xml:"name,attr"`
+	Entity reference `xml:"Entity"`
+}
+
+// vAppTemplate is a vApp template of a catalog.
+type vAppTemplate struct {
+	Href   string      `xml:"href,attr"`
+	Name   string      `xml:"name,attr"`
+	Status int         `xml:"status,attr"`
+	Links  []link      `xml:"Link"`
+	Tasks  []task      `xml:"Tasks>Task"`
+	VMs    []reference `xml:"Children>Vm"`
+}
+
+// client is a session of the vCloud Director API.
+type client struct {
+	endpoint   string
+	httpClient *http.Client
+	// header and token are the header and value of the authorization.
+	header string
+	token  string
+}
+
+// newClient logs in to vCloud Director as user of the organization.
+func newClient(endpoint, user, password, orgName string, insecure bool) (*client, error) {
+	c := &client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+
+	req, err := http.NewRequest("POST", c.endpoint+"/api/sessions", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(user+"@"+orgName, password)
+	req.Header.Set("Accept", "application/*+xml;version="+apiVersion)
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error logging in to %s: %s", c.endpoint, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		return nil, fmt.Errorf("error logging in to %s: %s", c.endpoint, responseError(rsp))
+	}
+
+	// Newer versions return a bearer token, older ones a session token.
+	if token := rsp.Header.Get("X-Vmware-Vcloud-Access-Token"); token != "" {
+		c.header, c.token = "Authorization", "Bearer "+token
+	} else {
+		c.header, c.token = "X-Vcloud-Authorization", rsp.Header.Get("X-Vcloud-Authorization")
+	}
+	if c.token == "" {
+		return nil, fmt.Errorf("error logging in to %s: no session token", c.endpoint)
+	}
+	return c, nil
+}
+
+// do sends a request to href with in as the XML body, if it is not nil, and
+// decodes the XML response into out, if it is not nil. href is either absolute
+// or relative to the endpoint. The headers of the response are returned.
+func (c *client) do(method, href, contentType string, in, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := xml.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(append([]byte(xml.Header), b...))
+	}
+	return c.send(method, href, contentType, body, -1, out)
+}
+
+// send sends a request with a raw body of the given length, or an unknown
+// length if it is negative.
+func (c *client) send(method, href, contentType string, body io.Reader, length int64, out interface{}) (http.Header, error) {
+	if !strings.HasPrefix(href, "http") {
+		href = c.endpoint + href
+	}
+	req, err := http.NewRequest(method, href, body)
+	if err != nil {
+		return nil, err
+	}
+	if length >= 0 {
+		req.ContentLength = length
+	}
+	req.Header.Set("Accept", "application/*+xml;version="+apiVersion)
+	req.Header.Set(c.header, c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s", method, href, responseError(rsp))
+	}
+	if out == nil {
+		return rsp.Header, nil
+	}
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return rsp.Header, nil
+	}
+	return rsp.Header, xml.Unmarshal(b, out)
+}
+
+// responseError returns the error of a failed response.
+func responseError(rsp *http.Response) error {
+	b, _ := ioutil.ReadAll(rsp.Body)
+	e := &apiError{}
+	if err := xml.Unmarshal(b, e); err == nil && e.Message != "" {
+		return fmt.Errorf("%s: %s", rsp.Status, e.Message)
+	}
+	return fmt.Errorf("%s: %s", rsp.Status, strings.TrimSpace(string(b)))
+}
+
+// waitTask waits until the task succeeds, or returns its error.
+func (c *client) waitTask(t task) error {
+	deadline := time.Now().Add(TaskTimeout)
+	for {
+		switch t.Status {
+		case taskSuccess:
+			return nil
+		case taskError, taskCanceled, taskAborted:
+			if t.Error != nil {
+				return fmt.Errorf("task %s %s: %s", t.Operation, t.Status, t.Error.Message)
+			}
+			return fmt.Errorf("task %s %s", t.Operation, t.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for task %s", t.Operation)
+		}
+		time.Sleep(taskPollInterval)
+		if _, err := c.do("GET", t.Href, "", nil, &t); err != nil {
+			return err
+		}
+	}
+}
+
+// waitTasks waits until all the tasks succeed.
+func (c *client) waitTasks(tasks []task) error {
+	for _, t := range tasks {
+		if err := c.waitTask(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends a request for an action which returns a task, and waits until
+// the task succeeds.
+func (c *client) post(href, contentType string, in interface{}) error {
+	t := task{}
+	if _, err := c.do("POST", href, contentType, in, &t); err != nil {
+		return err
+	}
+	if t.Href == "" {
+		return nil
+	}
+	return c.waitTask(t)
+}
+
+// remove deletes an entity and waits until it is deleted.
+func (c *client) remove(href string) error {
+	t := task{}
+	if _, err := c.do("DELETE", href, "", nil, &t); err != nil {
+		return err
+	}
+	if t.Href == "" {
+		return nil
+	}
+	return c.waitTask(t)
+}
+
+// findLink returns the link of the given type and name, or any name if name is
+// empty.
+func findLink(links []link, typ, name string) (link, bool) {
+	for _, l := range links {
+		if l.Type == typ && (name == "" || l.Name == name) {
+			return l, true
+		}
+	}
+	return link{}, false
+}
+
+// findRef returns the reference with the given name.
+func findRef(refs []reference, name string) (reference, bool) {
+	for _, r := range refs {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return reference{}, false
+}
+
+// org returns the organization with the given name.
+func (c *client) org(name string) (*org, error) {
+	list := &struct {
+		Orgs []reference `xml:"Org"`
+	}{}
+	if _, err := c.do("GET", "/api/org", "", nil, list); err != nil {
+		return nil, err
+	}
+	ref, ok := findRef(list.Orgs, name)
+	if !ok {
+		return nil, fmt.Errorf("organization %q not found", name)
+	}
+	o := &org{}
+	if _, err := c.do("GET", ref.Href, "", nil, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// vdc returns the virtual data center of the organization with the given name,
+// or its only one if name is empty.
+func (c *client) vdc(o *org, name string) (*vdc, error) {
+	l, ok := findLink(o.Links, typeVDC, name)
+	if !ok {
+		return nil, fmt.Errorf("VDC %q not found in organization %s", name, o.Name)
+	}
+	v := &vdc{}
+	if _, err := c.do("GET", l.Href, "", nil, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// catalog returns the catalog of the organization with the given name.
+func (c *client) catalog(o *org, name string) (*catalog, error) {
+	l, ok := findLink(o.Links, typeCatalog, name)
+	if !ok {
+		return nil, fmt.Errorf("catalog %q not found in organization %s", name, o.Name)
+	}
+	cat := &catalog{}
+	if _, err := c.do("GET", l.Href, "", nil, cat); err != nil {
+		return nil, err
+	}
+	return cat, nil
+}
+
+// catalogItem returns the item of the catalog with the given name.
+func (c *client) catalogItem(cat *catalog, name string) (*catalogItem, error) {
+	ref, ok := findRef(cat.Items, name)
+	if !ok {
+		return nil, fmt.Errorf("item %q not found in catalog %s", name, cat.Name)
+	}
+	item := &catalogItem{}
+	if _, err := c.do("GET", ref.Href, "", nil, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
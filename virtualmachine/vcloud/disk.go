@@ -0,0 +1,233 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vcloud
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// Disk is a named independent disk of a VDC, which exists independently of the
+// VMs it is attached to.
+type Disk struct {
+	// Name is the name of the disk, which must be unique in the VDC.
+	Name string
+	// SizeMB is the size of the disk created if it does not exist.
+	SizeMB int64
+	// StorageProfile is the storage profile of the disk created if it does
+	// not exist. The default one of the VDC is used if it is empty.
+	StorageProfile string
+	// Machine is the name of the machine of the vApp the disk is attached
+	// to. It defaults to the first machine.
+	Machine string
+}
+
+// diskCreateParams is the request to create an independent disk.
+type diskCreateParams struct {
+	XMLName xml.Name `xml:"DiskCreateParams"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Disk    struct {
+		Name           string     `xml:"name,attr"`
+		SizeMB         int64      `xml:"sizeMb,attr"`
+		StorageProfile *reference `xml:"StorageProfile,omitempty"`
+	} `xml:"Disk"`
+}
+
+// diskAttachParams is the request to attach or detach an independent disk.
+type diskAttachParams struct {
+	XMLName xml.Name  `xml:"DiskAttachOrDetachParams"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Disk    reference `xml:"Disk"`
+}
+
+// diskEntity is an independent disk.
+type diskEntity struct {
+	Href  string `xml:"href,attr"`
+	Name  string `xml:"name,attr"`
+	Tasks []task `xml:"Tasks>Task"`
+}
+
+// storageProfile returns the storage profile of the VDC with the given name.
+func storageProfile(v *vdc, name string) (reference, error) {
+	ref, ok := findRef(v.StorageProfiles, name)
+	if !ok {
+		return reference{}, fmt.Errorf("storage profile %q not found in VDC %s", name, v.Name)
+	}
+	return reference{Href: ref.Href}, nil
+}
+
+// findDisk returns the reference of the independent disk of the VDC with the
+// given name, and whether it exists.
+func findDisk(v *vdc, name string) (reference, bool, error) {
+	var found []reference
+	for _, r := range v.ResourceEntities {
+		if r.Type == typeDisk && r.Name == name {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return reference{}, false, nil
+	case 1:
+		return found[0], true, nil
+	}
+	return reference{}, false, fmt.Errorf("more than one disk named %q in VDC %s", name, v.Name)
+}
+
+// CreateDisk creates the independent disk in the VDC of the vApp, unless a
+// disk with its name already exists.
+func (vm *VM) CreateDisk(disk Disk) error {
+	c, _, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	_, err = c.createDisk(v, disk)
+	return err
+}
+
+// createDisk creates the independent disk in the VDC unless it exists, and
+// returns its reference.
+func (c *client) createDisk(v *vdc, disk Disk) (reference, error) {
+	ref, ok, err := findDisk(v, disk.Name)
+	if err != nil || ok {
+		return ref, err
+	}
+
+	params := &diskCreateParams{Xmlns: xmlnsVCloud}
+	params.Disk.Name = disk.Name
+	params.Disk.SizeMB = disk.SizeMB
+	if disk.StorageProfile != "" {
+		profile, err := storageProfile(v, disk.StorageProfile)
+		if err != nil {
+			return reference{}, err
+		}
+		params.Disk.StorageProfile = &profile
+	}
+	l, ok := findLink(v.Links, typeDiskCreateParams, "")
+	if !ok {
+		return reference{}, fmt.Errorf("VDC %s does not allow creating disks", v.Name)
+	}
+
+	created := &diskEntity{}
+	if _, err := c.do("POST", l.Href, typeDiskCreateParams, params, created); err != nil {
+		return reference{}, fmt.Errorf("error creating disk %s: %s", disk.Name, err)
+	}
+	if err := c.waitTasks(created.Tasks); err != nil {
+		return reference{}, fmt.Errorf("error creating disk %s: %s", disk.Name, err)
+	}
+	return reference{Href: created.Href, Name: created.Name, Type: typeDisk}, nil
+}
+
+// DeleteDisk deletes the independent disk with the given name from the VDC of
+// the vApp. It must not be attached to any VM.
+func (vm *VM) DeleteDisk(name string) error {
+	c, _, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	ref, ok, err := findDisk(v, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("disk %q not found in VDC %s", name, v.Name)
+	}
+	if err := c.remove(ref.Href); err != nil {
+		return fmt.Errorf("error deleting disk %s: %s", name, err)
+	}
+	return nil
+}
+
+// AttachDisk attaches the independent disk with the given name to the machine.
+func (vm *VM) AttachDisk(machine, name string) error {
+	return vm.diskAction(machine, name, "attach")
+}
+
+// DetachDisk detaches the independent disk with the given name from the
+// machine.
+func (vm *VM) DetachDisk(machine, name string) error {
+	return vm.diskAction(machine, name, "detach")
+}
+
+// diskAction attaches or detaches the independent disk.
+func (vm *VM) diskAction(machine, name, action string) error {
+	c, _, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	ref, ok, err := findDisk(v, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("disk %q not found in VDC %s", name, v.Name)
+	}
+	return vm.postDiskAction(c, machine, ref, action)
+}
+
+// postDiskAction attaches or detaches the disk of the reference.
+func (vm *VM) postDiskAction(c *client, machine string, ref reference, action string) error {
+	_, m, err := vm.getMachine(machine)
+	if err != nil {
+		return err
+	}
+	params := &diskAttachParams{Xmlns: xmlnsVCloud, Disk: reference{Href: ref.Href}}
+	if err := c.post(m.Href+"/disk/action/"+action, typeDiskAttachParams, params); err != nil {
+		return fmt.Errorf("error running %s of disk %s on machine %s: %s", action, ref.Name, machine, err)
+	}
+	return nil
+}
+
+// diskMachine returns the machine the disk is attached to.
+func (vm *VM) diskMachine(disk Disk) string {
+	if disk.Machine != "" {
+		return disk.Machine
+	}
+	return vm.Machines[0].Name
+}
+
+// attachDisks creates the disks of the vApp which do not exist, and attaches
+// them to their machines.
+func (vm *VM) attachDisks() error {
+	if len(vm.Disks) == 0 {
+		return nil
+	}
+	c, _, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	for _, disk := range vm.Disks {
+		ref, err := c.createDisk(v, disk)
+		if err != nil {
+			return err
+		}
+		if err := vm.postDiskAction(c, vm.diskMachine(disk), ref, "attach"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detachDisks detaches the disks of the vApp from their machines.
+func (vm *VM) detachDisks() error {
+	if len(vm.Disks) == 0 {
+		return nil
+	}
+	c, _, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	for _, disk := range vm.Disks {
+		ref, ok, err := findDisk(v, disk.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := vm.postDiskAction(c, vm.diskMachine(disk), ref, "detach"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vcloud
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Types of NAT rules
+const (
+	NATDestination = "dnat"
+	NATSource      = "snat"
+)
+
+// typeEdgeRule is the media type of the NAT and firewall rules of the edge
+// gateway API.
+const typeEdgeRule = "application/xml"
+
+// NATRule is a NAT rule of the edge gateway between one of its external IPs
+// and a machine of the vApp, such as to expose the machine on the external
+// network like a floating IP.
+type NATRule struct {
+	// Type is one of the NAT constants. A destination rule translates
+	// ExternalIP and ExternalPort to the IP of the machine and InternalPort,
+	// a source rule translates the IP of the machine to ExternalIP.
+	Type string
+	// Machine is the name of the machine of the vApp. It defaults to the
+	// first machine.
+	Machine    string
+	ExternalIP string
+	// Protocol is "tcp", "udp" or "any", the default, for destination rules.
+	Protocol string
+	// ExternalPort and InternalPort are the ports of destination rules. They
+	// default to "any".
+	ExternalPort string
+	InternalPort string
+}
+
+// FirewallRule is a rule of the edge gateway firewall which accepts traffic.
+type FirewallRule struct {
+	Name string
+	// Source and Destination are IPs or CIDRs. They default to "any".
+	Source      string
+	Destination string
+	// Protocol is "tcp", "udp" or "any", the default.
+	Protocol string
+	// Port is the destination port. It defaults to "any".
+	Port string
+}
+
+// natRules is the request to add NAT rules to an edge gateway.
+type natRules struct {
+	XMLName xml.Name  `xml:"natRules"`
+	Rules   []natRule `xml:"natRule"`
+}
+
+// natRule is a NAT rule of an edge gateway.
+type natRule struct {
+	Action            string `xml:"action"`
+	OriginalAddress   string `xml:"originalAddress"`
+	TranslatedAddress string `xml:"translatedAddress"`
+	Protocol          string `xml:"protocol,omitempty"`
+	OriginalPort      string `xml:"originalPort,omitempty"`
+	TranslatedPort    string `xml:"translatedPort,omitempty"`
+	Enabled           bool   `xml:"enabled"`
+	Description       string `xml:"description,omitempty"`
+}
+
+// firewallRules is the request to add firewall rules to an edge gateway.
+type firewallRules struct {
+	XMLName xml.Name       `xml:"firewallRules"`
+	Rules   []firewallRule `xml:"firewallRule"`
+}
+
+// firewallRule is a firewall rule of an edge gateway.
+type firewallRule struct {
+	Name        string       `xml:"name"`
+	Action      string       `xml:"action"`
+	Source      *ruleAddress `xml:"source,omitempty"`
+	Destination *ruleAddress `xml:"destination,omitempty"`
+	Service     *ruleService `xml:"application>service,omitempty"`
+	Enabled     bool         `xml:"enabled"`
+}
+
+// ruleAddress is the source or destination of a firewall rule.
+type ruleAddress struct {
+	IPAddress string `xml:"ipAddress"`
+}
+
+// ruleService is the protocol and port of a firewall rule.
+type ruleService struct {
+	Protocol string `xml:"protocol"`
+	Port     string `xml:"port,omitempty"`
+}
+
+// edgeGateways are the edge gateways of a VDC.
+type edgeGateways struct {
+	Records []reference `xml:"EdgeGatewayRecord"`
+}
+
+// edgeGatewayID returns the ID of the edge gateway of the VDC with the given
+// name, or its only one if name is empty.
+func (c *client) edgeGatewayID(v *vdc, name string) (string, error) {
+	var href string
+	for _, l := range v.Links {
+		if l.Rel == "edgeGateways" {
+			href = l.Href
+			break
+		}
+	}
+	if href == "" {
+		return "", fmt.Errorf("VDC %s has no edge gateway", v.Name)
+	}
+	gateways := &edgeGateways{}
+	if _, err := c.do("GET", href, "", nil, gateways); err != nil {
+		return "", err
+	}
+	if name == "" && len(gateways.Records) == 1 {
+		return path.Base(gateways.Records[0].Href), nil
+	}
+	ref, ok := findRef(gateways.Records, name)
+	if !ok {
+		return "", fmt.Errorf("edge gateway %q not found in VDC %s", name, v.Name)
+	}
+	return path.Base(ref.Href), nil
+}
+
+// addEdgeRule adds the NAT or firewall rules to the edge gateway, and returns
+// the ID of the rule.
+func (c *client) addEdgeRule(edgeID, service string, rules interface{}) (string, error) {
+	header, err := c.do("POST", "/network/edges/"+edgeID+"/"+service+"/config/rules", typeEdgeRule, rules, nil)
+	if err != nil {
+		return "", err
+	}
+	location := header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("no ID returned for the %s rule", service)
+	}
+	return path.Base(location), nil
+}
+
+// removeEdgeRule removes the NAT or firewall rule from the edge gateway.
+func (c *client) removeEdgeRule(edgeID, service, id string) error {
+	_, err := c.do("DELETE", "/network/edges/"+edgeID+"/"+service+"/config/rules/"+id, "", nil, nil)
+	return err
+}
+
+// natRule returns the rule of the edge gateway API of the NAT rule for the
+// machine with the given IP.
+func (r NATRule) natRule(vApp, ip string) natRule {
+	rule := natRule{
+		Action:            r.Type,
+		OriginalAddress:   ip,
+		TranslatedAddress: r.ExternalIP,
+		Enabled:           true,
+		Description:       "libretto " + vApp,
+	}
+	if r.Type == NATDestination {
+		rule.OriginalAddress, rule.TranslatedAddress = r.ExternalIP, ip
+		rule.Protocol = defaultAny(r.Protocol)
+		rule.OriginalPort = defaultAny(r.ExternalPort)
+		rule.TranslatedPort = defaultAny(r.InternalPort)
+	}
+	return rule
+}
+
+// firewallRule returns the rule of the edge gateway API of the firewall rule.
+func (r FirewallRule) firewallRule() firewallRule {
+	rule := firewallRule{Name: r.Name, Action: "accept", Enabled: true}
+	if r.Source != "" && r.Source != "any" {
+		rule.Source = &ruleAddress{IPAddress: r.Source}
+	}
+	if r.Destination != "" && r.Destination != "any" {
+		rule.Destination = &ruleAddress{IPAddress: r.Destination}
+	}
+	if r.Protocol != "" && r.Protocol != "any" {
+		rule.Service = &ruleService{Protocol: r.Protocol, Port: r.Port}
+	}
+	return rule
+}
+
+// defaultAny returns s, or "any" if it is empty.
+func defaultAny(s string) string {
+	if s == "" {
+		return "any"
+	}
+	return s
+}
+
+// hasEdgeRules returns whether the vApp has NAT or firewall rules.
+func (vm *VM) hasEdgeRules() bool {
+	return len(vm.NATRules) > 0 || len(vm.FirewallRules) > 0
+}
+
+// addEdgeRules adds the NAT and firewall rules of the vApp to the edge gateway,
+// and records their IDs so that they are removed when it is destroyed.
+func (vm *VM) addEdgeRules() error {
+	if !vm.hasEdgeRules() {
+		return nil
+	}
+	c, _, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	if vm.EdgeGatewayID == "" {
+		if vm.EdgeGatewayID, err = c.edgeGatewayID(v, vm.EdgeGateway); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range vm.NATRules {
+		name := vm.ruleMachine(r)
+		_, m, err := vm.getMachine(name)
+		if err != nil {
+			return err
+		}
+		ip := machineIP(*m)
+		if ip == "" {
+			return fmt.Errorf("machine %s has no IP to NAT", name)
+		}
+		id, err := c.addEdgeRule(vm.EdgeGatewayID, "nat", &natRules{Rules: []natRule{r.natRule(vm.Name, ip)}})
+		if err != nil {
+			return fmt.Errorf("error adding NAT rule of machine %s: %s", name, err)
+		}
+		vm.NATRuleIDs = append(vm.NATRuleIDs, id)
+	}
+	for _, r := range vm.FirewallRules {
+		id, err := c.addEdgeRule(vm.EdgeGatewayID, "firewall", &firewallRules{Rules: []firewallRule{r.firewallRule()}})
+		if err != nil {
+			return fmt.Errorf("error adding firewall rule %s: %s", r.Name, err)
+		}
+		vm.FirewallRuleIDs = append(vm.FirewallRuleIDs, id)
+	}
+	return nil
+}
+
+// removeEdgeRules removes the NAT and firewall rules added to the edge gateway
+// by addEdgeRules. The rules which cannot be removed are kept so that it can be
+// retried.
+func (vm *VM) removeEdgeRules() error {
+	if len(vm.NATRuleIDs) == 0 && len(vm.FirewallRuleIDs) == 0 {
+		return nil
+	}
+	c, err := vm.session()
+	if err != nil {
+		return err
+	}
+	var errs []string
+	remove := func(service string, ids []string) []string {
+		var kept []string
+		for _, id := range ids {
+			if err := c.removeEdgeRule(vm.EdgeGatewayID, service, id); err != nil {
+				errs = append(errs, fmt.Sprintf("error removing %s rule %s: %s", service, id, err))
+				kept = append(kept, id)
+			}
+		}
+		return kept
+	}
+	vm.NATRuleIDs = remove("nat", vm.NATRuleIDs)
+	vm.FirewallRuleIDs = remove("firewall", vm.FirewallRuleIDs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// ruleMachine returns the name of the machine of the NAT rule.
+func (vm *VM) ruleMachine(r NATRule) string {
+	if r.Machine != "" {
+		return r.Machine
+	}
+	return vm.Machines[0].Name
+}
+
+// machineIP returns the IP of the primary NIC of the VM on its network, or an
+// empty string if it has none.
+func machineIP(m vmEntity) string {
+	primary := -1
+	var ip string
+	for _, conn := range m.NetworkConnections {
+		if conn.IPAddress != "" && (primary < 0 || conn.Index < primary) {
+			primary, ip = conn.Index, conn.IPAddress
+		}
+	}
+	return ip
+}
+
+// sshAddress returns the external IP and port of the first destination NAT
+// rule of the first machine which forwards to its SSH port, if any.
+func (vm *VM) sshAddress() (net.IP, int, bool) {
+	for _, r := range vm.NATRules {
+		if r.Type != NATDestination || vm.ruleMachine(r) != vm.Machines[0].Name {
+			continue
+		}
+		if r.InternalPort != "22" && defaultAny(r.InternalPort) != "any" {
+			continue
+		}
+		ip := net.ParseIP(r.ExternalIP)
+		if ip == nil {
+			continue
+		}
+		port := 22
+		if r.ExternalPort != "" && r.ExternalPort != "any" {
+			p, err := strconv.Atoi(r.ExternalPort)
+			if err != nil {
+				continue
+			}
+			port = p
+		}
+		return ip, port, true
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,715 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+// Package vcloud provides a standard way to create vApps of virtual machines on
+// VMware vCloud Director.
+package vcloud
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/apcera/libretto/ssh"
+	"github.com/apcera/libretto/util"
+	lvm "github.com/apcera/libretto/virtualmachine"
+	"github.com/apcera/util/uuid"
+)
+
+// IP allocation modes of network connections
+const (
+	IPAllocationPool   = "POOL"
+	IPAllocationDHCP   = "DHCP"
+	IPAllocationManual = "MANUAL"
+)
+
+// Fence modes of vApp networks
+const (
+	FenceBridged = "bridged"
+	FenceNAT     = "natRouted"
+	FenceIsolate = "isolated"
+)
+
+// Statuses of vApps and their VMs
+const (
+	statusFailedCreation = -1
+	statusUnresolved     = 0
+	statusResolved       = 1
+	statusSuspended      = 3
+	statusPoweredOn      = 4
+	statusWaitingInput   = 5
+	statusPoweredOff     = 8
+	statusInconsistent   = 9
+)
+
+// VM represents a vCloud Director vApp composed of one or more machines, each
+// created from a vApp template of a catalog.
+type VM struct {
+	// Endpoint is the URL of vCloud Director, such as
+	// "https://vcd.example.com".
+	Endpoint string
+	Username string
+	Password string
+	// Org is the organization the user belongs to, and the vApp is created
+	// in.
+	Org string
+	// VDC is the virtual data center of the organization the vApp is created
+	// in. The only one of the organization is used if it is empty.
+	VDC string
+	// Insecure skips the verification of the certificate of Endpoint.
+	Insecure bool
+
+	// Name is the name of the vApp. A random one is used if it is empty.
+	Name        string
+	Description string
+	// Catalog is the catalog the templates of the machines are in, unless
+	// they set their own.
+	Catalog string
+	// Networks are the org VDC networks of the vApp.
+	Networks []Network
+	// Machines are the VMs of the vApp.
+	Machines []Machine
+	// StorageProfile is the storage profile of the VDC the machines are
+	// stored on, unless they set their own. The default one of the VDC is
+	// used if it is empty.
+	StorageProfile string
+	// Disks are the independent disks attached to the machines once they
+	// are created. They are detached, but not deleted, when the vApp is
+	// destroyed, so their data survives it.
+	Disks []Disk
+
+	// EdgeGateway is the name of the edge gateway of the VDC NATRules and
+	// FirewallRules are added to. The only one of the VDC is used if it is
+	// empty.
+	EdgeGateway string
+	// NATRules expose the machines on the external network of the edge
+	// gateway. They are removed when the vApp is destroyed.
+	NATRules []NATRule
+	// FirewallRules are added to the firewall of the edge gateway, typically
+	// to accept the traffic of NATRules. They are removed when the vApp is
+	// destroyed.
+	FirewallRules []FirewallRule
+
+	// Credentials are used to connect to the machines with SSH.
+	Credentials ssh.Credentials
+
+	// Href is the URL of the vApp, set by Provision.
+	Href string
+	// EdgeGatewayID, NATRuleIDs and FirewallRuleIDs are the IDs of the edge
+	// gateway and of the rules added to it, set by Provision.
+	EdgeGatewayID   string
+	NATRuleIDs      []string
+	FirewallRuleIDs []string
+
+	client *client
+}
+
+// Network is an org VDC network the vApp is connected to.
+type Network struct {
+	// Name is the name of the org VDC network.
+	Name string
+	// FenceMode is one of the Fence constants. It defaults to FenceBridged.
+	FenceMode string
+}
+
+// Machine is a VM of the vApp.
+type Machine struct {
+	// Name is the name of the VM in the vApp. It defaults to the name of the
+	// vApp followed by the index of the machine.
+	Name string
+	// Template is the name of the catalog item of the vApp template the VM
+	// is created from.
+	Template string
+	// Catalog is the catalog of Template, instead of the one of the vApp.
+	Catalog string
+	// TemplateVM is the name of the VM of the template to create the machine
+	// from. The first one is used if it is empty.
+	TemplateVM string
+	// Network is the name of the network of the vApp the VM is connected
+	// to. It defaults to the first network of the vApp. The VM is not
+	// connected to any network if the vApp has none.
+	Network string
+	// IPAllocationMode is one of the IPAllocation constants. It defaults to
+	// IPAllocationManual if IP is set, and IPAllocationPool otherwise.
+	IPAllocationMode string
+	IP               string
+	// Customization is the guest customization of the VM, if any.
+	Customization *Customization
+	// StorageProfile is the storage profile of the VM, instead of the one
+	// of the vApp.
+	StorageProfile string
+}
+
+// Customization is the guest customization of a machine, which is applied
+// when the machine is first powered on.
+type Customization struct {
+	// ComputerName is the host name of the guest.
+	ComputerName string
+	// AdminPassword is the password of the administrator of the guest. A
+	// random one is generated if it is empty and AutoAdminPassword is set.
+	AdminPassword     string
+	AutoAdminPassword bool
+	// Script is a script run in the guest during the customization.
+	Script string
+}
+
+// vApp is a vApp of a VDC.
+type vApp struct {
+	Href     string     `xml:"href,attr"`
+	Name     string     `xml:"name,attr"`
+	Status   int        `xml:"status,attr"`
+	Deployed bool       `xml:"deployed,attr"`
+	Links    []link     `xml:"Link"`
+	Tasks    []task     `xml:"Tasks>Task"`
+	VMs      []vmEntity `xml:"Children>Vm"`
+}
+
+// vmEntity is a VM of a vApp.
+type vmEntity struct {
+	Href               string              `xml:"href,attr"`
+	Name               string              `xml:"name,attr"`
+	Status             int                 `xml:"status,attr"`
+	Links              []link              `xml:"Link"`
+	NetworkConnections []networkConnection `xml:"NetworkConnectionSection>NetworkConnection"`
+}
+
+// networkConnection is a NIC of a VM.
+type networkConnection struct {
+	Network           string `xml:"network,attr"`
+	Index             int    `xml:"NetworkConnectionIndex"`
+	IPAddress         string `xml:"IpAddress"`
+	ExternalIPAddress string `xml:"ExternalIpAddress"`
+}
+
+// composeVAppParams is the request to compose a vApp.
+type composeVAppParams struct {
+	XMLName             xml.Name             `xml:"ComposeVAppParams"`
+	Xmlns               string               `xml:"xmlns,attr"`
+	XmlnsOVF            string               `xml:"xmlns:ovf,attr"`
+	Name                string               `xml:"name,attr"`
+	Deploy              bool                 `xml:"deploy,attr"`
+	PowerOn             bool                 `xml:"powerOn,attr"`
+	Description         string               `xml:"Description,omitempty"`
+	InstantiationParams *instantiationParams `xml:"InstantiationParams,omitempty"`
+	SourcedItems        []sourcedItem        `xml:"SourcedItem"`
+	AllEULAsAccepted    bool                 `xml:"AllEULAsAccepted"`
+}
+
+// instantiationParams are the network configuration of a vApp.
+type instantiationParams struct {
+	Info           string              `xml:"NetworkConfigSection>ovf:Info"`
+	NetworkConfigs []vAppNetworkConfig `xml:"NetworkConfigSection>NetworkConfig"`
+}
+
+// vAppNetworkConfig is a network of a vApp.
+type vAppNetworkConfig struct {
+	NetworkName   string    `xml:"networkName,attr"`
+	ParentNetwork reference `xml:"Configuration>ParentNetwork"`
+	FenceMode     string    `xml:"Configuration>FenceMode"`
+}
+
+// sourcedItem is a VM of a vApp template to add to a vApp.
+type sourcedItem struct {
+	Source              reference              `xml:"Source"`
+	InstantiationParams *vmInstantiationParams `xml:"InstantiationParams,omitempty"`
+	StorageProfile      *reference             `xml:"StorageProfile,omitempty"`
+}
+
+// vmInstantiationParams are the sections of a VM added to a vApp.
+type vmInstantiationParams struct {
+	NetworkConnectionSection  *networkConnectionSection  `xml:"NetworkConnectionSection,omitempty"`
+	GuestCustomizationSection *guestCustomizationSection `xml:"GuestCustomizationSection,omitempty"`
+}
+
+// networkConnectionSection are the NICs of a VM.
+type networkConnectionSection struct {
+	Info                          string                    `xml:"ovf:Info"`
+	PrimaryNetworkConnectionIndex int                       `xml:"PrimaryNetworkConnectionIndex"`
+	NetworkConnections            []networkConnectionParams `xml:"NetworkConnection"`
+}
+
+// networkConnectionParams is a NIC of a VM.
+type networkConnectionParams struct {
+	Network          string `xml:"network,attr"`
+	Index            int    `xml:"NetworkConnectionIndex"`
+	IPAddress        string `xml:"IpAddress,omitempty"`
+	IsConnected      bool   `xml:"IsConnected"`
+	IPAllocationMode string `xml:"IpAddressAllocationMode"`
+}
+
+// guestCustomizationSection is the guest customization of a VM.
+type guestCustomizationSection struct {
+	Info                 string `xml:"ovf:Info"`
+	Enabled              bool   `xml:"Enabled"`
+	AdminPasswordEnabled bool   `xml:"AdminPasswordEnabled"`
+	AdminPasswordAuto    bool   `xml:"AdminPasswordAuto"`
+	AdminPassword        string `xml:"AdminPassword,omitempty"`
+	CustomizationScript  string `xml:"CustomizationScript,omitempty"`
+	ComputerName         string `xml:"ComputerName,omitempty"`
+}
+
+// undeployVAppParams is the request to undeploy a vApp.
+type undeployVAppParams struct {
+	XMLName     xml.Name `xml:"UndeployVAppParams"`
+	Xmlns       string   `xml:"xmlns,attr"`
+	PowerAction string   `xml:"UndeployPowerAction"`
+}
+
+// Compile-time check that VM implements the VirtualMachine interface.
+var _ lvm.VirtualMachine = (*VM)(nil)
+
+// GetName returns the name of the vApp.
+func (vm *VM) GetName() string {
+	return vm.Name
+}
+
+// session returns the client of the vCloud Director session, logging in if
+// needed.
+func (vm *VM) session() (*client, error) {
+	if vm.client != nil {
+		return vm.client, nil
+	}
+	c, err := newClient(vm.Endpoint, vm.Username, vm.Password, vm.Org, vm.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	vm.client = c
+	return c, nil
+}
+
+// getVDC returns the client, the organization and the VDC of the vApp.
+func (vm *VM) getVDC() (*client, *org, *vdc, error) {
+	c, err := vm.session()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	o, err := c.org(vm.Org)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	v, err := c.vdc(o, vm.VDC)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c, o, v, nil
+}
+
+// getVApp returns the client and the vApp.
+func (vm *VM) getVApp() (*client, *vApp, error) {
+	if vm.Href == "" {
+		return nil, nil, ErrNoVApp
+	}
+	c, err := vm.session()
+	if err != nil {
+		return nil, nil, err
+	}
+	app := &vApp{}
+	if _, err := c.do("GET", vm.Href, "", nil, app); err != nil {
+		return nil, nil, err
+	}
+	return c, app, nil
+}
+
+// getMachine returns the client and the VM of the vApp with the given name.
+func (vm *VM) getMachine(name string) (*client, *vmEntity, error) {
+	c, app, err := vm.getVApp()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range app.VMs {
+		if app.VMs[i].Name == name {
+			return c, &app.VMs[i], nil
+		}
+	}
+	return nil, nil, ErrMachineNotFound
+}
+
+// Provision composes the vApp from the templates of the machines, connects it
+// to its networks and powers it on, which applies the guest customizations.
+func (vm *VM) Provision() error {
+	if len(vm.Machines) == 0 {
+		return lvm.ErrSourceNotSpecified
+	}
+	if vm.Name == "" {
+		vm.Name = fmt.Sprintf("libretto-%s", uuid.Variant4())
+	}
+
+	c, o, v, err := vm.getVDC()
+	if err != nil {
+		return err
+	}
+	params, err := vm.composeParams(c, o, v)
+	if err != nil {
+		return err
+	}
+	l, ok := findLink(v.Links, typeComposeVAppParams, "")
+	if !ok {
+		return fmt.Errorf("VDC %s does not allow composing vApps", v.Name)
+	}
+
+	app := &vApp{}
+	if _, err := c.do("POST", l.Href, typeComposeVAppParams, params, app); err != nil {
+		return lvm.WrapErrors(lvm.ErrCreatingVM, err)
+	}
+	vm.Href = app.Href
+	if err := c.waitTasks(app.Tasks); err != nil {
+		return lvm.WrapErrors(lvm.ErrCreatingVM, err)
+	}
+	if err := vm.attachDisks(); err != nil {
+		return err
+	}
+	return vm.addEdgeRules()
+}
+
+// composeParams returns the request to compose the vApp.
+func (vm *VM) composeParams(c *client, o *org, v *vdc) (*composeVAppParams, error) {
+	params := &composeVAppParams{
+		Xmlns:            xmlnsVCloud,
+		XmlnsOVF:         xmlnsOVF,
+		Name:             vm.Name,
+		Deploy:           true,
+		PowerOn:          true,
+		Description:      vm.Description,
+		AllEULAsAccepted: true,
+	}
+
+	if len(vm.Networks) > 0 {
+		params.InstantiationParams = &instantiationParams{Info: "Configuration parameters for logical networks"}
+		for _, n := range vm.Networks {
+			ref, ok := findRef(v.AvailableNetworks, n.Name)
+			if !ok {
+				return nil, fmt.Errorf("network %q not found in VDC %s", n.Name, v.Name)
+			}
+			fenceMode := n.FenceMode
+			if fenceMode == "" {
+				fenceMode = FenceBridged
+			}
+			params.InstantiationParams.NetworkConfigs = append(params.InstantiationParams.NetworkConfigs,
+				vAppNetworkConfig{NetworkName: n.Name, ParentNetwork: reference{Href: ref.Href}, FenceMode: fenceMode})
+		}
+	}
+
+	templates := map[string]*vAppTemplate{}
+	for i, m := range vm.Machines {
+		catalogName := m.Catalog
+		if catalogName == "" {
+			catalogName = vm.Catalog
+		}
+		key := catalogName + "/" + m.Template
+		template, ok := templates[key]
+		if !ok {
+			var err error
+			template, err = c.template(o, catalogName, m.Template)
+			if err != nil {
+				return nil, err
+			}
+			templates[key] = template
+		}
+		source, err := templateVM(template, m.TemplateVM)
+		if err != nil {
+			return nil, err
+		}
+
+		name := m.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%d", vm.Name, i)
+			vm.Machines[i].Name = name
+		}
+		item := sourcedItem{
+			Source:              reference{Href: source.Href, Name: name},
+			InstantiationParams: &vmInstantiationParams{},
+		}
+		if len(vm.Networks) > 0 {
+			item.InstantiationParams.NetworkConnectionSection = vm.networkConnection(m)
+		}
+		if m.Customization != nil {
+			item.InstantiationParams.GuestCustomizationSection = customizationSection(m.Customization)
+		}
+		profile := m.StorageProfile
+		if profile == "" {
+			profile = vm.StorageProfile
+		}
+		if profile != "" {
+			ref, err := storageProfile(v, profile)
+			if err != nil {
+				return nil, err
+			}
+			item.StorageProfile = &ref
+		}
+		params.SourcedItems = append(params.SourcedItems, item)
+	}
+	return params, nil
+}
+
+// template returns the vApp template of the catalog item.
+func (c *client) template(o *org, catalogName, itemName string) (*vAppTemplate, error) {
+	cat, err := c.catalog(o, catalogName)
+	if err != nil {
+		return nil, err
+	}
+	item, err := c.catalogItem(cat, itemName)
+	if err != nil {
+		return nil, err
+	}
+	template := &vAppTemplate{}
+	if _, err := c.do("GET", item.Entity.Href, "", nil, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// templateVM returns the VM of the template with the given name, or its first
+// one if name is empty.
+func templateVM(template *vAppTemplate, name string) (reference, error) {
+	if len(template.VMs) == 0 {
+		return reference{}, fmt.Errorf("template %s has no VM", template.Name)
+	}
+	if name == "" {
+		return template.VMs[0], nil
+	}
+	if ref, ok := findRef(template.VMs, name); ok {
+		return ref, nil
+	}
+	return reference{}, fmt.Errorf("VM %q not found in template %s", name, template.Name)
+}
+
+// networkConnection returns the NIC section of the machine.
+func (vm *VM) networkConnection(m Machine) *networkConnectionSection {
+	network := m.Network
+	if network == "" {
+		network = vm.Networks[0].Name
+	}
+	mode := m.IPAllocationMode
+	if mode == "" {
+		mode = IPAllocationPool
+		if m.IP != "" {
+			mode = IPAllocationManual
+		}
+	}
+	return &networkConnectionSection{
+		Info: "Specifies the available VM network connections",
+		NetworkConnections: []networkConnectionParams{
+			{Network: network, IPAddress: m.IP, IsConnected: true, IPAllocationMode: mode},
+		},
+	}
+}
+
+// customizationSection returns the guest customization section of the
+// customization.
+func customizationSection(cust *Customization) *guestCustomizationSection {
+	return &guestCustomizationSection{
+		Info:                 "Specifies Guest OS Customization Settings",
+		Enabled:              true,
+		AdminPasswordEnabled: cust.AdminPassword != "" || cust.AutoAdminPassword,
+		AdminPasswordAuto:    cust.AdminPassword == "" && cust.AutoAdminPassword,
+		AdminPassword:        cust.AdminPassword,
+		CustomizationScript:  cust.Script,
+		ComputerName:         cust.ComputerName,
+	}
+}
+
+// GetIPs returns the IP addresses of the machines, in the order of the
+// machines, the external addresses of NAT-routed NICs first.
+func (vm *VM) GetIPs() ([]net.IP, error) {
+	_, app, err := vm.getVApp()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, m := range vm.orderedVMs(app) {
+		ips = append(ips, machineIPs(m)...)
+	}
+	return ips, nil
+}
+
+// orderedVMs returns the VMs of the vApp in the order of the machines.
+func (vm *VM) orderedVMs(app *vApp) []vmEntity {
+	order := map[string]int{}
+	for i, m := range vm.Machines {
+		order[m.Name] = i
+	}
+	vms := append([]vmEntity(nil), app.VMs...)
+	sort.SliceStable(vms, func(i, j int) bool {
+		return order[vms[i].Name] < order[vms[j].Name]
+	})
+	return vms
+}
+
+// machineIPs returns the IP addresses of the VM, in the order of its NICs.
+func machineIPs(m vmEntity) []net.IP {
+	conns := append([]networkConnection(nil), m.NetworkConnections...)
+	sort.SliceStable(conns, func(i, j int) bool {
+		return conns[i].Index < conns[j].Index
+	})
+	var ips []net.IP
+	for _, conn := range conns {
+		for _, addr := range []string{conn.ExternalIPAddress, conn.IPAddress} {
+			if ip := net.ParseIP(addr); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// GetSSH returns an SSH client connected to the first machine of the vApp,
+// through the external IP of its NAT rule forwarding SSH, if any.
+func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	if ip, port, ok := vm.sshAddress(); ok {
+		return &ssh.SSHClient{Creds: &vm.Credentials, IP: ip, Port: port, Options: options}, nil
+	}
+	ips, err := util.GetVMIPs(vm, options)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.SSHClient{Creds: &vm.Credentials, IP: ips[0], Port: 22, Options: options}, nil
+}
+
+// GetMachineSSH returns an SSH client connected to the machine with the given
+// name.
+func (vm *VM) GetMachineSSH(name string, options ssh.Options) (ssh.Client, error) {
+	_, m, err := vm.getMachine(name)
+	if err != nil {
+		return nil, err
+	}
+	ips := machineIPs(*m)
+	if len(ips) == 0 {
+		return nil, lvm.ErrVMNoIP
+	}
+	return &ssh.SSHClient{Creds: &vm.Credentials, IP: ips[0], Port: 22, Options: options}, nil
+}
+
+// Destroy removes the edge gateway rules of the vApp, then powers off and
+// deletes the vApp and its machines. The vApp is deleted even if the rules
+// cannot be removed.
+func (vm *VM) Destroy() error {
+	c, app, err := vm.getVApp()
+	if err != nil {
+		return err
+	}
+	rulesErr := vm.removeEdgeRules()
+	if app.Deployed {
+		params := &undeployVAppParams{Xmlns: xmlnsVCloud, PowerAction: "powerOff"}
+		if err := c.post(app.Href+"/action/undeploy", typeUndeployParams, params); err != nil {
+			return lvm.WrapErrors(lvm.ErrStoppingVM, err)
+		}
+	}
+	// Keep the independent disks, which are deleted with the VMs they are
+	// attached to.
+	if err := vm.detachDisks(); err != nil {
+		return lvm.WrapErrors(lvm.ErrDeletingVM, err)
+	}
+	if err := c.remove(app.Href); err != nil {
+		return lvm.WrapErrors(lvm.ErrDeletingVM, err)
+	}
+	vm.Href = ""
+	if rulesErr != nil {
+		return lvm.WrapErrors(lvm.ErrDeletingVM, rulesErr)
+	}
+	return nil
+}
+
+// GetState returns the state of the vApp.
+func (vm *VM) GetState() (string, error) {
+	_, app, err := vm.getVApp()
+	if err != nil {
+		return "", err
+	}
+	return mapState(app.Status), nil
+}
+
+// GetMachineState returns the state of the machine with the given name.
+func (vm *VM) GetMachineState(name string) (string, error) {
+	_, m, err := vm.getMachine(name)
+	if err != nil {
+		return "", err
+	}
+	return mapState(m.Status), nil
+}
+
+// mapState maps the status of a vApp or a VM to a libretto state.
+func mapState(status int) string {
+	switch status {
+	case statusPoweredOn:
+		return lvm.VMRunning
+	case statusPoweredOff, statusResolved:
+		return lvm.VMHalted
+	case statusSuspended:
+		return lvm.VMSuspended
+	case statusUnresolved, statusWaitingInput:
+		return lvm.VMPending
+	case statusFailedCreation, statusInconsistent:
+		return lvm.VMError
+	}
+	return lvm.VMUnknown
+}
+
+// Suspend suspends the machines of the vApp.
+func (vm *VM) Suspend() error {
+	return vm.power("suspend", lvm.ErrSuspendingVM)
+}
+
+// Resume resumes the suspended machines of the vApp.
+func (vm *VM) Resume() error {
+	return vm.power("powerOn", lvm.ErrResumingVM)
+}
+
+// Halt powers off the machines of the vApp.
+func (vm *VM) Halt() error {
+	return vm.power("powerOff", lvm.ErrStoppingVM)
+}
+
+// Start powers on the machines of the vApp.
+func (vm *VM) Start() error {
+	return vm.power("powerOn", lvm.ErrStartingVM)
+}
+
+// power runs a power action on the vApp.
+func (vm *VM) power(action string, errAction error) error {
+	c, app, err := vm.getVApp()
+	if err != nil {
+		return err
+	}
+	if err := c.post(app.Href+"/power/action/"+action, "", nil); err != nil {
+		return lvm.WrapErrors(errAction, err)
+	}
+	return nil
+}
+
+// PowerOnMachine powers on the machine with the given name.
+func (vm *VM) PowerOnMachine(name string) error {
+	return vm.powerMachine(name, "powerOn", lvm.ErrStartingVM)
+}
+
+// PowerOffMachine powers off the machine with the given name.
+func (vm *VM) PowerOffMachine(name string) error {
+	return vm.powerMachine(name, "powerOff", lvm.ErrStoppingVM)
+}
+
+// ShutdownMachine shuts down the guest of the machine with the given name,
+// which requires VMware Tools.
+func (vm *VM) ShutdownMachine(name string) error {
+	return vm.powerMachine(name, "shutdown", lvm.ErrStoppingVM)
+}
+
+// ResetMachine resets the machine with the given name.
+func (vm *VM) ResetMachine(name string) error {
+	return vm.powerMachine(name, "reset", lvm.ErrStartingVM)
+}
+
+// SuspendMachine suspends the machine with the given name.
+func (vm *VM) SuspendMachine(name string) error {
+	return vm.powerMachine(name, "suspend", lvm.ErrSuspendingVM)
+}
+
+// powerMachine runs a power action on the machine with the given name.
+func (vm *VM) powerMachine(name, action string, errAction error) error {
+	c, m, err := vm.getMachine(name)
+	if err != nil {
+		return err
+	}
+	if err := c.post(m.Href+"/power/action/"+action, "", nil); err != nil {
+		return lvm.WrapErrors(errAction, err)
+	}
+	return nil
+}
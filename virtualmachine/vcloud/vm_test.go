@@ -0,0 +1,318 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vcloud
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apcera/libretto/ssh"
+)
+
+// fakeVCD is a fake vCloud Director serving fixed documents and Location
+// headers, which records the bodies of the requests.
+type fakeVCD struct {
+	*httptest.Server
+	docs      map[string]string
+	locations map[string]string
+	bodies    map[string]string
+}
+
+func newFakeVCD(t *testing.T) *fakeVCD {
+	f := &fakeVCD{docs: map[string]string{}, locations: map[string]string{}, bodies: map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/sessions" {
+			if user, _, _ := r.BasicAuth(); user != "user@org" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("X-Vmware-Vcloud-Access-Token", "token")
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		f.bodies[r.Method+" "+r.URL.Path] = string(b)
+		doc, ok := f.docs[r.Method+" "+r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `<Error message="%s %s not found"/>`, r.Method, r.URL.Path)
+			return
+		}
+		if location, ok := f.locations[r.Method+" "+r.URL.Path]; ok {
+			w.Header().Set("Location", location)
+		}
+		fmt.Fprint(w, strings.Replace(doc, "URL", f.URL, -1))
+	}))
+	return f
+}
+
+func (f *fakeVCD) vm() *VM {
+	return &VM{Endpoint: f.URL, Username: "user", Password: "password", Org: "org"}
+}
+
+// addVDC serves an organization with a VDC, a network and a catalog with a
+// template.
+func (f *fakeVCD) addVDC() {
+	f.docs["GET /api/org"] = `<OrgList><Org name="org" href="URL/api/org/1"/></OrgList>`
+	f.docs["GET /api/org/1"] = `<Org name="org" href="URL/api/org/1">
+		<Link rel="down" type="application/vnd.vmware.vcloud.vdc+xml" name="vdc" href="URL/api/vdc/1"/>
+		<Link rel="down" type="application/vnd.vmware.vcloud.catalog+xml" name="catalog" href="URL/api/catalog/1"/>
+	</Org>`
+	f.docs["GET /api/vdc/1"] = `<Vdc name="vdc" href="URL/api/vdc/1">
+		<Link rel="add" type="application/vnd.vmware.vcloud.composeVAppParams+xml" href="URL/api/vdc/1/action/composeVApp"/>
+		<AvailableNetworks><Network name="net" href="URL/api/network/1"/></AvailableNetworks>
+	</Vdc>`
+	f.docs["GET /api/catalog/1"] = `<Catalog name="catalog" href="URL/api/catalog/1">
+		<CatalogItems><CatalogItem name="ubuntu" href="URL/api/catalogItem/1"/></CatalogItems>
+	</Catalog>`
+	f.docs["GET /api/catalogItem/1"] = `<CatalogItem name="ubuntu" href="URL/api/catalogItem/1">
+		<Entity href="URL/api/vAppTemplate/1"/>
+	</CatalogItem>`
+	f.docs["GET /api/vAppTemplate/1"] = `<VAppTemplate name="ubuntu" href="URL/api/vAppTemplate/1">
+		<Children><Vm name="ubuntu-vm" href="URL/api/vAppTemplate/vm-1"/></Children>
+	</VAppTemplate>`
+}
+
+// TestProvision makes sure the vApp is composed of the machines.
+func TestProvision(t *testing.T) {
+	f := newFakeVCD(t)
+	defer f.Close()
+	f.addVDC()
+	f.docs["POST /api/vdc/1/action/composeVApp"] = `<VApp name="app" href="URL/api/vApp/1">
+		<Tasks><Task status="running" operation="compose" href="URL/api/task/1"/></Tasks>
+	</VApp>`
+	f.docs["GET /api/task/1"] = `<Task status="success" operation="compose" href="URL/api/task/1"/>`
+	taskPollInterval = 0
+
+	vm := f.vm()
+	vm.Name = "app"
+	vm.Catalog = "catalog"
+	vm.Networks = []Network{{Name: "net"}}
+	vm.Machines = []Machine{
+		{Template: "ubuntu", Customization: &Customization{ComputerName: "web", Script: "echo"}},
+		{Name: "db", Template: "ubuntu", IP: "10.0.0.5"},
+	}
+	if err := vm.Provision(); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if vm.Href != f.URL+"/api/vApp/1" {
+		t.Fatalf("Expected the vApp href to be set, got: %q", vm.Href)
+	}
+	if vm.Machines[0].Name != "app-0" {
+		t.Fatalf("Expected the default machine name app-0, got: %q", vm.Machines[0].Name)
+	}
+
+	body := f.bodies["POST /api/vdc/1/action/composeVApp"]
+	for _, want := range []string{
+		`<ComposeVAppParams xmlns="http://www.vmware.com/vcloud/v1.5" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" name="app" deploy="true" powerOn="true">`,
+		`<NetworkConfig networkName="net"><Configuration><ParentNetwork href="` + f.URL + `/api/network/1"></ParentNetwork><FenceMode>bridged</FenceMode></Configuration></NetworkConfig>`,
+		`<Source href="` + f.URL + `/api/vAppTemplate/vm-1" name="app-0"></Source>`,
+		`<IpAddressAllocationMode>POOL</IpAddressAllocationMode>`,
+		`<IpAddress>10.0.0.5</IpAddress><IsConnected>true</IsConnected><IpAddressAllocationMode>MANUAL</IpAddressAllocationMode>`,
+		`<CustomizationScript>echo</CustomizationScript><ComputerName>web</ComputerName>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the compose request to contain %s, got: %s", want, body)
+		}
+	}
+}
+
+// TestGetIPs makes sure IPs are returned in the order of the machines and
+// their NICs, external addresses first.
+func TestGetIPs(t *testing.T) {
+	f := newFakeVCD(t)
+	defer f.Close()
+	f.docs["GET /api/vApp/1"] = `<VApp name="app" status="4" href="URL/api/vApp/1"><Children>
+		<Vm name="db" status="4" href="URL/api/vApp/vm-2"><NetworkConnectionSection>
+			<NetworkConnection network="net"><NetworkConnectionIndex>0</NetworkConnectionIndex><IpAddress>10.0.0.2</IpAddress></NetworkConnection>
+		</NetworkConnectionSection></Vm>
+		<Vm name="web" status="8" href="URL/api/vApp/vm-1"><NetworkConnectionSection>
+			<NetworkConnection network="net"><NetworkConnectionIndex>1</NetworkConnectionIndex><IpAddress>10.0.1.1</IpAddress></NetworkConnection>
+			<NetworkConnection network="net"><NetworkConnectionIndex>0</NetworkConnectionIndex><IpAddress>10.0.0.1</IpAddress><ExternalIpAddress>1.2.3.4</ExternalIpAddress></NetworkConnection>
+		</NetworkConnectionSection></Vm>
+	</Children></VApp>`
+
+	vm := f.vm()
+	vm.Href = f.URL + "/api/vApp/1"
+	vm.Machines = []Machine{{Name: "web"}, {Name: "db"}}
+	ips, err := vm.GetIPs()
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	var got []string
+	for _, ip := range ips {
+		got = append(got, ip.String())
+	}
+	if want := "1.2.3.4 10.0.0.1 10.0.1.1 10.0.0.2"; strings.Join(got, " ") != want {
+		t.Fatalf("Expected IPs %s, got: %s", want, strings.Join(got, " "))
+	}
+
+	if state, err := vm.GetMachineState("web"); err != nil || state != "halted" {
+		t.Fatalf("Expected machine web to be halted, got: %q, %v", state, err)
+	}
+	if _, err := vm.GetMachineState("cache"); err != ErrMachineNotFound {
+		t.Fatalf("Expected ErrMachineNotFound, got: %v", err)
+	}
+}
+
+// TestAttachDisks makes sure missing disks are created on their storage
+// profile, and all of them are attached to their machines.
+func TestAttachDisks(t *testing.T) {
+	f := newFakeVCD(t)
+	defer f.Close()
+	f.addVDC()
+	f.docs["GET /api/vdc/1"] = `<Vdc name="vdc" href="URL/api/vdc/1">
+		<Link rel="add" type="application/vnd.vmware.vcloud.diskCreateParams+xml" href="URL/api/vdc/1/disk"/>
+		<ResourceEntities><ResourceEntity type="application/vnd.vmware.vcloud.disk+xml" name="data" href="URL/api/disk/1"/></ResourceEntities>
+		<VdcStorageProfiles><VdcStorageProfile name="fast" href="URL/api/vdcStorageProfile/1"/></VdcStorageProfiles>
+	</Vdc>`
+	f.docs["POST /api/vdc/1/disk"] = `<Disk name="logs" href="URL/api/disk/2"/>`
+	f.docs["GET /api/vApp/1"] = `<VApp name="app" href="URL/api/vApp/1"><Children>
+		<Vm name="web" href="URL/api/vApp/vm-1"/><Vm name="db" href="URL/api/vApp/vm-2"/>
+	</Children></VApp>`
+	f.docs["POST /api/vApp/vm-1/disk/action/attach"] = `<Task status="success" href="URL/api/task/1"/>`
+	f.docs["POST /api/vApp/vm-2/disk/action/attach"] = `<Task status="success" href="URL/api/task/2"/>`
+
+	vm := f.vm()
+	vm.Href = f.URL + "/api/vApp/1"
+	vm.Machines = []Machine{{Name: "web"}, {Name: "db"}}
+	vm.Disks = []Disk{{Name: "data"}, {Name: "logs", SizeMB: 1024, StorageProfile: "fast", Machine: "db"}}
+	if err := vm.attachDisks(); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+
+	create := f.bodies["POST /api/vdc/1/disk"]
+	want := `<Disk name="logs" sizeMb="1024"><StorageProfile href="` + f.URL + `/api/vdcStorageProfile/1"></StorageProfile></Disk>`
+	if !strings.Contains(create, want) {
+		t.Errorf("Expected the create request to contain %s, got: %s", want, create)
+	}
+	for path, disk := range map[string]string{"/api/vApp/vm-1": "/api/disk/1", "/api/vApp/vm-2": "/api/disk/2"} {
+		body := f.bodies["POST "+path+"/disk/action/attach"]
+		if !strings.Contains(body, `<Disk href="`+f.URL+disk+`"></Disk>`) {
+			t.Errorf("Expected disk %s to be attached to %s, got: %s", disk, path, body)
+		}
+	}
+}
+
+// TestEdgeRules makes sure the NAT and firewall rules are added to the edge
+// gateway, used for SSH and removed on Destroy.
+func TestEdgeRules(t *testing.T) {
+	f := newFakeVCD(t)
+	defer f.Close()
+	f.addVDC()
+	f.docs["GET /api/vdc/1"] = `<Vdc name="vdc" href="URL/api/vdc/1">
+		<Link rel="edgeGateways" type="application/vnd.vmware.vcloud.query.records+xml" href="URL/api/admin/vdc/1/edgeGateways"/>
+	</Vdc>`
+	f.docs["GET /api/admin/vdc/1/edgeGateways"] = `<QueryResultRecords>
+		<EdgeGatewayRecord name="edge" href="URL/api/admin/edgeGateway/e1"/>
+	</QueryResultRecords>`
+	f.docs["GET /api/vApp/1"] = `<VApp name="app" deployed="false" href="URL/api/vApp/1"><Children>
+		<Vm name="web" href="URL/api/vApp/vm-1"><NetworkConnectionSection>
+			<NetworkConnection network="net"><NetworkConnectionIndex>0</NetworkConnectionIndex><IpAddress>10.0.0.1</IpAddress></NetworkConnection>
+		</NetworkConnectionSection></Vm>
+	</Children></VApp>`
+	f.docs["POST /network/edges/e1/nat/config/rules"] = ""
+	f.locations["POST /network/edges/e1/nat/config/rules"] = "/network/edges/e1/nat/config/rules/196609"
+	f.docs["POST /network/edges/e1/firewall/config/rules"] = ""
+	f.locations["POST /network/edges/e1/firewall/config/rules"] = "/network/edges/e1/firewall/config/rules/131073"
+	f.docs["DELETE /network/edges/e1/nat/config/rules/196609"] = ""
+	f.docs["DELETE /network/edges/e1/firewall/config/rules/131073"] = ""
+	f.docs["DELETE /api/vApp/1"] = ""
+
+	vm := f.vm()
+	vm.Name = "app"
+	vm.Href = f.URL + "/api/vApp/1"
+	vm.Machines = []Machine{{Name: "web"}}
+	vm.NATRules = []NATRule{{Type: NATDestination, ExternalIP: "1.2.3.4", Protocol: "tcp", ExternalPort: "2222", InternalPort: "22"}}
+	vm.FirewallRules = []FirewallRule{{Name: "ssh", Destination: "1.2.3.4", Protocol: "tcp", Port: "2222"}}
+	if err := vm.addEdgeRules(); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if vm.EdgeGatewayID != "e1" || len(vm.NATRuleIDs) != 1 || vm.NATRuleIDs[0] != "196609" ||
+		len(vm.FirewallRuleIDs) != 1 || vm.FirewallRuleIDs[0] != "131073" {
+		t.Fatalf("Expected the rule IDs to be recorded, got: %q %v %v", vm.EdgeGatewayID, vm.NATRuleIDs, vm.FirewallRuleIDs)
+	}
+
+	for key, want := range map[string]string{
+		"POST /network/edges/e1/nat/config/rules":      `<natRule><action>dnat</action><originalAddress>1.2.3.4</originalAddress><translatedAddress>10.0.0.1</translatedAddress><protocol>tcp</protocol><originalPort>2222</originalPort><translatedPort>22</translatedPort><enabled>true</enabled>`,
+		"POST /network/edges/e1/firewall/config/rules": `<firewallRule><name>ssh</name><action>accept</action><destination><ipAddress>1.2.3.4</ipAddress></destination><application><service><protocol>tcp</protocol><port>2222</port></service></application><enabled>true</enabled></firewallRule>`,
+	} {
+		if body := f.bodies[key]; !strings.Contains(body, want) {
+			t.Errorf("Expected %s to contain %s, got: %s", key, want, body)
+		}
+	}
+
+	client, err := vm.GetSSH(ssh.Options{})
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if c := client.(*ssh.SSHClient); c.IP.String() != "1.2.3.4" || c.Port != 2222 {
+		t.Fatalf("Expected SSH through 1.2.3.4:2222, got: %s:%d", c.IP, c.Port)
+	}
+
+	if err := vm.Destroy(); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if len(vm.NATRuleIDs) != 0 || len(vm.FirewallRuleIDs) != 0 {
+		t.Fatalf("Expected the rules to be removed, got: %v %v", vm.NATRuleIDs, vm.FirewallRuleIDs)
+	}
+}
+
+// TestUploadOVA makes sure the descriptor and the files of the OVA are
+// uploaded to their links.
+func TestUploadOVA(t *testing.T) {
+	f := newFakeVCD(t)
+	defer f.Close()
+	f.addVDC()
+	f.docs["GET /api/catalog/1"] = `<Catalog name="catalog" href="URL/api/catalog/1">
+		<Link rel="add" type="application/vnd.vmware.vcloud.uploadVAppTemplateParams+xml" href="URL/api/catalog/1/action/upload"/>
+	</Catalog>`
+	f.docs["POST /api/catalog/1/action/upload"] = `<CatalogItem name="ubuntu" href="URL/api/catalogItem/2">
+		<Entity href="URL/api/vAppTemplate/2"/>
+	</CatalogItem>`
+	f.docs["GET /api/vAppTemplate/2"] = `<VAppTemplate name="ubuntu" href="URL/api/vAppTemplate/2">
+		<Tasks><Task status="success" href="URL/api/task/1"/></Tasks>
+		<Files>
+			<File name="descriptor.ovf"><Link rel="upload:default" href="URL/transfer/1/descriptor.ovf"/></File>
+			<File name="disk1.vmdk"><Link rel="upload:default" href="URL/transfer/1/disk1.vmdk"/></File>
+		</Files>
+	</VAppTemplate>`
+	f.docs["PUT /transfer/1/descriptor.ovf"] = ""
+	f.docs["PUT /transfer/1/disk1.vmdk"] = ""
+
+	ova := &bytes.Buffer{}
+	tw := tar.NewWriter(ova)
+	for _, file := range []struct{ name, body string }{
+		{"ubuntu.ovf", "<Envelope/>"},
+		{"ubuntu.mf", "SHA1(disk1.vmdk)= 0"},
+		{"disk1.vmdk", "disk"},
+	} {
+		tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.body)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(file.body))
+	}
+	tw.Close()
+
+	if err := f.vm().UploadOVA("catalog", "ubuntu", "", ova); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if body := f.bodies["POST /api/catalog/1/action/upload"]; !strings.Contains(body, `<UploadVAppTemplateParams xmlns="http://www.vmware.com/vcloud/v1.5" name="ubuntu">`) {
+		t.Errorf("Expected the upload request to contain the template name, got: %s", body)
+	}
+	for key, want := range map[string]string{
+		"PUT /transfer/1/descriptor.ovf": "<Envelope/>",
+		"PUT /transfer/1/disk1.vmdk":     "disk",
+	} {
+		if body := f.bodies[key]; body != want {
+			t.Errorf("Expected %s to upload %q, got: %q", key, want, body)
+		}
+	}
+}
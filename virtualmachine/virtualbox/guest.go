@@ -0,0 +1,160 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package virtualbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+// Guest Additions steps of Provision
+const (
+	// GuestAdditionsVerify checks that the Guest Additions are running in the
+	// guest once it is booted.
+	GuestAdditionsVerify = "verify"
+	// GuestAdditionsInstall installs the Guest Additions of the version of
+	// VirtualBox in the guest once it is booted, unless they are already
+	// running. This updates older Guest Additions, which must be running.
+	GuestAdditionsInstall = "install"
+)
+
+// ErrGuestAdditionsNotRunning is returned when the Guest Additions are not
+// running in the guest.
+var ErrGuestAdditionsNotRunning = errors.New("the guest additions are not running in the guest")
+
+// SharedFolder represents a folder of the host shared with the guest.
+type SharedFolder struct {
+	// Name is the name of the shared folder in the guest.
+	Name     string
+	HostPath string
+	// GuestPath is the mount point of the folder in the guest when it is
+	// auto-mounted.
+	GuestPath string
+	AutoMount bool
+	ReadOnly  bool
+}
+
+// addSharedFolder shares the folder with the VM.
+func addSharedFolder(vm *VM, folder SharedFolder) error {
+	args := []string{"sharedfolder", "add", vm.Name, "--name", folder.Name, "--hostpath", folder.HostPath}
+	if folder.ReadOnly {
+		args = append(args, "--readonly")
+	}
+	if folder.AutoMount {
+		args = append(args, "--automount")
+	}
+	if folder.GuestPath != "" {
+		args = append(args, "--auto-mount-point", folder.GuestPath)
+	}
+	if _, err := runner.RunCombinedError(args...); err != nil {
+		return fmt.Errorf("error sharing folder %s with vm %s: %s", folder.HostPath, vm.Name, err)
+	}
+	return nil
+}
+
+// guestAdditionsVersion returns the version of the Guest Additions running in
+// the guest, or an empty string if they are not running.
+func (vm *VM) guestAdditionsVersion() (string, error) {
+	stdout, err := runner.RunCombinedError("guestproperty", "get", vm.Name, "/VirtualBox/GuestAdd/Version")
+	if err != nil {
+		return "", lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	stdout = strings.TrimSpace(stdout)
+	if !strings.HasPrefix(stdout, "Value:") {
+		return "", nil
+	}
+	return strings.TrimSpace(strings.TrimPrefix(stdout, "Value:")), nil
+}
+
+// provisionGuestAdditions verifies or installs the Guest Additions of the
+// guest, as set in the config of the VM.
+func (vm *VM) provisionGuestAdditions() error {
+	switch vm.Config.GuestAdditions {
+	case "":
+		return nil
+	case GuestAdditionsVerify, GuestAdditionsInstall:
+	default:
+		return fmt.Errorf("unknown guest additions step %q", vm.Config.GuestAdditions)
+	}
+
+	version, err := vm.guestAdditionsVersion()
+	if err != nil {
+		return err
+	}
+	if vm.Config.GuestAdditions == GuestAdditionsVerify {
+		if version == "" {
+			return ErrGuestAdditionsNotRunning
+		}
+		return nil
+	}
+
+	stdout, err := runner.RunCombinedError("--version")
+	if err != nil {
+		return err
+	}
+	// The version of VirtualBox is followed by its revision, such as
+	// "5.1.22r115126".
+	hostVersion := strings.SplitN(strings.TrimSpace(stdout), "r", 2)[0]
+	if version != "" && strings.HasPrefix(version, hostVersion) {
+		return nil
+	}
+	if _, err := runner.RunCombinedError(vm.guestControlArgs("updateadditions", "--wait-start")...); err != nil {
+		return fmt.Errorf("error installing the guest additions in vm %s: %s", vm.Name, err)
+	}
+	return nil
+}
+
+// guestControlArgs returns the arguments of the guestcontrol command, which
+// runs in the guest as the SSH user of the credentials of the VM.
+func (vm *VM) guestControlArgs(command string, args ...string) []string {
+	gcArgs := []string{"guestcontrol", vm.Name, command}
+	if vm.Credentials.SSHUser != "" {
+		gcArgs = append(gcArgs, "--username", vm.Credentials.SSHUser)
+	}
+	if vm.Credentials.SSHPassword != "" {
+		gcArgs = append(gcArgs, "--password", vm.Credentials.SSHPassword)
+	}
+	return append(gcArgs, args...)
+}
+
+// RunInGuest runs the program at the given path of the guest with the
+// arguments, through the Guest Additions as the SSH user of the credentials
+// of the VM, and returns its stdout and stderr. It does not need the guest to
+// be reachable over the network.
+func (vm *VM) RunInGuest(path string, args ...string) (string, string, error) {
+	gcArgs := vm.guestControlArgs("run", "--exe", path, "--wait-stdout", "--wait-stderr", "--", path)
+	stdout, stderr, err := runner.Run(append(gcArgs, args...)...)
+	if err != nil {
+		return stdout, stderr, fmt.Errorf("error running %s in vm %s: %s", path, vm.Name, err)
+	}
+	return stdout, stderr, nil
+}
+
+// CopyToGuest copies the file or directory at src on the host to dst in the
+// guest, through the Guest Additions.
+func (vm *VM) CopyToGuest(src string, dst string, recursive bool) error {
+	args := vm.guestControlArgs("copyto")
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	if _, err := runner.RunCombinedError(append(args, src, dst)...); err != nil {
+		return fmt.Errorf("error copying %s to vm %s: %s", src, vm.Name, err)
+	}
+	return nil
+}
+
+// CopyFromGuest copies the file or directory at src in the guest to dst on the
+// host, through the Guest Additions.
+func (vm *VM) CopyFromGuest(src string, dst string, recursive bool) error {
+	args := vm.guestControlArgs("copyfrom")
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	if _, err := runner.RunCombinedError(append(args, src, dst)...); err != nil {
+		return fmt.Errorf("error copying %s from vm %s: %s", src, vm.Name, err)
+	}
+	return nil
+}
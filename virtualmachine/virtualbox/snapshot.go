@@ -0,0 +1,48 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package virtualbox
+
+import "fmt"
+
+// TakeSnapshot takes a snapshot of the VM with the given name and description.
+// A running VM is paused while the snapshot is taken, unless live is set.
+func (vm *VM) TakeSnapshot(name string, description string, live bool) error {
+	args := []string{"snapshot", vm.Name, "take", name}
+	if description != "" {
+		args = append(args, "--description", description)
+	}
+	if live {
+		args = append(args, "--live")
+	}
+	if _, err := runner.RunCombinedError(args...); err != nil {
+		return fmt.Errorf("error taking snapshot %s of vm %s: %s", name, vm.Name, err)
+	}
+	return nil
+}
+
+// RestoreSnapshot restores the VM, which must be halted, to the snapshot with
+// the given name.
+func (vm *VM) RestoreSnapshot(name string) error {
+	if _, err := runner.RunCombinedError("snapshot", vm.Name, "restore", name); err != nil {
+		return fmt.Errorf("error restoring snapshot %s of vm %s: %s", name, vm.Name, err)
+	}
+	return nil
+}
+
+// RestoreCurrentSnapshot restores the VM, which must be halted, to its current
+// snapshot.
+func (vm *VM) RestoreCurrentSnapshot() error {
+	if _, err := runner.RunCombinedError("snapshot", vm.Name, "restorecurrent"); err != nil {
+		return fmt.Errorf("error restoring the current snapshot of vm %s: %s", vm.Name, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes the snapshot with the given name, merging its changes
+// into its child.
+func (vm *VM) DeleteSnapshot(name string) error {
+	if _, err := runner.RunCombinedError("snapshot", vm.Name, "delete", name); err != nil {
+		return fmt.Errorf("error deleting snapshot %s of vm %s: %s", name, vm.Name, err)
+	}
+	return nil
+}
@@ -5,10 +5,14 @@ package virtualbox
 import (
 	"fmt"
 	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/apcera/libretto/util"
 	lvm "github.com/apcera/libretto/virtualmachine"
 )
 
@@ -115,20 +119,92 @@ func GetBridgedDevices() ([]string, error) {
 	return deviceNames, nil
 }
 
-func (vm *VM) configure() error {
+func (vm *VM) configure() (err error) {
 	// Delete any existing nics from the VM, will add the network cards from the passed in config
 	if err := DeleteNICs(vm); err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			if vm.release() == nil {
+				vm.saveHostOnlyIfs()
+			}
+		}
+	}()
+
+	for i := range vm.Config.NICs {
+		if err := vm.allocate(&vm.Config.NICs[i]); err != nil {
+			return err
+		}
+		if err := AddNIC(vm, vm.Config.NICs[i]); err != nil {
+			return err
+		}
+	}
+
+	var args []string
+	if vm.Config.CPUs > 0 {
+		args = append(args, "--cpus", strconv.Itoa(vm.Config.CPUs))
+	}
+	if vm.Config.MemoryMB > 0 {
+		args = append(args, "--memory", strconv.Itoa(vm.Config.MemoryMB))
+	}
+	if vm.Config.VRAMMB > 0 {
+		args = append(args, "--vram", strconv.Itoa(vm.Config.VRAMMB))
+	}
+	if len(args) > 0 {
+		if _, err := runner.RunCombinedError(append([]string{"modifyvm", vm.Name}, args...)...); err != nil {
+			return err
+		}
+	}
 
-	for _, nic := range vm.Config.NICs {
-		if err := AddNIC(vm, nic); err != nil {
+	for _, folder := range vm.Config.SharedFolders {
+		if err := addSharedFolder(vm, folder); err != nil {
 			return err
 		}
 	}
+
+	if vm.Config.DiskSizeMB > 0 {
+		return vm.resizeDisk(vm.Config.DiskSizeMB)
+	}
 	return nil
 }
 
+// resizeDisk grows the first disk of the VM to the given size, converting it
+// to VDI first if it is a VMDK.
+func (vm *VM) resizeDisk(sizeMB int) error {
+	stdout, err := runner.RunCombinedError("showvminfo", vm.Name, "--machinereadable")
+	if err != nil {
+		return lvm.WrapErrors(lvm.ErrVMInfoFailed, err)
+	}
+	var match []string
+	for _, line := range strings.Split(stdout, "\n") {
+		if match = diskRegexp.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			break
+		}
+	}
+	if match == nil {
+		return fmt.Errorf("no disk found on vm %s", vm.Name)
+	}
+	controller, port, device, disk := match[1], match[2], match[3], match[4]
+
+	if strings.EqualFold(match[5], "vmdk") {
+		vdi := strings.TrimSuffix(disk, filepath.Ext(disk)) + ".vdi"
+		if _, err := runner.RunCombinedError("clonemedium", "disk", disk, vdi, "--format", "VDI"); err != nil {
+			return err
+		}
+		if _, err := runner.RunCombinedError("storageattach", vm.Name, "--storagectl", controller, "--port", port, "--device", device, "--type", "hdd", "--medium", vdi); err != nil {
+			return err
+		}
+		if _, err := runner.RunCombinedError("closemedium", "disk", disk, "--delete"); err != nil {
+			return err
+		}
+		disk = vdi
+	}
+
+	_, err = runner.RunCombinedError("modifymedium", "disk", disk, "--resize", strconv.Itoa(sizeMB))
+	return err
+}
+
 // This function makes a single request to get IPs from a VM.
 func (vm *VM) requestIPs() []net.IP {
 	if vm.ipUpdate == nil {
@@ -239,19 +315,236 @@ func getStringFromBacking(backing Backing) string {
 		return "nat"
 	case Bridged:
 		return "bridged"
+	case HostOnly:
+		return "hostonly"
+	case Internal:
+		return "intnet"
 	}
 	return "null"
 }
 
+// portForwardRule returns the VBoxManage rule of the port forward.
+func portForwardRule(pf PortForward) string {
+	protocol := pf.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return fmt.Sprintf("%s,%s,%s,%d,%s,%d", pf.Name, protocol, pf.HostIP, pf.HostPort, pf.GuestIP, pf.GuestPort)
+}
+
+// hostOnlyIfsKey is the extra data key of the VM holding the names of the
+// host-only interfaces created for it.
+const hostOnlyIfsKey = "libretto/HostOnlyInterfaces"
+
+// usedHostPorts returns the host ports forwarded to the registered VMs, which
+// are used even though nothing listens on them while the VMs are halted.
+func usedHostPorts() (map[int]bool, error) {
+	out, err := runner.RunCombinedError("list", "vms")
+	if err != nil {
+		return nil, fmt.Errorf("error listing the vms: %s", err)
+	}
+	used := map[int]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		match := vmListRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		info, err := runner.RunCombinedError("showvminfo", match[1], "--machinereadable")
+		if err != nil {
+			// The VM may have been deleted since it was listed.
+			continue
+		}
+		for _, l := range strings.Split(info, "\n") {
+			if m := forwardingRegexp.FindStringSubmatch(strings.TrimSpace(l)); m != nil {
+				if port, err := strconv.Atoi(m[1]); err == nil {
+					used[port] = true
+				}
+			}
+		}
+	}
+	return used, nil
+}
+
+// hostOnlySubnets returns the IPV4 subnets of the host-only interfaces, which
+// are used even though the host has no address on them while they are down.
+func hostOnlySubnets() ([]*net.IPNet, error) {
+	out, err := runner.RunCombinedError("list", "hostonlyifs")
+	if err != nil {
+		return nil, fmt.Errorf("error listing the host-only interfaces: %s", err)
+	}
+	var subnets []*net.IPNet
+	var ip net.IP
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch strings.TrimSpace(fields[0]) {
+		case "Name":
+			ip = nil
+		case "IPAddress":
+			ip = net.ParseIP(value).To4()
+		case "NetworkMask":
+			mask := net.ParseIP(value).To4()
+			if ip != nil && mask != nil {
+				subnets = append(subnets, &net.IPNet{IP: ip.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)})
+			}
+		}
+	}
+	return subnets, nil
+}
+
+// allocate allocates the host ports of the port forwarding rules of the NIC
+// which have none, and a host-only interface to a host-only NIC which has none.
+// The ports and subnets of the existing VMs and host-only interfaces are
+// skipped, as the allocations of other processes are not known.
+func (vm *VM) allocate(nic *NIC) error {
+	var usedPorts map[int]bool
+	for i := range nic.PortForwards {
+		if nic.PortForwards[i].HostPort != 0 {
+			continue
+		}
+		if usedPorts == nil {
+			var err error
+			if usedPorts, err = usedHostPorts(); err != nil {
+				return err
+			}
+		}
+		port, err := util.DefaultAllocator.AllocatePortExcept(usedPorts)
+		if err != nil {
+			return err
+		}
+		vm.ports = append(vm.ports, port)
+		nic.PortForwards[i].HostPort = port
+	}
+
+	if nic.Backing != HostOnly || nic.BackingDevice != "" {
+		return nil
+	}
+	usedSubnets, err := hostOnlySubnets()
+	if err != nil {
+		return err
+	}
+	subnet, err := util.DefaultAllocator.AllocateSubnetExcept(usedSubnets)
+	if err != nil {
+		return err
+	}
+	out, err := runner.RunCombinedError("hostonlyif", "create")
+	if err != nil {
+		util.DefaultAllocator.ReleaseSubnet(subnet)
+		return fmt.Errorf("error creating a host-only interface: %s", err)
+	}
+	match := hostOnlyIfRegexp.FindStringSubmatch(out)
+	if match == nil {
+		util.DefaultAllocator.ReleaseSubnet(subnet)
+		return fmt.Errorf("error creating a host-only interface: unexpected output %q", out)
+	}
+	if vm.hostOnlyIfs == nil {
+		vm.hostOnlyIfs = map[string]*net.IPNet{}
+	}
+	vm.hostOnlyIfs[match[1]] = subnet
+	if err := vm.saveHostOnlyIfs(); err != nil {
+		return err
+	}
+
+	// The host is the first IP of the subnet.
+	ip := make(net.IP, len(subnet.IP.To4()))
+	copy(ip, subnet.IP.To4())
+	ip[3] = 1
+	_, err = runner.RunCombinedError("hostonlyif", "ipconfig", match[1], "--ip", ip.String(),
+		"--netmask", net.IP(subnet.Mask).String())
+	if err != nil {
+		return fmt.Errorf("error configuring the host-only interface %s: %s", match[1], err)
+	}
+	nic.BackingDevice = match[1]
+	return nil
+}
+
+// saveHostOnlyIfs keeps the names of the host-only interfaces created for the
+// VM in its extra data, or deletes them if there are none.
+func (vm *VM) saveHostOnlyIfs() error {
+	var names []string
+	for name := range vm.hostOnlyIfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := []string{"setextradata", vm.Name, hostOnlyIfsKey}
+	if len(names) > 0 {
+		args = append(args, strings.Join(names, ","))
+	}
+	if _, err := runner.RunCombinedError(args...); err != nil {
+		return fmt.Errorf("error saving the host-only interfaces of the vm: %s", err)
+	}
+	return nil
+}
+
+// loadHostOnlyIfs adds the host-only interfaces kept in the extra data of the
+// VM to the ones created for it by this process.
+func (vm *VM) loadHostOnlyIfs() error {
+	out, err := runner.RunCombinedError("getextradata", vm.Name, hostOnlyIfsKey)
+	if err != nil {
+		return fmt.Errorf("error loading the host-only interfaces of the vm: %s", err)
+	}
+	value := strings.TrimSpace(out)
+	if !strings.HasPrefix(value, "Value:") {
+		// No value is set.
+		return nil
+	}
+	for _, name := range strings.Split(strings.TrimSpace(strings.TrimPrefix(value, "Value:")), ",") {
+		if name == "" {
+			continue
+		}
+		if vm.hostOnlyIfs == nil {
+			vm.hostOnlyIfs = map[string]*net.IPNet{}
+		}
+		if _, ok := vm.hostOnlyIfs[name]; !ok {
+			vm.hostOnlyIfs[name] = nil
+		}
+	}
+	return nil
+}
+
+// release releases the host ports and removes the host-only interfaces
+// allocated to the VM.
+func (vm *VM) release() error {
+	for _, port := range vm.ports {
+		util.DefaultAllocator.ReleasePort(port)
+	}
+	vm.ports = nil
+
+	for name, subnet := range vm.hostOnlyIfs {
+		if _, err := runner.RunCombinedError("hostonlyif", "remove", name); err != nil {
+			return fmt.Errorf("error removing the host-only interface %s: %s", name, err)
+		}
+		if subnet != nil {
+			util.DefaultAllocator.ReleaseSubnet(subnet)
+		}
+		delete(vm.hostOnlyIfs, name)
+	}
+	return nil
+}
+
 // AddNIC adds a NIC to the VM.
 func AddNIC(vm *VM, nic NIC) error {
-	var err error
+	args := []string{"modifyvm", vm.Name, fmt.Sprintf("--nic%d", nic.Idx), getStringFromBacking(nic.Backing)}
 	switch nic.Backing {
 	case Nat:
-		_, _, err = runner.Run("modifyvm", vm.Name, fmt.Sprintf("--nic%d", nic.Idx), getStringFromBacking(nic.Backing))
+		for _, pf := range nic.PortForwards {
+			args = append(args, fmt.Sprintf("--natpf%d", nic.Idx), portForwardRule(pf))
+		}
 	case Bridged:
-		_, _, err = runner.Run("modifyvm", vm.Name, fmt.Sprintf("--nic%d", nic.Idx), getStringFromBacking(nic.Backing), fmt.Sprintf("--bridgeadapter%d", nic.Idx), nic.BackingDevice)
+		args = append(args, fmt.Sprintf("--bridgeadapter%d", nic.Idx), nic.BackingDevice)
+	case HostOnly:
+		args = append(args, fmt.Sprintf("--hostonlyadapter%d", nic.Idx), nic.BackingDevice)
+	case Internal:
+		if nic.BackingDevice != "" {
+			args = append(args, fmt.Sprintf("--intnet%d", nic.Idx), nic.BackingDevice)
+		}
+	default:
+		return nil
 	}
+	_, _, err := runner.Run(args...)
 	return err
 }
 
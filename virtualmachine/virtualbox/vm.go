@@ -8,7 +8,6 @@ import (
 	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -30,6 +29,21 @@ var createMutex = &sync.Mutex{}
 // Config represents a config for a VirtualBox VM
 type Config struct {
 	NICs []NIC
+	// CPUs, MemoryMB and VRAMMB replace the number of CPUs, the memory and
+	// the video memory of the imported VM when they are set.
+	CPUs     int
+	MemoryMB int
+	VRAMMB   int
+	// DiskSizeMB grows the first disk of the imported VM to this size when it
+	// is set. VMDK disks are converted to VDI, as only VDI and VHD disks can
+	// be resized.
+	DiskSizeMB int
+	// SharedFolders are the folders of the host shared with the guest.
+	SharedFolders []SharedFolder
+	// GuestAdditions is the Guest Additions step of Provision once the guest
+	// is booted, one of the GuestAdditions constants. There is none if it is
+	// empty.
+	GuestAdditions string
 }
 
 // Backing represents a backing for VirtualBox NIC
@@ -37,11 +51,42 @@ type Backing int
 
 // NIC represents a Virtualbox NIC
 type NIC struct {
-	Idx           int
-	Backing       Backing
+	Idx     int
+	Backing Backing
+	// BackingDevice is the host interface of bridged and host-only NICs, such
+	// as "en0" or "vboxnet0", and the network name of internal NICs. A
+	// host-only interface on a free subnet of the host is created for
+	// host-only NICs if it is empty, and removed when the VM is destroyed.
 	BackingDevice string
+	// PortForwards are the port forwarding rules of NAT NICs.
+	PortForwards []PortForward
 }
 
+// PortForward represents a NAT port forwarding rule, which forwards a port of
+// the host to a port of the guest.
+type PortForward struct {
+	// Name is the unique name of the rule.
+	Name string
+	// Protocol is either "tcp" or "udp". It defaults to "tcp".
+	Protocol string
+	// HostIP is the IP of the host to listen on. All of them are used if it
+	// is empty.
+	HostIP string
+	// HostPort is allocated from the free ports of the host if it is 0, so
+	// that VMs provisioned concurrently do not conflict.
+	HostPort  int
+	GuestIP   string
+	GuestPort int
+}
+
+// Start types of a VirtualBox VM
+const (
+	// StartTypeGUI starts the VM with a window.
+	StartTypeGUI = "gui"
+	// StartTypeHeadless starts the VM without a window.
+	StartTypeHeadless = "headless"
+)
+
 // Runner is an encapsulation around the vmrun utility.
 type Runner interface {
 	Run(args ...string) (string, string, error)
@@ -56,15 +101,22 @@ var runner Runner = vboxRunner{}
 
 // Regexp for parsing vboxmanage output.
 var (
-	ipLineRegexp    = regexp.MustCompile(`/VirtualBox/GuestInfo/Net/0/V4/IP`)
-	ipAddrRegexp    = regexp.MustCompile(`value: .*, timestamp`)
-	timestampRegexp = regexp.MustCompile(`timestamp: \d*`)
-	networkRegexp   = regexp.MustCompile(`(?s)Name:.*?VBoxNetworkName`)
-	stateRegexp     = regexp.MustCompile(`^State:`)
-	runningRegexp   = regexp.MustCompile(`running`)
-	backingRegexp   = regexp.MustCompile(`Attachment: NAT`)
-	disabledRegexp  = regexp.MustCompile(`disabled$`)
-	nicRegexp       = regexp.MustCompile(`^NIC \d\d?:`)
+	ipLineRegexp     = regexp.MustCompile(`/VirtualBox/GuestInfo/Net/0/V4/IP`)
+	ipAddrRegexp     = regexp.MustCompile(`value: .*, timestamp`)
+	timestampRegexp  = regexp.MustCompile(`timestamp: \d*`)
+	networkRegexp    = regexp.MustCompile(`(?s)Name:.*?VBoxNetworkName`)
+	stateRegexp      = regexp.MustCompile(`^State:`)
+	runningRegexp    = regexp.MustCompile(`running`)
+	backingRegexp    = regexp.MustCompile(`Attachment: NAT`)
+	bridgedRegexp    = regexp.MustCompile(`Attachment: Bridged Interface '(.*?)'`)
+	hostOnlyRegexp   = regexp.MustCompile(`Attachment: Host-only Interface '(.*?)'`)
+	internalRegexp   = regexp.MustCompile(`Attachment: Internal Network '(.*?)'`)
+	disabledRegexp   = regexp.MustCompile(`disabled$`)
+	nicRegexp        = regexp.MustCompile(`^NIC \d\d?:`)
+	hostOnlyIfRegexp = regexp.MustCompile(`Interface '(.+?)' was successfully created`)
+	vmListRegexp     = regexp.MustCompile(`^".*" \{(.+)\}$`)
+	forwardingRegexp = regexp.MustCompile(`^Forwarding\(\d+\)="[^,]*,[^,]*,[^,]*,(\d+),`)
+	diskRegexp       = regexp.MustCompile(`(?i)^"(.+)-(\d+)-(\d+)"="(.+\.(vmdk|vdi|vhd))"$`)
 )
 
 // Backing information for VirtualBox network cards
@@ -73,16 +125,35 @@ const (
 	Bridged
 	Unsupported
 	Disabled
+	HostOnly
+	Internal
 )
 
 // VM represents a VirtualBox VM
 type VM struct {
-	Src         string
+	// Src is the OVA or OVF file the VM is imported from. It may also be an
+	// HTTP(S) URL of an OVA, which is downloaded into CacheDir.
+	Src string
+	// SrcChecksum is the SHA-256 checksum of Src, as a hex string. It is
+	// verified if it is set.
+	SrcChecksum string
+	// CacheDir is the directory URLs are downloaded into. A libretto
+	// directory of the temporary directory is used if it is empty.
+	CacheDir    string
 	ips         []net.IP
 	Credentials libssh.Credentials
 	Name        string
 	Config      Config
-	ipUpdate    map[string]string
+	// StartType is the way the VM is started, one of the StartType
+	// constants. The VirtualBox default is used if it is empty.
+	StartType string
+	ipUpdate  map[string]string
+	// ports and hostOnlyIfs are the host ports and host-only interfaces
+	// allocated to the VM. The names of the interfaces are also kept in the
+	// extra data of the VM, so that they are removed when it is destroyed by
+	// another process, whose hostOnlyIfs have no subnets.
+	ports       []int
+	hostOnlyIfs map[string]*net.IPNet
 }
 
 // GetName returns the name of the virtual machine
@@ -98,6 +169,25 @@ func (vm *VM) GetSSH(options libssh.Options) (libssh.Client, error) {
 	}
 	vm.ips = ips
 
+	// Connect through a forwarded port of a NAT NIC, as the IP of the guest
+	// is not reachable from the host.
+	for _, nic := range vm.Config.NICs {
+		if nic.Backing != Nat {
+			continue
+		}
+		for _, pf := range nic.PortForwards {
+			if pf.GuestPort != 22 || (pf.Protocol != "" && pf.Protocol != "tcp") {
+				continue
+			}
+			ip := net.ParseIP(pf.HostIP)
+			if ip == nil || ip.IsUnspecified() {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+			client := libssh.SSHClient{Creds: &vm.Credentials, IP: ip, Port: pf.HostPort, Options: options}
+			return &client, nil
+		}
+	}
+
 	client := libssh.SSHClient{Creds: &vm.Credentials, IP: ips[0], Port: 22, Options: options}
 	return &client, nil
 }
@@ -108,6 +198,10 @@ func (vm *VM) Destroy() error {
 	if err != nil {
 		return err
 	}
+	// The extra data of the VM is deleted with it.
+	if err := vm.loadHostOnlyIfs(); err != nil {
+		return err
+	}
 
 	// vbox will not release it's lock immediately after the stop
 	time.Sleep(1 * time.Second)
@@ -116,7 +210,7 @@ func (vm *VM) Destroy() error {
 	if err != nil {
 		return lvm.WrapErrors(lvm.ErrDeletingVM, err)
 	}
-	return nil
+	return vm.release()
 }
 
 // Halt powers off the VM without destroying it
@@ -137,7 +231,11 @@ func (vm *VM) Halt() error {
 
 // Start powers on the VM
 func (vm *VM) Start() error {
-	_, err := runner.RunCombinedError("startvm", vm.Name)
+	args := []string{"startvm", vm.Name}
+	if vm.StartType != "" {
+		args = append(args, "--type", vm.StartType)
+	}
+	_, err := runner.RunCombinedError(args...)
 	if err != nil {
 		// If the user has paused the VM it reads as halted but the Start
 		// command will fail. Try to resume it as a backup.
@@ -212,6 +310,15 @@ func (vm *VM) GetInterfaces() ([]NIC, error) {
 			nic.Idx = idx
 			if match := backingRegexp.FindStringSubmatch(line); match != nil {
 				nic.Backing = Nat
+			} else if match := bridgedRegexp.FindStringSubmatch(line); match != nil {
+				nic.Backing = Bridged
+				nic.BackingDevice = match[1]
+			} else if match := hostOnlyRegexp.FindStringSubmatch(line); match != nil {
+				nic.Backing = HostOnly
+				nic.BackingDevice = match[1]
+			} else if match := internalRegexp.FindStringSubmatch(line); match != nil {
+				nic.Backing = Internal
+				nic.BackingDevice = match[1]
 			} else if match := disabledRegexp.FindStringSubmatch(line); match != nil {
 				nic.Backing = Disabled
 			} else {
@@ -235,7 +342,7 @@ func (vm *VM) Provision() error {
 	if src == "" {
 		return lvm.ErrSourceNotSpecified
 	}
-	ovaPath, err := filepath.Abs(src)
+	ovaPath, err := util.FetchImage(src, vm.SrcChecksum, vm.CacheDir)
 	if err != nil {
 		return err
 	}
@@ -254,7 +361,10 @@ func (vm *VM) Provision() error {
 		return err
 	}
 
-	return vm.waitUntilReady()
+	if err = vm.waitUntilReady(); err != nil {
+		return err
+	}
+	return vm.provisionGuestAdditions()
 }
 
 // Run runs a VBoxManage command.
@@ -24,6 +24,32 @@ type VirtualMachine interface {
 	GetSSH(ssh.Options) (ssh.Client, error)
 }
 
+// DashboardLinker is implemented by VMs whose provider has a web console. The
+// returned URL is a deep link to the VM in that console.
+type DashboardLinker interface {
+	GetDashboardURL() (string, error)
+}
+
+// Address is an IP address of a VM.
+type Address struct {
+	IP net.IP
+	// Public is true if the address is reachable from outside the network of
+	// the VM, such as a public IPv4 address or a global IPv6 address.
+	Public bool
+}
+
+// IsIPv6 returns true if the address is an IPv6 address.
+func (a Address) IsIPv6() bool {
+	return a.IP != nil && a.IP.To4() == nil
+}
+
+// Addresser is implemented by VMs which report all their IP addresses,
+// including IPv6 addresses, unlike GetIPs which returns a fixed public and
+// private IPv4 address.
+type Addresser interface {
+	GetAddresses() ([]Address, error)
+}
+
 const (
 	// VMStarting is the state to use when the VM is starting
 	VMStarting = "starting"
@@ -0,0 +1,71 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vmrun
+
+import "fmt"
+
+// GuestProgram is a program to run in the guest through VMware Tools.
+type GuestProgram struct {
+	// Path is the path of the program in the guest.
+	Path string
+	Args []string
+	// NoWait returns as soon as the program is started, instead of waiting
+	// for it to exit.
+	NoWait bool
+	// Interactive runs the program in the desktop session of the user, such
+	// as for GUI programs.
+	Interactive bool
+}
+
+// runInGuest runs a vmrun guest command as the SSH user of the credentials of
+// the VM.
+func (vm *VM) runInGuest(args ...string) (string, error) {
+	guestArgs := []string{"-gu", vm.Credentials.SSHUser, "-gp", vm.Credentials.SSHPassword}
+	return vm.runCombinedError(append(guestArgs, args...)...)
+}
+
+// RunInGuest runs the program in the guest through VMware Tools, which works
+// before the network of the guest is up. An error is returned if the program
+// exits with a non-zero exit code.
+func (vm *VM) RunInGuest(program GuestProgram) error {
+	args := []string{"runProgramInGuest", vm.vmxPath()}
+	if program.NoWait {
+		args = append(args, "-noWait")
+	}
+	if program.Interactive {
+		args = append(args, "-interactive")
+	}
+	args = append(args, program.Path)
+	args = append(args, program.Args...)
+	if _, err := vm.runInGuest(args...); err != nil {
+		return fmt.Errorf("error running %s in the guest: %s", program.Path, err)
+	}
+	return nil
+}
+
+// RunScriptInGuest runs the script with the interpreter at the given path of
+// the guest, such as "/bin/sh", through VMware Tools.
+func (vm *VM) RunScriptInGuest(interpreter string, script string) error {
+	if _, err := vm.runInGuest("runScriptInGuest", vm.vmxPath(), interpreter, script); err != nil {
+		return fmt.Errorf("error running a script in the guest: %s", err)
+	}
+	return nil
+}
+
+// CopyToGuest copies the file at src on the host to dst in the guest through
+// VMware Tools.
+func (vm *VM) CopyToGuest(src string, dst string) error {
+	if _, err := vm.runInGuest("copyFileFromHostToGuest", vm.vmxPath(), src, dst); err != nil {
+		return fmt.Errorf("error copying %s to the guest: %s", src, err)
+	}
+	return nil
+}
+
+// CopyFromGuest copies the file at src in the guest to dst on the host through
+// VMware Tools.
+func (vm *VM) CopyFromGuest(src string, dst string) error {
+	if _, err := vm.runInGuest("copyFileFromGuestToHost", vm.vmxPath(), src, dst); err != nil {
+		return fmt.Errorf("error copying %s from the guest: %s", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vmrun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/apcera/libretto/util"
+)
+
+// fetchSrc downloads Src if it is a URL, verifies its checksum, and converts
+// it to a VMX file if it is an OVA or OVF. Src is replaced with the VMX file.
+func (vm *VM) fetchSrc() error {
+	src, err := util.FetchImage(vm.Src, vm.SrcChecksum, vm.CacheDir)
+	if err != nil {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(src))
+	if ext != ".ova" && ext != ".ovf" {
+		vm.Src = src
+		return nil
+	}
+
+	// Convert the OVA once, next to it.
+	name := strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	vmx := filepath.Join(strings.TrimSuffix(src, filepath.Ext(src))+".vmwarevm", name+".vmx")
+	if _, err := os.Stat(vmx); err != nil {
+		if err := ovfTool("--acceptAllEulas", "--allowExtraConfig", src, vmx); err != nil {
+			return err
+		}
+	}
+	vm.Src = vmx
+	return nil
+}
+
+// ovfTool runs an ovftool command.
+func ovfTool(args ...string) error {
+	// If ovftool is not found in the system path, fall back to the hard coded
+	// path (OVFToolPath).
+	ovfToolPath, err := exec.LookPath("ovftool")
+	if err != nil {
+		ovfToolPath = OVFToolPath
+	}
+	out, err := exec.Command(ovfToolPath, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error converting the ovf with ovftool: %s: %s", err, out)
+	}
+	return nil
+}
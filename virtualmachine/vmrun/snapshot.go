@@ -0,0 +1,106 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vmrun
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	libssh "github.com/apcera/libretto/ssh"
+)
+
+// CreateSnapshot takes a snapshot of the VM with the given name.
+func (vm *VM) CreateSnapshot(name string) error {
+	if _, err := vm.runCombinedError("snapshot", vm.vmxPath(), name); err != nil {
+		return fmt.Errorf("error taking snapshot %s: %s", name, err)
+	}
+	return nil
+}
+
+// RevertToSnapshot reverts the VM to the snapshot with the given name. The VM
+// is powered off after reverting unless the snapshot was taken while it was
+// running.
+func (vm *VM) RevertToSnapshot(name string) error {
+	if _, err := vm.runCombinedError("revertToSnapshot", vm.vmxPath(), name); err != nil {
+		return fmt.Errorf("error reverting to snapshot %s: %s", name, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes the snapshot with the given name, and its children if
+// deleteChildren is set.
+func (vm *VM) DeleteSnapshot(name string, deleteChildren bool) error {
+	args := []string{"deleteSnapshot", vm.vmxPath(), name}
+	if deleteChildren {
+		args = append(args, "andDeleteChildren")
+	}
+	if _, err := vm.runCombinedError(args...); err != nil {
+		return fmt.Errorf("error deleting snapshot %s: %s", name, err)
+	}
+	return nil
+}
+
+// ListSnapshots returns the names of the snapshots of the VM.
+func (vm *VM) ListSnapshots() ([]string, error) {
+	stdout, err := vm.runCombinedError("listSnapshots", vm.vmxPath())
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots: %s", err)
+	}
+	var names []string
+	for _, line := range strings.Split(stdout, "\n") {
+		// The first line is the number of snapshots, such as
+		// "Total snapshots: 2".
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "Total snapshots:") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// Clone clones the VM, which must be powered off, into the dst directory, and
+// returns the clone. A linked clone shares the disks of the VM as of the
+// snapshot with the given name, or its current snapshot if it is empty, and
+// needs the VM to have a snapshot. A full clone copies the disks.
+func (vm *VM) Clone(name string, dst string, linked bool, snapshot string) (*VM, error) {
+	clone := &VM{
+		Name: name,
+		Src:  filepath.Join(dst, filepath.Base(vm.Src)),
+		Dst:  dst,
+		Credentials: libssh.Credentials{
+			SSHUser:        vm.Credentials.SSHUser,
+			SSHPassword:    vm.Credentials.SSHPassword,
+			SSHPrivateKey:  vm.Credentials.SSHPrivateKey,
+			SSHCertificate: vm.Credentials.SSHCertificate,
+		},
+	}
+	clone.HostType, clone.Host = vm.HostType, vm.Host
+	clone.HostUsername, clone.HostPassword = vm.HostUsername, vm.HostPassword
+	clone.HostPasswordFile = vm.HostPasswordFile
+	if err := vm.cloneVM(vm.vmxPath(), clone.vmxPath(), name, linked, snapshot); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// cloneVM clones the VM of the src VMX file to the dst VMX file, on the host
+// of the VM.
+func (vm *VM) cloneVM(src string, dst string, name string, linked bool, snapshot string) error {
+	mode := "full"
+	if linked {
+		mode = "linked"
+	}
+	args := []string{"clone", src, dst, mode}
+	if snapshot != "" {
+		args = append(args, "-snapshot="+snapshot)
+	}
+	if name != "" {
+		args = append(args, "-cloneName="+name)
+	}
+	if _, err := vm.runCombinedError(args...); err != nil {
+		return fmt.Errorf("error cloning %s: %s", src, err)
+	}
+	return nil
+}
@@ -38,6 +38,19 @@ ethernet{{.Idx}}.present = "TRUE"
 ethernet{{.Idx}}.virtualdev = "vmxnet3"
 `
 
+// Host types of vmrun
+const (
+	HostTypeFusion      = "fusion"
+	HostTypeWorkstation = "ws"
+	HostTypePlayer      = "player"
+	// HostTypeServer is a remote VMware Server or Workstation Server.
+	HostTypeServer = "server"
+	// HostTypeShared is a Workstation which shares its VMs.
+	HostTypeShared = "ws-shared"
+	// HostTypeESX is a remote standalone ESXi host.
+	HostTypeESX = "esx"
+)
+
 const vmrunTimeout = 90 * time.Second
 
 // ErrVmrunTimeout is returned when vmrun doesn't finish executing in `vmrunTimeout` seconds.
@@ -74,6 +87,9 @@ type Runner interface {
 
 // vmrunRunner implements the Runner interface.
 type vmrunRunner struct {
+	// stdin is written to the standard input of vmrun, such as the host
+	// password it prompts for.
+	stdin string
 }
 
 // Run runs a vmrun command.
@@ -96,6 +112,9 @@ func (f vmrunRunner) Run(args ...string) (string, string, error) {
 	cmd := exec.Command(vmrunPath, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	if f.stdin != "" {
+		cmd.Stdin = strings.NewReader(f.stdin)
+	}
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
@@ -132,13 +151,121 @@ func (f vmrunRunner) RunCombinedError(args ...string) (string, error) {
 
 // VM represents a single VMware VM and all the operations for provisioning that type
 type VM struct {
-	Name        string
-	Src         string
+	Name string
+	// Src is the VMX file of the VM the VM is copied from. It may also be an
+	// OVA or OVF file, or an HTTP(S) URL of an OVA, which is downloaded into
+	// CacheDir and converted to a VMX file there with ovftool.
+	Src string
+	// SrcChecksum is the SHA-256 checksum of Src, as a hex string. It is
+	// verified if it is set.
+	SrcChecksum string
+	// CacheDir is the directory URLs are downloaded into. A libretto
+	// directory of the temporary directory is used if it is empty.
+	CacheDir    string
 	Dst         string
 	VmxFilePath string
 	ips         []net.IP
 	Credentials libssh.Credentials
 	Config      Config
+	// LinkedClone makes Provision create the VM as a linked clone of the VM
+	// at Src, which shares its disks, instead of copying its directory. The
+	// VM at Src must have a snapshot.
+	LinkedClone bool
+	// Snapshot is the snapshot of the VM at Src linked clones are created
+	// from. The current snapshot is used if it is empty.
+	Snapshot string
+	// HostType is the type of the host of the VM, one of the HostType
+	// constants. vmrun picks the local product if it is empty.
+	HostType string
+	// Host is the URL of the SDK of remote hosts, such as
+	// "https://host:8333/sdk" or "https://esx/sdk". The VM must already
+	// exist on a remote host, and Src is the datastore path of its VMX file,
+	// such as "[datastore1] vm/vm.vmx".
+	Host         string
+	HostUsername string
+	// HostPassword is the password of HostUsername. It is written to vmrun
+	// when it prompts for it rather than passed as an argument, so that it
+	// does not show up in the process list. HostPasswordFile is read when it
+	// is empty.
+	HostPassword     string
+	HostPasswordFile string
+	// DeleteRemoteFiles makes Destroy delete the files of a VM on a remote
+	// host. Destroy only unregisters it from the host otherwise.
+	DeleteRemoteFiles bool
+}
+
+// remote returns whether the VM is on a remote host.
+func (vm *VM) remote() bool {
+	switch vm.HostType {
+	case HostTypeServer, HostTypeShared, HostTypeESX:
+		return true
+	}
+	return false
+}
+
+// vmxPath returns the path of the VMX file of the VM, which is in its
+// destination unless it is on a remote host.
+func (vm *VM) vmxPath() string {
+	if vm.remote() {
+		vm.VmxFilePath = vm.Src
+		return vm.VmxFilePath
+	}
+	_, vmxFileName := filepath.Split(vm.Src)
+	vm.VmxFilePath = fmt.Sprintf("%s/%s", vm.Dst, vmxFileName)
+	return vm.VmxFilePath
+}
+
+// hostArgs returns the vmrun arguments which select the host of the VM.
+func (vm *VM) hostArgs() []string {
+	var args []string
+	if vm.HostType != "" {
+		args = append(args, "-T", vm.HostType)
+	}
+	if vm.Host != "" {
+		args = append(args, "-h", vm.Host)
+	}
+	if vm.HostUsername != "" {
+		args = append(args, "-u", vm.HostUsername)
+	}
+	return args
+}
+
+// hostRunner returns the runner of the vmrun commands on the host of the VM,
+// which writes the host password to vmrun when it prompts for it.
+func (vm *VM) hostRunner() (Runner, error) {
+	password := vm.HostPassword
+	if password == "" && vm.HostPasswordFile != "" {
+		b, err := ioutil.ReadFile(vm.HostPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the host password: %s", err)
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	}
+	r, ok := runner.(vmrunRunner)
+	if !ok || password == "" {
+		return runner, nil
+	}
+	r.stdin = password + "\n"
+	return r, nil
+}
+
+// run runs a vmrun command on the host of the VM.
+func (vm *VM) run(args ...string) (string, string, error) {
+	r, err := vm.hostRunner()
+	if err != nil {
+		return "", "", err
+	}
+	return r.Run(append(vm.hostArgs(), args...)...)
+}
+
+// runCombinedError runs a vmrun command on the host of the VM. The output is
+// stdout and the combined err/stderr from the command.
+func (vm *VM) runCombinedError(args ...string) (string, error) {
+	r, err := vm.hostRunner()
+	if err != nil {
+		return "", err
+	}
+	return r.RunCombinedError(append(vm.hostArgs(), args...)...)
 }
 
 var backingList = []string{"nat", "bridged"}
@@ -160,12 +287,24 @@ func (vm *VM) GetSSH(options libssh.Options) (libssh.Client, error) {
 	return &client, nil
 }
 
-// Destroy powers off the VM and deletes its files from disk.
+// Destroy powers off the VM and deletes its files from disk. A VM on a remote
+// host is only unregistered from the host unless DeleteRemoteFiles is set.
 func (vm *VM) Destroy() (err error) {
 	err = vm.haltWithFlag(true)
 	if err != nil {
 		return err
 	}
+	if vm.remote() {
+		command := "unregister"
+		if vm.DeleteRemoteFiles {
+			command = "deleteVM"
+		}
+		_, err = vm.runCombinedError(command, vm.vmxPath())
+		if err != nil {
+			err = lvm.WrapErrors(lvm.ErrDeletingVM, err)
+		}
+		return
+	}
 	if vm.Dst != "" {
 		err = os.RemoveAll(vm.Dst)
 		if err != nil {
@@ -177,12 +316,6 @@ func (vm *VM) Destroy() (err error) {
 }
 
 func (vm *VM) haltWithFlag(hard bool) error {
-	src := vm.Src
-	dst := vm.Dst
-
-	_, vmxFileName := filepath.Split(src)
-	vm.VmxFilePath = fmt.Sprintf("%s/%s", dst, vmxFileName)
-
 	// FIXME: Cannot use nogui flag here, it breaks vmrun's getGuestIP
 	// functionality.
 	flag := "soft"
@@ -190,7 +323,7 @@ func (vm *VM) haltWithFlag(hard bool) error {
 		flag = "hard"
 	}
 
-	_, err := runner.RunCombinedError("stop", vm.VmxFilePath, flag)
+	_, err := vm.runCombinedError("stop", vm.vmxPath(), flag)
 	return err
 }
 
@@ -201,15 +334,9 @@ func (vm *VM) Halt() error {
 
 // Suspend suspends the active state of the VM.
 func (vm *VM) Suspend() error {
-	src := vm.Src
-	dst := vm.Dst
-
-	_, vmxFileName := filepath.Split(src)
-	vm.VmxFilePath = fmt.Sprintf("%s/%s", dst, vmxFileName)
-
 	// FIXME: Cannot use nogui flag here, it breaks vmrun's getGuestIP
 	// functionality.
-	_, err := runner.RunCombinedError("suspend", vm.VmxFilePath)
+	_, err := vm.runCombinedError("suspend", vm.vmxPath())
 	return err
 }
 
@@ -220,15 +347,9 @@ func (vm *VM) Resume() error {
 
 // Start powers on the VM
 func (vm *VM) Start() error {
-	src := vm.Src
-	dst := vm.Dst
-
-	_, vmxFileName := filepath.Split(src)
-	vm.VmxFilePath = fmt.Sprintf("%s/%s", dst, vmxFileName)
-
 	// FIXME: Cannot use nogui flag here, it breaks vmrun's getGuestIP
 	// functionality.
-	out, err := runner.RunCombinedError("start", vm.VmxFilePath)
+	out, err := vm.runCombinedError("start", vm.vmxPath())
 	if err != nil {
 		return lvm.WrapErrors(err, errors.New(out))
 	}
@@ -245,7 +366,7 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 
 // GetState gets the power state of the VM through VMware tools.
 func (vm *VM) GetState() (string, error) {
-	stdout, stderr, err := runner.Run("list")
+	stdout, stderr, err := vm.run("list")
 	if err != nil {
 		return "", err
 	}
@@ -253,6 +374,13 @@ func (vm *VM) GetState() (string, error) {
 		return "", fmt.Errorf("Failed to get state using the vmrun utility: %s", stderr)
 	}
 
+	if vm.remote() {
+		if strings.Contains(stdout, vm.Src) {
+			return lvm.VMRunning, nil
+		}
+		return lvm.VMSuspended, nil
+	}
+
 	if strings.Contains(stdout, vm.Dst) {
 		return lvm.VMRunning, nil
 	}
@@ -288,10 +416,21 @@ func (vm *VM) Provision() error {
 		return lvm.ErrSourceNotSpecified
 	}
 
+	// The VMX file of a VM on a remote host cannot be copied or changed.
+	if vm.remote() {
+		vm.vmxPath()
+		return vm.waitUntilReady()
+	}
+
 	if dst == "" {
 		return lvm.ErrDestNotSpecified
 	}
 
+	if err := vm.fetchSrc(); err != nil {
+		return err
+	}
+	src = vm.Src
+
 	srcPath, _ := filepath.Abs(filepath.Dir(src))
 	srcPath += "/"
 
@@ -310,15 +449,20 @@ func (vm *VM) Provision() error {
 		return lvm.ErrCreatingVM
 	}
 
-	// Copy over the source path to the destination.
-	err := copyDir(srcPath, dst)
+	_, vmxFileName := filepath.Split(src)
+	vm.VmxFilePath = fmt.Sprintf("%s/%s", dst, vmxFileName)
+
+	var err error
+	if vm.LinkedClone {
+		err = vm.cloneVM(srcPath+vmxFileName, vm.VmxFilePath, vm.Name, true, vm.Snapshot)
+	} else {
+		// Copy over the source path to the destination.
+		err = copyDir(srcPath, dst)
+	}
 	if err != nil {
 		return err
 	}
 
-	_, vmxFileName := filepath.Split(src)
-	vm.VmxFilePath = fmt.Sprintf("%s/%s", dst, vmxFileName)
-
 	err = vm.configure()
 	if err != nil {
 		return err
@@ -377,7 +521,7 @@ func (vm *VM) requestIPs() []net.IP {
 	ips := []net.IP{}
 	// FIXME: Cannot use nogui flag here, it breaks vmrun's getGuestIP
 	// functionality.
-	stdout, _, _ := runner.Run("getGuestIPAddress", vm.VmxFilePath, "wait")
+	stdout, _, _ := vm.run("getGuestIPAddress", vm.VmxFilePath, "wait")
 	if stdout != "" {
 		if ip := net.ParseIP(strings.TrimSpace(stdout)); ip != nil {
 			ips = append(ips, ip)
@@ -6,3 +6,6 @@ package vmrun
 
 // VMRunPath is the path to the vmrun command line utility.
 var VMRunPath = "/Applications/VMware Fusion.app/Contents/Library/vmrun"
+
+// OVFToolPath is the path to the ovftool command line utility.
+var OVFToolPath = "/Applications/VMware Fusion.app/Contents/Library/VMware OVF Tool/ovftool"
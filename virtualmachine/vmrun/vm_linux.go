@@ -6,3 +6,7 @@ package vmrun
 
 // VMRunPath is a hardcoded path to fall back to when vmrun is not in the $PATH.
 var VMRunPath = "/usr/bin/vmrun"
+
+// OVFToolPath is a hardcoded path to fall back to when ovftool is not in the
+// $PATH.
+var OVFToolPath = "/usr/bin/ovftool"
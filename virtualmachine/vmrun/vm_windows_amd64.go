@@ -12,6 +12,9 @@ import (
 // VMRunPath is default path to vmrun to fallback when it is not on path.
 var VMRunPath string
 
+// OVFToolPath is default path to ovftool to fallback when it is not on path.
+var OVFToolPath = filepath.Join(os.Getenv("ProgramFiles(x86)"), "VMware", "VMware OVF Tool", "ovftool.exe")
+
 // VMwareProducts define VMware products those contain vmrun.exe in Windows.
 var VMwareProducts = [3]string{"VMware Workstation", "VMware Player", "VMware VIX"}
 
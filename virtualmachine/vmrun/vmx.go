@@ -0,0 +1,413 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vmrun
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lvm "github.com/apcera/libretto/virtualmachine"
+)
+
+// ErrVMRunning is returned when the VMX file of a running VM is edited.
+var ErrVMRunning = errors.New("the vmx file of a running vm cannot be edited")
+
+// ErrVMSuspended is returned when the VMX file of a suspended VM, which has a
+// checkpoint of its state, is edited.
+var ErrVMSuspended = errors.New("the vmx file of a suspended vm cannot be edited")
+
+// Connection types of ethernet adapters
+const (
+	ConnectionNat      = "nat"
+	ConnectionBridged  = "bridged"
+	ConnectionHostOnly = "hostonly"
+	// ConnectionCustom connects the adapter to the virtual network named by
+	// the VNet of the adapter, such as "vmnet2".
+	ConnectionCustom = "custom"
+)
+
+// Virtual devices of ethernet adapters
+const (
+	VirtualDevE1000   = "e1000"
+	VirtualDevE1000e  = "e1000e"
+	VirtualDevVmxnet3 = "vmxnet3"
+)
+
+// File types of serial ports
+const (
+	SerialFile    = "file"
+	SerialPipe    = "pipe"
+	SerialNetwork = "network"
+	SerialDevice  = "device"
+)
+
+// Maximum numbers of ethernet adapters and serial ports of a VM.
+const (
+	maxEthernetAdapters = 10
+	maxSerialPorts      = 4
+)
+
+var vmxLineRegexp = regexp.MustCompile(`^\s*([^=#\s]+)\s*=\s*"(.*)"\s*$`)
+
+// vmxEntry is a line of a VMX file. Lines without a key, such as comments,
+// are kept as they are.
+type vmxEntry struct {
+	key   string
+	value string
+	raw   string
+}
+
+// VMX is the configuration of a VM, as in its VMX file. Keys are case
+// insensitive.
+type VMX struct {
+	entries []vmxEntry
+}
+
+// ParseVMX parses the content of a VMX file.
+func ParseVMX(content string) *VMX {
+	vmx := &VMX{}
+	for _, line := range strings.Split(strings.TrimRight(content, "\n"), "\n") {
+		if match := vmxLineRegexp.FindStringSubmatch(line); match != nil {
+			vmx.entries = append(vmx.entries, vmxEntry{key: match[1], value: match[2]})
+		} else {
+			vmx.entries = append(vmx.entries, vmxEntry{raw: line})
+		}
+	}
+	return vmx
+}
+
+// String returns the content of the VMX file.
+func (v *VMX) String() string {
+	var b bytes.Buffer
+	for _, e := range v.entries {
+		if e.key == "" {
+			b.WriteString(e.raw)
+		} else {
+			fmt.Fprintf(&b, "%s = \"%s\"", e.key, e.value)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Get returns the value of the key, and whether it is set.
+func (v *VMX) Get(key string) (string, bool) {
+	for _, e := range v.entries {
+		if e.key != "" && strings.EqualFold(e.key, key) {
+			return e.value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of the key, adding it if it is not set.
+func (v *VMX) Set(key string, value string) {
+	for i, e := range v.entries {
+		if e.key != "" && strings.EqualFold(e.key, key) {
+			v.entries[i].value = value
+			return
+		}
+	}
+	v.entries = append(v.entries, vmxEntry{key: key, value: value})
+}
+
+// Delete deletes the key.
+func (v *VMX) Delete(key string) {
+	v.deleteIf(func(k string) bool {
+		return strings.EqualFold(k, key)
+	})
+}
+
+// deletePrefix deletes the keys which start with the prefix.
+func (v *VMX) deletePrefix(prefix string) {
+	v.deleteIf(func(k string) bool {
+		return strings.HasPrefix(strings.ToLower(k), strings.ToLower(prefix))
+	})
+}
+
+func (v *VMX) deleteIf(match func(key string) bool) {
+	entries := v.entries[:0]
+	for _, e := range v.entries {
+		if e.key == "" || !match(e.key) {
+			entries = append(entries, e)
+		}
+	}
+	v.entries = entries
+}
+
+// getInt returns the integer value of the key, or 0 if it is not set.
+func (v *VMX) getInt(key string) (int, error) {
+	value, ok := v.Get(key)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q of %s: %s", value, key, err)
+	}
+	return n, nil
+}
+
+// CPUs returns the number of virtual CPUs of the VM.
+func (v *VMX) CPUs() (int, error) {
+	n, err := v.getInt("numvcpus")
+	if err == nil && n == 0 {
+		// The VM has one CPU if it is not set.
+		n = 1
+	}
+	return n, err
+}
+
+// SetCPUs sets the number of virtual CPUs of the VM.
+func (v *VMX) SetCPUs(cpus int) error {
+	if cpus < 1 {
+		return fmt.Errorf("invalid number of cpus %d", cpus)
+	}
+	v.Set("numvcpus", strconv.Itoa(cpus))
+	return nil
+}
+
+// MemoryMB returns the memory of the VM in MB.
+func (v *VMX) MemoryMB() (int, error) {
+	return v.getInt("memsize")
+}
+
+// SetMemoryMB sets the memory of the VM in MB, which must be a multiple of 4.
+func (v *VMX) SetMemoryMB(memoryMB int) error {
+	if memoryMB < 4 || memoryMB%4 != 0 {
+		return fmt.Errorf("invalid memory size %d MB, it must be a positive multiple of 4", memoryMB)
+	}
+	v.Set("memsize", strconv.Itoa(memoryMB))
+	return nil
+}
+
+// EthernetAdapter is an ethernet adapter of a VM.
+type EthernetAdapter struct {
+	// Idx is the index of the adapter, starting at 0.
+	Idx int
+	// ConnectionType is one of the Connection constants.
+	ConnectionType string
+	// VirtualDev is one of the VirtualDev constants. The default of the guest
+	// OS is used if it is empty.
+	VirtualDev string
+	// VNet is the virtual network of custom adapters, such as "vmnet2".
+	VNet string
+	// BSDName is the host interface of bridged adapters on Fusion, such as
+	// "en0".
+	BSDName string
+	// MAC is the static MAC address of the adapter. One is generated if it
+	// is empty.
+	MAC string
+}
+
+// EthernetAdapters returns the present ethernet adapters of the VM.
+func (v *VMX) EthernetAdapters() []EthernetAdapter {
+	var adapters []EthernetAdapter
+	for i := 0; i < maxEthernetAdapters; i++ {
+		prefix := fmt.Sprintf("ethernet%d.", i)
+		if present, _ := v.Get(prefix + "present"); !strings.EqualFold(present, "TRUE") {
+			continue
+		}
+		a := EthernetAdapter{Idx: i}
+		a.ConnectionType, _ = v.Get(prefix + "connectionType")
+		a.VirtualDev, _ = v.Get(prefix + "virtualDev")
+		a.VNet, _ = v.Get(prefix + "vnet")
+		a.BSDName, _ = v.Get(prefix + "bsdName")
+		if addressType, _ := v.Get(prefix + "addressType"); strings.EqualFold(addressType, "static") {
+			a.MAC, _ = v.Get(prefix + "address")
+		}
+		adapters = append(adapters, a)
+	}
+	return adapters
+}
+
+// SetEthernetAdapter adds the ethernet adapter to the VM, replacing the adapter
+// with the same index.
+func (v *VMX) SetEthernetAdapter(a EthernetAdapter) error {
+	if a.Idx < 0 || a.Idx >= maxEthernetAdapters {
+		return fmt.Errorf("invalid ethernet adapter index %d", a.Idx)
+	}
+	switch a.ConnectionType {
+	case ConnectionNat, ConnectionBridged, ConnectionHostOnly:
+	case ConnectionCustom:
+		if a.VNet == "" {
+			return errors.New("a custom ethernet adapter must have a vnet")
+		}
+	default:
+		return fmt.Errorf("invalid connection type %q", a.ConnectionType)
+	}
+	switch a.VirtualDev {
+	case "", VirtualDevE1000, VirtualDevE1000e, VirtualDevVmxnet3:
+	default:
+		return fmt.Errorf("invalid virtual device %q", a.VirtualDev)
+	}
+	if a.MAC != "" {
+		if _, err := net.ParseMAC(a.MAC); err != nil {
+			return fmt.Errorf("invalid mac address %q: %s", a.MAC, err)
+		}
+	}
+
+	prefix := fmt.Sprintf("ethernet%d.", a.Idx)
+	v.deletePrefix(prefix)
+	v.Set(prefix+"present", "TRUE")
+	v.Set(prefix+"connectionType", a.ConnectionType)
+	if a.VirtualDev != "" {
+		v.Set(prefix+"virtualDev", a.VirtualDev)
+	}
+	if a.VNet != "" {
+		v.Set(prefix+"vnet", a.VNet)
+	}
+	if a.BSDName != "" {
+		v.Set(prefix+"bsdName", a.BSDName)
+	}
+	if a.MAC != "" {
+		v.Set(prefix+"addressType", "static")
+		v.Set(prefix+"address", a.MAC)
+	} else {
+		v.Set(prefix+"addressType", "generated")
+	}
+	return nil
+}
+
+// RemoveEthernetAdapter removes the ethernet adapter with the given index.
+func (v *VMX) RemoveEthernetAdapter(idx int) {
+	v.deletePrefix(fmt.Sprintf("ethernet%d.", idx))
+}
+
+// SerialPort is a serial port of a VM.
+type SerialPort struct {
+	// Idx is the index of the port, starting at 0.
+	Idx int
+	// FileType is one of the Serial constants.
+	FileType string
+	// FileName is the file, named pipe, host device or network URI, such as
+	// "telnet://:5000", the port is connected to.
+	FileName string
+	// Server makes a network port listen for connections, and a named pipe
+	// be created by the VM.
+	Server bool
+}
+
+// SerialPorts returns the present serial ports of the VM.
+func (v *VMX) SerialPorts() []SerialPort {
+	var ports []SerialPort
+	for i := 0; i < maxSerialPorts; i++ {
+		prefix := fmt.Sprintf("serial%d.", i)
+		if present, _ := v.Get(prefix + "present"); !strings.EqualFold(present, "TRUE") {
+			continue
+		}
+		p := SerialPort{Idx: i}
+		p.FileType, _ = v.Get(prefix + "fileType")
+		p.FileName, _ = v.Get(prefix + "fileName")
+		if p.FileType == SerialNetwork {
+			endPoint, _ := v.Get(prefix + "network.endPoint")
+			p.Server = endPoint == "server"
+		} else {
+			endPoint, _ := v.Get(prefix + "pipe.endPoint")
+			p.Server = endPoint == "server"
+		}
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// SetSerialPort adds the serial port to the VM, replacing the port with the
+// same index.
+func (v *VMX) SetSerialPort(p SerialPort) error {
+	if p.Idx < 0 || p.Idx >= maxSerialPorts {
+		return fmt.Errorf("invalid serial port index %d", p.Idx)
+	}
+	switch p.FileType {
+	case SerialFile, SerialPipe, SerialNetwork, SerialDevice:
+	default:
+		return fmt.Errorf("invalid serial port file type %q", p.FileType)
+	}
+	if p.FileName == "" {
+		return errors.New("a serial port must have a file name")
+	}
+
+	prefix := fmt.Sprintf("serial%d.", p.Idx)
+	v.deletePrefix(prefix)
+	v.Set(prefix+"present", "TRUE")
+	v.Set(prefix+"fileType", p.FileType)
+	v.Set(prefix+"fileName", p.FileName)
+	endPoint := "client"
+	if p.Server {
+		endPoint = "server"
+	}
+	switch p.FileType {
+	case SerialNetwork:
+		v.Set(prefix+"network.endPoint", endPoint)
+	case SerialPipe:
+		v.Set(prefix+"pipe.endPoint", endPoint)
+	}
+	return nil
+}
+
+// RemoveSerialPort removes the serial port with the given index.
+func (v *VMX) RemoveSerialPort(idx int) {
+	v.deletePrefix(fmt.Sprintf("serial%d.", idx))
+}
+
+// ReadVMX reads the VMX file of the VM.
+func (vm *VM) ReadVMX() (*VMX, error) {
+	if vm.remote() {
+		return nil, lvm.ErrNotImplemented
+	}
+	b, err := ioutil.ReadFile(vm.vmxPath())
+	if err != nil {
+		return nil, err
+	}
+	return ParseVMX(string(b)), nil
+}
+
+// EditVMX edits the VMX file of the VM, which must neither be running nor
+// suspended, with fn. The file is left as it is if fn returns an error.
+func (vm *VM) EditVMX(fn func(*VMX) error) error {
+	state, err := vm.GetState()
+	if err != nil {
+		return err
+	}
+	if state == lvm.VMRunning {
+		return ErrVMRunning
+	}
+	suspended, err := hasCheckpoint(vm.vmxPath())
+	if err != nil {
+		return err
+	}
+	if suspended {
+		return ErrVMSuspended
+	}
+
+	vmx, err := vm.ReadVMX()
+	if err != nil {
+		return err
+	}
+	if err := fn(vmx); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(vm.VmxFilePath, []byte(vmx.String()), 0755)
+}
+
+// hasCheckpoint returns whether the directory of the VMX file holds a
+// checkpoint of the state of the suspended VM, which would not match an
+// edited configuration.
+func hasCheckpoint(vmxPath string) (bool, error) {
+	for _, pattern := range []string{"*.vmss", "*.vmem"} {
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(vmxPath), pattern))
+		if err != nil {
+			return false, err
+		}
+		if len(matches) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
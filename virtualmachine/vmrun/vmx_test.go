@@ -0,0 +1,147 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vmrun
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testVMX = `.encoding = "UTF-8"
+# A comment
+config.version = "8"
+numvcpus = "2"
+memsize = "1024"
+ethernet0.present = "TRUE"
+ethernet0.connectionType = "nat"
+ethernet0.virtualDev = "e1000"
+ethernet0.addressType = "generated"
+`
+
+// TestParseVMXRoundTrip makes sure a VMX file is written back as it is read.
+func TestParseVMXRoundTrip(t *testing.T) {
+	vmx := ParseVMX(testVMX)
+	if got := vmx.String(); got != testVMX {
+		t.Fatalf("Expected the vmx file to be unchanged, got:\n%s", got)
+	}
+
+	vmx.Set("numvcpus", "4")
+	vmx.Set("guestOS", "ubuntu-64")
+	got := ParseVMX(vmx.String())
+	if cpus, err := got.CPUs(); err != nil || cpus != 4 {
+		t.Fatalf("Expected 4 cpus, got: %d, %v", cpus, err)
+	}
+	if guestOS, ok := got.Get("guestOS"); !ok || guestOS != "ubuntu-64" {
+		t.Fatalf("Expected the guest os to be added, got: %q", guestOS)
+	}
+}
+
+// TestVMXKeysCaseInsensitive makes sure keys match regardless of their case.
+func TestVMXKeysCaseInsensitive(t *testing.T) {
+	vmx := ParseVMX(testVMX)
+	if v, ok := vmx.Get("ETHERNET0.CONNECTIONTYPE"); !ok || v != "nat" {
+		t.Fatalf("Expected the connection type of the adapter, got: %q", v)
+	}
+
+	vmx.Set("MemSize", "2048")
+	if memoryMB, err := vmx.MemoryMB(); err != nil || memoryMB != 2048 {
+		t.Fatalf("Expected the memory to be replaced, got: %d, %v", memoryMB, err)
+	}
+	if len(vmx.entries) != len(ParseVMX(testVMX).entries) {
+		t.Fatalf("Expected no key to be added, got:\n%s", vmx)
+	}
+
+	vmx.Delete("Config.Version")
+	if _, ok := vmx.Get("config.version"); ok {
+		t.Fatal("Expected the key to be deleted")
+	}
+}
+
+// TestSetEthernetAdapter makes sure adapters are validated and replaced.
+func TestSetEthernetAdapter(t *testing.T) {
+	vmx := ParseVMX(testVMX)
+	invalid := []EthernetAdapter{
+		{Idx: -1, ConnectionType: ConnectionNat},
+		{Idx: maxEthernetAdapters, ConnectionType: ConnectionNat},
+		{Idx: 1, ConnectionType: "wifi"},
+		{Idx: 1, ConnectionType: ConnectionCustom},
+		{Idx: 1, ConnectionType: ConnectionNat, VirtualDev: "rtl8139"},
+		{Idx: 1, ConnectionType: ConnectionNat, MAC: "00:50:56"},
+	}
+	for _, a := range invalid {
+		if err := vmx.SetEthernetAdapter(a); err == nil {
+			t.Fatalf("Expected an error for the adapter %+v", a)
+		}
+	}
+
+	a := EthernetAdapter{Idx: 0, ConnectionType: ConnectionCustom, VNet: "vmnet2", VirtualDev: VirtualDevVmxnet3, MAC: "00:50:56:00:00:01"}
+	if err := vmx.SetEthernetAdapter(a); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	adapters := ParseVMX(vmx.String()).EthernetAdapters()
+	if len(adapters) != 1 || adapters[0] != a {
+		t.Fatalf("Expected the adapter to be replaced, got: %+v", adapters)
+	}
+
+	vmx.RemoveEthernetAdapter(0)
+	if adapters := vmx.EthernetAdapters(); len(adapters) != 0 {
+		t.Fatalf("Expected the adapter to be removed, got: %+v", adapters)
+	}
+}
+
+// TestSetSerialPort makes sure serial ports are validated and replaced.
+func TestSetSerialPort(t *testing.T) {
+	vmx := ParseVMX(testVMX)
+	invalid := []SerialPort{
+		{Idx: -1, FileType: SerialFile, FileName: "serial.log"},
+		{Idx: maxSerialPorts, FileType: SerialFile, FileName: "serial.log"},
+		{Idx: 0, FileType: "usb", FileName: "serial.log"},
+		{Idx: 0, FileType: SerialFile},
+	}
+	for _, p := range invalid {
+		if err := vmx.SetSerialPort(p); err == nil {
+			t.Fatalf("Expected an error for the serial port %+v", p)
+		}
+	}
+
+	ports := []SerialPort{
+		{Idx: 0, FileType: SerialNetwork, FileName: "telnet://:5000", Server: true},
+		{Idx: 1, FileType: SerialFile, FileName: "serial.log"},
+	}
+	for _, p := range ports {
+		if err := vmx.SetSerialPort(p); err != nil {
+			t.Fatalf("Expected to get no errors, got: %s", err)
+		}
+	}
+	got := ParseVMX(vmx.String()).SerialPorts()
+	if len(got) != 2 || got[0] != ports[0] || got[1] != ports[1] {
+		t.Fatalf("Expected the serial ports to be added, got: %+v", got)
+	}
+
+	vmx.RemoveSerialPort(0)
+	if got := vmx.SerialPorts(); len(got) != 1 || got[0] != ports[1] {
+		t.Fatalf("Expected the first serial port to be removed, got: %+v", got)
+	}
+}
+
+// TestHasCheckpoint makes sure the checkpoint of a suspended VM is detected.
+func TestHasCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vmxPath := filepath.Join(dir, "vm.vmx")
+	if suspended, err := hasCheckpoint(vmxPath); err != nil || suspended {
+		t.Fatalf("Expected no checkpoint, got: %t, %v", suspended, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "vm-1234.vmss"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if suspended, err := hasCheckpoint(vmxPath); err != nil || !suspended {
+		t.Fatalf("Expected a checkpoint, got: %t, %v", suspended, err)
+	}
+}
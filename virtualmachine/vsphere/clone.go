@@ -0,0 +1,172 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/apcera/libretto/util"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// CloneModeFull creates full clones, which copy the disks of the
+	// template.
+	CloneModeFull = "full"
+	// CloneModeLinked creates linked clones, which share the disks of a
+	// snapshot of the template and only store their changes.
+	CloneModeLinked = "linked"
+	// CloneModeInstant creates instant clones, which fork the memory and
+	// disks of a running VM. It requires vSphere 6.7 or later.
+	CloneModeInstant = "instant"
+
+	// instantCloneVersion is the vSphere API version which introduced instant
+	// clones.
+	instantCloneVersion = "6.7"
+)
+
+// ErrorNoSnapshot is returned when a linked clone is requested from a template
+// which has no snapshot.
+var ErrorNoSnapshot = errors.New("the template has no snapshot to link clones to")
+
+// cloneMode returns the clone mode of the VM.
+func (vm *VM) cloneMode() string {
+	switch {
+	case vm.CloneMode != "":
+		return vm.CloneMode
+	case vm.UseLinkedClones:
+		return CloneModeLinked
+	default:
+		return CloneModeFull
+	}
+}
+
+// findSnapshot returns the snapshot with the given name in the snapshot tree,
+// or nil if there is none.
+func findSnapshot(tree []types.VirtualMachineSnapshotTree, name string) *types.ManagedObjectReference {
+	for _, s := range tree {
+		if s.Name == name {
+			ref := s.Snapshot
+			return &ref
+		}
+		if ref := findSnapshot(s.ChildSnapshotList, name); ref != nil {
+			return ref
+		}
+	}
+	return nil
+}
+
+// linkedCloneSnapshot returns the snapshot of the template which linked clones
+// are created from, the one named by vm.Snapshot or the current one.
+func linkedCloneSnapshot(vm *VM, templateMo *mo.VirtualMachine) (*types.ManagedObjectReference, error) {
+	if templateMo.Snapshot == nil {
+		return nil, ErrorNoSnapshot
+	}
+	if vm.Snapshot == "" {
+		if templateMo.Snapshot.CurrentSnapshot == nil {
+			return nil, ErrorNoSnapshot
+		}
+		return templateMo.Snapshot.CurrentSnapshot, nil
+	}
+
+	ref := findSnapshot(templateMo.Snapshot.RootSnapshotList, vm.Snapshot)
+	if ref == nil {
+		return nil, NewErrorObjectNotFound(errors.New("snapshot not found"), vm.Snapshot)
+	}
+	return ref, nil
+}
+
+// instantCloneSpec is the InstantCloneSpec of the vSphere API, which the
+// vendored SDK lacks.
+type instantCloneSpec struct {
+	Name     string                           `xml:"name"`
+	Location types.VirtualMachineRelocateSpec `xml:"location"`
+}
+
+type instantCloneRequest struct {
+	This types.ManagedObjectReference `xml:"_this"`
+	Spec instantCloneSpec             `xml:"spec"`
+}
+
+type instantCloneResponse struct {
+	Returnval types.ManagedObjectReference `xml:"returnval"`
+}
+
+type instantCloneBody struct {
+	Req    *instantCloneRequest  `xml:"urn:vim25 InstantClone_Task,omitempty"`
+	Res    *instantCloneResponse `xml:"urn:vim25 InstantClone_TaskResponse,omitempty"`
+	Fault_ *soap.Fault           `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+func (b *instantCloneBody) Fault() *soap.Fault { return b.Fault_ }
+
+// instantClone forks the running VM named vm.Template into the VM, on a random
+// datastore out of vm.Datastores. The clone is running when it returns.
+var instantClone = func(vm *VM, dcMo *mo.Datacenter) error {
+	n := util.Random(1, len(vm.Datastores))
+	vm.datastore = vm.Datastores[n-1]
+	dsMo, err := findDatastore(vm, dcMo, vm.datastore)
+	if err != nil {
+		return err
+	}
+	dsMor := dsMo.Reference()
+
+	sourceMo, err := findVM(vm, dcMo, vm.Template)
+	if err != nil {
+		return fmt.Errorf("error retrieving the source vm: %s", err)
+	}
+
+	l, err := getVMLocation(vm, dcMo)
+	if err != nil {
+		return err
+	}
+	pool, folder, err := getVMPlacement(vm, dcMo, l)
+	if err != nil {
+		return err
+	}
+
+	req := instantCloneBody{
+		Req: &instantCloneRequest{
+			This: sourceMo.Reference(),
+			Spec: instantCloneSpec{
+				Name: vm.Name,
+				Location: types.VirtualMachineRelocateSpec{
+					Folder:    &folder,
+					Pool:      &pool,
+					Datastore: &dsMor,
+				},
+			},
+		},
+	}
+	var res instantCloneBody
+
+	// The method only exists as of the 6.7 API, so the request must be made
+	// with that version, through a client of its own as the session may be
+	// shared.
+	sc, err := newVersionClient(vm, instantCloneVersion)
+	if err != nil {
+		return err
+	}
+	if err := sc.RoundTrip(vm.ctx, &req, &res); err != nil {
+		return fmt.Errorf("error instant cloning vm: %s", err)
+	}
+
+	t := object.NewTask(vm.client.Client, res.Res.Returnval)
+	tInfo, err := t.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for instant clone task to finish: %s", err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("instant clone task finished with error: %s", tInfo.Error)
+	}
+
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve cloned VM: %s", err)
+	}
+	return waitForIP(vm, vmMo)
+}
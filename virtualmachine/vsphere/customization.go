@@ -0,0 +1,162 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Customization represents the guest OS customization applied to a VM when it
+// is cloned, so that clones get their own identity instead of the one of the
+// template. It requires VMware Tools in the template, and Perl for Linux
+// guests.
+type Customization struct {
+	// SpecName is the name of a customization spec stored in vCenter. When
+	// set, the other fields are ignored.
+	SpecName string
+	// Hostname is the host name of the guest. The VM name is used if empty.
+	Hostname string
+	// Domain is the DNS domain of a Linux guest.
+	Domain string
+	// TimeZone is the time zone of a Linux guest, such as "Etc/UTC".
+	TimeZone string
+	// DNSServers and DNSSuffixes are the DNS settings of the guest.
+	DNSServers  []string
+	DNSSuffixes []string
+	// Interfaces are the IP settings of the network cards of the VM, in order.
+	// Network cards without settings use DHCP.
+	Interfaces []InterfaceCustomization
+	// Windows runs sysprep with the given settings instead of customizing a
+	// Linux guest.
+	Windows *WindowsCustomization
+}
+
+// InterfaceCustomization represents the IP settings of a network card.
+type InterfaceCustomization struct {
+	// IP is the static IPv4 address of the network card. DHCP is used if it
+	// is empty.
+	IP         string
+	SubnetMask string
+	Gateways   []string
+}
+
+// WindowsCustomization represents the sysprep settings of a Windows guest.
+type WindowsCustomization struct {
+	FullName string
+	OrgName  string
+	// ProductKey is the Windows license key.
+	ProductKey string
+	// AdminPassword is the password of the Administrator account.
+	AdminPassword string
+	// TimeZone is the Microsoft time zone index, such as 85 for GMT.
+	TimeZone int32
+	// Workgroup is the workgroup the guest joins, unless JoinDomain is set.
+	Workgroup string
+	// JoinDomain is the Active Directory domain the guest joins, with the
+	// credentials of DomainAdmin.
+	JoinDomain          string
+	DomainAdmin         string
+	DomainAdminPassword string
+	// PerSeatLicense uses per seat licensing for Windows Server, instead of
+	// per server licensing with AutoUsers concurrent connections.
+	PerSeatLicense bool
+	AutoUsers      int32
+}
+
+// customizationSpec returns the customization spec of the VM, from vCenter if
+// it is named by SpecName, or nil if the VM is not customized.
+var customizationSpec = func(vm *VM) (*types.CustomizationSpec, error) {
+	c := vm.Customization
+	if c == nil {
+		return nil, nil
+	}
+	if c.SpecName == "" {
+		spec := buildCustomizationSpec(c, vm.Name)
+		return &spec, nil
+	}
+
+	m := object.NewCustomizationSpecManager(vm.client.Client)
+	item, err := m.GetCustomizationSpec(vm.ctx, c.SpecName)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving customization spec %q: %s", c.SpecName, err)
+	}
+	return &item.Spec, nil
+}
+
+// buildCustomizationSpec returns the customization spec of the customization
+// of a VM with the given name.
+func buildCustomizationSpec(c *Customization, name string) types.CustomizationSpec {
+	hostname := c.Hostname
+	if hostname == "" {
+		hostname = name
+	}
+
+	spec := types.CustomizationSpec{
+		GlobalIPSettings: types.CustomizationGlobalIPSettings{
+			DnsServerList: c.DNSServers,
+			DnsSuffixList: c.DNSSuffixes,
+		},
+	}
+
+	if w := c.Windows; w != nil {
+		sysprep := &types.CustomizationSysprep{
+			GuiUnattended: types.CustomizationGuiUnattended{
+				TimeZone:       w.TimeZone,
+				AutoLogon:      false,
+				AutoLogonCount: 1,
+			},
+			UserData: types.CustomizationUserData{
+				FullName:     w.FullName,
+				OrgName:      w.OrgName,
+				ComputerName: &types.CustomizationFixedName{Name: hostname},
+				ProductId:    w.ProductKey,
+			},
+		}
+		if w.AdminPassword != "" {
+			sysprep.GuiUnattended.Password = &types.CustomizationPassword{Value: w.AdminPassword, PlainText: true}
+		}
+		if w.JoinDomain != "" {
+			sysprep.Identification = types.CustomizationIdentification{
+				JoinDomain:          w.JoinDomain,
+				DomainAdmin:         w.DomainAdmin,
+				DomainAdminPassword: &types.CustomizationPassword{Value: w.DomainAdminPassword, PlainText: true},
+			}
+		} else {
+			sysprep.Identification = types.CustomizationIdentification{JoinWorkgroup: w.Workgroup}
+		}
+		if w.PerSeatLicense {
+			sysprep.LicenseFilePrintData = &types.CustomizationLicenseFilePrintData{
+				AutoMode: types.CustomizationLicenseDataModePerSeat,
+			}
+		} else if w.AutoUsers > 0 {
+			sysprep.LicenseFilePrintData = &types.CustomizationLicenseFilePrintData{
+				AutoMode:  types.CustomizationLicenseDataModePerServer,
+				AutoUsers: w.AutoUsers,
+			}
+		}
+		spec.Identity = sysprep
+	} else {
+		spec.Identity = &types.CustomizationLinuxPrep{
+			HostName: &types.CustomizationFixedName{Name: hostname},
+			Domain:   c.Domain,
+			TimeZone: c.TimeZone,
+		}
+	}
+
+	for _, iface := range c.Interfaces {
+		adapter := types.CustomizationIPSettings{Ip: &types.CustomizationDhcpIpGenerator{}}
+		if iface.IP != "" {
+			adapter = types.CustomizationIPSettings{
+				Ip:         &types.CustomizationFixedIp{IpAddress: iface.IP},
+				SubnetMask: iface.SubnetMask,
+				Gateway:    iface.Gateways,
+			}
+		}
+		spec.NicSettingMap = append(spec.NicSettingMap, types.CustomizationAdapterMapping{Adapter: adapter})
+	}
+
+	return spec
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/apcera/libretto/util"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// selectDatastore picks one of the datastores with the given names in the
+// datacenter, the one with the most free space if MostFreeDatastore is set,
+// a random one otherwise.
+var selectDatastore = func(vm *VM, dcMo *mo.Datacenter, names []string) (string, error) {
+	if len(names) == 0 {
+		return "", errors.New("no datastores to pick from")
+	}
+	if !vm.MostFreeDatastore {
+		n := util.Random(1, len(names))
+		return names[n-1], nil
+	}
+
+	candidates := map[string]bool{}
+	for _, name := range names {
+		candidates[name] = true
+	}
+
+	var selected string
+	var freeSpace int64 = -1
+	for _, dsMor := range dcMo.Datastore {
+		dsMo := mo.Datastore{}
+		ps := []string{"name", "summary"}
+		if err := vm.collector.RetrieveOne(vm.ctx, dsMor, ps, &dsMo); err != nil {
+			return "", NewErrorPropertyRetrieval(dsMor, ps, err)
+		}
+		s := dsMo.Summary
+		if !candidates[dsMo.Name] || !s.Accessible || s.MaintenanceMode == string(types.DatastoreSummaryMaintenanceModeStateInMaintenance) {
+			continue
+		}
+		if s.FreeSpace > freeSpace {
+			selected, freeSpace = dsMo.Name, s.FreeSpace
+		}
+	}
+	if selected == "" {
+		return "", NewErrorObjectNotFound(errors.New("no accessible datastore found"), fmt.Sprint(names))
+	}
+	return selected, nil
+}
+
+// findStoragePod finds the datastore cluster with the given name in the
+// folder tree.
+func findStoragePod(vm *VM, mor types.ManagedObjectReference, name string) (*mo.StoragePod, error) {
+	switch mor.Type {
+	case "Folder":
+		folderMo := mo.Folder{}
+		if err := vm.collector.RetrieveOne(vm.ctx, mor, []string{"childEntity"}, &folderMo); err != nil {
+			return nil, err
+		}
+		for _, child := range folderMo.ChildEntity {
+			pod, err := findStoragePod(vm, child, name)
+			if err != nil {
+				if _, ok := err.(ErrorObjectNotFound); !ok {
+					return nil, err
+				}
+			}
+			if pod != nil {
+				return pod, nil
+			}
+		}
+	case "StoragePod":
+		podMo := mo.StoragePod{}
+		if err := vm.collector.RetrieveOne(vm.ctx, mor, []string{"name"}, &podMo); err != nil {
+			return nil, err
+		}
+		if podMo.Name == name {
+			return &podMo, nil
+		}
+	}
+	return nil, NewErrorObjectNotFound(errors.New("could not find the datastore cluster"), name)
+}
+
+// cloneToDatastoreCluster clones the template with the clone spec into the
+// folder, onto the datastore recommended by Storage DRS in the datastore
+// cluster of the VM, and sets the datastore of the VM to it.
+var cloneToDatastoreCluster = func(vm *VM, folder types.ManagedObjectReference, templateMo *mo.VirtualMachine, cisp types.VirtualMachineCloneSpec) (*object.Task, error) {
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return nil, err
+	}
+	dcFolders := mo.Datacenter{}
+	if err := vm.collector.RetrieveOne(vm.ctx, dcMo.Reference(), []string{"datastoreFolder"}, &dcFolders); err != nil {
+		return nil, NewErrorPropertyRetrieval(dcMo.Reference(), []string{"datastoreFolder"}, err)
+	}
+	pod, err := findStoragePod(vm, dcFolders.DatastoreFolder, vm.DatastoreCluster)
+	if err != nil {
+		return nil, err
+	}
+	podMor := pod.Reference()
+	templateMor := templateMo.Reference()
+
+	// Storage DRS picks the datastore.
+	cisp.Location.Datastore = nil
+	spec := types.StoragePlacementSpec{
+		Type:             string(types.StoragePlacementSpecPlacementTypeClone),
+		Vm:               &templateMor,
+		CloneName:        vm.Name,
+		CloneSpec:        &cisp,
+		Folder:           &folder,
+		ResourcePool:     cisp.Location.Pool,
+		Host:             cisp.Location.Host,
+		PodSelectionSpec: types.StorageDrsPodSelectionSpec{StoragePod: &podMor},
+	}
+
+	srm := object.NewStorageResourceManager(vm.client.Client)
+	result, err := srm.RecommendDatastores(vm.ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("error getting storage DRS recommendations: %s", err)
+	}
+	if len(result.Recommendations) == 0 {
+		return nil, fmt.Errorf("no storage DRS recommendation for datastore cluster %s", vm.DatastoreCluster)
+	}
+
+	rec := result.Recommendations[0]
+	for _, action := range rec.Action {
+		if a, ok := action.(*types.StoragePlacementAction); ok {
+			dsMo := mo.Datastore{}
+			if err := vm.collector.RetrieveOne(vm.ctx, a.Destination, []string{"name"}, &dsMo); err != nil {
+				return nil, NewErrorPropertyRetrieval(a.Destination, []string{"name"}, err)
+			}
+			vm.datastore = dsMo.Name
+			break
+		}
+	}
+
+	return srm.ApplyStorageDrsRecommendation(vm.ctx, []string{rec.Key})
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// DiskProvisioningThin allocates the space of a disk as it is written.
+	DiskProvisioningThin = "thin"
+	// DiskProvisioningThick allocates the space of a disk when it is created,
+	// and zeroes it as it is first written.
+	DiskProvisioningThick = "thick"
+	// DiskProvisioningEagerZeroedThick allocates and zeroes the space of a
+	// disk when it is created, as required by fault tolerance and clustering.
+	DiskProvisioningEagerZeroedThick = "eagerZeroedThick"
+)
+
+// diskProvisioning returns the provisioning of the disks imported from the OVF
+// of the VM.
+func (vm *VM) diskProvisioning() string {
+	if vm.DiskProvisioning == "" {
+		return DiskProvisioningThin
+	}
+	return vm.DiskProvisioning
+}
+
+// setDiskProvisioning sets the provisioning of the disk backing to one of the
+// DiskProvisioning constants. An empty provisioning leaves it unchanged.
+func setDiskProvisioning(backing *types.VirtualDiskFlatVer2BackingInfo, provisioning string) error {
+	switch provisioning {
+	case "":
+	case DiskProvisioningThin:
+		backing.ThinProvisioned = types.NewBool(true)
+		backing.EagerlyScrub = types.NewBool(false)
+	case DiskProvisioningThick:
+		backing.ThinProvisioned = types.NewBool(false)
+		backing.EagerlyScrub = types.NewBool(false)
+	case DiskProvisioningEagerZeroedThick:
+		backing.ThinProvisioned = types.NewBool(false)
+		backing.EagerlyScrub = types.NewBool(true)
+	default:
+		return fmt.Errorf("unknown disk provisioning %q", provisioning)
+	}
+	return nil
+}
+
+// relocateDisks returns the disk locators which clone the disks of the
+// template onto the datastore with the given provisioning.
+func relocateDisks(devices object.VirtualDeviceList, ds types.ManagedObjectReference, provisioning string) ([]types.VirtualMachineRelocateSpecDiskLocator, error) {
+	var locators []types.VirtualMachineRelocateSpecDiskLocator
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		backing := &types.VirtualDiskFlatVer2BackingInfo{DiskMode: string(types.VirtualDiskModePersistent)}
+		if err := setDiskProvisioning(backing, provisioning); err != nil {
+			return nil, err
+		}
+		locators = append(locators, types.VirtualMachineRelocateSpecDiskLocator{
+			DiskId:          device.GetVirtualDevice().Key,
+			Datastore:       ds,
+			DiskBackingInfo: backing,
+		})
+	}
+	return locators, nil
+}
+
+// addDisks adds the disks to the VM, on their datastore or the one of the VM.
+var addDisks = func(vm *VM, dcMo *mo.Datacenter, vmMo *mo.VirtualMachine, disks []Disk) error {
+	vmObj := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	devices, err := vmObj.Device(vm.ctx)
+	if err != nil {
+		return err
+	}
+
+	var add []types.BaseVirtualDevice
+	for _, disk := range disks {
+		controller, err := devices.FindDiskController(disk.Controller)
+		if err != nil {
+			return err
+		}
+
+		datastore := disk.Datastore
+		if datastore == "" {
+			datastore = vm.datastore
+		}
+		var dsRef types.ManagedObjectReference
+		if datastore != "" {
+			ds, err := findDatastore(vm, dcMo, datastore)
+			if err != nil {
+				return err
+			}
+			dsRef = ds.Reference()
+		} else {
+			ps := []string{"datastore"}
+			if err := vm.collector.RetrieveOne(vm.ctx, vmMo.Reference(), ps, vmMo); err != nil {
+				return NewErrorPropertyRetrieval(vmMo.Reference(), ps, err)
+			}
+			if len(vmMo.Datastore) == 0 {
+				return fmt.Errorf("vm %s has no datastore to add disks to", vmMo.Name)
+			}
+			dsRef = vmMo.Datastore[0]
+		}
+
+		d := devices.CreateDisk(controller, dsRef, "")
+		d.CapacityInKB = disk.Size
+		if err := setDiskProvisioning(d.Backing.(*types.VirtualDiskFlatVer2BackingInfo), disk.Provisioning); err != nil {
+			return err
+		}
+		// Keep the new disk in the list so that the next one gets another
+		// key and unit number.
+		devices = append(devices, d)
+		add = append(add, d)
+	}
+	return vmObj.AddDevice(vm.ctx, add...)
+}
+
+// AddDisks adds the disks to this VM, which can be running if its guest
+// supports hot-adding disks.
+func (vm *VM) AddDisks(disks ...Disk) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	return addDisks(vm, dcMo, vmMo, disks)
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// DRSRuleAffinity keeps the VMs of the rule on the same host.
+	DRSRuleAffinity = "affinity"
+	// DRSRuleAntiAffinity keeps the VMs of the rule on different hosts.
+	DRSRuleAntiAffinity = "anti-affinity"
+	// DRSRuleHostAffinity runs the VMs of the rule on the hosts of the host
+	// group of the rule.
+	DRSRuleHostAffinity = "host-affinity"
+	// DRSRuleHostAntiAffinity keeps the VMs of the rule off the hosts of the
+	// host group of the rule.
+	DRSRuleHostAntiAffinity = "host-anti-affinity"
+
+	// DRSAutomationDisabled disables DRS for a VM.
+	DRSAutomationDisabled = "disabled"
+	// DRSAutomationManual only recommends placements and migrations of a VM.
+	DRSAutomationManual = string(types.DrsBehaviorManual)
+	// DRSAutomationPartiallyAutomated places a VM automatically when it is
+	// powered on, and only recommends migrations.
+	DRSAutomationPartiallyAutomated = string(types.DrsBehaviorPartiallyAutomated)
+	// DRSAutomationFullyAutomated places and migrates a VM automatically.
+	DRSAutomationFullyAutomated = string(types.DrsBehaviorFullyAutomated)
+)
+
+// DRSRule is a DRS rule of the cluster a set of VMs runs on.
+type DRSRule struct {
+	// Name is the name of the rule. VM-host rules also create a VM group of
+	// the same name.
+	Name string
+	// Type is the type of the rule, one of the DRSRule constants.
+	Type string
+	// VMs are the names of the VMs of the rule.
+	VMs []string
+	// HostGroup is the name of the host group of VM-host rules.
+	HostGroup string
+	// Mandatory makes VM-host rules a requirement rather than a preference,
+	// such as for license constraints.
+	Mandatory bool
+}
+
+// drsRuleSpec returns the cluster config spec which creates the rule with the
+// VMs, or replaces the rule of the same name of the cluster config.
+func drsRuleSpec(rule DRSRule, vms []types.ManagedObjectReference, config *types.ClusterConfigInfoEx) (*types.ClusterConfigSpecEx, error) {
+	if rule.Name == "" || len(vms) == 0 {
+		return nil, errors.New("a drs rule must have a name and vms")
+	}
+
+	spec := &types.ClusterConfigSpecEx{}
+	info := types.ClusterRuleInfo{Name: rule.Name, Enabled: types.NewBool(true)}
+	var ruleInfo types.BaseClusterRuleInfo
+	switch rule.Type {
+	case DRSRuleAffinity:
+		ruleInfo = &types.ClusterAffinityRuleSpec{ClusterRuleInfo: info, Vm: vms}
+	case DRSRuleAntiAffinity:
+		ruleInfo = &types.ClusterAntiAffinityRuleSpec{ClusterRuleInfo: info, Vm: vms}
+	case DRSRuleHostAffinity, DRSRuleHostAntiAffinity:
+		if rule.HostGroup == "" {
+			return nil, errors.New("a vm-host drs rule must have a host group")
+		}
+		info.Mandatory = types.NewBool(rule.Mandatory)
+		hostRule := &types.ClusterVmHostRuleInfo{ClusterRuleInfo: info, VmGroupName: rule.Name}
+		if rule.Type == DRSRuleHostAffinity {
+			hostRule.AffineHostGroupName = rule.HostGroup
+		} else {
+			hostRule.AntiAffineHostGroupName = rule.HostGroup
+		}
+		ruleInfo = hostRule
+
+		group := types.ClusterGroupSpec{
+			ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+			Info:            &types.ClusterVmGroup{ClusterGroupInfo: types.ClusterGroupInfo{Name: rule.Name}, Vm: vms},
+		}
+		for _, g := range config.Group {
+			if g.GetClusterGroupInfo().Name == rule.Name {
+				group.Operation = types.ArrayUpdateOperationEdit
+			}
+		}
+		spec.GroupSpec = append(spec.GroupSpec, group)
+	default:
+		return nil, fmt.Errorf("unknown drs rule type %q", rule.Type)
+	}
+
+	ruleSpec := types.ClusterRuleSpec{
+		ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+		Info:            ruleInfo,
+	}
+	for _, r := range config.Rule {
+		if existing := r.GetClusterRuleInfo(); existing.Name == rule.Name {
+			ruleSpec.Operation = types.ArrayUpdateOperationEdit
+			ruleInfo.GetClusterRuleInfo().Key = existing.Key
+		}
+	}
+	spec.RulesSpec = append(spec.RulesSpec, ruleSpec)
+	return spec, nil
+}
+
+// drsAutomationSpec returns the cluster config spec which sets the DRS
+// automation level override of the VM.
+func drsAutomationSpec(vmRef types.ManagedObjectReference, level string, config *types.ClusterConfigInfoEx) (*types.ClusterConfigSpecEx, error) {
+	info := &types.ClusterDrsVmConfigInfo{Key: vmRef, Enabled: types.NewBool(true)}
+	switch level {
+	case DRSAutomationDisabled:
+		info.Enabled = types.NewBool(false)
+	case DRSAutomationManual, DRSAutomationPartiallyAutomated, DRSAutomationFullyAutomated:
+		info.Behavior = types.DrsBehavior(level)
+	default:
+		return nil, fmt.Errorf("unknown drs automation level %q", level)
+	}
+
+	vmSpec := types.ClusterDrsVmConfigSpec{
+		ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationAdd},
+		Info:            info,
+	}
+	for _, c := range config.DrsVmConfig {
+		if c.Key == vmRef {
+			vmSpec.Operation = types.ArrayUpdateOperationEdit
+		}
+	}
+	return &types.ClusterConfigSpecEx{DrsVmConfigSpec: []types.ClusterDrsVmConfigSpec{vmSpec}}, nil
+}
+
+// CreateDRSRule creates the DRS rule on the destination cluster of this VM, or
+// replaces the rule of the same name.
+func (vm *VM) CreateDRSRule(rule DRSRule) error {
+	return vm.reconfigureCluster(func(dcMo *mo.Datacenter, config *types.ClusterConfigInfoEx) (*types.ClusterConfigSpecEx, error) {
+		var vms []types.ManagedObjectReference
+		for _, name := range rule.VMs {
+			vmMo, err := findVM(vm, dcMo, name)
+			if err != nil {
+				return nil, err
+			}
+			vms = append(vms, vmMo.Reference())
+		}
+		return drsRuleSpec(rule, vms, config)
+	})
+}
+
+// DeleteDRSRule deletes the DRS rule with the given name from the destination
+// cluster of this VM, along with the VM group of VM-host rules.
+func (vm *VM) DeleteDRSRule(name string) error {
+	return vm.reconfigureCluster(func(dcMo *mo.Datacenter, config *types.ClusterConfigInfoEx) (*types.ClusterConfigSpecEx, error) {
+		spec := &types.ClusterConfigSpecEx{}
+		for _, r := range config.Rule {
+			info := r.GetClusterRuleInfo()
+			if info.Name != name {
+				continue
+			}
+			spec.RulesSpec = append(spec.RulesSpec, types.ClusterRuleSpec{
+				ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationRemove, RemoveKey: info.Key},
+			})
+			if _, ok := r.(*types.ClusterVmHostRuleInfo); ok {
+				spec.GroupSpec = append(spec.GroupSpec, types.ClusterGroupSpec{
+					ArrayUpdateSpec: types.ArrayUpdateSpec{Operation: types.ArrayUpdateOperationRemove, RemoveKey: name},
+				})
+			}
+		}
+		if len(spec.RulesSpec) == 0 {
+			return nil, NewErrorObjectNotFound(errors.New("drs rule not found"), name)
+		}
+		return spec, nil
+	})
+}
+
+// SetDRSAutomation overrides the DRS automation level of the cluster for this
+// VM, with one of the DRSAutomation constants.
+func (vm *VM) SetDRSAutomation(level string) error {
+	return vm.reconfigureCluster(func(dcMo *mo.Datacenter, config *types.ClusterConfigInfoEx) (*types.ClusterConfigSpecEx, error) {
+		vmMo, err := findVM(vm, dcMo, vm.Name)
+		if err != nil {
+			return nil, err
+		}
+		return drsAutomationSpec(vmMo.Reference(), level, config)
+	})
+}
+
+// reconfigureCluster applies the config spec returned by fn, given the current
+// config, to the destination cluster of this VM.
+func (vm *VM) reconfigureCluster(fn func(*mo.Datacenter, *types.ClusterConfigInfoEx) (*types.ClusterConfigSpecEx, error)) error {
+	if vm.Destination.DestinationType != DestinationTypeCluster {
+		return ErrorDestinationNotSupported
+	}
+
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	crMo, err := findClusterComputeResource(vm, dcMo, vm.Destination.DestinationName)
+	if err != nil {
+		return err
+	}
+	ps := []string{"configurationEx"}
+	if err := vm.collector.RetrieveOne(vm.ctx, crMo.Reference(), ps, crMo); err != nil {
+		return NewErrorPropertyRetrieval(crMo.Reference(), ps, err)
+	}
+	config, ok := crMo.ConfigurationEx.(*types.ClusterConfigInfoEx)
+	if !ok {
+		return fmt.Errorf("%s is not a drs cluster", vm.Destination.DestinationName)
+	}
+
+	spec, err := fn(dcMo, config)
+	if err != nil {
+		return err
+	}
+
+	cr := object.NewClusterComputeResource(vm.client.Client, crMo.Reference())
+	reconfigureTask, err := cr.Reconfigure(vm.ctx, spec, true)
+	if err != nil {
+		return fmt.Errorf("error creating a reconfigure task on the cluster: %s", err)
+	}
+	tInfo, err := reconfigureTask.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for cluster reconfigure task: %s", err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("cluster reconfigure task returned an error: %s", tInfo.Error)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/apcera/libretto/ssh"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/guest"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var (
+	// GuestOperationsTimeout is the maximum time to wait for VMware Tools to
+	// accept guest operations, and for a command run through them. This is
+	// not thread-safe.
+	GuestOperationsTimeout = 10 * time.Minute
+
+	// ErrorGuestToolsNotRunning is returned when VMware Tools is not running in
+	// the guest, which guest operations require.
+	ErrorGuestToolsNotRunning = errors.New("VMware Tools is not running in the guest")
+	// ErrorGuestOperationsTimeout is returned when a command run through guest
+	// operations does not complete in time.
+	ErrorGuestOperationsTimeout = errors.New("timed out waiting for the guest program to exit")
+
+	// This ensures that guestClient implements the ssh.Client interface at
+	// compile time.
+	_ ssh.Client = (*guestClient)(nil)
+)
+
+// guestClient is an ssh.Client which runs commands and transfers files through
+// the guest operations of VMware Tools, without network access to the guest.
+// It authenticates to the guest with the SSH user and password of the VM.
+type guestClient struct {
+	vm *VM
+
+	client    *govmomi.Client
+	collector collector
+	ctx       context.Context
+	cancel    context.CancelFunc
+	vmRef     types.ManagedObjectReference
+	windows   bool
+
+	privateKey string
+	password   string
+}
+
+// auth returns the guest authentication of the client.
+func (c *guestClient) auth() types.BaseGuestAuthentication {
+	return &types.NamePasswordAuthentication{
+		Username: c.vm.Credentials.SSHUser,
+		Password: c.password,
+	}
+}
+
+// Connect opens a session to the vSphere API, and checks that VMware Tools is
+// running in the guest and accepts the credentials.
+func (c *guestClient) Connect() error {
+	if c.client == nil {
+		if err := SetupSession(c.vm); err != nil {
+			return err
+		}
+		// Keep the session of this client, as the methods of the VM replace
+		// the session of the VM.
+		c.client, c.collector = c.vm.client, c.vm.collector
+		c.ctx, c.cancel = c.vm.ctx, c.vm.cancel
+
+		dcMo, err := GetDatacenter(c.vm)
+		if err != nil {
+			c.Disconnect()
+			return err
+		}
+		vmMo, err := findVM(c.vm, dcMo, c.vm.Name)
+		if err != nil {
+			c.Disconnect()
+			return err
+		}
+		c.vmRef = vmMo.Reference()
+	}
+
+	vmMo := mo.VirtualMachine{}
+	ps := []string{"guest.toolsRunningStatus", "guest.guestFamily"}
+	if err := c.collector.RetrieveOne(c.ctx, c.vmRef, ps, &vmMo); err != nil {
+		return NewErrorPropertyRetrieval(c.vmRef, ps, err)
+	}
+	if vmMo.Guest == nil || vmMo.Guest.ToolsRunningStatus != string(types.VirtualMachineToolsRunningStatusGuestToolsRunning) {
+		return ErrorGuestToolsNotRunning
+	}
+	c.windows = vmMo.Guest.GuestFamily == string(types.VirtualMachineGuestOsFamilyWindowsGuest)
+
+	am, err := c.operations().AuthManager(c.ctx)
+	if err != nil {
+		return err
+	}
+	if err := am.ValidateCredentials(c.ctx, c.auth()); err != nil {
+		return fmt.Errorf("error validating the guest credentials: %s", err)
+	}
+	return nil
+}
+
+// Disconnect closes the session to the vSphere API.
+func (c *guestClient) Disconnect() {
+	if c.client == nil {
+		return
+	}
+	if c.vm.KeepAlive <= 0 {
+		c.client.Logout(c.ctx)
+	}
+	c.cancel()
+	c.client = nil
+}
+
+// operations returns the guest operations manager of the VM.
+func (c *guestClient) operations() *guest.OperationsManager {
+	return guest.NewOperationsManager(c.client.Client, c.vmRef)
+}
+
+// Run runs the command in the guest, with the shell of the guest, and writes
+// its output to stdout and stderr once it exits.
+func (c *guestClient) Run(command string, stdout io.Writer, stderr io.Writer) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	fm, err := c.operations().FileManager(c.ctx)
+	if err != nil {
+		return err
+	}
+	pm, err := c.operations().ProcessManager(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	// Guest programs have no output streams, so the output is redirected to
+	// temporary files in the guest.
+	outputs := make([]string, 2)
+	for i := range outputs {
+		outputs[i], err = fm.CreateTemporaryFile(c.ctx, c.auth(), "libretto-", ".out", "")
+		if err != nil {
+			return fmt.Errorf("error creating a temporary file in the guest: %s", err)
+		}
+		defer fm.DeleteFile(c.ctx, c.auth(), outputs[i])
+	}
+
+	spec := &types.GuestProgramSpec{
+		ProgramPath: "/bin/sh",
+		Arguments:   "-c " + shellQuote(fmt.Sprintf("%s > %s 2> %s", command, shellQuote(outputs[0]), shellQuote(outputs[1]))),
+	}
+	if c.windows {
+		spec = &types.GuestProgramSpec{
+			ProgramPath: `C:\Windows\System32\cmd.exe`,
+			Arguments:   fmt.Sprintf(`/c %s > "%s" 2> "%s"`, command, outputs[0], outputs[1]),
+		}
+	}
+	pid, err := pm.StartProgram(c.ctx, c.auth(), spec)
+	if err != nil {
+		return fmt.Errorf("error starting the guest program: %s", err)
+	}
+
+	var exitCode int32
+	start := time.Now()
+	for {
+		procs, err := pm.ListProcesses(c.ctx, c.auth(), []int64{pid})
+		if err != nil {
+			return fmt.Errorf("error listing the guest processes: %s", err)
+		}
+		if len(procs) > 0 && procs[0].EndTime != nil {
+			exitCode = procs[0].ExitCode
+			break
+		}
+		if time.Since(start) >= GuestOperationsTimeout {
+			return ErrorGuestOperationsTimeout
+		}
+		time.Sleep(time.Second)
+	}
+
+	for i, w := range []io.Writer{stdout, stderr} {
+		if err := c.download(fm, w, outputs[i]); err != nil {
+			return err
+		}
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("guest program exited with code %d: %s", exitCode, command)
+	}
+	return nil
+}
+
+// Upload writes the content of src to the file dst in the guest with the given
+// mode, which is ignored by Windows guests.
+func (c *guestClient) Upload(src io.Reader, dst string, size int, mode uint32) error {
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	fm, err := c.operations().FileManager(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	var attrs types.BaseGuestFileAttributes = &types.GuestPosixFileAttributes{Permissions: int64(mode)}
+	if c.windows {
+		attrs = &types.GuestWindowsFileAttributes{}
+	}
+	u, err := fm.InitiateFileTransferToGuest(c.ctx, c.auth(), dst, attrs, int64(size), true)
+	if err != nil {
+		return fmt.Errorf("error initiating the file transfer to the guest: %s", err)
+	}
+	transferURL, err := fm.TransferURL(c.ctx, u)
+	if err != nil {
+		return err
+	}
+
+	p := soap.DefaultUpload
+	p.ContentLength = int64(size)
+	return c.client.Client.Upload(src, transferURL, &p)
+}
+
+// Download writes the content of the file src in the guest to dst.
+func (c *guestClient) Download(dst io.WriteCloser, src string) error {
+	defer dst.Close()
+
+	if err := c.Connect(); err != nil {
+		return err
+	}
+
+	fm, err := c.operations().FileManager(c.ctx)
+	if err != nil {
+		return err
+	}
+	return c.download(fm, dst, src)
+}
+
+// download writes the content of the file src in the guest to w.
+func (c *guestClient) download(fm *guest.FileManager, w io.Writer, src string) error {
+	info, err := fm.InitiateFileTransferFromGuest(c.ctx, c.auth(), src)
+	if err != nil {
+		return fmt.Errorf("error initiating the file transfer from the guest: %s", err)
+	}
+	transferURL, err := fm.TransferURL(c.ctx, info.Url)
+	if err != nil {
+		return err
+	}
+
+	r, _, err := c.client.Client.Download(transferURL, &soap.DefaultDownload)
+	if err != nil {
+		return fmt.Errorf("error downloading %s from the guest: %s", src, err)
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Validate checks that the client has the credentials of the guest.
+func (c *guestClient) Validate() error {
+	if c.vm.Credentials.SSHUser == "" {
+		return fmt.Errorf("a guest user must be specified")
+	}
+	return nil
+}
+
+// WaitForSSH waits until VMware Tools is running in the guest and accepts the
+// credentials.
+func (c *guestClient) WaitForSSH(maxWait time.Duration) error {
+	start := time.Now()
+
+	for {
+		err := c.Connect()
+		if err == nil {
+			return nil
+		}
+		if c.client == nil {
+			return err
+		}
+
+		if time.Since(start) >= maxWait {
+			break
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+
+	return ssh.ErrTimeout
+}
+
+// SetSSHPrivateKey stores the private key, which guest operations do not use.
+func (c *guestClient) SetSSHPrivateKey(s string) {
+	c.privateKey = s
+}
+
+// GetSSHPrivateKey returns the stored private key.
+func (c *guestClient) GetSSHPrivateKey() string {
+	return c.privateKey
+}
+
+// SetSSHPassword sets the password of the guest user.
+func (c *guestClient) SetSSHPassword(s string) {
+	c.password = s
+}
+
+// GetSSHPassword returns the password of the guest user.
+func (c *guestClient) GetSSHPassword() string {
+	return c.password
+}
+
+// RunInGuest runs the command in the guest through VMware Tools, as the SSH
+// user of the VM, and returns its output.
+func (vm *VM) RunInGuest(command string) (string, string, error) {
+	client := vm.getGuestClient()
+	defer client.Disconnect()
+
+	var stdout, stderr bytes.Buffer
+	err := client.Run(command, &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+// getGuestClient returns a client of the guest operations of the VM.
+func (vm *VM) getGuestClient() *guestClient {
+	return &guestClient{vm: vm, password: vm.Credentials.SSHPassword}
+}
+
+// shellQuote quotes the string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,424 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+const (
+	// libraryItemTypeOvf is the type of content library items holding an OVF
+	// template.
+	libraryItemTypeOvf = "ovf"
+	// libraryItemTypeVMTemplate is the type of content library items holding
+	// a VM template, which requires vSphere 6.7 U1 or later.
+	libraryItemTypeVMTemplate = "vm-template"
+)
+
+// LibrarySubscription configures a library subscribed to a library published
+// by another vCenter.
+type LibrarySubscription struct {
+	// URL is the publish URL of the published library, which ends with
+	// "lib.json".
+	URL string
+	// Thumbprint is the SHA-1 thumbprint of the certificate of the publisher,
+	// required for HTTPS URLs with certificates which are not trusted.
+	Thumbprint string
+	// Username and Password authenticate to the published library, if it
+	// requires authentication.
+	Username string
+	Password string
+	// OnDemand only downloads the content of the items when they are used,
+	// instead of when the library syncs.
+	OnDemand bool
+}
+
+// restClient is a client of the vSphere Automation REST API of the vCenter of
+// a VM, which the content library is only available through.
+type restClient struct {
+	client  *http.Client
+	base    string
+	session string
+}
+
+// newRESTClient logs in to the REST API of the vCenter of the VM.
+var newRESTClient = func(vm *VM) (*restClient, error) {
+	config, err := tlsConfig(vm)
+	if err != nil {
+		return nil, err
+	}
+	c := &restClient{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: config},
+		},
+		base: fmt.Sprintf("https://%s/rest", vm.Host),
+	}
+
+	req, err := http.NewRequest("POST", c.base+"/com/vmware/cis/session", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(vm.Username, vm.Password)
+	if err := c.send(req, &c.session); err != nil {
+		return nil, fmt.Errorf("error logging in to the vSphere REST API: %s", err)
+	}
+	return c, nil
+}
+
+// send sends the request and decodes the value of the JSON response into out,
+// if not nil.
+func (c *restClient) send(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	if c.session != "" {
+		req.Header.Set("vmware-api-session-id", c.session)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return NewErrorBadResponse(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	result := struct {
+		Value interface{} `json:"value"`
+	}{Value: out}
+	return json.NewDecoder(resp.Body).Decode(&result)
+}
+
+// do sends a request of the given method to the path of the REST API, with
+// the JSON encoding of in as body if not nil.
+func (c *restClient) do(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// logout deletes the session of the client.
+func (c *restClient) logout() {
+	c.do("DELETE", "/com/vmware/cis/session", nil, nil)
+}
+
+// findLibrary returns the ID of the content library with the given name.
+func (c *restClient) findLibrary(name string) (string, error) {
+	var ids []string
+	spec := map[string]interface{}{"spec": map[string]string{"name": name}}
+	if err := c.do("POST", "/com/vmware/content/library?~action=find", spec, &ids); err != nil {
+		return "", fmt.Errorf("error finding content library %s: %s", name, err)
+	}
+	if len(ids) == 0 {
+		return "", NewErrorObjectNotFound(errors.New("content library not found"), name)
+	}
+	return ids[0], nil
+}
+
+// findLibraryItem returns the ID of the item with the given name in the
+// library, or an empty string if there is none.
+func (c *restClient) findLibraryItem(libraryID string, name string) (string, error) {
+	var ids []string
+	spec := map[string]interface{}{"spec": map[string]string{"library_id": libraryID, "name": name}}
+	if err := c.do("POST", "/com/vmware/content/library/item?~action=find", spec, &ids); err != nil {
+		return "", fmt.Errorf("error finding content library item %s: %s", name, err)
+	}
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[0], nil
+}
+
+// deployFromLibrary deploys the VM from the content library item onto one of
+// the datastores, instead of cloning it from a template, and powers it on.
+var deployFromLibrary = func(vm *VM, dcMo *mo.Datacenter) error {
+	c, err := newRESTClient(vm)
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+
+	libraryID, err := c.findLibrary(vm.Library)
+	if err != nil {
+		return err
+	}
+	itemID, err := c.findLibraryItem(libraryID, vm.LibraryItem)
+	if err != nil {
+		return err
+	}
+	if itemID == "" {
+		return NewErrorObjectNotFound(errors.New("content library item not found"), vm.LibraryItem)
+	}
+	var item struct {
+		Type string `json:"type"`
+	}
+	if err := c.do("GET", "/com/vmware/content/library/item/id:"+url.PathEscape(itemID), nil, &item); err != nil {
+		return fmt.Errorf("error getting content library item %s: %s", vm.LibraryItem, err)
+	}
+
+	vm.datastore, err = selectDatastore(vm, dcMo, vm.Datastores)
+	if err != nil {
+		return err
+	}
+	dsMo, err := findDatastore(vm, dcMo, vm.datastore)
+	if err != nil {
+		return err
+	}
+	l, err := getVMLocation(vm, dcMo)
+	if err != nil {
+		return err
+	}
+	pool, folder, err := getVMPlacement(vm, dcMo, l)
+	if err != nil {
+		return err
+	}
+
+	switch item.Type {
+	case libraryItemTypeOvf:
+		networkMapping, err := createNetworkMapping(vm, vm.Networks, l.Networks)
+		if err != nil {
+			return err
+		}
+		networks := []map[string]string{}
+		for _, m := range networkMapping {
+			networks = append(networks, map[string]string{"key": m.Name, "value": m.Network.Value})
+		}
+		properties := []map[string]string{}
+		for _, p := range ovfProperties(vm.OvfProperties) {
+			properties = append(properties, map[string]string{"id": p.Key, "value": p.Value})
+		}
+
+		spec := map[string]interface{}{
+			"target": map[string]string{
+				"resource_pool_id": pool.Value,
+				"host_id":          l.Host.Value,
+				"folder_id":        folder.Value,
+			},
+			"deployment_spec": map[string]interface{}{
+				"name":                 vm.Name,
+				"accept_all_EULA":      true,
+				"default_datastore_id": dsMo.Reference().Value,
+				"network_mappings":     networks,
+				"additional_parameters": []interface{}{map[string]interface{}{
+					"@class":     "com.vmware.vcenter.ovf.property_params",
+					"type":       "PropertyParams",
+					"properties": properties,
+				}},
+			},
+		}
+		var result struct {
+			Succeeded bool        `json:"succeeded"`
+			Error     interface{} `json:"error"`
+		}
+		if err := c.do("POST", "/com/vmware/vcenter/ovf/library-item/id:"+url.PathEscape(itemID)+"?~action=deploy", spec, &result); err != nil {
+			return fmt.Errorf("error deploying content library item %s: %s", vm.LibraryItem, err)
+		}
+		if !result.Succeeded {
+			return fmt.Errorf("deploying content library item %s failed: %v", vm.LibraryItem, result.Error)
+		}
+	case libraryItemTypeVMTemplate:
+		spec := map[string]interface{}{
+			"spec": map[string]interface{}{
+				"name": vm.Name,
+				"placement": map[string]string{
+					"resource_pool": pool.Value,
+					"host":          l.Host.Value,
+					"folder":        folder.Value,
+				},
+				"vm_home_storage": map[string]string{"datastore": dsMo.Reference().Value},
+				"disk_storage":    map[string]string{"datastore": dsMo.Reference().Value},
+			},
+		}
+		if err := c.do("POST", "/vcenter/vm-template/library-items/"+url.PathEscape(itemID)+"?action=deploy", spec, nil); err != nil {
+			return fmt.Errorf("error deploying content library item %s: %s", vm.LibraryItem, err)
+		}
+	default:
+		return fmt.Errorf("content library item %s of type %q cannot be deployed", vm.LibraryItem, item.Type)
+	}
+
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve deployed VM: %s", err)
+	}
+	if len(vm.NICs) > 0 {
+		if err = configureNICs(vm, dcMo, vmMo); err != nil {
+			return err
+		}
+	}
+	if len(vm.Disks) > 0 {
+		if err = reconfigureVM(vm, vmMo); err != nil {
+			return err
+		}
+	}
+	return start(vm)
+}
+
+// PublishToLibrary captures this VM as an OVF template to the item with the
+// given name in the content library, replacing the content of the item if it
+// already exists. The VM must be powered off.
+func (vm *VM) PublishToLibrary(library string, item string) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+
+	c, err := newRESTClient(vm)
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+
+	libraryID, err := c.findLibrary(library)
+	if err != nil {
+		return err
+	}
+	itemID, err := c.findLibraryItem(libraryID, item)
+	if err != nil {
+		return err
+	}
+	target := map[string]string{"library_id": libraryID}
+	if itemID != "" {
+		target = map[string]string{"library_item_id": itemID}
+	}
+
+	spec := map[string]interface{}{
+		"source":      map[string]string{"type": "VirtualMachine", "id": vmMo.Reference().Value},
+		"target":      target,
+		"create_spec": map[string]string{"name": item},
+	}
+	var result struct {
+		Succeeded bool        `json:"succeeded"`
+		Error     interface{} `json:"error"`
+	}
+	if err := c.do("POST", "/com/vmware/vcenter/ovf/library-item", spec, &result); err != nil {
+		return fmt.Errorf("error publishing vm to content library item %s: %s", item, err)
+	}
+	if !result.Succeeded {
+		return fmt.Errorf("publishing vm to content library item %s failed: %v", item, result.Error)
+	}
+	return nil
+}
+
+// CreateLibrary creates a local content library with the given name on the
+// datastore. If publish is set, the library is published so that libraries
+// of other vCenters can subscribe to it, and its publish URL is returned.
+func (vm *VM) CreateLibrary(name string, datastore string, publish bool) (string, error) {
+	spec := map[string]interface{}{
+		"name": name,
+		"type": "LOCAL",
+		"publish_info": map[string]interface{}{
+			"published":             publish,
+			"authentication_method": "NONE",
+		},
+	}
+	var publishURL string
+	err := vm.createLibrary("/com/vmware/content/local-library", datastore, spec, func(c *restClient, id string) error {
+		if !publish {
+			return nil
+		}
+		var library struct {
+			PublishInfo struct {
+				PublishURL string `json:"publish_url"`
+			} `json:"publish_info"`
+		}
+		if err := c.do("GET", "/com/vmware/content/local-library/id:"+url.PathEscape(id), nil, &library); err != nil {
+			return fmt.Errorf("error getting content library %s: %s", name, err)
+		}
+		publishURL = library.PublishInfo.PublishURL
+		return nil
+	})
+	return publishURL, err
+}
+
+// SubscribeLibrary creates a content library with the given name on the
+// datastore, which is subscribed to a library published by another vCenter
+// and syncs its items automatically.
+func (vm *VM) SubscribeLibrary(name string, datastore string, sub LibrarySubscription) error {
+	info := map[string]interface{}{
+		"subscription_url":       sub.URL,
+		"automatic_sync_enabled": true,
+		"on_demand":              sub.OnDemand,
+		"authentication_method":  "NONE",
+	}
+	if sub.Thumbprint != "" {
+		info["ssl_thumbprint"] = sub.Thumbprint
+	}
+	if sub.Username != "" {
+		info["authentication_method"] = "BASIC"
+		info["user_name"] = sub.Username
+		info["password"] = sub.Password
+	}
+	spec := map[string]interface{}{
+		"name":              name,
+		"type":              "SUBSCRIBED",
+		"subscription_info": info,
+	}
+	return vm.createLibrary("/com/vmware/content/subscribed-library", datastore, spec, nil)
+}
+
+// createLibrary creates a content library of the given create spec on the
+// datastore with the service at path, and calls fn with the ID of the library
+// if it is not nil.
+func (vm *VM) createLibrary(path string, datastore string, spec map[string]interface{}, fn func(*restClient, string) error) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	dsMo, err := findDatastore(vm, dcMo, datastore)
+	if err != nil {
+		return err
+	}
+
+	c, err := newRESTClient(vm)
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+
+	spec["storage_backings"] = []map[string]string{{"type": "DATASTORE", "datastore_id": dsMo.Reference().Value}}
+	var id string
+	if err := c.do("POST", path, map[string]interface{}{"create_spec": spec}, &id); err != nil {
+		return fmt.Errorf("error creating content library %s: %s", spec["name"], err)
+	}
+	if fn != nil {
+		return fn(c, id)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	// AdapterTypeVmxnet3 is the paravirtual network adapter, which needs the
+	// driver of VMware Tools in the guest.
+	AdapterTypeVmxnet3 = "vmxnet3"
+	// AdapterTypeE1000 is the emulated Intel 82545EM adapter.
+	AdapterTypeE1000 = "e1000"
+	// AdapterTypeE1000e is the emulated Intel 82574 adapter.
+	AdapterTypeE1000e = "e1000e"
+)
+
+// NIC represents a network card of the VM.
+type NIC struct {
+	// Network is the name or inventory path of the network, such as a
+	// standard portgroup, a distributed portgroup or an NSX segment.
+	Network string
+	// AdapterType is the type of the adapter, one of the AdapterType
+	// constants. It defaults to vmxnet3.
+	AdapterType string
+	// MAC is the static MAC address of the card. vSphere generates one if it
+	// is empty.
+	MAC string
+}
+
+// configureNICs replaces the network cards of the VM, which must be powered
+// off, with the NICs of the VM.
+var configureNICs = func(vm *VM, dcMo *mo.Datacenter, vmMo *mo.VirtualMachine) error {
+	vmObj := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	devices, err := vmObj.Device(vm.ctx)
+	if err != nil {
+		return err
+	}
+
+	var changes []types.BaseVirtualDeviceConfigSpec
+	for _, card := range devices.SelectByType((*types.VirtualEthernetCard)(nil)) {
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationRemove,
+			Device:    card,
+		})
+	}
+
+	f := datacenterFinder(vm, dcMo)
+	for _, nic := range vm.NICs {
+		network, err := f.Network(vm.ctx, nic.Network)
+		if err != nil {
+			return NewErrorObjectNotFound(err, nic.Network)
+		}
+		backing, err := network.EthernetCardBackingInfo(vm.ctx)
+		if err != nil {
+			return fmt.Errorf("error getting the backing of network %s: %s", nic.Network, err)
+		}
+
+		adapterType := nic.AdapterType
+		if adapterType == "" {
+			adapterType = AdapterTypeVmxnet3
+		}
+		card, err := devices.CreateEthernetCard(adapterType, backing)
+		if err != nil {
+			return err
+		}
+		// Keep the new card in the list so that the next one gets another key.
+		card.GetVirtualDevice().Key = devices.NewKey()
+		devices = append(devices, card)
+		if nic.MAC != "" {
+			c := card.(types.BaseVirtualEthernetCard).GetVirtualEthernetCard()
+			c.AddressType = string(types.VirtualEthernetCardMacTypeManual)
+			c.MacAddress = nic.MAC
+		}
+		changes = append(changes, &types.VirtualDeviceConfigSpec{
+			Operation: types.VirtualDeviceConfigSpecOperationAdd,
+			Device:    card,
+		})
+	}
+
+	return reconfigure(vm, vmMo, types.VirtualMachineConfigSpec{DeviceChange: changes})
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	"github.com/apcera/libretto/util"
+)
+
+// isURL returns whether the OVF location is an HTTP URL rather than a local
+// path.
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// isOva returns whether the OVF location is an OVA archive, which is a tar of
+// the OVF descriptor and the files it references.
+func isOva(location string) bool {
+	return strings.EqualFold(path.Ext(location), ".ova")
+}
+
+// ovfLocation returns the location of the OVF of the VM. An OVA URL is
+// downloaded into the cache directory first, so that the archive is not
+// downloaded again for each of the files it holds.
+func (vm *VM) ovfLocation() (string, error) {
+	if !isURL(vm.OvfPath) || !isOva(vm.OvfPath) {
+		return vm.OvfPath, nil
+	}
+	if vm.ovaPath == "" {
+		p, err := util.FetchImage(vm.OvfPath, "", vm.CacheDir)
+		if err != nil {
+			return "", fmt.Errorf("error downloading the ova %s: %s", vm.OvfPath, err)
+		}
+		vm.ovaPath = p
+	}
+	return vm.ovaPath, nil
+}
+
+// openLocation opens the local file or downloads the URL at the location.
+var openLocation = func(location string) (io.ReadCloser, int64, error) {
+	if !isURL(location) {
+		f, err := open(location)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+
+	resp, err := http.Get(location)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, NewErrorBadResponse(resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// ovaEntry is a file in an OVA archive, which closes the archive when closed.
+type ovaEntry struct {
+	io.Reader
+	io.Closer
+}
+
+// openOvaEntry opens the first file in the OVA archive at the location whose
+// name matches.
+func openOvaEntry(location string, match func(name string) bool) (io.ReadCloser, int64, error) {
+	ova, _, err := openLocation(location)
+	if err != nil {
+		return nil, 0, err
+	}
+	r := tar.NewReader(ova)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ova.Close()
+			return nil, 0, fmt.Errorf("error reading the ova %s: %s", location, err)
+		}
+		if match(hdr.Name) {
+			return ovaEntry{Reader: r, Closer: ova}, hdr.Size, nil
+		}
+	}
+	ova.Close()
+	return nil, 0, fmt.Errorf("file not found in the ova %s", location)
+}
+
+// readOvfDescriptor returns the OVF descriptor at the location, which is an
+// OVF file or an OVA archive, either local or a URL.
+var readOvfDescriptor = func(location string) (string, error) {
+	var r io.ReadCloser
+	var err error
+	if isOva(location) {
+		r, _, err = openOvaEntry(location, func(name string) bool {
+			return strings.EqualFold(path.Ext(name), ".ovf")
+		})
+	} else {
+		r, _, err = openLocation(location)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to open the ovf file: %s", err)
+	}
+	defer r.Close()
+
+	ovfContent, err := readAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open the ovf file: %s", err)
+	}
+	return string(ovfContent), nil
+}
+
+// openOvfFile opens the file referenced by the OVF descriptor at the location.
+// Relative paths are relative to the directory of the descriptor, or to the
+// root of the OVA archive. It returns the file and its size.
+var openOvfFile = func(location string, name string) (io.ReadCloser, int64, error) {
+	switch {
+	case isOva(location):
+		return openOvaEntry(location, func(entry string) bool {
+			return path.Clean(entry) == path.Clean(name)
+		})
+	case isURL(location):
+		base, err := url.Parse(location)
+		if err != nil {
+			return nil, 0, NewErrorParsingURL(location, err)
+		}
+		ref, err := url.Parse(name)
+		if err != nil {
+			return nil, 0, NewErrorParsingURL(name, err)
+		}
+		return openLocation(base.ResolveReference(ref).String())
+	case !filepath.IsAbs(name):
+		// If the path is not abs, convert it into an ABS path relative to the OVF file
+		name = filepath.Join(filepath.Dir(location), name)
+	}
+	return openLocation(name)
+}
+
+// ovfProperties returns the property mapping of the OVF properties, sorted by
+// key.
+func ovfProperties(properties map[string]string) []types.KeyValue {
+	var mapping []types.KeyValue
+	for k, v := range properties {
+		mapping = append(mapping, types.KeyValue{Key: k, Value: v})
+	}
+	sort.Slice(mapping, func(i, j int) bool {
+		return mapping[i].Key < mapping[j].Key
+	})
+	return mapping
+}
+
+// fileLease is the lease of one of the files of an OVF upload. It reports the
+// progress of the file as the progress of the whole upload, and leaves
+// completing the lease to the caller once all the files are uploaded.
+type fileLease struct {
+	Lease
+	offset int64
+	size   int64
+	total  int64
+}
+
+// Progress reports the progress of the whole upload given the percentage of
+// the file which is uploaded.
+func (l fileLease) Progress(p int) {
+	if l.total <= 0 {
+		return
+	}
+	l.Lease.Progress(int((l.offset + l.size*int64(p)/100) * 100 / l.total))
+}
+
+// Complete does nothing, as the lease is completed once all the files are
+// uploaded.
+func (l fileLease) Complete() error {
+	return nil
+}
+
+// deployFromOvf deploys the VM directly from its OVF or OVA onto one of the
+// datastores, without a template, and powers it on.
+var deployFromOvf = func(vm *VM, dcMo *mo.Datacenter) error {
+	var err error
+	vm.datastore, err = selectDatastore(vm, dcMo, vm.Datastores)
+	if err != nil {
+		return err
+	}
+	l, err := getVMLocation(vm, dcMo)
+	if err != nil {
+		return err
+	}
+	var folder types.ManagedObjectReference
+	l.ResourcePool, folder, err = getVMPlacement(vm, dcMo, l)
+	if err != nil {
+		return err
+	}
+
+	vmMo, err := importOvf(vm, dcMo, vm.Name, l, folder)
+	if err != nil {
+		return err
+	}
+	if len(vm.NICs) > 0 {
+		if err = configureNICs(vm, dcMo, vmMo); err != nil {
+			return err
+		}
+	}
+	if len(vm.Disks) > 0 {
+		if err = reconfigureVM(vm, vmMo); err != nil {
+			return err
+		}
+	}
+	return start(vm)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// getVMPlacement returns the resource pool and the inventory folder of the VM
+// at the given location. They are the ResourcePool or VApp and the Folder of
+// the VM if set, otherwise the root resource pool of the destination and the
+// VM folder of the datacenter.
+var getVMPlacement = func(vm *VM, dcMo *mo.Datacenter, l location) (pool types.ManagedObjectReference, folder types.ManagedObjectReference, err error) {
+	pool, folder = l.ResourcePool, dcMo.VmFolder
+	if vm.ResourcePool == "" && vm.VApp == "" && vm.Folder == "" {
+		return
+	}
+
+	f := datacenterFinder(vm, dcMo)
+	switch {
+	case vm.VApp != "":
+		var app *object.VirtualApp
+		app, err = f.VirtualApp(vm.ctx, vm.VApp)
+		if err != nil {
+			err = NewErrorObjectNotFound(err, vm.VApp)
+			return
+		}
+		pool = app.Reference()
+	case vm.ResourcePool != "":
+		var rp *object.ResourcePool
+		rp, err = f.ResourcePool(vm.ctx, vm.ResourcePool)
+		if err != nil {
+			err = NewErrorObjectNotFound(err, vm.ResourcePool)
+			return
+		}
+		pool = rp.Reference()
+	}
+
+	if vm.Folder != "" {
+		var fo *object.Folder
+		fo, err = f.Folder(vm.ctx, vm.Folder)
+		if err != nil {
+			err = NewErrorObjectNotFound(err, vm.Folder)
+			return
+		}
+		folder = fo.Reference()
+	}
+	return
+}
+
+// datacenterFinder returns a finder of the inventory paths of the datacenter.
+func datacenterFinder(vm *VM, dcMo *mo.Datacenter) *find.Finder {
+	f := find.NewFinder(vm.client.Client, true)
+	f.SetDatacenter(object.NewDatacenter(vm.client.Client, dcMo.Reference()))
+	return f
+}
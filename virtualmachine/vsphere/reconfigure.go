@@ -0,0 +1,224 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var (
+	// ShutdownTimeout is the maximum time Reconfigure waits for the guest to
+	// shut down when it restarts the VM. This is not thread-safe.
+	ShutdownTimeout = 5 * time.Minute
+
+	// ErrorRestartRequired is returned by Reconfigure when the change requires
+	// powering off the running VM and restarting it is not allowed.
+	ErrorRestartRequired = errors.New("the vm must be powered off to apply the change")
+)
+
+// DiskSpec represents a change to the disks of a VM by Reconfigure.
+type DiskSpec struct {
+	// Label is the label of the existing disk to grow, such as "Hard disk 1",
+	// or its device name, such as "disk-1000-0". A new disk is added if it is
+	// empty.
+	Label string
+	// Size is the capacity of the disk in KB. Existing disks can only grow.
+	Size int64
+	// Controller is the controller of a new disk, such as "scsi", "nvme" or
+	// a controller device name. The first SCSI controller is used if it is
+	// empty.
+	Controller string
+	// Provisioning is the provisioning of a new disk, one of the
+	// DiskProvisioning constants. It defaults to thin provisioning.
+	Provisioning string
+}
+
+// Reconfigure sets the number of CPUs and the memory of this VM, and grows or
+// adds the given disks. A zero cpus or memoryMB leaves them unchanged. A
+// running VM is changed in place if its guest supports hot-adding the CPUs and
+// memory. Otherwise, its guest is shut down and the VM powered on again if
+// restart is true, and ErrorRestartRequired is returned if it is false.
+func (vm *VM) Reconfigure(cpus int, memoryMB int, disks []DiskSpec, restart bool) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	ps := []string{"config", "datastore", "runtime.powerState"}
+	if err := vm.collector.RetrieveOne(vm.ctx, vmMo.Reference(), ps, vmMo); err != nil {
+		return NewErrorPropertyRetrieval(vmMo.Reference(), ps, err)
+	}
+
+	spec, err := reconfigureSpec(vmMo, cpus, memoryMB, disks)
+	if err != nil {
+		return err
+	}
+	if spec.NumCPUs == 0 && spec.MemoryMB == 0 && len(spec.DeviceChange) == 0 {
+		return nil
+	}
+
+	powerOff := vmMo.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn &&
+		needsPowerOff(vmMo.Config, cpus, memoryMB)
+	if powerOff {
+		if !restart {
+			return ErrorRestartRequired
+		}
+		if err := shutdownGuest(vm, vmMo); err != nil {
+			return err
+		}
+	}
+
+	err = reconfigure(vm, vmMo, spec)
+	if powerOff {
+		// Power the VM back on even if the reconfiguration failed.
+		if e := start(vm); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// reconfigureSpec returns the config spec which sets the number of CPUs and
+// the memory of the VM, and grows or adds the disks.
+func reconfigureSpec(vmMo *mo.VirtualMachine, cpus int, memoryMB int, disks []DiskSpec) (types.VirtualMachineConfigSpec, error) {
+	spec := types.VirtualMachineConfigSpec{}
+	if vmMo.Config == nil {
+		return spec, fmt.Errorf("the config of vm %s is not available", vmMo.Name)
+	}
+	hw := vmMo.Config.Hardware
+	if cpus > 0 && int32(cpus) != hw.NumCPU {
+		spec.NumCPUs = int32(cpus)
+	}
+	if memoryMB > 0 && int32(memoryMB) != hw.MemoryMB {
+		spec.MemoryMB = int64(memoryMB)
+	}
+
+	devices := object.VirtualDeviceList(hw.Device)
+	for _, disk := range disks {
+		if disk.Label != "" {
+			d := findDisk(devices, disk.Label)
+			if d == nil {
+				return spec, fmt.Errorf("disk %q not found on vm %s", disk.Label, vmMo.Name)
+			}
+			if disk.Size < d.CapacityInKB {
+				return spec, fmt.Errorf("disk %q cannot shrink from %d KB to %d KB", disk.Label, d.CapacityInKB, disk.Size)
+			}
+			if disk.Size == d.CapacityInKB {
+				continue
+			}
+			d.CapacityInKB = disk.Size
+			spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+				Operation: types.VirtualDeviceConfigSpecOperationEdit,
+				Device:    d,
+			})
+			continue
+		}
+
+		if len(vmMo.Datastore) == 0 {
+			return spec, fmt.Errorf("vm %s has no datastore to add disks to", vmMo.Name)
+		}
+		controller, err := devices.FindDiskController(disk.Controller)
+		if err != nil {
+			return spec, err
+		}
+		d := devices.CreateDisk(controller, vmMo.Datastore[0], "")
+		d.CapacityInKB = disk.Size
+		if err := setDiskProvisioning(d.Backing.(*types.VirtualDiskFlatVer2BackingInfo), disk.Provisioning); err != nil {
+			return spec, err
+		}
+		// Keep the new disk in the list so that the next one gets another
+		// key and unit number.
+		devices = append(devices, d)
+		spec.DeviceChange = append(spec.DeviceChange, &types.VirtualDeviceConfigSpec{
+			Operation:     types.VirtualDeviceConfigSpecOperationAdd,
+			FileOperation: types.VirtualDeviceConfigSpecFileOperationCreate,
+			Device:        d,
+		})
+	}
+	return spec, nil
+}
+
+// findDisk returns the disk with the given label or device name, or nil if
+// there is none.
+func findDisk(devices object.VirtualDeviceList, label string) *types.VirtualDisk {
+	for _, device := range devices.SelectByType((*types.VirtualDisk)(nil)) {
+		d := device.(*types.VirtualDisk)
+		if devices.Name(d) == label {
+			return d
+		}
+		if info := d.DeviceInfo; info != nil && info.GetDescription().Label == label {
+			return d
+		}
+	}
+	return nil
+}
+
+// needsPowerOff returns whether the VM must be powered off to change its
+// number of CPUs and memory, because its guest does not support hot-adding or
+// hot-removing them. Memory can never be hot-removed.
+func needsPowerOff(config *types.VirtualMachineConfigInfo, cpus int, memoryMB int) bool {
+	enabled := func(b *bool) bool {
+		return b != nil && *b
+	}
+
+	hw := config.Hardware
+	switch {
+	case cpus > 0 && int32(cpus) > hw.NumCPU && !enabled(config.CpuHotAddEnabled):
+		return true
+	case cpus > 0 && int32(cpus) < hw.NumCPU && !enabled(config.CpuHotRemoveEnabled):
+		return true
+	case memoryMB > 0 && int32(memoryMB) > hw.MemoryMB && !enabled(config.MemoryHotAddEnabled):
+		return true
+	case memoryMB > 0 && int32(memoryMB) < hw.MemoryMB:
+		return true
+	}
+	return false
+}
+
+// shutdownGuest shuts the guest of the VM down and waits up to ShutdownTimeout
+// for the VM to be powered off.
+var shutdownGuest = func(vm *VM, vmMo *mo.VirtualMachine) error {
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	if err := vmo.ShutdownGuest(vm.ctx); err != nil {
+		return fmt.Errorf("error shutting down the guest of the vm: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(vm.ctx, ShutdownTimeout)
+	defer cancel()
+	if err := vmo.WaitForPowerState(ctx, types.VirtualMachinePowerStatePoweredOff); err != nil {
+		return fmt.Errorf("error waiting for the guest of the vm to shut down: %s", err)
+	}
+	return nil
+}
+
+// reconfigure applies the config spec to the VM.
+var reconfigure = func(vm *VM, vmMo *mo.VirtualMachine, spec types.VirtualMachineConfigSpec) error {
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	reconfigureTask, err := vmo.Reconfigure(vm.ctx, spec)
+	if err != nil {
+		return fmt.Errorf("error creating a reconfigure task on the vm: %s", err)
+	}
+	tInfo, err := reconfigureTask.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for reconfigure task: %s", err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("reconfigure task returned an error: %s", tInfo.Error)
+	}
+	return nil
+}
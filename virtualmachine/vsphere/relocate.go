@@ -0,0 +1,135 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// RelocateHost migrates this VM with vMotion to the given host or cluster,
+// such as to evacuate a host before maintenance. The path is relative to the
+// host folder of the datacenter, such as "cluster" or "cluster/host". DRS
+// picks the host when the VM is migrated to a cluster. The VM keeps its
+// datastores, and its resource pool if it belongs to the target cluster, or
+// moves to the root resource pool of the target otherwise.
+func (vm *VM) RelocateHost(path string) error {
+	return vm.relocate(func(dcMo *mo.Datacenter, vmMo *mo.VirtualMachine) (types.VirtualMachineRelocateSpec, error) {
+		spec := types.VirtualMachineRelocateSpec{}
+		f := datacenterFinder(vm, dcMo)
+
+		var pool *object.ResourcePool
+		var owner types.ManagedObjectReference
+		if cr, err := f.ClusterComputeResource(vm.ctx, path); err == nil {
+			if pool, err = cr.ResourcePool(vm.ctx); err != nil {
+				return spec, fmt.Errorf("error finding the resource pool of cluster %s: %s", path, err)
+			}
+			owner = cr.Reference()
+		} else {
+			host, err := f.HostSystem(vm.ctx, path)
+			if err != nil {
+				return spec, NewErrorObjectNotFound(err, path)
+			}
+			if pool, err = host.ResourcePool(vm.ctx); err != nil {
+				return spec, fmt.Errorf("error finding the resource pool of host %s: %s", path, err)
+			}
+			hostMo := mo.HostSystem{}
+			if err := vm.collector.RetrieveOne(vm.ctx, host.Reference(), []string{"parent"}, &hostMo); err != nil {
+				return spec, NewErrorPropertyRetrieval(host.Reference(), []string{"parent"}, err)
+			}
+			if hostMo.Parent != nil {
+				owner = *hostMo.Parent
+			}
+			hostRef := host.Reference()
+			spec.Host = &hostRef
+		}
+
+		poolRef := pool.Reference()
+		current, err := currentPool(vm, vmMo, owner)
+		if err != nil {
+			return spec, err
+		}
+		if current != nil {
+			poolRef = *current
+		}
+		spec.Pool = &poolRef
+		return spec, nil
+	})
+}
+
+// currentPool returns the resource pool of the VM if it belongs to the given
+// compute resource, or nil if it does not.
+func currentPool(vm *VM, vmMo *mo.VirtualMachine, owner types.ManagedObjectReference) (*types.ManagedObjectReference, error) {
+	if err := vm.collector.RetrieveOne(vm.ctx, vmMo.Reference(), []string{"resourcePool"}, vmMo); err != nil {
+		return nil, NewErrorPropertyRetrieval(vmMo.Reference(), []string{"resourcePool"}, err)
+	}
+	if vmMo.ResourcePool == nil {
+		return nil, nil
+	}
+	poolMo := mo.ResourcePool{}
+	if err := vm.collector.RetrieveOne(vm.ctx, *vmMo.ResourcePool, []string{"owner"}, &poolMo); err != nil {
+		return nil, NewErrorPropertyRetrieval(*vmMo.ResourcePool, []string{"owner"}, err)
+	}
+	if poolMo.Owner != owner {
+		return nil, nil
+	}
+	return vmMo.ResourcePool, nil
+}
+
+// RelocateDatastore migrates the files and disks of this VM with Storage
+// vMotion to the given datastore. The VM stays on its host.
+func (vm *VM) RelocateDatastore(datastore string) error {
+	return vm.relocate(func(dcMo *mo.Datacenter, vmMo *mo.VirtualMachine) (types.VirtualMachineRelocateSpec, error) {
+		spec := types.VirtualMachineRelocateSpec{}
+		dsMo, err := findDatastore(vm, dcMo, datastore)
+		if err != nil {
+			return spec, err
+		}
+		dsRef := dsMo.Reference()
+		spec.Datastore = &dsRef
+		return spec, nil
+	})
+}
+
+// relocate migrates this VM with the relocate spec returned by fn.
+func (vm *VM) relocate(fn func(*mo.Datacenter, *mo.VirtualMachine) (types.VirtualMachineRelocateSpec, error)) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	spec, err := fn(dcMo, vmMo)
+	if err != nil {
+		return err
+	}
+	return relocateVM(vm, vmMo, spec)
+}
+
+// relocateVM runs the relocate task of the VM and waits for it to finish.
+var relocateVM = func(vm *VM, vmMo *mo.VirtualMachine, spec types.VirtualMachineRelocateSpec) error {
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	relocateTask, err := vmo.Relocate(vm.ctx, spec, types.VirtualMachineMovePriorityDefaultPriority)
+	if err != nil {
+		return fmt.Errorf("error creating a relocate task on the vm: %s", err)
+	}
+	tInfo, err := relocateTask.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for relocate task: %s", err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("relocate task returned an error: %s", tInfo.Error)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// sessions caches the clients of the sessions of VMs with a KeepAlive, by
+// URL and TLS options.
+var sessions = struct {
+	sync.Mutex
+	clients map[string]*govmomi.Client
+}{clients: map[string]*govmomi.Client{}}
+
+// sessionKey returns the key of the cached session of the VM.
+func sessionKey(vm *VM) string {
+	return fmt.Sprintf("%s|%t|%s|%s", vm.uri, vm.Insecure, vm.RootCAs, vm.Thumbprint)
+}
+
+// getClient returns the cached client of the session of the VM if it has a
+// KeepAlive, logging in again if the session has expired, and a new client
+// otherwise.
+func getClient(vm *VM) (*govmomi.Client, error) {
+	if vm.KeepAlive <= 0 {
+		return newClient(vm)
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+	key := sessionKey(vm)
+	if c, ok := sessions.clients[key]; ok {
+		s, err := c.SessionManager.UserSession(vm.ctx)
+		if err == nil && s != nil {
+			return c, nil
+		}
+		if err == nil && c.Login(vm.ctx, vm.uri.User) == nil {
+			return c, nil
+		}
+		delete(sessions.clients, key)
+	}
+	c, err := newClient(vm)
+	if err != nil {
+		return nil, err
+	}
+	sessions.clients[key] = c
+	return c, nil
+}
+
+// closeSession logs out of the session of the VM, unless it is cached, and
+// cancels its context.
+func (vm *VM) closeSession() {
+	if vm.KeepAlive <= 0 {
+		vm.client.Logout(vm.ctx)
+	}
+	vm.cancel()
+}
+
+// newSOAPClient returns a client of the SDK of the VM, which verifies the
+// certificate of the host against RootCAs and Thumbprint. It logs in again
+// when the session expires while it is kept alive.
+func newSOAPClient(vm *VM) (*govmomi.Client, error) {
+	soapClient := soap.NewClient(vm.uri, vm.Insecure)
+	if vm.RootCAs != "" {
+		if err := soapClient.SetRootCAs(vm.RootCAs); err != nil {
+			return nil, fmt.Errorf("error loading the root CAs: %s", err)
+		}
+	}
+	if vm.Thumbprint != "" {
+		soapClient.SetThumbprint(vm.uri.Host, vm.Thumbprint)
+	}
+
+	vimClient, err := vim25.NewClient(vm.ctx, soapClient)
+	if err != nil {
+		return nil, err
+	}
+	c := &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}
+	if vm.KeepAlive > 0 {
+		user := vm.uri.User
+		vimClient.RoundTripper = session.KeepAliveHandler(soapClient, vm.KeepAlive, func(rt soap.RoundTripper) error {
+			return keepAlive(c, user)
+		})
+	}
+
+	if err := c.Login(vm.ctx, vm.uri.User); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newVersionClient returns a SOAP client of the session of the VM which makes
+// its requests with the given version of the API, leaving the version of the
+// session client untouched.
+func newVersionClient(vm *VM, version string) (*soap.Client, error) {
+	sc := vm.client.Client.Client
+	c := sc.NewServiceClient(sc.URL().Path, sc.Namespace)
+	if vm.RootCAs != "" {
+		if err := c.SetRootCAs(vm.RootCAs); err != nil {
+			return nil, fmt.Errorf("error loading the root CAs: %s", err)
+		}
+	}
+	if vm.Thumbprint != "" {
+		c.SetThumbprint(vm.uri.Host, vm.Thumbprint)
+	}
+	c.Version = version
+	return c, nil
+}
+
+// keepAlive checks that the session of the client is still authenticated, and
+// logs in again if it is not. Errors, such as the host being unreachable, are
+// ignored so that the session is kept alive once it is back.
+func keepAlive(c *govmomi.Client, user *url.Userinfo) error {
+	ctx := context.Background()
+	s, err := c.SessionManager.UserSession(ctx)
+	if err != nil || s != nil {
+		return nil
+	}
+	// Keep trying on the next idle period if logging in fails, as stopping
+	// the keep-alive from its handler never returns.
+	c.Login(ctx, user)
+	return nil
+}
+
+// tlsConfig returns the TLS config of the connections to the host of the VM,
+// which verifies its certificate against RootCAs and Thumbprint.
+func tlsConfig(vm *VM) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: vm.Insecure}
+	if vm.RootCAs != "" {
+		pool := x509.NewCertPool()
+		for _, name := range filepath.SplitList(vm.RootCAs) {
+			pem, err := ioutil.ReadFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("error loading the root CAs: %s", err)
+			}
+			pool.AppendCertsFromPEM(pem)
+		}
+		config.RootCAs = pool
+	}
+	if vm.Thumbprint != "" && !vm.Insecure {
+		// Check the thumbprint instead of the chain of the certificate.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyThumbprint(rawCerts, vm.Thumbprint)
+		}
+	}
+	return config, nil
+}
+
+// verifyThumbprint checks that the SHA-1 thumbprint of the leaf certificate
+// matches the given one.
+func verifyThumbprint(rawCerts [][]byte, thumbprint string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("the host presented no certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	if actual := soap.ThumbprintSHA1(cert); !strings.EqualFold(actual, thumbprint) {
+		return fmt.Errorf("host certificate thumbprint %s does not match %s", actual, thumbprint)
+	}
+	return nil
+}
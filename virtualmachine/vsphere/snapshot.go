@@ -0,0 +1,128 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// SnapshotInfo represents a snapshot in the snapshot tree of a VM.
+type SnapshotInfo struct {
+	Name        string
+	Description string
+	Created     time.Time
+	// State is the power state of the VM when the snapshot was taken, such as
+	// "poweredOn" if it includes the memory of the VM.
+	State    string
+	Quiesced bool
+	// Current is set on the snapshot the VM is currently running from.
+	Current  bool
+	Children []SnapshotInfo
+}
+
+// snapshotTree returns the snapshot infos of the snapshot tree.
+func snapshotTree(tree []types.VirtualMachineSnapshotTree, current *types.ManagedObjectReference) []SnapshotInfo {
+	var infos []SnapshotInfo
+	for _, s := range tree {
+		infos = append(infos, SnapshotInfo{
+			Name:        s.Name,
+			Description: s.Description,
+			Created:     s.CreateTime,
+			State:       string(s.State),
+			Quiesced:    s.Quiesced,
+			Current:     current != nil && *current == s.Snapshot,
+			Children:    snapshotTree(s.ChildSnapshotList, current),
+		})
+	}
+	return infos
+}
+
+// CreateSnapshot creates a snapshot of this VM with the given name. If memory
+// is set, the snapshot includes the memory of the running VM. If quiesce is
+// set, VMware Tools quiesces the file systems of the guest first.
+func (vm *VM) CreateSnapshot(name string, description string, memory bool, quiesce bool) error {
+	return vm.snapshotOp("create snapshot", func(vmo *object.VirtualMachine) (*object.Task, error) {
+		return vmo.CreateSnapshot(vm.ctx, name, description, memory, quiesce)
+	})
+}
+
+// ListSnapshots returns the snapshot tree of this VM.
+func (vm *VM) ListSnapshots() ([]SnapshotInfo, error) {
+	if err := SetupSession(vm); err != nil {
+		return nil, err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return nil, err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return nil, err
+	}
+	if vmMo.Snapshot == nil {
+		return nil, nil
+	}
+	return snapshotTree(vmMo.Snapshot.RootSnapshotList, vmMo.Snapshot.CurrentSnapshot), nil
+}
+
+// RevertToSnapshot reverts this VM to the snapshot with the given name, or
+// path such as "base/updated" if names are ambiguous. The VM is powered on
+// if the snapshot includes its memory.
+func (vm *VM) RevertToSnapshot(name string) error {
+	return vm.snapshotOp("revert to snapshot", func(vmo *object.VirtualMachine) (*object.Task, error) {
+		return vmo.RevertToSnapshot(vm.ctx, name, false)
+	})
+}
+
+// DeleteSnapshot deletes the snapshot with the given name, or path if names
+// are ambiguous, and its children if removeChildren is set. The disks of the
+// snapshot are consolidated into its child or the running VM.
+func (vm *VM) DeleteSnapshot(name string, removeChildren bool) error {
+	return vm.snapshotOp("delete snapshot", func(vmo *object.VirtualMachine) (*object.Task, error) {
+		return vmo.RemoveSnapshot(vm.ctx, name, removeChildren, types.NewBool(true))
+	})
+}
+
+// snapshotOp runs the snapshot operation task on this VM and waits for it.
+func (vm *VM) snapshotOp(op string, fn func(*object.VirtualMachine) (*object.Task, error)) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	return runSnapshotTask(vm, vmMo, op, fn)
+}
+
+// runSnapshotTask runs the task started by fn on the VM and waits for it.
+var runSnapshotTask = func(vm *VM, vmMo *mo.VirtualMachine, op string, fn func(*object.VirtualMachine) (*object.Task, error)) error {
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	t, err := fn(vmo)
+	if err != nil {
+		return fmt.Errorf("error creating a %s task on the vm: %s", op, err)
+	}
+	tInfo, err := t.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for %s task: %s", op, err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("%s task returned an error: %s", op, tInfo.Error)
+	}
+	return nil
+}
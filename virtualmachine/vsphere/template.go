@@ -0,0 +1,135 @@
+// Copyright 2017 Apcera Inc. All rights reserved.
+
+package vsphere
+
+import (
+	"fmt"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// MarkAsTemplate converts this VM, which must be powered off, to a template
+// which VMs can be cloned from.
+func (vm *VM) MarkAsTemplate() error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	if err := vmo.MarkAsTemplate(vm.ctx); err != nil {
+		return fmt.Errorf("error converting the vm to a template: %s", err)
+	}
+	return nil
+}
+
+// MarkAsVM converts the template named by the Name of this VM back to a VM, in
+// the resource pool and on a host of the destination of this VM which has one
+// of its Datastores.
+func (vm *VM) MarkAsVM() error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+	// The host of the VM must have one of its datastores.
+	vm.datastore, err = selectDatastore(vm, dcMo, vm.Datastores)
+	if err != nil {
+		return err
+	}
+	l, err := getVMLocation(vm, dcMo)
+	if err != nil {
+		return err
+	}
+	pool, _, err := getVMPlacement(vm, dcMo, l)
+	if err != nil {
+		return err
+	}
+
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	rpo := object.NewResourcePool(vm.client.Client, pool)
+	hso := object.NewHostSystem(vm.client.Client, l.Host)
+	if err := vmo.MarkAsVirtualMachine(vm.ctx, *rpo, hso); err != nil {
+		return fmt.Errorf("error converting the template to a vm: %s", err)
+	}
+	return nil
+}
+
+// CloneToTemplate clones this VM to a template with the given name, in the
+// folder of this VM and on one of its Datastores, or on the datastore of this
+// VM if it has none. The VM is left as it is.
+func (vm *VM) CloneToTemplate(name string) error {
+	if err := SetupSession(vm); err != nil {
+		return err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return err
+	}
+	e, err := Exists(vm, dcMo, name)
+	if err != nil {
+		return fmt.Errorf("failed to check if the template already exists: %s", err)
+	}
+	if e {
+		return fmt.Errorf("template already exists: %s", name)
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return err
+	}
+
+	_, folder, err := getVMPlacement(vm, dcMo, location{})
+	if err != nil {
+		return err
+	}
+
+	cisp := types.VirtualMachineCloneSpec{Template: true, PowerOn: false}
+	if len(vm.Datastores) > 0 {
+		datastore, err := selectDatastore(vm, dcMo, vm.Datastores)
+		if err != nil {
+			return err
+		}
+		dsMo, err := findDatastore(vm, dcMo, datastore)
+		if err != nil {
+			return err
+		}
+		dsMor := dsMo.Reference()
+		cisp.Location.Datastore = &dsMor
+	}
+
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+	t, err := vmo.Clone(vm.ctx, object.NewFolder(vm.client.Client, folder), name, cisp)
+	if err != nil {
+		return fmt.Errorf("error cloning vm to a template: %s", err)
+	}
+	tInfo, err := t.WaitForResult(vm.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error waiting for clone task to finish: %s", err)
+	}
+	if tInfo.Error != nil {
+		return fmt.Errorf("clone task finished with error: %s", tInfo.Error)
+	}
+	return nil
+}
@@ -13,7 +13,6 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -47,7 +46,7 @@ var getURI = func(host string) string {
 }
 
 var newClient = func(vm *VM) (*govmomi.Client, error) {
-	return govmomi.NewClient(vm.ctx, vm.uri, vm.Insecure)
+	return newSOAPClient(vm)
 }
 
 var newFinder = func(c *vim25.Client) finder {
@@ -68,7 +67,7 @@ var SetupSession = func(vm *VM) error {
 	u.User = url.UserPassword(vm.Username, vm.Password)
 	vm.uri = u
 	vm.ctx, vm.cancel = context.WithCancel(context.Background())
-	client, err := newClient(vm)
+	client, err := getClient(vm)
 	if err != nil {
 		return NewErrorClientFailed(err)
 	}
@@ -109,6 +108,10 @@ var readAll = func(r io.Reader) ([]byte, error) {
 }
 
 var parseOvf = func(ovfLocation string) (string, error) {
+	if isURL(ovfLocation) || isOva(ovfLocation) {
+		return readOvfDescriptor(ovfLocation)
+	}
+
 	ovf, err := open(ovfLocation)
 	if err != nil {
 		return "", fmt.Errorf("Failed to open the ovf file: %s", err)
@@ -228,45 +231,70 @@ var uploadOvf = func(vm *VM, specResult *types.OvfCreateImportSpecResult, lease
 		return fmt.Errorf("error waiting on the nfc lease: %s", err)
 	}
 
-	//FIXME (Preet): Hard coded to just upload the first device.
-	url := leaseInfo.DeviceUrl[0].Url
-	if strings.Contains(url, "*") {
-		url = strings.Replace(url, "*", vm.Host, 1)
-	}
-
-	path := specResult.FileItem[0].Path
-	if !filepath.IsAbs(path) {
-		// If the path is not abs, convert it into an ABS path relative to the OVF file
-		dir := filepath.Dir(vm.OvfPath)
-		path = filepath.Join(dir, path)
-	}
-	file, err := open(path)
+	location, err := vm.ovfLocation()
 	if err != nil {
 		return err
 	}
-	info, _ := file.Stat()
-	totalBytes := info.Size()
-	reader := NewProgressReader(file, totalBytes, lease)
-	reader.StartProgress()
-	err = createRequest(reader, "POST", vm.Insecure, totalBytes, url, "application/x-vnd.vmware-streamVmdk")
+
+	// The files are uploaded to the ESXi host, so the thumbprint of vCenter
+	// does not apply to it.
+	config, err := tlsConfig(vm)
 	if err != nil {
 		return err
 	}
-	reader.Wait()
-	return nil
+	config.InsecureSkipVerify = vm.Insecure
+	config.VerifyPeerCertificate = nil
+
+	var totalBytes int64
+	for _, item := range specResult.FileItem {
+		totalBytes += item.Size
+	}
+
+	// Upload each file to the device URL of the lease it is imported to.
+	var offset int64
+	for _, item := range specResult.FileItem {
+		var url string
+		for _, device := range leaseInfo.DeviceUrl {
+			if device.ImportKey == item.DeviceId {
+				url = device.Url
+				break
+			}
+		}
+		if url == "" {
+			return fmt.Errorf("no device url in the nfc lease for %s", item.Path)
+		}
+		if strings.Contains(url, "*") {
+			url = strings.Replace(url, "*", vm.Host, 1)
+		}
+
+		file, size, err := openOvfFile(location, item.Path)
+		if err != nil {
+			return err
+		}
+		reader := NewProgressReader(file, size, fileLease{Lease: lease, offset: offset, size: item.Size, total: totalBytes})
+		reader.StartProgress()
+		err = createRequest(reader, "POST", config, size, url, "application/x-vnd.vmware-streamVmdk")
+		file.Close()
+		if err != nil {
+			return err
+		}
+		reader.Wait()
+		offset += item.Size
+	}
+	return lease.Complete()
 }
 
 var clientDo = func(c *http.Client, r *http.Request) (*http.Response, error) {
 	return c.Do(r)
 }
 
-var createRequest = func(r io.Reader, method string, insecure bool, length int64, url string, contentType string) error {
+var createRequest = func(r io.Reader, method string, config *tls.Config, length int64, url string, contentType string) error {
 	request, _ := http.NewRequest(method, url, r)
 	request.Header.Add("Connection", "Keep-Alive")
 	request.Header.Add("Content-Type", contentType)
 	request.Header.Add("Content-Length", fmt.Sprintf("%d", length))
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+		TLSClientConfig: config,
 	}
 	client := &http.Client{
 		Transport: tr,
@@ -317,7 +345,7 @@ func searchTree(vm *VM, mor types.ManagedObjectReference, name string) (*mo.Virt
 	case "VirtualMachine":
 		// Base recursive case, compare for value
 		vmMo := mo.VirtualMachine{}
-		err := vm.collector.RetrieveOne(vm.ctx, mor, []string{"name", "guest.ipAddress", "guest.guestState", "guest.net", "runtime.question", "snapshot.currentSnapshot"}, &vmMo)
+		err := vm.collector.RetrieveOne(vm.ctx, mor, []string{"name", "guest.ipAddress", "guest.guestState", "guest.net", "runtime.question", "snapshot"}, &vmMo)
 		if err != nil {
 			return nil, NewErrorObjectNotFound(errors.New("could not find the vm"), name)
 		}
@@ -330,8 +358,11 @@ func searchTree(vm *VM, mor types.ManagedObjectReference, name string) (*mo.Virt
 }
 
 var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []string) error {
-	n := util.Random(1, len(usableDatastores))
-	vm.datastore = usableDatastores[n-1]
+	var err error
+	vm.datastore, err = selectDatastore(vm, dcMo, usableDatastores)
+	if err != nil {
+		return err
+	}
 	dsMo, err := findDatastore(vm, dcMo, vm.datastore)
 	if err != nil {
 		return err
@@ -348,6 +379,11 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 	if err != nil {
 		return err
 	}
+	var folder types.ManagedObjectReference
+	l.ResourcePool, folder, err = getVMPlacement(vm, dcMo, l)
+	if err != nil {
+		return err
+	}
 
 	// TODO: If the network needs to be reconfigured as well then this needs
 	// to delete all the network cards and create VirtualDevice specs.
@@ -357,6 +393,16 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 		Host:      &l.Host,
 		Datastore: &dsMor,
 	}
+	if vm.DiskProvisioning != "" {
+		devices, err := vmObj.Device(vm.ctx)
+		if err != nil {
+			return err
+		}
+		relocateSpec.Disk, err = relocateDisks(devices, dsMor, vm.DiskProvisioning)
+		if err != nil {
+			return err
+		}
+	}
 
 	cisp := types.VirtualMachineCloneSpec{
 		Location: relocateSpec,
@@ -366,7 +412,11 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 
 	// To create a linked clone, we need to set the DiskMoveType and reference
 	// the snapshot of the VM we are cloning.
-	if vm.UseLinkedClones {
+	if vm.cloneMode() == CloneModeLinked {
+		snapshot, err := linkedCloneSnapshot(vm, vmMo)
+		if err != nil {
+			return err
+		}
 		relocateSpec = types.VirtualMachineRelocateSpec{
 			Pool:         &l.ResourcePool,
 			Host:         &l.Host,
@@ -377,12 +427,22 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 			Location: relocateSpec,
 			Template: false,
 			PowerOn:  false,
-			Snapshot: vmMo.Snapshot.CurrentSnapshot,
+			Snapshot: snapshot,
 		}
 	}
 
-	folderObj := object.NewFolder(vm.client.Client, dcMo.VmFolder)
-	t, err := vmObj.Clone(vm.ctx, folderObj, vm.Name, cisp)
+	cisp.Customization, err = customizationSpec(vm)
+	if err != nil {
+		return err
+	}
+
+	var t *object.Task
+	if vm.DatastoreCluster != "" {
+		t, err = cloneToDatastoreCluster(vm, folder, vmMo, cisp)
+	} else {
+		folderObj := object.NewFolder(vm.client.Client, folder)
+		t, err = vmObj.Clone(vm.ctx, folderObj, vm.Name, cisp)
+	}
 	if err != nil {
 		return fmt.Errorf("error cloning vm from template: %s", err)
 	}
@@ -397,6 +457,11 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 	if err != nil {
 		return fmt.Errorf("failed to retrieve cloned VM: %s", err)
 	}
+	if len(vm.NICs) > 0 {
+		if err = configureNICs(vm, dcMo, vmMo); err != nil {
+			return err
+		}
+	}
 	if len(vm.Disks) > 0 {
 		if err = reconfigureVM(vm, vmMo); err != nil {
 			return err
@@ -413,34 +478,11 @@ var cloneFromTemplate = func(vm *VM, dcMo *mo.Datacenter, usableDatastores []str
 }
 
 var reconfigureVM = func(vm *VM, vmMo *mo.VirtualMachine) error {
-	vmObj := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
-	devices, err := vmObj.Device(vm.ctx)
+	dc, err := GetDatacenter(vm)
 	if err != nil {
 		return err
 	}
-
-	var add []types.BaseVirtualDevice
-	for _, disk := range vm.Disks {
-		controller, err := devices.FindDiskController(disk.Controller)
-		if err != nil {
-			return err
-		}
-
-		dc, err := GetDatacenter(vm)
-		if err != nil {
-			return err
-		}
-
-		ds, err := findDatastore(vm, dc, vm.datastore)
-		if err != nil {
-			return err
-		}
-
-		d := devices.CreateDisk(controller, ds.Reference(), "")
-		d.CapacityInKB = disk.Size
-		add = append(add, d)
-	}
-	return vmObj.AddDevice(vm.ctx, add...)
+	return addDisks(vm, dc, vmMo, vm.Disks)
 }
 
 var waitForIP = func(vm *VM, vmMo *mo.VirtualMachine) error {
@@ -614,32 +656,80 @@ var createTemplateName = func(t string, ds string) string {
 var uploadTemplate = func(vm *VM, dcMo *mo.Datacenter, selectedDatastore string) error {
 	template := createTemplateName(vm.Template, selectedDatastore)
 	vm.datastore = selectedDatastore
-	// Read the ovf file
-	ovfContent, err := parseOvf(vm.OvfPath)
+	l, err := getVMLocation(vm, dcMo)
 	if err != nil {
 		return err
 	}
 
-	dsMo, err := findDatastore(vm, dcMo, selectedDatastore)
+	// Import into the DC's vm folder for now. We can make it user configurable later.
+	vmMo, err := importOvf(vm, dcMo, template, l, dcMo.VmFolder)
 	if err != nil {
 		return err
 	}
-	l, err := getVMLocation(vm, dcMo)
+
+	// LinkedClones cannot be created from templates, but must be created from snapshots of VMs.
+	// If UseLinkedClones is set to true, do not mark this is a template and instead
+	// create the necessary snapshot to produce a linked clone from.
+	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
+
+	if vm.cloneMode() == CloneModeLinked {
+		s := snapshot{
+			Name:        "snapshot-" + template,
+			Description: "Snapshot created by Libretto for linked clones.",
+			Memory:      false,
+			Quiesce:     false,
+		}
+
+		snapshotTask, err := vmo.CreateSnapshot(vm.ctx, s.Name, s.Description, s.Memory, s.Quiesce)
+
+		if err != nil {
+			return fmt.Errorf("error creating snapshot of the vm: %s", err)
+		}
+		tInfo, err := snapshotTask.WaitForResult(vm.ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error waiting for snapshot to finish: %s", err)
+		}
+		if tInfo.Error != nil {
+			return fmt.Errorf("snapshot task returned an error: %s", err)
+		}
+	} else {
+		err = vmo.MarkAsTemplate(vm.ctx)
+		if err != nil {
+			return fmt.Errorf("error converting the uploaded VM to a template: %s", err)
+		}
+	}
+	return nil
+}
+
+// importOvf imports the OVF of the VM onto its datastore as a VM with the given
+// name, in the resource pool of the location and the folder, and returns it.
+var importOvf = func(vm *VM, dcMo *mo.Datacenter, name string, l location, folder types.ManagedObjectReference) (*mo.VirtualMachine, error) {
+	// Read the ovf file
+	location, err := vm.ovfLocation()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	ovfContent, err := parseOvf(location)
+	if err != nil {
+		return nil, err
+	}
+
+	dsMo, err := findDatastore(vm, dcMo, vm.datastore)
+	if err != nil {
+		return nil, err
 	}
 
 	networkMapping, err := createNetworkMapping(vm, vm.Networks, l.Networks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create an import spec
 	cisp := types.OvfCreateImportSpecParams{
 		HostSystem:       &l.Host,
-		EntityName:       template,
-		DiskProvisioning: "thin",
-		PropertyMapping:  nil,
+		EntityName:       name,
+		DiskProvisioning: vm.diskProvisioning(),
+		PropertyMapping:  ovfProperties(vm.OvfProperties),
 		NetworkMapping:   networkMapping,
 	}
 
@@ -649,67 +739,34 @@ var uploadTemplate = func(vm *VM, dcMo *mo.Datacenter, selectedDatastore string)
 	specResult, err := ovfManager.CreateImportSpec(vm.ctx, ovfContent, rpo,
 		object.NewDatastore(vm.client.Client, dsMo.Reference()), cisp)
 	if err != nil {
-		return fmt.Errorf("failed to create an import spec for the VM: %s", err)
+		return nil, fmt.Errorf("failed to create an import spec for the VM: %s", err)
 	}
 
 	// FIXME (Preet) specResult can also have warnings. Need to log/return those.
 	if specResult.Error != nil {
-		return fmt.Errorf("errors returned from the ovf manager api. Errors: %s", specResult.Error)
+		return nil, fmt.Errorf("errors returned from the ovf manager api. Errors: %s", specResult.Error)
 	}
 
 	// If any of the unit numbers in the spec are 0, they need to be reset to -1
 	resetUnitNumbers(specResult)
 
 	hso := object.NewHostSystem(vm.client.Client, l.Host)
-	// Import into the DC's vm folder for now. We can make it user configurable later.
-	fo := object.NewFolder(vm.client.Client, dcMo.VmFolder)
+	fo := object.NewFolder(vm.client.Client, folder)
 	lease, err := rpo.ImportVApp(vm.ctx, specResult.ImportSpec, fo, hso)
 	if err != nil {
-		return fmt.Errorf("error getting an nfc lease: %s", err)
+		return nil, fmt.Errorf("error getting an nfc lease: %s", err)
 	}
 
 	err = uploadOvf(vm, specResult, NewLease(vm.ctx, lease))
 	if err != nil {
-		return fmt.Errorf("error uploading the ovf template: %s", err)
+		return nil, fmt.Errorf("error uploading the ovf template: %s", err)
 	}
 
-	vmMo, err := findVM(vm, dcMo, template)
+	vmMo, err := findVM(vm, dcMo, name)
 	if err != nil {
-		return fmt.Errorf("error getting the uploaded VM: %s", err)
-	}
-
-	// LinkedClones cannot be created from templates, but must be created from snapshots of VMs.
-	// If UseLinkedClones is set to true, do not mark this is a template and instead
-	// create the necessary snapshot to produce a linked clone from.
-	vmo := object.NewVirtualMachine(vm.client.Client, vmMo.Reference())
-
-	if vm.UseLinkedClones {
-		s := snapshot{
-			Name:        "snapshot-" + template,
-			Description: "Snapshot created by Libretto for linked clones.",
-			Memory:      false,
-			Quiesce:     false,
-		}
-
-		snapshotTask, err := vmo.CreateSnapshot(vm.ctx, s.Name, s.Description, s.Memory, s.Quiesce)
-
-		if err != nil {
-			return fmt.Errorf("error creating snapshot of the vm: %s", err)
-		}
-		tInfo, err := snapshotTask.WaitForResult(vm.ctx, nil)
-		if err != nil {
-			return fmt.Errorf("error waiting for snapshot to finish: %s", err)
-		}
-		if tInfo.Error != nil {
-			return fmt.Errorf("snapshot task returned an error: %s", err)
-		}
-	} else {
-		err = vmo.MarkAsTemplate(vm.ctx)
-		if err != nil {
-			return fmt.Errorf("error converting the uploaded VM to a template: %s", err)
-		}
+		return nil, fmt.Errorf("error getting the uploaded VM: %s", err)
 	}
-	return nil
+	return vmMo, nil
 }
 
 var getNetworkName = func(vm *VM, network types.ManagedObjectReference) (string, error) {
@@ -728,6 +785,13 @@ var getNetworkName = func(vm *VM, network types.ManagedObjectReference) (string,
 			return "", err
 		}
 		return dst.Name, nil
+	case "OpaqueNetwork":
+		dst := mo.OpaqueNetwork{}
+		err := vm.collector.RetrieveOne(vm.ctx, network, []string{"name"}, &dst)
+		if err != nil {
+			return "", err
+		}
+		return dst.Name, nil
 	}
 	return "", fmt.Errorf("Could not retrieve the network name for: %s", network.Value)
 }
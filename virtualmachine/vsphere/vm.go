@@ -259,6 +259,12 @@ type collector interface {
 type Disk struct {
 	Size       int64
 	Controller string
+	// Datastore is the name of the datastore of the disk. The datastore of
+	// the VM is used if it is empty.
+	Datastore string
+	// Provisioning is one of the DiskProvisioning constants. It defaults to
+	// thin provisioning.
+	Provisioning string
 }
 
 // Snapshot represents a vSphere snapshot to create
@@ -300,6 +306,8 @@ type Lease interface {
 
 var _ lvm.VirtualMachine = (*VM)(nil)
 
+var _ lvm.DashboardLinker = (*VM)(nil)
+
 // VM represents a vSphere VM.
 type VM struct {
 	// Host represents the vSphere host to use for creating this VM.
@@ -312,19 +320,70 @@ type VM struct {
 	Password string
 	// Insecure allows connecting without cert validation when set to true.
 	Insecure bool
+	// RootCAs is the path of a PEM file of the certificate authorities to
+	// verify the certificate of Host against, instead of the system ones.
+	// Several files may be separated by the OS path list separator.
+	RootCAs string
+	// Thumbprint is the SHA-1 thumbprint of the certificate of Host, such as
+	// "AB:CD:...". A self-signed certificate with this thumbprint is accepted.
+	Thumbprint string
+	// KeepAlive, when set, caches the session to Host for the methods of all
+	// the VMs with the same Host, credentials and TLS options, and keeps it
+	// alive by checking it after it has been idle for this long. The session
+	// is logged in again when it expires.
+	KeepAlive time.Duration
 	// Datacenter configures the datacenter onto which to import the VM.
 	Datacenter string
-	// OvfPath represents the location of the OVF file on disk.
+	// OvfPath represents the location of the OVF file on disk. It may also be
+	// an OVA archive, and an HTTP URL of either. OVA URLs are downloaded once
+	// into CacheDir.
 	OvfPath string
+	// CacheDir is the directory OVA URLs are downloaded into. A libretto
+	// directory of the temporary directory is used if it is empty.
+	CacheDir string
+	// OvfProperties assigns values to the properties of the OVF, such as the
+	// network settings of vendor appliances, by property key.
+	OvfProperties map[string]string
+	// DeployOVF deploys the VM directly from the OVF at OvfPath onto one of
+	// Datastores, instead of cloning it from a template.
+	DeployOVF bool
+	// Library and LibraryItem name a content library item holding an OVF or
+	// VM template. If set, the VM is deployed from the item onto one of
+	// Datastores instead of being cloned from a template.
+	Library     string
+	LibraryItem string
 	// Networks defines a mapping from each network label inside the ovf file
 	// to a vSphere network. Must be available on the host or deploy will fail.
 	Networks map[string]string
+	// NICs replaces the network cards of the template, or of the OVF, with
+	// cards on the given networks. They are not applied to instant clones.
+	NICs []NIC
 	// Name is the name to use for the VM on vSphere and internally.
 	Name string
 	// Template is the name to use for the VM's template
 	Template string
+	// ResourcePool is the inventory path of the resource pool of the VM, such
+	// as "/dc/host/cluster/Resources/pool", or "cluster/Resources/pool"
+	// relative to the host folder of the datacenter. The root resource pool
+	// of the destination is used if it is empty.
+	ResourcePool string
+	// VApp is the inventory path of the vApp the VM is created in, instead of
+	// ResourcePool, relative to the VM folder of the datacenter if it is not
+	// absolute.
+	VApp string
+	// Folder is the inventory path of the folder of the VM, such as
+	// "/dc/vm/team" or "vm/team" relative to the datacenter. The VM folder of
+	// the datacenter is used if it is empty.
+	Folder string
 	// Datastores is a slice of permissible datastores. One is picked out of these.
 	Datastores []string
+	// MostFreeDatastore picks the datastore with the most free space out of
+	// Datastores instead of a random one.
+	MostFreeDatastore bool
+	// DatastoreCluster is the name of a datastore cluster to clone the VM onto,
+	// on the datastore recommended by Storage DRS. Templates are still stored
+	// on Datastores.
+	DatastoreCluster string
 	// UseLocalTemplates is a flag to indicate whether a template should be uploaded on all
 	// the datastores that were passed in.
 	UseLocalTemplates bool
@@ -334,6 +393,11 @@ type VM struct {
 	Credentials ssh.Credentials
 	// Disks is a slice of extra disks to attach to the VM
 	Disks []Disk
+	// DiskProvisioning is the provisioning of the disks cloned from the
+	// template or imported from the OVF, one of the DiskProvisioning
+	// constants. Disks are imported thin and cloned as in the template if it
+	// is empty. It does not apply to linked and instant clones.
+	DiskProvisioning string
 	// QuestionResponses is a map of regular expressions to match question text
 	// to responses when a VM encounters a questions which would otherwise
 	// prevent normal operation. The response strings should be the string value
@@ -342,13 +406,38 @@ type VM struct {
 	// UseLinkedClones is a flag to indicate whether VMs cloned from templates should be
 	// linked clones.
 	UseLinkedClones bool
-	uri             *url.URL
-	ctx             context.Context
-	cancel          context.CancelFunc
-	client          *govmomi.Client
-	finder          finder
-	collector       collector
-	datastore       string
+	// CloneMode is the way the VM is cloned, one of the CloneMode constants. It
+	// defaults to linked clones if UseLinkedClones is set, full clones otherwise.
+	// Instant clones fork the running VM named Template, which is used as it is
+	// instead of being uploaded from the OVF.
+	CloneMode string
+	// Customization is the guest OS customization applied to the clone, such as
+	// its host name and static IPs. It is not applied to instant clones.
+	Customization *Customization
+	// UseGuestOperations makes GetSSH return a client which runs commands and
+	// transfers files through the guest operations of VMware Tools, as the
+	// SSH user and password of Credentials, instead of over the network.
+	UseGuestOperations bool
+	// IPTimeout is the maximum time GetIPs waits for VMware Tools to report
+	// an IP of the VM. GetIPs returns the IPs known at once if it is zero.
+	IPTimeout time.Duration
+	// PublicNetwork is the name of the network whose IPs GetIPs returns
+	// first, which GetSSH connects to. The network cards are in order if it
+	// is empty.
+	PublicNetwork string
+	// IPv4Only makes GetIPs leave out the IPV6 addresses of the VM.
+	IPv4Only bool
+	// Snapshot is the name of the snapshot of the template linked clones are
+	// created from. The current snapshot is used if it is empty.
+	Snapshot  string
+	uri       *url.URL
+	ctx       context.Context
+	cancel    context.CancelFunc
+	client    *govmomi.Client
+	finder    finder
+	collector collector
+	datastore string
+	ovaPath   string
 }
 
 // Provision provisions this VM.
@@ -358,10 +447,7 @@ func (vm *VM) Provision() (err error) {
 	}
 
 	// Cancel the sdk context
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 
 	// Get a reference to the datacenter with host and vm folders populated
 	dcMo, err := GetDatacenter(vm)
@@ -369,12 +455,51 @@ func (vm *VM) Provision() (err error) {
 		return fmt.Errorf("Failed to retrieve datacenter: %s", err)
 	}
 
+	if vm.DeployOVF || vm.LibraryItem != "" {
+		e, err := Exists(vm, dcMo, vm.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check if the vm already exists: %s", err)
+		}
+		if e {
+			return ErrorVMExists
+		}
+
+		if vm.LibraryItem != "" {
+			if err := deployFromLibrary(vm, dcMo); err != nil {
+				return fmt.Errorf("error while deploying vm from content library: %s", err)
+			}
+			return nil
+		}
+		if err := deployFromOvf(vm, dcMo); err != nil {
+			return fmt.Errorf("error while deploying vm from ovf: %s", err)
+		}
+		return nil
+	}
+
+	if vm.cloneMode() == CloneModeInstant {
+		e, err := Exists(vm, dcMo, vm.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check if the vm already exists: %s", err)
+		}
+		if e {
+			return ErrorVMExists
+		}
+
+		if err := instantClone(vm, dcMo); err != nil {
+			return fmt.Errorf("error while instant cloning vm: %s", err)
+		}
+		return nil
+	}
+
 	// Upload a template to all the datastores if `UseLocalTemplates` is set.
-	// Otherwise pick a random datastore out of the list that was passed in.
+	// Otherwise pick a datastore out of the list that was passed in.
 	var datastores = vm.Datastores
 	if !vm.UseLocalTemplates {
-		n := util.Random(1, len(vm.Datastores))
-		datastores = []string{vm.Datastores[n-1]}
+		d, err := selectDatastore(vm, dcMo, vm.Datastores)
+		if err != nil {
+			return err
+		}
+		datastores = []string{d}
 	}
 
 	usableDatastores := []string{}
@@ -424,15 +549,15 @@ func (vm *VM) GetName() string {
 }
 
 // GetIPs returns the IPs of this VM. Returns all the IPs known to the API for
-// the different network cards for this VM. Includes IPV4 and IPV6 addresses.
+// the different network cards for this VM, with the IPs on PublicNetwork
+// first. Link-local and loopback addresses are left out, as are IPV6
+// addresses if IPv4Only is set. It waits up to IPTimeout for VMware Tools to
+// report an IP.
 func (vm *VM) GetIPs() ([]net.IP, error) {
 	if err := SetupSession(vm); err != nil {
 		return nil, err
 	}
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 
 	// Get a reference to the datacenter with host and vm folders populated
 	dcMo, err := GetDatacenter(vm)
@@ -443,27 +568,57 @@ func (vm *VM) GetIPs() ([]net.IP, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Lazy initialized when there is an IP address later.
-	var ips []net.IP
-	for _, nic := range vmMo.Guest.Net {
+	ips := guestIPs(vmMo.Guest, vm.PublicNetwork, vm.IPv4Only)
+	for deadline := time.Now().Add(vm.IPTimeout); len(ips) == 0 && time.Now().Before(deadline); {
+		time.Sleep(ipPollInterval)
+		ps := []string{"guest.ipAddress", "guest.net"}
+		if err := vm.collector.RetrieveOne(vm.ctx, vmMo.Reference(), ps, vmMo); err != nil {
+			return nil, NewErrorPropertyRetrieval(vmMo.Reference(), ps, err)
+		}
+		ips = guestIPs(vmMo.Guest, vm.PublicNetwork, vm.IPv4Only)
+	}
+	return ips, nil
+}
+
+// ipPollInterval is the interval at which GetIPs checks whether VMware Tools
+// reported an IP.
+var ipPollInterval = 5 * time.Second
+
+// guestIPs returns the usable IPs of the network cards of the guest, with the
+// IPs on the public network first.
+func guestIPs(guest *types.GuestInfo, publicNetwork string, ipv4Only bool) []net.IP {
+	if guest == nil {
+		return nil
+	}
+	usable := func(ip net.IP) bool {
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			return false
+		}
+		return !ipv4Only || ip.To4() != nil
+	}
+
+	var public, ips []net.IP
+	for _, nic := range guest.Net {
 		for _, ip := range nic.IpAddress {
 			netIP := net.ParseIP(ip)
-			if netIP == nil {
+			if !usable(netIP) {
 				continue
 			}
-			if ips == nil {
-				ips = make([]net.IP, 0, 1)
+			if publicNetwork != "" && nic.Network == publicNetwork {
+				public = append(public, netIP)
+			} else {
+				ips = append(ips, netIP)
 			}
-			ips = append(ips, netIP)
 		}
 	}
-	if ips == nil && vmMo.Guest.IpAddress != "" {
-		ip := net.ParseIP(vmMo.Guest.IpAddress)
-		if ip != nil {
+	ips = append(public, ips...)
+	if ips == nil && guest.IpAddress != "" {
+		ip := net.ParseIP(guest.IpAddress)
+		if usable(ip) {
 			ips = append(ips, ip)
 		}
 	}
-	return ips, nil
+	return ips
 }
 
 // Destroy deletes this VM from vSphere.
@@ -471,10 +626,7 @@ func (vm *VM) Destroy() (err error) {
 	if err := SetupSession(vm); err != nil {
 		return err
 	}
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 
 	state, err := getState(vm)
 	if err != nil {
@@ -562,10 +714,7 @@ func (vm *VM) GetState() (state string, err error) {
 	if err := SetupSession(vm); err != nil {
 		return "", lvm.ErrVMInfoFailed
 	}
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 	state, err = getState(vm)
 	if err != nil {
 		return "", err
@@ -588,10 +737,7 @@ func (vm *VM) Suspend() (err error) {
 		return err
 	}
 
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 
 	// Get a reference to the datacenter with host and vm folders populated
 	dcMo, err := GetDatacenter(vm)
@@ -622,10 +768,7 @@ func (vm *VM) Halt() (err error) {
 	if err := SetupSession(vm); err != nil {
 		return err
 	}
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 
 	return halt(vm)
 }
@@ -635,10 +778,7 @@ func (vm *VM) Start() (err error) {
 	if err := SetupSession(vm); err != nil {
 		return err
 	}
-	defer func() {
-		vm.client.Logout(vm.ctx)
-		vm.cancel()
-	}()
+	defer vm.closeSession()
 
 	return start(vm)
 }
@@ -648,8 +788,40 @@ func (vm *VM) Resume() (err error) {
 	return vm.Start()
 }
 
-// GetSSH returns an ssh client configured for this VM.
+// GetDashboardURL returns the URL of the VM summary page in the vSphere web
+// client of the vCenter this VM is managed by.
+func (vm *VM) GetDashboardURL() (string, error) {
+	if err := SetupSession(vm); err != nil {
+		return "", err
+	}
+	defer vm.closeSession()
+
+	// Get a reference to the datacenter with host and vm folders populated
+	dcMo, err := GetDatacenter(vm)
+	if err != nil {
+		return "", err
+	}
+	vmMo, err := findVM(vm, dcMo, vm.Name)
+	if err != nil {
+		return "", err
+	}
+
+	objectID := fmt.Sprintf("urn:vmomi:VirtualMachine:%s:%s", vmMo.Reference().Value, vm.client.ServiceContent.About.InstanceUuid)
+	return fmt.Sprintf("https://%s/ui/#?extensionId=vsphere.core.vm.summary&objectId=%s&navigator=vsphere.core.viTree.hostsAndClustersView",
+		vm.Host, url.QueryEscape(objectID)), nil
+}
+
+// GetSSH returns an ssh client configured for this VM. With
+// UseGuestOperations, the client goes through VMware Tools instead.
 func (vm *VM) GetSSH(options ssh.Options) (ssh.Client, error) {
+	if vm.UseGuestOperations {
+		client := vm.getGuestClient()
+		if err := client.WaitForSSH(GuestOperationsTimeout); err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
 	ips, err := util.GetVMIPs(vm, options)
 	if err != nil {
 		return nil, err
@@ -3,12 +3,17 @@
 package vsphere
 
 import (
+	"archive/tar"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -577,9 +582,56 @@ func TestUploadOvfLeaseWaitError(t *testing.T) {
 	}
 }
 
+func TestUploadOvfTLSConfig(t *testing.T) {
+	oldOpenOvfFile, oldNewProgressReader, oldCreateRequest := openOvfFile, NewProgressReader, createRequest
+	defer func() {
+		openOvfFile, NewProgressReader, createRequest = oldOpenOvfFile, oldNewProgressReader, oldCreateRequest
+	}()
+	openOvfFile = func(string, string) (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(strings.NewReader("disk")), 4, nil
+	}
+	NewProgressReader = func(io.Reader, int64, Lease) ProgressReader {
+		return mockProgressReader{}
+	}
+	var config *tls.Config
+	createRequest = func(_ io.Reader, _ string, c *tls.Config, _ int64, _ string, _ string) error {
+		config = c
+		return nil
+	}
+
+	ca, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.Close()
+	defer os.Remove(ca.Name())
+
+	l := mockLease{
+		MockWait: func() (*nfc.LeaseInfo, error) {
+			return &nfc.LeaseInfo{
+				HttpNfcLeaseInfo: types.HttpNfcLeaseInfo{
+					DeviceUrl: []types.HttpNfcLeaseDeviceUrl{{ImportKey: "disk", Url: "https://*/nfc/disk"}},
+				},
+			}, nil
+		},
+	}
+	vm := VM{Host: "esxi", RootCAs: ca.Name(), Thumbprint: "AA:BB"}
+	sr := types.OvfCreateImportSpecResult{
+		FileItem: []types.OvfFileItem{{DeviceId: "disk", Path: "disk.vmdk", Size: 4}},
+	}
+	if err = uploadOvf(&vm, &sr, l); err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	// The upload goes to the ESXi host, it is not checked against the
+	// thumbprint of vCenter
+	if config == nil || config.RootCAs == nil || config.InsecureSkipVerify || config.VerifyPeerCertificate != nil {
+		t.Fatalf("Expected the root CAs without the thumbprint, got: %+v", config)
+	}
+}
+
 func TestCreateRequestNewRequestError(t *testing.T) {
 	errProtocol := `unsupported protocol scheme ""`
-	err := createRequest(mockProgressReader{}, "foo", true, 0, "", "foo")
+	err := createRequest(mockProgressReader{}, "foo", &tls.Config{InsecureSkipVerify: true}, 0, "", "foo")
 	if !strings.Contains(err.Error(), errProtocol) {
 		t.Fatalf("Expected error to contain %q, got: %q", errProtocol, err)
 	}
@@ -593,7 +645,7 @@ func TestCreateRequestBadStatusCode(t *testing.T) {
 	clientDo = func(c *http.Client, r *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: 404}, nil
 	}
-	err := createRequest(mockProgressReader{}, "foo", true, 0, "", "foo")
+	err := createRequest(mockProgressReader{}, "foo", &tls.Config{InsecureSkipVerify: true}, 0, "", "foo")
 	if _, ok := err.(ErrorBadResponse); !ok {
 		t.Fatalf("Expected to get a bad response error got: %s", err)
 	}
@@ -607,7 +659,7 @@ func TestCreateRequestHappyPath(t *testing.T) {
 	clientDo = func(c *http.Client, r *http.Request) (*http.Response, error) {
 		return &http.Response{StatusCode: 201}, nil
 	}
-	err := createRequest(mockProgressReader{}, "foo", true, 0, "", "foo")
+	err := createRequest(mockProgressReader{}, "foo", &tls.Config{InsecureSkipVerify: true}, 0, "", "foo")
 	if err != nil {
 		t.Fatalf("Expected to get no errors got: %s", err)
 	}
@@ -721,3 +773,341 @@ func TestResolveAnswerAndOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestFindSnapshot(t *testing.T) {
+	tree := []types.VirtualMachineSnapshotTree{
+		{
+			Name:     "root",
+			Snapshot: types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: "snapshot-1"},
+			ChildSnapshotList: []types.VirtualMachineSnapshotTree{
+				{
+					Name:     "child",
+					Snapshot: types.ManagedObjectReference{Type: "VirtualMachineSnapshot", Value: "snapshot-2"},
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"root", "snapshot-1"},
+		{"child", "snapshot-2"},
+		{"missing", ""},
+	}
+	for _, tc := range testCases {
+		ref := findSnapshot(tree, tc.name)
+		if tc.expected == "" {
+			if ref != nil {
+				t.Errorf("Expected no snapshot named %q, got %s", tc.name, ref.Value)
+			}
+			continue
+		}
+		if ref == nil || ref.Value != tc.expected {
+			t.Errorf("Expected snapshot %q to be %s, got %v", tc.name, tc.expected, ref)
+		}
+	}
+}
+
+func TestBuildCustomizationSpec(t *testing.T) {
+	c := &Customization{
+		DNSServers: []string{"10.0.0.2"},
+		Interfaces: []InterfaceCustomization{
+			{IP: "10.0.0.10", SubnetMask: "255.255.255.0", Gateways: []string{"10.0.0.1"}},
+			{},
+		},
+	}
+
+	spec := buildCustomizationSpec(c, "vm-1")
+	linux, ok := spec.Identity.(*types.CustomizationLinuxPrep)
+	if !ok {
+		t.Fatalf("Expected a Linux identity, got %T", spec.Identity)
+	}
+	if name := linux.HostName.(*types.CustomizationFixedName).Name; name != "vm-1" {
+		t.Errorf("Expected the host name to default to the VM name, got %q", name)
+	}
+	if len(spec.NicSettingMap) != 2 {
+		t.Fatalf("Expected 2 adapter mappings, got %d", len(spec.NicSettingMap))
+	}
+	if ip, ok := spec.NicSettingMap[0].Adapter.Ip.(*types.CustomizationFixedIp); !ok || ip.IpAddress != "10.0.0.10" {
+		t.Errorf("Expected a fixed IP for the first adapter, got %#v", spec.NicSettingMap[0].Adapter.Ip)
+	}
+	if _, ok := spec.NicSettingMap[1].Adapter.Ip.(*types.CustomizationDhcpIpGenerator); !ok {
+		t.Errorf("Expected DHCP for the second adapter, got %#v", spec.NicSettingMap[1].Adapter.Ip)
+	}
+
+	c.Windows = &WindowsCustomization{JoinDomain: "corp.example.com", DomainAdmin: "admin"}
+	spec = buildCustomizationSpec(c, "vm-1")
+	sysprep, ok := spec.Identity.(*types.CustomizationSysprep)
+	if !ok {
+		t.Fatalf("Expected a sysprep identity, got %T", spec.Identity)
+	}
+	if sysprep.Identification.JoinDomain != "corp.example.com" || sysprep.Identification.JoinWorkgroup != "" {
+		t.Errorf("Expected the guest to join the domain, got %#v", sysprep.Identification)
+	}
+}
+
+func TestSelectDatastoreMostFree(t *testing.T) {
+	summaries := map[string]types.DatastoreSummary{
+		"ds-1": {Name: "small", FreeSpace: 10, Accessible: true},
+		"ds-2": {Name: "large", FreeSpace: 100, Accessible: true},
+		"ds-3": {Name: "largest", FreeSpace: 1000, Accessible: false},
+	}
+	c := mockCollector{}
+	c.MockRetrieveOne = func(_ context.Context, mor types.ManagedObjectReference, _ []string, dst interface{}) error {
+		ds := dst.(*mo.Datastore)
+		ds.Name = summaries[mor.Value].Name
+		ds.Summary = summaries[mor.Value]
+		return nil
+	}
+	vm := &VM{collector: c, MostFreeDatastore: true}
+	dc := &mo.Datacenter{
+		Datastore: []types.ManagedObjectReference{{Value: "ds-1"}, {Value: "ds-2"}, {Value: "ds-3"}},
+	}
+
+	name, err := selectDatastore(vm, dc, []string{"small", "large", "largest"})
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if name != "large" {
+		t.Fatalf("Expected the accessible datastore with the most free space, got: %s", name)
+	}
+}
+
+func TestNeedsPowerOff(t *testing.T) {
+	yes := true
+	config := &types.VirtualMachineConfigInfo{
+		Hardware:            types.VirtualHardware{NumCPU: 2, MemoryMB: 2048},
+		CpuHotAddEnabled:    &yes,
+		MemoryHotAddEnabled: &yes,
+	}
+
+	tests := []struct {
+		cpus, memoryMB int
+		expected       bool
+	}{
+		{0, 0, false},
+		{4, 4096, false},
+		{1, 0, true},
+		{0, 1024, true},
+	}
+	for _, test := range tests {
+		if got := needsPowerOff(config, test.cpus, test.memoryMB); got != test.expected {
+			t.Fatalf("Expected needsPowerOff(%d, %d) to be %t, got: %t", test.cpus, test.memoryMB, test.expected, got)
+		}
+	}
+
+	config.CpuHotAddEnabled = nil
+	if !needsPowerOff(config, 4, 0) {
+		t.Fatal("Expected adding CPUs without hot-add to need a power off")
+	}
+}
+
+func TestSnapshotTree(t *testing.T) {
+	current := types.ManagedObjectReference{Value: "snapshot-2"}
+	tree := []types.VirtualMachineSnapshotTree{{
+		Snapshot: types.ManagedObjectReference{Value: "snapshot-1"},
+		Name:     "base",
+		State:    types.VirtualMachinePowerStatePoweredOff,
+		ChildSnapshotList: []types.VirtualMachineSnapshotTree{{
+			Snapshot: current,
+			Name:     "updated",
+			State:    types.VirtualMachinePowerStatePoweredOn,
+			Quiesced: true,
+		}},
+	}}
+
+	infos := snapshotTree(tree, &current)
+	if len(infos) != 1 || infos[0].Name != "base" || infos[0].Current {
+		t.Fatalf("Expected the base snapshot at the root, got: %+v", infos)
+	}
+	children := infos[0].Children
+	if len(children) != 1 || children[0].Name != "updated" || !children[0].Current || !children[0].Quiesced || children[0].State != "poweredOn" {
+		t.Fatalf("Expected the current updated snapshot as child, got: %+v", children)
+	}
+}
+
+func TestOpenOvfFileFromOva(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ova")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ova := filepath.Join(dir, "appliance.ova")
+	f, err := os.Create(ova)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(f)
+	for _, file := range []struct{ name, content string }{
+		{"appliance.ovf", "<Envelope/>"},
+		{"appliance-disk1.vmdk", "disk"},
+	} {
+		w.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))})
+		w.Write([]byte(file.content))
+	}
+	w.Close()
+	f.Close()
+
+	descriptor, err := parseOvf(ova)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if descriptor != "<Envelope/>" {
+		t.Fatalf("Expected the ovf descriptor of the ova, got: %s", descriptor)
+	}
+
+	r, size, err := openOvfFile(ova, "appliance-disk1.vmdk")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	defer r.Close()
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "disk" || size != 4 {
+		t.Fatalf("Expected the disk of the ova, got: %q (%d bytes)", b, size)
+	}
+}
+
+func TestOvfLocationDownloadsOvaOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ova")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("ova"))
+	}))
+	defer server.Close()
+
+	vm := &VM{OvfPath: server.URL + "/appliance.ova", CacheDir: dir}
+	for i := 0; i < 2; i++ {
+		p, err := vm.ovfLocation()
+		if err != nil {
+			t.Fatalf("Expected no error, got: %s", err)
+		}
+		if !strings.HasPrefix(p, dir) || filepath.Base(p) != "appliance.ova" {
+			t.Fatalf("Expected the ova to be downloaded into %s, got: %s", dir, p)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("Expected the ova to be downloaded once, got %d requests", requests)
+	}
+
+	vm = &VM{OvfPath: server.URL + "/appliance.ovf", CacheDir: dir}
+	if p, _ := vm.ovfLocation(); p != vm.OvfPath {
+		t.Fatalf("Expected the ovf url to be used as it is, got: %s", p)
+	}
+}
+
+func TestDRSRuleSpec(t *testing.T) {
+	vms := []types.ManagedObjectReference{{Type: "VirtualMachine", Value: "vm-1"}}
+	config := &types.ClusterConfigInfoEx{
+		Rule: []types.BaseClusterRuleInfo{
+			&types.ClusterVmHostRuleInfo{ClusterRuleInfo: types.ClusterRuleInfo{Key: 7, Name: "licensed"}},
+		},
+	}
+
+	spec, err := drsRuleSpec(DRSRule{Name: "licensed", Type: DRSRuleHostAffinity, VMs: []string{"vm"}, HostGroup: "hosts", Mandatory: true}, vms, config)
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if len(spec.RulesSpec) != 1 || spec.RulesSpec[0].Operation != types.ArrayUpdateOperationEdit {
+		t.Fatalf("Expected the existing rule to be edited, got: %+v", spec.RulesSpec)
+	}
+	rule := spec.RulesSpec[0].Info.(*types.ClusterVmHostRuleInfo)
+	if rule.Key != 7 || rule.AffineHostGroupName != "hosts" || rule.VmGroupName != "licensed" || !*rule.Mandatory {
+		t.Fatalf("Expected a mandatory vm-host rule, got: %+v", rule)
+	}
+	if len(spec.GroupSpec) != 1 || spec.GroupSpec[0].Operation != types.ArrayUpdateOperationAdd {
+		t.Fatalf("Expected the vm group to be added, got: %+v", spec.GroupSpec)
+	}
+
+	if _, err := drsRuleSpec(DRSRule{Name: "spread", Type: "unknown"}, vms, config); err == nil {
+		t.Fatal("Expected an error for an unknown rule type")
+	}
+}
+
+func TestCurrentPool(t *testing.T) {
+	pool := types.ManagedObjectReference{Type: "ResourcePool", Value: "resgroup-2"}
+	cluster := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c1"}
+	c := mockCollector{}
+	c.MockRetrieveOne = func(_ context.Context, _ types.ManagedObjectReference, _ []string, dst interface{}) error {
+		switch o := dst.(type) {
+		case *mo.VirtualMachine:
+			o.ResourcePool = &pool
+		case *mo.ResourcePool:
+			o.Owner = cluster
+		}
+		return nil
+	}
+	vm := &VM{collector: c}
+	vmMo := &mo.VirtualMachine{}
+
+	current, err := currentPool(vm, vmMo, cluster)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %s", err)
+	}
+	if current == nil || *current != pool {
+		t.Fatalf("Expected the pool of the vm to be kept, got: %v", current)
+	}
+
+	other := types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c2"}
+	if current, err = currentPool(vm, vmMo, other); err != nil || current != nil {
+		t.Fatalf("Expected the pool of the vm not to be kept on another cluster, got: %v, %v", current, err)
+	}
+}
+
+func TestRelocateDisks(t *testing.T) {
+	devices := object.VirtualDeviceList{
+		&types.VirtualDisk{VirtualDevice: types.VirtualDevice{Key: 2000}},
+		&types.VirtualE1000{},
+	}
+	ds := types.ManagedObjectReference{Type: "Datastore", Value: "ds-1"}
+
+	locators, err := relocateDisks(devices, ds, DiskProvisioningEagerZeroedThick)
+	if err != nil {
+		t.Fatalf("Expected to get no errors, got: %s", err)
+	}
+	if len(locators) != 1 || locators[0].DiskId != 2000 || locators[0].Datastore != ds {
+		t.Fatalf("Expected a locator for the disk, got: %+v", locators)
+	}
+	backing := locators[0].DiskBackingInfo.(*types.VirtualDiskFlatVer2BackingInfo)
+	if *backing.ThinProvisioned || !*backing.EagerlyScrub {
+		t.Fatalf("Expected an eager zeroed thick backing, got: %+v", backing)
+	}
+
+	if _, err := relocateDisks(devices, ds, "sparse"); err == nil {
+		t.Fatal("Expected an error for an unknown provisioning")
+	}
+}
+
+func TestGuestIPs(t *testing.T) {
+	guest := &types.GuestInfo{
+		IpAddress: "10.0.0.5",
+		Net: []types.GuestNicInfo{
+			{Network: "private", IpAddress: []string{"10.0.0.5", "fe80::1", "2001:db8::5"}},
+			{Network: "public", IpAddress: []string{"169.254.0.2", "192.0.2.5"}},
+		},
+	}
+
+	ips := guestIPs(guest, "public", true)
+	if len(ips) != 2 || ips[0].String() != "192.0.2.5" || ips[1].String() != "10.0.0.5" {
+		t.Fatalf("Expected the public IPV4 address first, got: %v", ips)
+	}
+	ips = guestIPs(guest, "", false)
+	if len(ips) != 3 || ips[0].String() != "10.0.0.5" || ips[1].String() != "2001:db8::5" {
+		t.Fatalf("Expected the IPV4 and global IPV6 addresses in order, got: %v", ips)
+	}
+	ips = guestIPs(&types.GuestInfo{IpAddress: "10.0.0.5"}, "public", false)
+	if len(ips) != 1 || ips[0].String() != "10.0.0.5" {
+		t.Fatalf("Expected the IP address of the guest, got: %v", ips)
+	}
+	if ips := guestIPs(nil, "", false); ips != nil {
+		t.Fatalf("Expected no IPs without guest info, got: %v", ips)
+	}
+}